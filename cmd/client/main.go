@@ -3,15 +3,23 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/user"
+	"runtime"
 	"strings"
 	"time"
 
+	"github.com/stockholmr/fpsmonitor/internal/agentconfig"
+	"github.com/stockholmr/fpsmonitor/internal/tlsconfig"
 	"gopkg.in/guregu/null.v3"
+	ini "gopkg.in/ini.v1"
 )
 
 type NetworkAdapter struct {
@@ -24,22 +32,110 @@ type Computer struct {
 	ComputerName null.String      `json:"name"`
 	Username     null.String      `json:"username"`
 	Adapters     []NetworkAdapter `json:"adapters"`
+	Version      null.String      `json:"version"`
+	Commit       null.String      `json:"commit"`
+	OS           null.String      `json:"os"`
+	Arch         null.String      `json:"arch"`
 }
 
-func main() {
-	user, err := user.Current()
+// Version and Commit are set at build time by the makefile, e.g.
+// -ldflags "-X main.Version=1.4.2 -X main.Commit=abc1234".
+var (
+	Version = "dev"
+	Commit  = "unknown"
+)
+
+// headerMinVersion carries the minimum version the server accepts when it
+// answers http.StatusUpgradeRequired.
+const headerMinVersion = "X-Fpsmonitor-Min-Version"
+
+var (
+	Client = struct {
+		ServerURL string `ini:"ServerURL"`
+
+		// StateFile keeps the configuration last returned by the server.
+		StateFile string `ini:"StateFile" comment:"Configuration last returned by the server. The client reports once per run, start it with -daemon to keep reporting at the interval set by the server instead of scheduling it."`
+	}{
+		ServerURL: "http://127.0.0.1:8080",
+		StateFile: "fpsmonitor_agent.json",
+	}
+
+	TLS = struct {
+		CAFile     string   `ini:"CAFile"`
+		CertFile   string   `ini:"CertFile"`
+		KeyFile    string   `ini:"KeyFile"`
+		MinVersion string   `ini:"MinVersion"`
+		PinSHA256  []string `ini:"PinSHA256" delim:","`
+	}{
+		MinVersion: "1.2",
+	}
+
+	configFile = "fpsmonitor_client.ini"
+)
+
+func loadConfig() {
+	if _, err := os.Stat(configFile); err != nil {
+		if !os.IsExist(err) {
+			cfg := ini.Empty()
+			secClient, _ := cfg.NewSection("Client")
+			secClient.ReflectFrom(&Client)
+			secTLS, _ := cfg.NewSection("TLS")
+			secTLS.ReflectFrom(&TLS)
+			cfg.SaveTo(configFile)
+		}
+	}
+
+	cfg, err := ini.Load(configFile)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("failed to load config: %s", err)
 	}
 
+	cfg.Section("Client").MapTo(&Client)
+	cfg.Section("TLS").MapTo(&TLS)
+}
+
+// loadAgentConfig returns the configuration saved by the last run, the
+// default when there is none.
+func loadAgentConfig() *agentconfig.Config {
+	c, err := agentconfig.Load(Client.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("ignoring saved agent configuration: %s", err)
+		}
+		return agentconfig.Default()
+	}
+	return c
+}
+
+// collect builds the report, running the collectors the configuration
+// enables.
+func collect(c *agentconfig.Config) (*Computer, error) {
 	pcName, _ := os.Hostname()
 	data := new(Computer)
 	data.ComputerName = null.NewString(pcName, true)
-	data.Username = null.NewString(user.Username, true)
+	data.Version = null.StringFrom(Version)
+	data.Commit = null.StringFrom(Commit)
+
+	if c.Collects(agentconfig.CollectUser) {
+		user, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		data.Username = null.NewString(user.Username, true)
+	}
+
+	if c.Collects(agentconfig.CollectPlatform) {
+		data.OS = null.StringFrom(runtime.GOOS)
+		data.Arch = null.StringFrom(runtime.GOARCH)
+	}
+
+	if !c.Collects(agentconfig.CollectAdapters) {
+		return data, nil
+	}
 
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	for _, ifa := range ifaces {
@@ -47,22 +143,19 @@ func main() {
 			continue
 		}
 
-		if strings.Contains(strings.ToLower(ifa.Name), "bluetooth") {
-			continue
-		}
-
-		if strings.Contains(strings.ToLower(ifa.Name), "vethernet") {
+		if !c.Interface(ifa.Name) {
 			continue
 		}
 
 		adds, _ := ifa.Addrs()
-		ips := ""
+		var addrs []string
 		for _, a := range adds {
 			if strings.Contains(a.String(), "::") {
 				continue
 			}
-			ips += a.String()
+			addrs = append(addrs, a.String())
 		}
+		ips := strings.Join(addrs, ",")
 
 		if strings.Contains(ips, "169.254") {
 			continue
@@ -75,18 +168,128 @@ func main() {
 		})
 	}
 
+	return data, nil
+}
+
+// outdatedError is returned by report when the server asks for an upgrade.
+type outdatedError struct {
+	minVersion string
+}
+
+func (e *outdatedError) Error() string {
+	return fmt.Sprintf("agent version %s is below the minimum version %s", Version, e.minVersion)
+}
+
+// report posts the report and returns the configuration in the response,
+// nil when the server sent none.
+func report(client *http.Client, data *Computer) (*agentconfig.Config, error) {
 	jsonStr, err := json.MarshalIndent(data, "", "    ")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
-	client := &http.Client{
-		Timeout: time.Second * 10,
+	req, err := http.NewRequest("POST", strings.TrimRight(Client.ServerURL, "/")+"/computers/update", bytes.NewBuffer(jsonStr))
+	if err != nil {
+		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fpsmonitor-client/"+Version)
 
-	_, err = client.Post("http://127.0.0.1:8080/computers/update", "application/json", bytes.NewBuffer(jsonStr))
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// The report was stored, but this agent is too old.
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		return nil, &outdatedError{minVersion: resp.Header.Get(headerMinVersion)}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server rejected report: %s", resp.Status)
+	}
+
+	next := &agentconfig.Config{}
+	if err = json.NewDecoder(resp.Body).Decode(next); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read agent configuration: %s", err)
+	}
+	return next, nil
+}
+
+// apply returns the configuration to use from now on, saving next when
+// it is a new version.
+func apply(current *agentconfig.Config, next *agentconfig.Config) *agentconfig.Config {
+	if next == nil || next.Version == current.Version {
+		return current
+	}
+	if err := next.Validate(); err != nil {
+		log.Printf("ignoring agent configuration %d: %s", next.Version, err)
+		return current
+	}
+	if err := next.Save(Client.StateFile); err != nil {
+		log.Printf("failed to save agent configuration: %s", err)
+	}
+	log.Printf("applied agent configuration %d, reporting every %s", next.Version, next.Every())
+	return next
+}
+
+func main() {
+	daemon := flag.Bool("daemon", false, "keep running and report at the interval set by the server instead of reporting once")
+	flag.Parse()
+
+	loadConfig()
+	current := loadAgentConfig()
+
+	tlsConfig, err := tlsconfig.Client(tlsconfig.ClientOptions{
+		CAFile:     TLS.CAFile,
+		CertFile:   TLS.CertFile,
+		KeyFile:    TLS.KeyFile,
+		MinVersion: TLS.MinVersion,
+		PinSHA256:  TLS.PinSHA256,
+	})
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	client := &http.Client{
+		Timeout: time.Second * 10,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+
+	for {
+		data, err := collect(current)
+		var next *agentconfig.Config
+		if err == nil {
+			next, err = report(client, data)
+		}
+
+		var outdated *outdatedError
+		if errors.As(err, &outdated) {
+			log.Printf("**************************************************************")
+			log.Printf("*** fpsmonitor client %s (%s) IS OUTDATED", Version, Commit)
+			log.Printf("*** the server requires version %s or newer, upgrade this agent", outdated.minVersion)
+			log.Printf("**************************************************************")
+			if !*daemon {
+				os.Exit(2)
+			}
+		} else if err != nil {
+			if !*daemon {
+				log.Fatal(err)
+			}
+			log.Print(err)
+		}
+
+		current = apply(current, next)
+		if !*daemon {
+			return
+		}
+		time.Sleep(current.Every())
+	}
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/computer"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// accountCommand manages the accounts signing in to the web interface.
+// Passwords are read from the first line of standard input.
+func accountCommand(args []string, flags map[string]string) int {
+	fs := flag.NewFlagSet("account", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: account add <username> <role>   create an account, the password is read from stdin")
+		fmt.Fprintln(fs.Output(), "       account list                    list the accounts")
+		fmt.Fprintln(fs.Output(), "       account role <username> <role>  change the role of an account")
+		fmt.Fprintln(fs.Output(), "       account passwd <username>       set the password read from stdin")
+		fmt.Fprintln(fs.Output(), "       account delete <username>       delete an account")
+		fmt.Fprintf(fs.Output(), "roles: %s\n", strings.Join(account.Roles(), ", "))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	want := map[string]int{"add": 3, "list": 1, "role": 3, "passwd": 2, "delete": 2}
+	if n, found := want[fs.Arg(0)]; !found || fs.NArg() != n {
+		fs.Usage()
+		return 2
+	}
+
+	c, err := loadConfig(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	db, err := database.Open(c.Database.Driver, c.Database.DataSource())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "database failed: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	if err = computer.Migrate(ctx, db); err != nil {
+		fmt.Fprintf(os.Stderr, "database failed: %s\n", err)
+		return 1
+	}
+
+	accounts := account.NewRepository(db)
+
+	switch fs.Arg(0) {
+	case "add":
+		err = addAccount(ctx, accounts, fs.Arg(1), fs.Arg(2))
+	case "list":
+		err = listAccounts(ctx, accounts)
+	default:
+		err = changeAccount(ctx, accounts, fs.Arg(0), fs.Arg(1), fs.Arg(2))
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "account failed: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func addAccount(ctx context.Context, accounts account.Repository, username string, role string) error {
+	acct := &account.Account{
+		Username: null.StringFrom(username),
+		Role:     null.StringFrom(role),
+	}
+	if err := acct.Validate(); err != nil {
+		return err
+	}
+
+	hash, err := readPassword()
+	if err != nil {
+		return err
+	}
+	acct.Password = null.StringFrom(hash)
+
+	if _, err = accounts.Create(ctx, acct); err != nil {
+		return err
+	}
+	fmt.Printf("account %s created with the %s role\n", acct.Username.String, role)
+	return nil
+}
+
+func listAccounts(ctx context.Context, accounts account.Repository) error {
+	list, err := accounts.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tROLE\tCREATED\tLAST LOGIN")
+	for _, a := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Username.String, a.Role.String, a.Created.String, a.LastLogin.String)
+	}
+	return w.Flush()
+}
+
+// changeAccount runs the role, passwd and delete commands.
+func changeAccount(ctx context.Context, accounts account.Repository, command string, username string, role string) error {
+	acct, err := accounts.SelectWithUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if acct == nil {
+		return fmt.Errorf("no account named %q", username)
+	}
+
+	switch command {
+	case "delete":
+		if err = accounts.Delete(ctx, acct.ID.Int64); err != nil {
+			return err
+		}
+		fmt.Printf("account %s deleted\n", acct.Username.String)
+		return nil
+	case "role":
+		if !account.ValidRole(role) {
+			return fmt.Errorf("unknown role %q, use one of %s", role, strings.Join(account.Roles(), ", "))
+		}
+		acct.Role = null.StringFrom(role)
+	case "passwd":
+		hash, err := readPassword()
+		if err != nil {
+			return err
+		}
+		acct.Password = null.StringFrom(hash)
+	}
+
+	if err = accounts.Update(ctx, acct); err != nil {
+		return err
+	}
+	fmt.Printf("account %s updated\n", acct.Username.String)
+	return nil
+}
+
+// readPassword hashes the first line of standard input.
+func readPassword() (string, error) {
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "password: ")
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return account.HashPassword(strings.TrimRight(line, "\r\n"))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/stockholmr/fpsmonitor/internal/backup"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	logging "github.com/stockholmr/lumber"
+)
+
+// backupCommand writes a backup of the database while the server may be
+// running, to file when given and to Backup.Dir otherwise.
+func backupCommand(args []string, flags map[string]string) int {
+	if len(args) > 1 {
+		fmt.Fprintln(os.Stderr, "usage: backup [file]")
+		return 2
+	}
+
+	c, err := loadConfig(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if c.Database.Driver != database.SQLite {
+		fmt.Fprintln(os.Stderr, backup.ErrUnsupported)
+		return 1
+	}
+
+	db, err := database.Open(c.Database.Driver, c.Database.DataSource())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "database failed: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	if len(args) == 1 {
+		if err = backup.Snapshot(ctx, db, args[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "backup failed: %s\n", err)
+			return 1
+		}
+		fmt.Printf("backup written to %s\n", args[0])
+		return 0
+	}
+
+	s := backup.NewScheduler(db, c.Backup.Dir, c.Backup.Keep, logging.NewConsoleLogger(c.LogLevel()))
+	f, err := s.Create(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup failed: %s\n", err)
+		return 1
+	}
+	fmt.Printf("backup written to %s (%d bytes)\n", f.Name, f.Size)
+	return 0
+}
+
+// restoreCommand replaces the database with a verified backup. The server
+// must be stopped first.
+func restoreCommand(args []string, flags map[string]string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: restore <file>")
+		return 2
+	}
+
+	c, err := loadConfig(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if c.Database.Driver != database.SQLite {
+		fmt.Fprintln(os.Stderr, backup.ErrUnsupported)
+		return 1
+	}
+
+	if err = backup.Restore(context.Background(), args[0], c.Database.File); err != nil {
+		fmt.Fprintf(os.Stderr, "restore failed: %s\n", err)
+		return 1
+	}
+
+	fmt.Printf("restored %s from %s, the previous database was kept as %s.pre-restore\n", c.Database.File, args[0], c.Database.File)
+	return 0
+}
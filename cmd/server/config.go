@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/stockholmr/fpsmonitor/internal/config"
+	logging "github.com/stockholmr/lumber"
+)
+
+// reloaders are run with the effective configuration after SIGHUP, they
+// are registered before watchConfig starts.
+var reloaders []func(*config.Config)
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [command]\n\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "Commands:\n")
+		fmt.Fprintf(fs.Output(), "  config print    print the effective configuration\n")
+		fmt.Fprintf(fs.Output(), "  backup [file]   write a backup of the database, safe while the server runs\n")
+		fmt.Fprintf(fs.Output(), "  restore <file>  replace the database with a backup, stop the server first\n")
+		fmt.Fprintf(fs.Output(), "  import <file>   import computers from a CSV or JSON file, see import -h\n")
+		fmt.Fprintf(fs.Output(), "  account ...     add, list and change the accounts signing in, see account -h\n\n")
+		fmt.Fprintf(fs.Output(), "Every configuration key can also be set with an environment variable,\n")
+		fmt.Fprintf(fs.Output(), "e.g. %sSERVER_PORT, flags take precedence over the environment.\n\n", config.EnvPrefix)
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
+	}
+}
+
+// loadConfig writes the default configuration on first start, then loads
+// and validates it.
+func loadConfig(flags map[string]string) (*config.Config, error) {
+	if _, err := os.Stat(configFile); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read config: %s", err)
+		}
+		if err = config.WriteDefault(configFile); err != nil {
+			return nil, fmt.Errorf("failed to write default config: %s", err)
+		}
+	}
+
+	c, err := config.Load(configFile, os.Environ(), flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config %s: %s", configFile, err)
+	}
+
+	if err = c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", configFile, err)
+	}
+
+	warnings, err := config.Deprecated(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config %s: %s", configFile, err)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configFile, w)
+	}
+	return c, nil
+}
+
+func configCommand(args []string, flags map[string]string) int {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, "usage: config print")
+		return 2
+	}
+
+	// Print the defaults rather than creating the file when it is missing.
+	file := configFile
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		file = ""
+	}
+
+	c, err := config.Load(file, os.Environ(), flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config %s: %s\n", configFile, err)
+		return 1
+	}
+
+	if err = c.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err = c.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// watchConfig reloads the configuration on SIGHUP. Keys that can change
+// live are applied, changes to any other key are reported as needing a
+// restart.
+func watchConfig(flags map[string]string, logger logging.Logger) {
+	reloaders = append(reloaders, func(c *config.Config) {
+		logger.Level(c.LogLevel())
+	})
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		next, err := loadConfig(flags)
+		if err != nil {
+			logger.Errorf("config reload failed: %s", err)
+			continue
+		}
+
+		for _, change := range cfg.Reload(next) {
+			if change.Reload {
+				logger.Infof("config reload: %s updated", change.Key)
+			} else {
+				logger.Warnf("config reload: %s changed, restart required", change.Key)
+			}
+		}
+
+		for _, fn := range reloaders {
+			fn(cfg)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/stockholmr/fpsmonitor/internal/computer"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+)
+
+// importCommand imports computers and their network adapters from a CSV or
+// JSON file.
+func importCommand(args []string, flags map[string]string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "validate the file and report the changes without writing them")
+	format := fs.String("format", "", "file format, csv or json, taken from the file extension by default")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: import [-dry-run] [-format csv|json] <file>")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	file := fs.Arg(0)
+	if *format == "" {
+		*format = computer.ImportFormat(file)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	records, err := computer.ParseImport(f, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s not imported:\n%s\n", file, err)
+		return 1
+	}
+
+	c, err := loadConfig(flags)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	db, err := database.Open(c.Database.Driver, c.Database.DataSource())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "database failed: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	if err = computer.Migrate(ctx, db); err != nil {
+		fmt.Fprintf(os.Stderr, "database failed: %s\n", err)
+		return 1
+	}
+
+	result, err := computer.NewImporter(db).Import(ctx, records, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %s\n", err)
+		return 1
+	}
+
+	if result.DryRun {
+		fmt.Print("dry run, nothing was written: ")
+	}
+	fmt.Printf("%d computers created, %d updated, %d network adapters imported, %d skipped as reported by the agent\n",
+		result.Created, result.Updated, result.Adapters, result.Skipped)
+	return 0
+}
@@ -2,23 +2,28 @@ package main
 
 import (
 	"context"
+	"flag"
 	"net/http"
 	"os"
 	"os/signal"
 	"path"
+	"syscall"
 	"time"
 
-	"github.com/stockholmr/auth"
 	"github.com/stockholmr/fpsmonitor/internal/assets"
+	"github.com/stockholmr/fpsmonitor/internal/backup"
 	"github.com/stockholmr/fpsmonitor/internal/computer"
+	"github.com/stockholmr/fpsmonitor/internal/config"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"github.com/stockholmr/fpsmonitor/internal/health"
+	"github.com/stockholmr/fpsmonitor/internal/metrics"
+	"github.com/stockholmr/fpsmonitor/internal/tlsconfig"
 
 	logging "github.com/stockholmr/lumber"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
-	ini "gopkg.in/ini.v1"
 )
 
 /*
@@ -30,64 +35,51 @@ func (c *authController) LoggingMiddleware(next http.Handler) http.Handler {
 }
 */
 
-var (
-	Server = struct {
-		ListenAddress string `ini:"Listen"`
-		Port          string `ini:"Port"`
-		SessionKey    string `ini:"SessionKey"`
-	}{
-		ListenAddress: "",
-		Port:          "8080",
-	}
-
-	Database = struct {
-		File    string `ini:"File"`
-		Install bool   `ini:"-"`
-	}{
-		File:    "fpsmonitor.sqlite",
-		Install: false,
-	}
-
-	Logging = struct {
-		File string `ini:"File"`
-	}{
-		File: "fpsmonitor.log",
-	}
+// writeTimeout limits writing a response, event streams end 5 seconds
+// before it and are reopened by the browser.
+const writeTimeout = 15 * time.Second
 
+var (
 	configFile = "fpsmonitor.ini"
 	router     *mux.Router
 	db         *sqlx.DB
-	cfg        *ini.File
+	cfg        *config.Config
 )
 
 func main() {
-
-	_, err := os.Stat(configFile)
-	if err != nil {
-		if !os.IsExist(err) {
-			cfg = ini.Empty()
-			secServer, _ := cfg.NewSection("Server")
-			secServer.ReflectFrom(&Server)
-			secDatabase, _ := cfg.NewSection("Database")
-			secDatabase.ReflectFrom(&Database)
-			secLogging, _ := cfg.NewSection("Logging")
-			secLogging.ReflectFrom(&Logging)
-			cfg.SaveTo(configFile)
-		}
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&configFile, "config", configFile, "path to the configuration file")
+	overrides := config.Flags(fs)
+	fs.Usage = usage(fs)
+	fs.Parse(os.Args[1:])
+
+	var err error
+
+	switch fs.Arg(0) {
+	case "":
+	case "config":
+		os.Exit(configCommand(fs.Args()[1:], overrides()))
+	case "backup":
+		os.Exit(backupCommand(fs.Args()[1:], overrides()))
+	case "restore":
+		os.Exit(restoreCommand(fs.Args()[1:], overrides()))
+	case "import":
+		os.Exit(importCommand(fs.Args()[1:], overrides()))
+	case "account":
+		os.Exit(accountCommand(fs.Args()[1:], overrides()))
+	default:
+		fs.Usage()
+		os.Exit(2)
 	}
 
-	cfg, err = ini.Load(configFile)
+	cfg, err = loadConfig(overrides())
 	if err != nil {
-		logging.Fatal("failed to load config")
+		logging.Fatalf("%s", err)
 	}
 
-	cfg.Section("Server").MapTo(&Server)
-	cfg.Section("Database").MapTo(&Database)
-	cfg.Section("Logging").MapTo(&Logging)
-
 	// = Init Logger =========================================================================
 
-	dir := path.Dir(Logging.File)
+	dir := path.Dir(cfg.Logging.File)
 	err = os.Mkdir(dir, 0776)
 	if err != nil {
 		if !os.IsExist(err) {
@@ -95,8 +87,8 @@ func main() {
 		}
 	}
 
-	consoleLogger := logging.NewConsoleLogger(logging.TRACE)
-	fileLogger, err := logging.NewFileLogger(Logging.File, logging.TRACE, logging.ROTATE, 5000, 5, 100)
+	consoleLogger := logging.NewConsoleLogger(cfg.LogLevel())
+	fileLogger, err := logging.NewFileLogger(cfg.Logging.File, cfg.LogLevel(), logging.ROTATE, 5000, 5, 100)
 	if err != nil {
 		logging.Error(err)
 	}
@@ -110,85 +102,194 @@ func main() {
 
 	// = Init Datebase Connection =========================================================================
 
-	dbDir := path.Dir(Database.File)
-	err = os.Mkdir(dbDir, 0776)
-	if err != nil {
-		if !os.IsExist(err) {
-			logging.Fatalf("database failed: %s", err)
-		}
-	}
+	dbCtx := context.Background()
+	dbDir := path.Dir(cfg.Database.File)
 
-	if _, err := os.Stat(Database.File); err != nil {
-		if !os.IsExist(err) {
-			Database.Install = true
+	if cfg.Database.Driver == database.SQLite {
+		err = os.Mkdir(dbDir, 0776)
+		if err != nil {
+			if !os.IsExist(err) {
+				logging.Fatalf("database failed: %s", err)
+			}
 		}
 	}
 
-	db, err = sqlx.Open("sqlite3", Database.File)
+	db, err = database.Open(cfg.Database.Driver, cfg.Database.DataSource())
 	if err != nil {
 		logging.Fatalf("database failed: %s", err)
 	}
+	cfg.Database.Pool().Apply(db)
 
-	dbCtx := context.Background()
+	if err = computer.Migrate(dbCtx, db); err != nil {
+		logging.Fatalf("database failed: %s", err)
+	}
+
+	defer db.Close()
 
-	if err = auth.NewUserRepository(db).Install(); err != nil {
+	// = Init Health Checks ======================================================================
+
+	checker := health.NewChecker()
+	checker.Add("database", health.DatabaseCheck(db))
+	checker.Add("schema", func(ctx context.Context) error {
+		return computer.CheckSchema(ctx, db)
+	})
+	if cfg.Database.Driver == database.SQLite {
+		checker.Add("database_writable", health.WritableCheck(cfg.Database.File))
+		checker.Add("disk_space", health.DiskSpaceCheck(dbDir, uint64(cfg.Database.MinFreeSpace)<<20))
+	}
+
+	if err = checker.Ready(dbCtx).Err(); err != nil {
 		logging.Fatalf("database failed: %s", err)
 	}
 
-	if Database.Install {
-		if err = auth.NewUserRepository(db).Install(); err != nil {
-			logging.Fatalf("database failed: %s", err)
-		}
+	// = Init Backups ==========================================================================
 
-		if err = computer.NewComputerRepository(db).Install(dbCtx); err != nil {
-			logging.Fatalf("database failed: %s", err)
-		}
+	backupCtx, stopBackups := context.WithCancel(context.Background())
+	defer stopBackups()
 
-		if err = computer.NewNetworkAdapterRepository(db).Install(dbCtx); err != nil {
-			logging.Fatalf("database failed: %s", err)
+	backups := backup.NewScheduler(db, cfg.Backup.Dir, cfg.Backup.Keep, logger)
+	reloaders = append(reloaders, func(c *config.Config) {
+		backups.SetKeep(c.Backup.Keep)
+	})
+	if cfg.Backup.Interval > 0 {
+		if cfg.Database.Driver == database.SQLite {
+			go backups.Run(backupCtx, cfg.Backup.Interval)
+		} else {
+			logger.Warnf("backup: scheduled backups are only supported for the %s driver", database.SQLite)
 		}
+	}
 
-		if err = computer.NewUserRepository(db).Install(dbCtx); err != nil {
-			logging.Fatalf("database failed: %s", err)
-		}
+	// = Init Alerts ===========================================================================
+
+	alertCtx, stopAlerts := context.WithCancel(context.Background())
+	defer stopAlerts()
+
+	if cfg.Alerts.Interval > 0 {
+		go computer.NewAlerter(db, logger).Run(alertCtx, cfg.Alerts.Interval)
 	}
 
-	defer db.Close()
+	// = Init Webhooks =========================================================================
+
+	webhookCtx, stopWebhooks := context.WithCancel(context.Background())
+	defer stopWebhooks()
+
+	webhooks := computer.NewWebhooks(db, logger)
+	webhooks.SetRetention(cfg.Webhooks.Retention)
+	reloaders = append(reloaders, func(c *config.Config) {
+		webhooks.SetRetention(c.Webhooks.Retention)
+	})
+	if cfg.Webhooks.Interval > 0 {
+		go webhooks.Run(webhookCtx, cfg.Webhooks.Interval)
+	}
+
+	// = Init Check-ins ========================================================================
+
+	checkinCtx, stopCheckins := context.WithCancel(context.Background())
+	defer stopCheckins()
+
+	compactor := computer.NewCompactor(db, logger, cfg.Checkins.Retention)
+	reloaders = append(reloaders, func(c *config.Config) {
+		compactor.SetRetention(c.Checkins.Retention)
+	})
+	if cfg.Checkins.Interval > 0 {
+		go compactor.Run(checkinCtx, cfg.Checkins.Interval)
+	}
+
+	// = Init Mail =============================================================================
+
+	mailCtx, stopMail := context.WithCancel(context.Background())
+	defer stopMail()
+
+	if cfg.SMTP.Host != "" {
+		schedule := computer.DigestSchedule{Hour: cfg.Digest.Hour, Weekday: cfg.Digest.Weekday()}
+		go computer.NewMailer(db, logger, cfg.SMTP.Mail(), schedule).Run(mailCtx, time.Minute)
+	}
 
 	// = Init Session Store ======================================================================
 
-	sessionStore := sessions.NewCookieStore([]byte(Server.SessionKey))
+	sessionStore := sessions.NewCookieStore([]byte(cfg.Server.SessionKey))
+	sessionStore.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   7 * 24 * 60 * 60,
+		HttpOnly: true,
+		Secure:   cfg.Server.CertFile != "",
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	access := computer.NewAccess(db, logger, sessionStore, cfg.Server.AdminToken)
 
 	// = Init Mux Router =========================================================================
 
 	router = mux.NewRouter().StrictSlash(true)
+	router.Use(metrics.Middleware)
 
 	router.Handle("/bootstrap", assets.Bootstrap()).Methods("GET")
 	router.Handle("/jquery", assets.Jquery()).Methods("GET")
 	router.Handle("/axios", assets.Axios()).Methods("GET")
-
-	_ = auth.NewAuthController(db, logger, router, sessionStore, "list")
-	_ = computer.NewComputerController(db, logger, router)
+	router.Handle("/metrics", metrics.Handler()).Methods("GET").Name("metrics")
+	router.Handle("/healthz", checker.Liveness()).Methods("GET").Name("healthz")
+	router.Handle("/readyz", checker.Readiness()).Methods("GET").Name("readyz")
+
+	_ = computer.NewLoginController(access, logger, router)
+	_ = computer.NewComputerController(db, logger, router, cfg.Agents.MinVersion, access.Require)
+	_ = computer.NewDashboardController(db, logger, router, access.Require)
+	_ = computer.NewCheckinController(db, logger, router, cfg.Checkins.Expected, access.Require)
+	_ = computer.NewVersionController(db, logger, router, cfg.Agents.MinVersion, access.Require)
+	_ = computer.NewImportController(db, logger, router, access.Require)
+	_ = computer.NewTagController(db, logger, router, access.Require)
+	_ = computer.NewSubnetController(db, logger, router, access.Require)
+	_ = computer.NewFieldController(db, logger, router, access.Require)
+	_ = computer.NewMergeController(db, logger, router, access.Require)
+	_ = computer.NewAPIController(db, logger, router, access.Require)
+	_ = computer.NewKeyController(db, logger, router, access.Require)
+	_ = computer.NewAlertController(db, logger, router, access.Require)
+	_ = computer.NewWebhookController(db, logger, router, access.Require)
+	_ = computer.NewAgentConfigController(db, logger, router, access.Require)
+	_ = computer.NewNotificationController(db, logger, router, cfg.SMTP.Host != "", access.Require)
+	_ = computer.NewEventController(db, logger, router, writeTimeout-5*time.Second, access.Require)
+	_ = backup.NewBackupController(backups, cfg.Server.AdminToken, logger, router)
 
 	//router.Handle("/", alice.New(LoggingMiddleware).ThenFunc(computer.Index(db))).Methods("POST")
 
 	//router.Handle("/computers", alice.New(LoggingMiddleware).ThenFunc(computer.List(db))).Methods("GET", "POST")
 	//router.Handle("/computers/stylesheet", computer.Stylesheet()).Methods("GET")
 
+	// The reloaders are registered, apply config changes from now on.
+	go watchConfig(overrides(), logger)
+
 	// = Init HTTP Server =========================================================================
 
 	server := http.Server{
-		Addr:           Server.ListenAddress + ":" + Server.Port,
+		Addr:           cfg.Server.ListenAddress + ":" + cfg.Server.Port,
 		Handler:        router,
-		WriteTimeout:   15 * time.Second,
+		WriteTimeout:   writeTimeout,
 		ReadTimeout:    15 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 	}
 
-	go func() {
-		logging.Debugf("server started listening on address: %s port: %s", Server.ListenAddress, Server.Port)
-		err := server.ListenAndServe()
+	if cfg.Server.CertFile != "" {
+		server.TLSConfig, err = tlsconfig.Server(tlsconfig.ServerOptions{
+			CertFile:          cfg.Server.CertFile,
+			KeyFile:           cfg.Server.KeyFile,
+			MinVersion:        cfg.Server.MinTLSVersion,
+			ClientCAFile:      cfg.Server.ClientCAFile,
+			RequireClientCert: cfg.Server.RequireClientCert,
+		})
 		if err != nil {
+			logging.Fatalf("tls failed: %s", err)
+		}
+	}
+
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			logging.Debugf("server started listening with TLS on address: %s port: %s", cfg.Server.ListenAddress, cfg.Server.Port)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			logging.Debugf("server started listening on address: %s port: %s", cfg.Server.ListenAddress, cfg.Server.Port)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			logging.Fatalf("server failed: %s", err)
 		}
 	}()
@@ -196,8 +297,20 @@ func main() {
 	wait := time.Second * 15
 	c := make(chan os.Signal, 1)
 
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
+
+	// Fail readiness first and give the load balancer time to notice before
+	// the listener is closed.
+	checker.Shutdown()
+	stopBackups()
+	stopAlerts()
+	stopWebhooks()
+	stopCheckins()
+	stopMail()
+	logging.Infof("server draining for %s", cfg.Server.DrainDelay)
+	time.Sleep(cfg.Server.DrainDelay)
+
 	ctx, cancel := context.WithTimeout(context.Background(), wait)
 	defer cancel()
 	server.Shutdown(ctx)
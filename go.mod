@@ -5,20 +5,18 @@ go 1.17
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/sessions v1.2.1
-	github.com/jcelliott/lumber v0.0.0-20160324203708-dd349441af25
 	github.com/jmoiron/sqlx v1.3.4
 	github.com/justinas/alice v1.2.0
+	github.com/lib/pq v1.10.4
 	github.com/mattn/go-sqlite3 v1.14.9
-	github.com/stockholmr/auth v0.0.0-20211106110001-ea25f118f1a0
 	github.com/stockholmr/lumber v0.0.0-20211106165735-c0143c5fd0f1
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 	gopkg.in/guregu/null.v3 v3.5.0
 	gopkg.in/ini.v1 v1.63.2
 )
 
 require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/gorilla/securecookie v1.1.1 // indirect
-	github.com/rubenv/sql-migrate v0.0.0-20211023115951-9f02b1e13857 // indirect
 	github.com/stretchr/testify v1.7.0 // indirect
-	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
-	gopkg.in/gorp.v1 v1.7.2 // indirect
 )
@@ -0,0 +1,331 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Roles, each allowing everything the ones before it allow.
+const (
+	// RoleViewer may look at the inventory.
+	RoleViewer = "viewer"
+
+	// RoleHelpdesk may also edit the details, notes and tags of computers.
+	RoleHelpdesk = "helpdesk"
+
+	// RoleAdmin may also delete and merge computers, and change tags,
+	// locations, subnets, custom fields and access.
+	RoleAdmin = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleViewer:   1,
+	RoleHelpdesk: 2,
+	RoleAdmin:    3,
+}
+
+// Roles lists the roles from the least to the most privileged.
+func Roles() []string {
+	return []string{RoleViewer, RoleHelpdesk, RoleAdmin}
+}
+
+// ValidRole reports whether role is one of Roles.
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._@-]{0,63}$`)
+
+// Account is a person signing in to the web interface.
+type Account struct {
+	ID        null.Int    `db:"id" json:"id"`
+	Created   null.String `db:"created" json:"created"`
+	Deleted   null.String `db:"deleted" json:"-"`
+	Username  null.String `db:"username" json:"username"`
+	Password  null.String `db:"password" json:"-"`
+	Role      null.String `db:"role" json:"role"`
+	LastLogin null.String `db:"last_login" json:"last_login"`
+
+	// Email receives the notifications the account subscribed to.
+	Email null.String `db:"email" json:"email"`
+}
+
+// Allows reports whether the account holds role or a more privileged one.
+// A nil account allows nothing.
+func (a *Account) Allows(role string) bool {
+	if a == nil {
+		return false
+	}
+	return roleRank[role] > 0 && roleRank[a.Role.String] >= roleRank[role]
+}
+
+// Validate checks the username and role, lower casing the username.
+func (a *Account) Validate() error {
+	username := strings.ToLower(strings.TrimSpace(a.Username.String))
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("%q is not a valid username, use letters, digits and . _ @ -", a.Username.String)
+	}
+	a.Username.SetValid(username)
+
+	if !ValidRole(a.Role.String) {
+		return fmt.Errorf("unknown role %q, use one of %s", a.Role.String, strings.Join(Roles(), ", "))
+	}
+	return nil
+}
+
+// ErrUsernameTaken is returned when creating an account whose username is
+// already in use.
+var ErrUsernameTaken = errors.New("username is already taken")
+
+type Repository interface {
+	Install(context.Context) error
+	Select(context.Context, int64) (*Account, error)
+	SelectWithUsername(context.Context, string) (*Account, error)
+	Create(context.Context, *Account) (int64, error)
+	Update(context.Context, *Account) error
+	Delete(context.Context, int64) error
+	List(context.Context) ([]Account, error)
+
+	// Authenticate returns the account when password matches, nil when
+	// the username or password is wrong.
+	Authenticate(ctx context.Context, username string, password string) (*Account, error)
+}
+
+type repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository(db *sqlx.DB) Repository {
+	return &repository{
+		db: db,
+	}
+}
+
+func (r *repository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE accounts (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "deleted" TEXT,
+            "username" TEXT NOT NULL,
+            "password" TEXT NOT NULL,
+            "role" TEXT NOT NULL,
+            "last_login" TEXT,
+            "email" TEXT
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *repository) Select(ctx context.Context, id int64) (*Account, error) {
+	return r.get(ctx, `id=?`, id)
+}
+
+func (r *repository) SelectWithUsername(ctx context.Context, username string) (*Account, error) {
+	return r.get(ctx, `username=?`, strings.ToLower(strings.TrimSpace(username)))
+}
+
+func (r *repository) get(ctx context.Context, where string, arg interface{}) (*Account, error) {
+	data := Account{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            username,
+            password,
+            role,
+            last_login,
+            email
+        FROM accounts
+        WHERE `+where+`
+        AND deleted IS NULL`),
+		arg,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// Create stores a validated account, Password holding the hash made by
+// HashPassword.
+func (r *repository) Create(ctx context.Context, data *Account) (int64, error) {
+	existing, err := r.SelectWithUsername(ctx, data.Username.String)
+	if err != nil {
+		return -1, err
+	}
+	if existing != nil {
+		return -1, ErrUsernameTaken
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO accounts (
+            created,
+            username,
+            password,
+            role
+        ) VALUES (?,?,?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.Username,
+		data.Password,
+		data.Role,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+// Update writes the password, role, last login and email of the account.
+func (r *repository) Update(ctx context.Context, data *Account) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		tx.Rebind(`UPDATE accounts SET
+            password=?,
+            role=?,
+            last_login=?,
+            email=?
+        WHERE id=?`),
+		data.Password,
+		data.Role,
+		data.LastLogin,
+		data.Email,
+		data.ID,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *repository) Delete(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		tx.Rebind(`UPDATE accounts SET
+            deleted=?
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *repository) List(ctx context.Context) ([]Account, error) {
+	data := []Account{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            username,
+            password,
+            role,
+            last_login,
+            email
+        FROM accounts
+        WHERE deleted IS NULL
+        ORDER BY username`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// dummyHash is checked against for unknown usernames, so they take as long
+// to reject as wrong passwords.
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+func (r *repository) Authenticate(ctx context.Context, username string, password string) (*Account, error) {
+	acct, err := r.SelectWithUsername(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = HashPassword("not the password of anyone")
+	})
+
+	hash := dummyHash
+	if acct != nil {
+		hash = acct.Password.String
+	}
+
+	match, err := CheckPassword(hash, password)
+	if err != nil || !match || acct == nil {
+		return nil, err
+	}
+
+	acct.LastLogin = null.StringFrom(time.Now().Format("2006-01-02 15:04:05"))
+	if err = r.Update(ctx, acct); err != nil {
+		return nil, err
+	}
+	return acct, nil
+}
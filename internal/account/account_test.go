@@ -0,0 +1,103 @@
+package account
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	// RFC 7914, section 11.
+	key := deriveKey([]byte("passwd"), []byte("salt"), 1, 64)
+	equals(t, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc"+
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783", hex.EncodeToString(key))
+}
+
+func TestPassword(t *testing.T) {
+	_, err := HashPassword("short")
+	equals(t, ErrPasswordTooShort, err)
+
+	hash, err := HashPassword("correct horse battery")
+	equals(t, nil, err)
+
+	match, err := CheckPassword(hash, "correct horse battery")
+	equals(t, nil, err)
+	equals(t, true, match)
+
+	match, err = CheckPassword(hash, "correct horse")
+	equals(t, nil, err)
+	equals(t, false, match)
+
+	_, err = CheckPassword("plain text", "plain text")
+	equals(t, true, err != nil)
+}
+
+func TestAllows(t *testing.T) {
+	helpdesk := &Account{Role: null.StringFrom(RoleHelpdesk)}
+	equals(t, true, helpdesk.Allows(RoleViewer))
+	equals(t, true, helpdesk.Allows(RoleHelpdesk))
+	equals(t, false, helpdesk.Allows(RoleAdmin))
+	equals(t, false, helpdesk.Allows("owner"))
+
+	var anonymous *Account
+	equals(t, false, anonymous.Allows(RoleViewer))
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		username string
+		role     string
+		valid    bool
+	}{
+		{" Alice ", RoleAdmin, true},
+		{"helpdesk@example.org", RoleHelpdesk, true},
+		{"", RoleViewer, false},
+		{"two words", RoleViewer, false},
+		{"alice", "root", false},
+	} {
+		a := &Account{Username: null.StringFrom(tc.username), Role: null.StringFrom(tc.role)}
+		equals(t, tc.valid, a.Validate() == nil)
+	}
+
+	a := &Account{Username: null.StringFrom(" Alice "), Role: null.StringFrom(RoleAdmin)}
+	a.Validate()
+	equals(t, "alice", a.Username.String)
+}
+
+func TestAPIKey(t *testing.T) {
+	_, _, err := NewAPIKey(" ", ScopeRead, time.Time{})
+	equals(t, true, err != nil)
+	_, _, err = NewAPIKey("CMDB", "write", time.Time{})
+	equals(t, true, err != nil)
+
+	key, data, err := NewAPIKey("CMDB", ScopeExport, time.Now().Add(time.Hour))
+	equals(t, nil, err)
+	equals(t, HashAPIKey(key), data.Hash.String)
+	equals(t, true, strings.HasPrefix(key, data.Prefix.String))
+	equals(t, false, data.Expired(time.Now()))
+	equals(t, true, data.Expired(time.Now().Add(2*time.Hour)))
+
+	equals(t, true, data.Allows(ScopeRead))
+	equals(t, true, data.Allows(ScopeExport))
+	equals(t, false, data.Allows(ScopeAdmin))
+	equals(t, RoleViewer, data.Account().Role.String)
+
+	data.Scope = null.StringFrom(ScopeAdmin)
+	equals(t, RoleAdmin, data.Account().Role.String)
+}
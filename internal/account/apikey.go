@@ -0,0 +1,347 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Scopes of API keys, each allowing everything the ones before it allow.
+const (
+	// ScopeRead may read the JSON API.
+	ScopeRead = "read"
+
+	// ScopeExport may also download the CSV and XLSX exports.
+	ScopeExport = "export"
+
+	// ScopeAdmin may use the whole JSON API.
+	ScopeAdmin = "admin"
+)
+
+var scopeRank = map[string]int{
+	ScopeRead:   1,
+	ScopeExport: 2,
+	ScopeAdmin:  3,
+}
+
+// Scopes lists the scopes from the least to the most privileged.
+func Scopes() []string {
+	return []string{ScopeRead, ScopeExport, ScopeAdmin}
+}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	return scopeRank[scope] > 0
+}
+
+// keyPrefix starts every API key, making leaked keys easy to search for.
+const keyPrefix = "fpm_"
+
+// keyUseInterval limits how often the last use of a key is written.
+const keyUseInterval = time.Minute
+
+// APIKey lets scripts use the JSON API. Only the SHA-256 hash of the key
+// is stored, Prefix identifies it in listings. Keys created by an account
+// stop working when it is deleted and never allow more than its current
+// role, see Allows.
+type APIKey struct {
+	ID        null.Int    `db:"id" json:"id"`
+	Created   null.String `db:"created" json:"created"`
+	Revoked   null.String `db:"revoked" json:"revoked"`
+	Name      null.String `db:"name" json:"name"`
+	Prefix    null.String `db:"prefix" json:"prefix"`
+	Hash      null.String `db:"hash" json:"-"`
+	Scope     null.String `db:"scope" json:"scope"`
+	Expires   null.String `db:"expires" json:"expires"`
+	LastUsed  null.String `db:"last_used" json:"last_used"`
+	CreatedBy null.String `db:"created_by" json:"created_by"`
+
+	// AccountID is the account that created the key, null for keys
+	// created with the admin token. AccountRole is its current role, set
+	// by Authenticate.
+	AccountID   null.Int    `db:"account_id" json:"-"`
+	AccountRole null.String `db:"account_role" json:"-"`
+}
+
+// Allows reports whether the key holds scope or a more privileged one.
+// The admin scope also takes an account with the admin role.
+func (k *APIKey) Allows(scope string) bool {
+	if k.AccountRole.Valid && scope == ScopeAdmin && k.AccountRole.String != RoleAdmin {
+		return false
+	}
+	return scopeRank[scope] > 0 && scopeRank[k.Scope.String] >= scopeRank[scope]
+}
+
+// Expired reports whether the key expired before now.
+func (k *APIKey) Expired(now time.Time) bool {
+	return k.Expires.Valid && k.Expires.String <= now.Format("2006-01-02 15:04:05")
+}
+
+// Account returns the account requests made with the key act as, admin
+// keys get the admin role and the others the viewer role.
+func (k *APIKey) Account() *Account {
+	role := RoleViewer
+	if k.Allows(ScopeAdmin) {
+		role = RoleAdmin
+	}
+	return &Account{
+		Username: null.StringFrom("key " + k.Name.String),
+		Role:     null.StringFrom(role),
+	}
+}
+
+// NewAPIKey returns a random key, and the APIKey to store for it.
+func NewAPIKey(name string, scope string, expires time.Time) (string, *APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, errors.New("API key name is required")
+	}
+	if !ValidScope(scope) {
+		return "", nil, fmt.Errorf("unknown scope %q, use one of %s", scope, strings.Join(Scopes(), ", "))
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	data := &APIKey{
+		Name:   null.StringFrom(name),
+		Prefix: null.StringFrom(key[:len(keyPrefix)+6]),
+		Hash:   null.StringFrom(HashAPIKey(key)),
+		Scope:  null.StringFrom(scope),
+	}
+	if !expires.IsZero() {
+		data.Expires = null.StringFrom(expires.Format("2006-01-02 15:04:05"))
+	}
+	return key, data, nil
+}
+
+// HashAPIKey returns the hash stored for key. Keys are random, so a single
+// round of SHA-256 is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type KeyRepository interface {
+	Install(context.Context) error
+	Create(context.Context, *APIKey) (int64, error)
+	List(context.Context) ([]APIKey, error)
+	Revoke(context.Context, int64) error
+
+	// Authenticate returns the live key matching key and records its use,
+	// nil when there is none, it expired or was revoked or the account
+	// that created it was deleted.
+	Authenticate(ctx context.Context, key string) (*APIKey, error)
+}
+
+type keyRepository struct {
+	db *sqlx.DB
+}
+
+func NewKeyRepository(db *sqlx.DB) KeyRepository {
+	return &keyRepository{
+		db: db,
+	}
+}
+
+func (r *keyRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE api_keys (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "revoked" TEXT,
+            "name" TEXT NOT NULL,
+            "prefix" TEXT NOT NULL,
+            "hash" TEXT NOT NULL,
+            "scope" TEXT NOT NULL,
+            "expires" TEXT,
+            "last_used" TEXT,
+            "created_by" TEXT,
+            "account_id" INTEGER
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `CREATE UNIQUE INDEX api_keys_hash ON api_keys (hash)`)
+	return err
+}
+
+func (r *keyRepository) Create(ctx context.Context, data *APIKey) (int64, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO api_keys (
+            created,
+            name,
+            prefix,
+            hash,
+            scope,
+            expires,
+            created_by,
+            account_id
+        ) VALUES (?,?,?,?,?,?,?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.Name,
+		data.Prefix,
+		data.Hash,
+		data.Scope,
+		data.Expires,
+		data.CreatedBy,
+		data.AccountID,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+// List returns every key, revoked ones included, newest first.
+func (r *keyRepository) List(ctx context.Context) ([]APIKey, error) {
+	data := []APIKey{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            revoked,
+            name,
+            prefix,
+            hash,
+            scope,
+            expires,
+            last_used,
+            created_by,
+            account_id
+        FROM api_keys
+        ORDER BY id DESC`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *keyRepository) Revoke(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		tx.Rebind(`UPDATE api_keys SET
+            revoked=?
+        WHERE id=?
+        AND revoked IS NULL`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *keyRepository) Authenticate(ctx context.Context, key string) (*APIKey, error) {
+	if !strings.HasPrefix(key, keyPrefix) {
+		return nil, nil
+	}
+
+	data := APIKey{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            k.id,
+            k.created,
+            k.revoked,
+            k.name,
+            k.prefix,
+            k.hash,
+            k.scope,
+            k.expires,
+            k.last_used,
+            k.created_by,
+            k.account_id,
+            a.role AS account_role
+        FROM api_keys k
+        LEFT JOIN accounts a ON a.id = k.account_id AND a.deleted IS NULL
+        WHERE k.hash=?
+        AND k.revoked IS NULL`),
+		HashAPIKey(key),
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// The account that created the key was deleted.
+	if data.AccountID.Valid && !data.AccountRole.Valid {
+		return nil, nil
+	}
+
+	now := time.Now()
+	if data.Expired(now) {
+		return nil, nil
+	}
+
+	// Busy scripts would otherwise write on every request.
+	if data.LastUsed.String > now.Add(-keyUseInterval).Format("2006-01-02 15:04:05") {
+		return &data, nil
+	}
+
+	data.LastUsed = null.StringFrom(now.Format("2006-01-02 15:04:05"))
+	_, err = r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE api_keys SET last_used=? WHERE id=?`),
+		data.LastUsed,
+		data.ID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
@@ -0,0 +1,81 @@
+package account
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	hashScheme     = "pbkdf2-sha256"
+	hashIterations = 600000
+	hashSaltSize   = 16
+	hashKeySize    = 32
+)
+
+// MinPasswordLength is the shortest password accepted by HashPassword.
+const MinPasswordLength = 10
+
+// ErrPasswordTooShort is returned for passwords shorter than
+// MinPasswordLength.
+var ErrPasswordTooShort = fmt.Errorf("passwords need at least %d characters", MinPasswordLength)
+
+// HashPassword returns the salted PBKDF2-SHA256 hash of password in the
+// form "pbkdf2-sha256$iterations$salt$key".
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+
+	salt := make([]byte, hashSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := deriveKey([]byte(password), salt, hashIterations, hashKeySize)
+	return strings.Join([]string{
+		hashScheme,
+		strconv.Itoa(hashIterations),
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	}, "$"), nil
+}
+
+// CheckPassword reports whether password matches a hash made by
+// HashPassword.
+func CheckPassword(hash string, password string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != hashScheme {
+		return false, errors.New("unknown password hash")
+	}
+
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false, errors.New("invalid password hash iterations")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false, err
+	}
+
+	given := deriveKey([]byte(password), salt, iterations, len(key))
+	return subtle.ConstantTimeCompare(given, key) == 1, nil
+}
+
+// deriveKey derives a key of keyLen bytes with PBKDF2 and HMAC-SHA256.
+func deriveKey(password []byte, salt []byte, iterations int, keyLen int) []byte {
+	return pbkdf2.Key(password, salt, iterations, keyLen, sha256.New)
+}
@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/justinas/alice"
+)
+
+// MaxFormSize limits the forms read while looking for the token, before the
+// request has been authenticated.
+const MaxFormSize = 32 << 20
+
+// RequireToken rejects requests that do not carry token, either in an
+// "Authorization: Bearer" header or, for forms posted from the browser, in
+// the "token" form value. An empty token disables the routes it protects.
+func RequireToken(token string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if given == "" && r.Method == "POST" {
+				r.Body = http.MaxBytesReader(w, r.Body, MaxFormSize)
+				given = r.FormValue("token")
+			}
+
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="fpsmonitor"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	token := "0123456789abcdef0123456789abcdef"
+	handler := RequireToken(token)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for _, tc := range []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer " + token, http.StatusNoContent},
+	} {
+		req := httptest.NewRequest("GET", "/admin/backups", nil)
+		if tc.header != "" {
+			req.Header.Set("Authorization", tc.header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		equals(t, tc.status, rec.Code)
+	}
+
+	form := url.Values{"token": {token}}
+	req := httptest.NewRequest("POST", "/computers/import", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	equals(t, http.StatusNoContent, rec.Code)
+
+	rec = httptest.NewRecorder()
+	RequireToken("")(handler).ServeHTTP(rec, httptest.NewRequest("GET", "/admin/backups", nil))
+	equals(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,169 @@
+// Package agentconfig is the configuration the server returns to the agents
+// in the response to every report. Agents apply it and keep it until the
+// server returns another version.
+package agentconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Collectors the agent can be told to run.
+const (
+	CollectUser     = "user"
+	CollectAdapters = "adapters"
+	CollectPlatform = "platform"
+)
+
+// Collectors lists every collector.
+var Collectors = []string{CollectUser, CollectAdapters, CollectPlatform}
+
+const (
+	// MinInterval and MaxInterval bound the report interval.
+	MinInterval = time.Minute
+	MaxInterval = 7 * 24 * time.Hour
+)
+
+// Config is the configuration document of an agent. Interface names are
+// matched against the Include and Exclude patterns, see path.Match,
+// ignoring case.
+type Config struct {
+	// Version changes with every change to the configuration on the
+	// server, 0 is the built in default.
+	Version    int64    `json:"version"`
+	Interval   string   `json:"interval"`
+	Collectors []string `json:"collectors"`
+	Include    []string `json:"include"`
+	Exclude    []string `json:"exclude"`
+}
+
+// Default returns the configuration of agents the server has not
+// configured.
+func Default() *Config {
+	return &Config{
+		Interval:   "1h",
+		Collectors: append([]string(nil), Collectors...),
+		Include:    []string{},
+		Exclude:    []string{"*bluetooth*", "vethernet*"},
+	}
+}
+
+// Validate checks the interval, collectors and patterns.
+func (c *Config) Validate() error {
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil {
+		return fmt.Errorf("interval %q is not a duration such as 15m or 1h", c.Interval)
+	}
+	if d < MinInterval || d > MaxInterval {
+		return fmt.Errorf("interval must be between %s and %s", MinInterval, MaxInterval)
+	}
+
+	for _, name := range c.Collectors {
+		if !known(name) {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+
+	for _, p := range append(append([]string(nil), c.Include...), c.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid interface pattern %q", p)
+		}
+	}
+	return nil
+}
+
+func known(collector string) bool {
+	for _, c := range Collectors {
+		if c == collector {
+			return true
+		}
+	}
+	return false
+}
+
+// Every returns the report interval, MinInterval when it is invalid.
+func (c *Config) Every() time.Duration {
+	d, err := time.ParseDuration(c.Interval)
+	if err != nil || d < MinInterval {
+		return MinInterval
+	}
+	return d
+}
+
+// Collects reports whether the collector is enabled.
+func (c *Config) Collects(collector string) bool {
+	for _, name := range c.Collectors {
+		if name == collector {
+			return true
+		}
+	}
+	return false
+}
+
+// Interface reports whether the network interface name is reported,
+// it has to match an Include pattern if there are any and none of the
+// Exclude patterns.
+func (c *Config) Interface(name string) bool {
+	name = strings.ToLower(name)
+
+	if len(c.Include) > 0 && !matchAny(c.Include, name) {
+		return false
+	}
+	return !matchAny(c.Exclude, name)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Load reads the configuration saved to file.
+func Load(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Config{}
+	if err = json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Save writes the configuration to file, replacing it at once so a crash
+// never leaves half a file behind.
+func (c *Config) Save(file string) error {
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
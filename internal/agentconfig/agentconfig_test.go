@@ -0,0 +1,91 @@
+package agentconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: unexpected error: %s\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func TestValidate(t *testing.T) {
+	ok(t, Default().Validate())
+
+	for _, c := range []struct {
+		change func(*Config)
+		err    string
+	}{
+		{func(c *Config) { c.Interval = "often" }, `interval "often" is not a duration such as 15m or 1h`},
+		{func(c *Config) { c.Interval = "30s" }, "interval must be between 1m0s and 168h0m0s"},
+		{func(c *Config) { c.Collectors = []string{"user", "disks"} }, `unknown collector "disks"`},
+		{func(c *Config) { c.Include = []string{"eth["} }, `invalid interface pattern "eth["`},
+	} {
+		cfg := Default()
+		c.change(cfg)
+		equals(t, c.err, cfg.Validate().Error())
+	}
+}
+
+func TestInterface(t *testing.T) {
+	c := Default()
+	equals(t, true, c.Interface("Ethernet 2"))
+	equals(t, false, c.Interface("Bluetooth Network Connection"))
+	equals(t, false, c.Interface("vEthernet (WSL)"))
+
+	c.Include = []string{"eth*", "Wi-Fi"}
+	equals(t, true, c.Interface("Ethernet"))
+	equals(t, true, c.Interface("wi-fi"))
+	equals(t, false, c.Interface("en0"))
+
+	equals(t, true, c.Collects(CollectAdapters))
+	c.Collectors = []string{CollectUser}
+	equals(t, false, c.Collects(CollectAdapters))
+
+	equals(t, time.Hour, Default().Every())
+	c.Interval = "bad"
+	equals(t, MinInterval, c.Every())
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agentconfig")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "agent.json")
+	_, err = Load(file)
+	equals(t, true, os.IsNotExist(err))
+
+	c := Default()
+	c.Version = 7
+	c.Interval = "15m"
+	ok(t, c.Save(file))
+
+	loaded, err := Load(file)
+	ok(t, err)
+	equals(t, c, loaded)
+
+	files, err := ioutil.ReadDir(dir)
+	ok(t, err)
+	equals(t, 1, len(files))
+}
@@ -0,0 +1,184 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stockholmr/fpsmonitor/internal/computer"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+)
+
+// ErrUnsupported is returned for databases other than SQLite, which are
+// expected to be backed up with their own tooling.
+var ErrUnsupported = errors.New("backups are only supported for the " + database.SQLite + " driver")
+
+// Snapshot writes a consistent copy of db to file using the SQLite online
+// backup API. The copy is written next to file and only renamed into place
+// once it has been verified, so file is never left half written.
+func Snapshot(ctx context.Context, db *sqlx.DB, file string) error {
+	if db.DriverName() != database.SQLite {
+		return ErrUnsupported
+	}
+
+	tmp := file + ".tmp"
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := copyDatabase(ctx, db, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := Verify(ctx, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, file)
+}
+
+func copyDatabase(ctx context.Context, db *sqlx.DB, file string) error {
+	dest, err := sql.Open(database.SQLite, file)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(d interface{}) error {
+		return srcConn.Raw(func(s interface{}) error {
+			dc, ok := d.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected connection type %T", d)
+			}
+			sc, ok := s.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected connection type %T", s)
+			}
+
+			b, err := dc.Backup("main", sc, "main")
+			if err != nil {
+				return err
+			}
+
+			// Copying every page in a single step holds one read
+			// transaction, in WAL mode writers are not blocked by it and
+			// the backup can not be restarted by their changes.
+			if _, err = b.Step(-1); err != nil {
+				b.Finish()
+				return err
+			}
+			return b.Finish()
+		})
+	})
+}
+
+// Verify opens the backup in file and checks its integrity and that its
+// schema version matches this build.
+func Verify(ctx context.Context, file string) error {
+	if _, err := os.Stat(file); err != nil {
+		return err
+	}
+
+	db, err := sqlx.Open(database.SQLite, file)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err = db.GetContext(ctx, &result, `PRAGMA integrity_check`); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%s: integrity check failed: %s", file, result)
+	}
+
+	if err = computer.CheckSchema(ctx, db); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	return nil
+}
+
+// Restore replaces the database file dst with the backup in src once it
+// has been verified. The server must not be running. The replaced database
+// is kept as dst.pre-restore, together with its write-ahead log.
+func Restore(ctx context.Context, src string, dst string) error {
+	if err := Verify(ctx, src); err != nil {
+		return err
+	}
+
+	tmp := dst + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// The write-ahead log of the replaced database holds changes not yet
+	// written to it, it moves along under the name SQLite looks for. Left
+	// behind it would be applied to the restored database.
+	keep := dst + ".pre-restore"
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(keep + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		if err = os.Rename(dst, keep); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Rename(dst+suffix, keep+suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0664)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	if err = out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
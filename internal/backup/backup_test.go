@@ -0,0 +1,215 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/computer"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: "+msg+"\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: unexpected error: %s\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discard) Close() error {
+	return nil
+}
+
+func dbSetup(t *testing.T, file string) *sqlx.DB {
+	db, err := database.Open(database.SQLite, database.SQLiteDSN(file, database.SQLiteOptions{
+		JournalMode: "WAL",
+		BusyTimeout: time.Second * 5,
+		ForeignKeys: true,
+	}))
+	ok(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	ok(t, computer.Migrate(context.Background(), db))
+	return db
+}
+
+func countComputers(t *testing.T, file string) int {
+	db, err := sqlx.Open(database.SQLite, file)
+	ok(t, err)
+	defer db.Close()
+
+	count, err := computer.NewComputerRepository(db).Count(context.Background())
+	ok(t, err)
+	return count
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := dbSetup(t, filepath.Join(dir, "live.sqlite"))
+
+	repo := computer.NewComputerRepository(db)
+	for i := 0; i < 3; i++ {
+		_, err := repo.Create(ctx, &computer.Computer{Name: null.StringFrom(fmt.Sprintf("PC%02d", i))})
+		ok(t, err)
+	}
+
+	snapshot := filepath.Join(dir, "snapshot.sqlite")
+	ok(t, Snapshot(ctx, db, snapshot))
+	ok(t, Verify(ctx, snapshot))
+
+	_, err := os.Stat(snapshot + ".tmp")
+	assert(t, os.IsNotExist(err), "temporary file left behind")
+
+	// Changes after the snapshot are not part of it.
+	_, err = repo.Create(ctx, &computer.Computer{Name: null.StringFrom("PC99")})
+	ok(t, err)
+	equals(t, 3, countComputers(t, snapshot))
+
+	target := filepath.Join(dir, "restored.sqlite")
+	dbSetup(t, target).Close()
+
+	ok(t, Restore(ctx, snapshot, target))
+	equals(t, 3, countComputers(t, target))
+
+	_, err = os.Stat(target + ".pre-restore")
+	ok(t, err)
+}
+
+func TestRestoreKeepsWAL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	live := filepath.Join(dir, "live.sqlite")
+	db := dbSetup(t, live)
+	db.SetMaxOpenConns(1)
+	_, err := db.ExecContext(ctx, `PRAGMA wal_autocheckpoint=0`)
+	ok(t, err)
+
+	snapshot := filepath.Join(dir, "snapshot.sqlite")
+	ok(t, Snapshot(ctx, db, snapshot))
+
+	// A change only held by the write-ahead log of a server that was
+	// killed is kept with the replaced database.
+	_, err = computer.NewComputerRepository(db).Create(ctx, &computer.Computer{Name: null.StringFrom("PC01")})
+	ok(t, err)
+	_, err = os.Stat(live + "-wal")
+	ok(t, err)
+
+	ok(t, Restore(ctx, snapshot, live))
+	equals(t, 0, countComputers(t, live))
+	equals(t, 1, countComputers(t, live+".pre-restore"))
+}
+
+func TestSchedulerSameSecond(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := dbSetup(t, filepath.Join(dir, "live.sqlite"))
+
+	s := NewScheduler(db, filepath.Join(dir, "backups"), 0, lumber.NewBasicLogger(discard{}, lumber.FATAL))
+
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 2; i++ {
+		now := start.Add(time.Millisecond * time.Duration(250*i))
+		s.now = func() time.Time { return now }
+
+		_, err := s.Create(ctx)
+		ok(t, err)
+	}
+
+	list, err := s.List()
+	ok(t, err)
+	equals(t, 2, len(list))
+	equals(t, "fpsmonitor-20210301-120000.250.sqlite", list[0].Name)
+}
+
+func TestRestoreRejectsSchemaMismatch(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	old := filepath.Join(dir, "old.sqlite")
+	db := dbSetup(t, old)
+	ok(t, computer.NewSchemaRepository(db).SetVersion(ctx, computer.SchemaVersion()+1))
+	db.Close()
+
+	target := filepath.Join(dir, "target.sqlite")
+	dbSetup(t, target).Close()
+
+	err := Restore(ctx, old, target)
+	assert(t, err != nil, "expected restore of a mismatched schema to fail")
+
+	_, err = os.Stat(target + ".pre-restore")
+	assert(t, os.IsNotExist(err), "target replaced despite failed verification")
+}
+
+func TestSnapshotUnsupportedDriver(t *testing.T) {
+	db := sqlx.NewDb(nil, database.Postgres)
+	equals(t, ErrUnsupported, Snapshot(context.Background(), db, "unused"))
+}
+
+func TestSchedulerRetention(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := dbSetup(t, filepath.Join(dir, "live.sqlite"))
+
+	s := NewScheduler(db, filepath.Join(dir, "backups"), 2, lumber.NewBasicLogger(discard{}, lumber.FATAL))
+
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.Local)
+	for i := 0; i < 4; i++ {
+		now := start.Add(time.Hour * time.Duration(i))
+		s.now = func() time.Time { return now }
+
+		_, err := s.Create(ctx)
+		ok(t, err)
+	}
+
+	list, err := s.List()
+	ok(t, err)
+	equals(t, 2, len(list))
+	equals(t, "fpsmonitor-20210301-150000.000.sqlite", list[0].Name)
+	equals(t, "fpsmonitor-20210301-140000.000.sqlite", list[1].Name)
+
+	s.SetKeep(1)
+	now := start.Add(time.Hour * 4)
+	s.now = func() time.Time { return now }
+	_, err = s.Create(ctx)
+	ok(t, err)
+
+	list, err = s.List()
+	ok(t, err)
+	equals(t, 1, len(list))
+	equals(t, "fpsmonitor-20210301-160000.000.sqlite", list[0].Name)
+}
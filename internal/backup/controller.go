@@ -0,0 +1,82 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/admin"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+)
+
+type backupController struct {
+	log       lumber.Logger
+	scheduler *Scheduler
+}
+
+type BackupController interface {
+	Create(http.ResponseWriter, *http.Request)
+	List(http.ResponseWriter, *http.Request)
+}
+
+// NewBackupController registers the admin backup endpoints under
+// /admin/backups. Every request must carry token as a bearer token, an
+// empty token disables the endpoints.
+func NewBackupController(scheduler *Scheduler, token string, log lumber.Logger, router *mux.Router, middleware ...alice.Constructor) BackupController {
+	c := &backupController{
+		log:       log,
+		scheduler: scheduler,
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+		admin.RequireToken(token),
+	}
+	m = append(m, middleware...)
+
+	router.Handle("/admin/backups", alice.New(m...).ThenFunc(c.Create)).Methods("POST").Name("backup_create")
+	router.Handle("/admin/backups", alice.New(m...).ThenFunc(c.List)).Methods("GET").Name("backup_list")
+
+	return c
+}
+
+func (c *backupController) Create(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Minute*5)
+	defer cancel()
+
+	f, err := c.scheduler.Create(ctx)
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		status := http.StatusInternalServerError
+		if err == ErrUnsupported {
+			status = http.StatusNotImplemented
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	requestlog.Set(r.Context(), "backup", f.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(f)
+}
+
+func (c *backupController) List(w http.ResponseWriter, r *http.Request) {
+	list, err := c.scheduler.List()
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if list == nil {
+		list = []File{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
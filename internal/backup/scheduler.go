@@ -0,0 +1,192 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/metrics"
+	"github.com/stockholmr/lumber"
+)
+
+const (
+	filePrefix = "fpsmonitor-"
+	fileSuffix = ".sqlite"
+	fileTime   = "20060102-150405"
+
+	// fileTimeFraction names new backups, milliseconds keep two backups
+	// in the same second apart. Parsing fileTime accepts both.
+	fileTimeFraction = fileTime + ".000"
+)
+
+var (
+	backupTotal = metrics.NewCounterVec(
+		"fpsmonitor_backup_total",
+		"Number of database backups by result.",
+		"result",
+	)
+
+	backupLastSuccess = metrics.NewGaugeVec(
+		"fpsmonitor_backup_last_success_timestamp_seconds",
+		"Unix time of the last verified database backup.",
+	)
+)
+
+// File is a backup held in the backup directory.
+type File struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// Scheduler writes timestamped backups to a directory and removes all but
+// the newest Keep of them.
+type Scheduler struct {
+	mu   sync.Mutex
+	db   *sqlx.DB
+	dir  string
+	keep int
+	log  lumber.Logger
+	now  func() time.Time
+}
+
+func NewScheduler(db *sqlx.DB, dir string, keep int, log lumber.Logger) *Scheduler {
+	return &Scheduler{
+		db:   db,
+		dir:  dir,
+		keep: keep,
+		log:  log,
+		now:  time.Now,
+	}
+}
+
+// Create writes a new backup and applies the retention, returning the
+// backup written.
+func (s *Scheduler) Create(ctx context.Context) (File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0776); err != nil {
+		backupTotal.Inc("failure")
+		return File{}, err
+	}
+
+	created := s.now().Truncate(time.Millisecond)
+	name := filePrefix + created.Format(fileTimeFraction) + fileSuffix
+	file := filepath.Join(s.dir, name)
+
+	if err := Snapshot(ctx, s.db, file); err != nil {
+		backupTotal.Inc("failure")
+		return File{}, err
+	}
+
+	backupTotal.Inc("success")
+	backupLastSuccess.Set(float64(created.Unix()))
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return File{}, err
+	}
+
+	if err = s.prune(); err != nil {
+		return File{}, err
+	}
+
+	return File{
+		Name:    name,
+		Size:    info.Size(),
+		Created: created,
+	}, nil
+}
+
+// List returns the backups in the directory, newest first.
+func (s *Scheduler) List() ([]File, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var list []File
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+
+		created, err := time.ParseInLocation(fileTime, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix), time.Local)
+		if err != nil {
+			continue
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, File{
+			Name:    name,
+			Size:    info.Size(),
+			Created: created,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+	return list, nil
+}
+
+func (s *Scheduler) prune() error {
+	if s.keep <= 0 {
+		return nil
+	}
+
+	list, err := s.List()
+	if err != nil {
+		return err
+	}
+
+	for i := s.keep; i < len(list); i++ {
+		if err = os.Remove(filepath.Join(s.dir, list[i].Name)); err != nil {
+			return err
+		}
+		s.log.Infof("backup: removed %s", list[i].Name)
+	}
+	return nil
+}
+
+// SetKeep changes the number of backups kept, it applies from the next
+// backup.
+func (s *Scheduler) SetKeep(keep int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keep = keep
+}
+
+// Run writes a backup every interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f, err := s.Create(ctx)
+			if err != nil {
+				s.log.Errorf("backup failed: %s", err)
+				continue
+			}
+			s.log.Infof("backup: wrote %s (%d bytes)", f.Name, f.Size)
+		}
+	}
+}
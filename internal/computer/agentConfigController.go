@@ -0,0 +1,237 @@
+package computer
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/agentconfig"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+type agentConfigController struct {
+	log        lumber.Logger
+	configRepo AgentConfigRepository
+	tagRepo    TagRepository
+	lock       sync.Locker
+}
+
+type AgentConfigController interface {
+	Page(http.ResponseWriter, *http.Request)
+	Create(http.ResponseWriter, *http.Request)
+	Update(http.ResponseWriter, *http.Request)
+	Delete(http.ResponseWriter, *http.Request)
+}
+
+// NewAgentConfigController registers the page managing the configurations
+// returned to the agents at /computers/agent-config, which takes the admin
+// role.
+func NewAgentConfigController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) AgentConfigController {
+	c := &agentConfigController{
+		log:        log,
+		configRepo: NewAgentConfigRepository(db),
+		tagRepo:    NewTagRepository(db),
+		lock:       writeLock(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
+
+	r := router.PathPrefix("/computers/agent-config").Subrouter()
+	r.Handle("", admin.ThenFunc(c.Page)).Methods("GET").Name("agent_configs")
+	r.Handle("", admin.ThenFunc(c.Create)).Methods("POST").Name("agent_config_create")
+	r.Handle("/{id:[0-9]+}", admin.ThenFunc(c.Update)).Methods("POST").Name("agent_config_update")
+	r.Handle("/{id:[0-9]+}/delete", admin.ThenFunc(c.Delete)).Methods("POST").Name("agent_config_delete")
+
+	return c
+}
+
+// agentConfigPageData is rendered by agentConfigPage.
+type agentConfigPageData struct {
+	Title      string
+	Error      string
+	Configs    []AgentConfig
+	Tags       []Tag
+	Collectors []string
+	Default    *agentconfig.Config
+
+	// Form fills the form, it has an ID when a configuration is edited.
+	Form    *AgentConfig
+	Account *account.Account
+	CSRF    string
+}
+
+// Page lists the configurations, ?edit= fills the form with one of them.
+func (c *agentConfigController) Page(w http.ResponseWriter, r *http.Request) {
+	data := &agentConfigPageData{}
+
+	if edit := r.URL.Query().Get("edit"); edit != "" {
+		id, _ := strconv.Atoi(edit)
+		config, err := c.configRepo.Select(r.Context(), id)
+		if err != nil {
+			c.failed(w, r, err)
+			return
+		}
+		if config == nil {
+			http.NotFound(w, r)
+			return
+		}
+		data.Form = config
+	}
+
+	c.render(w, r, http.StatusOK, data)
+}
+
+func (c *agentConfigController) Create(w http.ResponseWriter, r *http.Request) {
+	config, status, msg := c.parse(r)
+	if msg != "" {
+		c.render(w, r, status, &agentConfigPageData{Error: msg, Form: config})
+		return
+	}
+
+	c.lock.Lock()
+	_, err := c.configRepo.Create(r.Context(), config)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/agent-config", http.StatusSeeOther)
+}
+
+// Update saves a configuration under a new revision, the agents using it
+// apply it with their next report.
+func (c *agentConfigController) Update(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	existing, err := c.configRepo.Select(r.Context(), id)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	if existing == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	config, status, msg := c.parse(r)
+	config.ID = existing.ID
+	if msg != "" {
+		c.render(w, r, status, &agentConfigPageData{Error: msg, Form: config})
+		return
+	}
+
+	c.lock.Lock()
+	err = c.configRepo.Update(r.Context(), config)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/agent-config", http.StatusSeeOther)
+}
+
+func (c *agentConfigController) Delete(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.configRepo.Delete(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/agent-config", http.StatusSeeOther)
+}
+
+// parse reads the configuration posted by the form. It returns the status
+// and message to show when the form is invalid.
+func (c *agentConfigController) parse(r *http.Request) (*AgentConfig, int, string) {
+	config := &AgentConfig{}
+	if err := r.ParseForm(); err != nil {
+		return config, http.StatusBadRequest, "The form could not be read."
+	}
+
+	config.Name = null.StringFrom(strings.TrimSpace(r.FormValue("name")))
+	config.Interval = null.StringFrom(strings.TrimSpace(r.FormValue("interval")))
+	config.Collectors = null.StringFrom(strings.Join(r.Form["collectors"], ","))
+	config.Include = null.StringFrom(strings.Join(splitList(r.FormValue("include"), "\n"), "\n"))
+	config.Exclude = null.StringFrom(strings.Join(splitList(r.FormValue("exclude"), "\n"), "\n"))
+
+	if config.Name.String == "" {
+		return config, http.StatusUnprocessableEntity, "A name is required."
+	}
+
+	config.Priority = null.IntFrom(0)
+	if p := strings.TrimSpace(r.FormValue("priority")); p != "" {
+		priority, err := strconv.Atoi(p)
+		if err != nil {
+			return config, http.StatusUnprocessableEntity, "The priority must be a whole number."
+		}
+		config.Priority = null.IntFrom(int64(priority))
+	}
+
+	if tag := r.FormValue("tag"); tag != "" {
+		id, _ := strconv.Atoi(tag)
+		t, err := c.tagRepo.Select(r.Context(), id)
+		if err != nil || t == nil {
+			return config, http.StatusUnprocessableEntity, "Unknown tag."
+		}
+		config.TagID = t.ID
+	}
+
+	if err := config.Document().Validate(); err != nil {
+		return config, http.StatusUnprocessableEntity, "The configuration is invalid: " + err.Error() + "."
+	}
+	return config, 0, ""
+}
+
+func (c *agentConfigController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *agentConfigController) render(w http.ResponseWriter, r *http.Request, status int, data *agentConfigPageData) {
+	data.Title = "Agent Configuration"
+	data.Collectors = agentconfig.Collectors
+	data.Default = agentconfig.Default()
+	data.Account = AccountFromContext(r.Context())
+	data.CSRF = CSRFFromContext(r.Context())
+
+	if data.Form == nil {
+		d := data.Default
+		data.Form = &AgentConfig{
+			Interval:   null.StringFrom(d.Interval),
+			Collectors: null.StringFrom(strings.Join(d.Collectors, ",")),
+			Exclude:    null.StringFrom(strings.Join(d.Exclude, "\n")),
+		}
+	}
+
+	var err error
+	if data.Configs, err = c.configRepo.List(r.Context()); err == nil {
+		data.Tags, err = c.tagRepo.List(r.Context())
+	}
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := agentConfigPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
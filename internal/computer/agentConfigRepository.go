@@ -0,0 +1,331 @@
+package computer
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/agentconfig"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// AgentConfig is the configuration handed to the agents of the computers
+// with a tag, or to every agent without a tag. Of the configurations
+// matching a computer those with a tag win, then the highest Priority.
+type AgentConfig struct {
+	ID      null.Int    `db:"id"`
+	Created null.String `db:"created"`
+	Updated null.String `db:"updated"`
+	Deleted null.String `db:"deleted"`
+
+	Name     null.String `db:"name"`
+	TagID    null.Int    `db:"tag_id"`
+	Priority null.Int    `db:"priority"`
+
+	// Revision is unique across every configuration and raised with every
+	// change, agents apply a configuration when it changes.
+	Revision null.Int `db:"revision"`
+
+	// Collectors is comma separated, Include and Exclude hold a pattern
+	// per line.
+	Interval   null.String `db:"interval"`
+	Collectors null.String `db:"collectors"`
+	Include    null.String `db:"include"`
+	Exclude    null.String `db:"exclude"`
+
+	// TagName is set by List.
+	TagName null.String `db:"tag_name"`
+}
+
+// Document returns the configuration sent to the agents.
+func (c *AgentConfig) Document() *agentconfig.Config {
+	return &agentconfig.Config{
+		Version:    c.Revision.Int64,
+		Interval:   c.Interval.String,
+		Collectors: splitList(c.Collectors.String, ","),
+		Include:    splitList(c.Include.String, "\n"),
+		Exclude:    splitList(c.Exclude.String, "\n"),
+	}
+}
+
+// splitList splits s at sep, leaving out blank items.
+func splitList(s string, sep string) []string {
+	list := []string{}
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+type AgentConfigRepository interface {
+	Install(context.Context) error
+	Create(context.Context, *AgentConfig) (int64, error)
+	Update(context.Context, *AgentConfig) error
+	Select(context.Context, int) (*AgentConfig, error)
+	Delete(context.Context, int) error
+	List(context.Context) ([]AgentConfig, error)
+
+	// ForComputer returns the configuration of the agent of a computer,
+	// nil when none matches.
+	ForComputer(ctx context.Context, computerID int64) (*AgentConfig, error)
+}
+
+type agentConfigRepository struct {
+	db *sqlx.DB
+}
+
+func NewAgentConfigRepository(db *sqlx.DB) AgentConfigRepository {
+	return &agentConfigRepository{
+		db: db,
+	}
+}
+
+func (r *agentConfigRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE agent_configs (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "updated" TEXT,
+            "deleted" TEXT,
+            "name" TEXT NOT NULL,
+            "tag_id" INTEGER,
+            "priority" INTEGER NOT NULL,
+            "revision" INTEGER NOT NULL,
+            "interval" TEXT NOT NULL,
+            "collectors" TEXT,
+            "include" TEXT,
+            "exclude" TEXT`+d.ForeignKey("tag_id", "tags")+`
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// nextRevision returns the revision of the next change.
+func nextRevision(ctx context.Context, tx *sqlx.Tx) (int64, error) {
+	var revision int64
+	err := tx.GetContext(ctx, &revision, `SELECT COALESCE(MAX(revision), 0)+1 FROM agent_configs`)
+	return revision, err
+}
+
+func (r *agentConfigRepository) Create(ctx context.Context, data *AgentConfig) (int64, error) {
+	defer observeQuery("agent_config", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	revision, err := nextRevision(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO agent_configs (
+            created,
+            updated,
+            name,
+            tag_id,
+            priority,
+            revision,
+            "interval",
+            collectors,
+            include,
+            exclude
+        ) VALUES (?,?,?,?,?,?,?,?,?,?)`,
+		now,
+		now,
+		data.Name,
+		data.TagID,
+		data.Priority.Int64,
+		revision,
+		data.Interval,
+		data.Collectors,
+		data.Include,
+		data.Exclude,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+func (r *agentConfigRepository) Update(ctx context.Context, data *AgentConfig) error {
+	defer observeQuery("agent_config", "Update", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	revision, err := nextRevision(ctx, tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		tx.Rebind(`UPDATE agent_configs SET
+            updated=?,
+            name=?,
+            tag_id=?,
+            priority=?,
+            revision=?,
+            "interval"=?,
+            collectors=?,
+            include=?,
+            exclude=?
+        WHERE id=?
+        AND deleted IS NULL`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.Name,
+		data.TagID,
+		data.Priority.Int64,
+		revision,
+		data.Interval,
+		data.Collectors,
+		data.Include,
+		data.Exclude,
+		data.ID,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// agentConfigColumns are the columns of agent_configs c joined with the
+// tags t.
+const agentConfigColumns = `c.id,
+            c.created,
+            c.updated,
+            c.name,
+            c.tag_id,
+            c.priority,
+            c.revision,
+            c."interval",
+            c.collectors,
+            c.include,
+            c.exclude,
+            t.name AS tag_name`
+
+func (r *agentConfigRepository) Select(ctx context.Context, id int) (*AgentConfig, error) {
+	defer observeQuery("agent_config", "Select", time.Now())
+
+	data := AgentConfig{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            `+agentConfigColumns+`
+        FROM agent_configs c
+        LEFT JOIN tags t ON t.id = c.tag_id
+        WHERE c.id=?
+        AND c.deleted IS NULL`),
+		id,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *agentConfigRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("agent_config", "Delete", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE agent_configs SET
+            deleted=?
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+	return err
+}
+
+func (r *agentConfigRepository) List(ctx context.Context) ([]AgentConfig, error) {
+	defer observeQuery("agent_config", "List", time.Now())
+
+	data := []AgentConfig{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            `+agentConfigColumns+`
+        FROM agent_configs c
+        LEFT JOIN tags t ON t.id = c.tag_id
+        WHERE c.deleted IS NULL
+        ORDER BY CASE WHEN c.tag_id IS NULL THEN 1 ELSE 0 END, c.priority DESC, c.id`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *agentConfigRepository) ForComputer(ctx context.Context, computerID int64) (*AgentConfig, error) {
+	defer observeQuery("agent_config", "ForComputer", time.Now())
+
+	data := AgentConfig{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            `+agentConfigColumns+`
+        FROM agent_configs c
+        LEFT JOIN tags t ON t.id = c.tag_id
+        WHERE c.deleted IS NULL
+        AND (c.tag_id IS NULL OR c.tag_id IN (SELECT tag_id
+            FROM computer_tags
+            WHERE computer_id=?))
+        ORDER BY CASE WHEN c.tag_id IS NULL THEN 1 ELSE 0 END, c.priority DESC, c.id
+        LIMIT 1`),
+		computerID,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
@@ -0,0 +1,404 @@
+package computer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Alert kinds. New computers, adapter changes, address conflicts, user
+// spread and privileged users are matched when a computer reports, stale
+// computers on the schedule of Alerter.Run.
+const (
+	AlertNewComputer     = "new_computer"
+	AlertAdapterAdded    = "adapter_added"
+	AlertAdapterRemoved  = "adapter_removed"
+	AlertAddressConflict = "address_conflict"
+	AlertStale           = "stale"
+	AlertUserSpread      = "user_spread"
+	AlertPrivilegedUser  = "privileged_user"
+)
+
+// AlertKinds lists the kinds with their descriptions, in the order they
+// are offered when creating a rule.
+var AlertKinds = []struct{ Kind, Description string }{
+	{AlertNewComputer, "New computer seen"},
+	{AlertAdapterAdded, "Network adapter added"},
+	{AlertAdapterRemoved, "Network adapter removed"},
+	{AlertAddressConflict, "MAC or IP address on another computer"},
+	{AlertStale, "Computer stale for threshold days"},
+	{AlertUserSpread, "User on more than threshold computers in a day"},
+	{AlertPrivilegedUser, "Username matching pattern signed in"},
+}
+
+// Alert severities.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Alert states. AlertActive selects the open and acknowledged alerts when
+// listing.
+const (
+	AlertOpen         = "open"
+	AlertAcknowledged = "acknowledged"
+	AlertResolved     = "resolved"
+	AlertActive       = "active"
+)
+
+// DefaultAlertCooldown is the cooldown in minutes of rules created without
+// one.
+const DefaultAlertCooldown = 60
+
+// alertSystem resolves alerts on behalf of the server.
+const alertSystem = "system"
+
+var ErrAlertState = errors.New("alerts can only be acknowledged or resolved")
+
+// Validate checks the rule, clearing the settings its kind does not use.
+func (r *AlertRule) Validate() error {
+	r.Name.SetValid(strings.TrimSpace(r.Name.String))
+	if r.Name.String == "" {
+		return errors.New("rule name is required")
+	}
+
+	known := false
+	for _, k := range AlertKinds {
+		known = known || k.Kind == r.Kind.String
+	}
+	if !known {
+		return fmt.Errorf("unknown alert kind %q", r.Kind.String)
+	}
+
+	switch r.Severity.String {
+	case "":
+		r.Severity = null.StringFrom(SeverityWarning)
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("unknown severity %q", r.Severity.String)
+	}
+
+	switch r.Kind.String {
+	case AlertStale, AlertUserSpread:
+		if r.Threshold.Int64 < 1 {
+			return errors.New("threshold must be at least 1")
+		}
+	default:
+		r.Threshold = null.Int{}
+	}
+
+	if r.Kind.String == AlertPrivilegedUser {
+		if strings.TrimSpace(r.Pattern.String) == "" {
+			return errors.New("username pattern is required")
+		}
+		if _, err := regexp.Compile(r.Pattern.String); err != nil {
+			return fmt.Errorf("invalid username pattern: %s", err)
+		}
+	} else {
+		r.Pattern = null.String{}
+	}
+
+	if r.Exclude.String == "" {
+		r.Exclude = null.String{}
+	} else if _, err := regexp.Compile(r.Exclude.String); err != nil {
+		return fmt.Errorf("invalid exclude pattern: %s", err)
+	}
+
+	if !r.Cooldown.Valid {
+		r.Cooldown = null.IntFrom(DefaultAlertCooldown)
+	}
+	if r.Cooldown.Int64 < 0 {
+		return errors.New("cooldown can not be negative")
+	}
+	return nil
+}
+
+// excludes reports whether the rule ignores the computer called name.
+func (r AlertRule) excludes(name string) bool {
+	if !r.Exclude.Valid {
+		return false
+	}
+	re, err := regexp.Compile("(?i)" + r.Exclude.String)
+	return err == nil && re.MatchString(name)
+}
+
+// Alerter evaluates the alert rules.
+type Alerter struct {
+	log       lumber.Logger
+	ruleRepo  AlertRuleRepository
+	alertRepo AlertRepository
+	webhooks  *Webhooks
+	outbox    *Outbox
+	lock      sync.Locker
+}
+
+func NewAlerter(db *sqlx.DB, log lumber.Logger) *Alerter {
+	return &Alerter{
+		log:       log,
+		ruleRepo:  NewAlertRuleRepository(db),
+		alertRepo: NewAlertRepository(db),
+		webhooks:  NewWebhooks(db, log),
+		outbox:    NewOutbox(db),
+		lock:      writeLock(db),
+	}
+}
+
+// rules returns the enabled rules of the given kinds.
+func (a *Alerter) rules(ctx context.Context, kinds ...string) ([]AlertRule, error) {
+	list, err := a.ruleRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []AlertRule
+	for _, rule := range list {
+		for _, kind := range kinds {
+			if rule.Kind.String == kind && !rule.Disabled.Valid {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// Ingest evaluates the rules matching reports. The caller holds the write
+// lock.
+func (a *Alerter) Ingest(ctx context.Context, e IngestEvent) error {
+	// A report ends the staleness of a computer.
+	if err := a.alertRepo.ResolveKind(ctx, AlertStale, e.ComputerID, alertSystem); err != nil {
+		return err
+	}
+
+	rules, err := a.rules(ctx, AlertNewComputer, AlertAdapterAdded, AlertAdapterRemoved,
+		AlertAddressConflict, AlertUserSpread, AlertPrivilegedUser)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if rule.excludes(e.Name) {
+			continue
+		}
+
+		switch rule.Kind.String {
+		case AlertNewComputer:
+			if e.New {
+				err = a.raise(ctx, rule, e.ComputerID, fmt.Sprintf("computer:%d", e.ComputerID),
+					fmt.Sprintf("New computer %s reported by %s.", e.Name, e.Username))
+			}
+		case AlertAdapterAdded:
+			err = a.adaptersChanged(ctx, rule, e, e.Added(), "reported the new network adapter")
+		case AlertAdapterRemoved:
+			err = a.adaptersChanged(ctx, rule, e, e.Removed(), "no longer reports the network adapter")
+		case AlertAddressConflict:
+			err = a.conflicts(ctx, rule, e)
+		case AlertUserSpread:
+			err = a.userSpread(ctx, rule, e)
+		case AlertPrivilegedUser:
+			re, compileErr := regexp.Compile("(?i)^(?:" + rule.Pattern.String + ")$")
+			if compileErr == nil && e.Username != "" && re.MatchString(e.Username) {
+				err = a.raise(ctx, rule, e.ComputerID, fmt.Sprintf("user:%d:%s", e.ComputerID, strings.ToLower(e.Username)),
+					fmt.Sprintf("Privileged user %s signed in to %s.", e.Username, e.Name))
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adaptersChanged raises an alert for every adapter added to or removed
+// from the computer, see IngestEvent.Added and Removed.
+func (a *Alerter) adaptersChanged(ctx context.Context, rule AlertRule, e IngestEvent, adapters []NetworkAdapter, change string) error {
+	for _, na := range adapters {
+		mac := strings.ToLower(na.MacAddress.String)
+		err := a.raise(ctx, rule, e.ComputerID, fmt.Sprintf("adapter:%d:%s", e.ComputerID, mac),
+			fmt.Sprintf("%s %s %s (%s).", e.Name, change, na.Name.String, na.MacAddress.String))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// conflicts raises an alert for every reported MAC address recorded on
+// another computer, and every reported IP address held by another computer
+// that reported recently.
+func (a *Alerter) conflicts(ctx context.Context, rule AlertRule, e IngestEvent) error {
+	var macs, ips []string
+	for _, na := range e.Adapters {
+		if mac := strings.ToLower(na.MacAddress.String); mac != "" {
+			macs = append(macs, mac)
+		}
+		for _, ip := range parseIPs(na.IPAddress.String) {
+			if !ip.IsLoopback() && !ip.IsLinkLocalUnicast() {
+				ips = append(ips, ip.String())
+			}
+		}
+	}
+
+	shared, err := a.alertRepo.SharedAdapters(ctx, e.ComputerID, macs, ips, time.Now().Add(-StaleAfter))
+	if err != nil {
+		return err
+	}
+
+	for _, na := range shared {
+		if rule.excludes(na.ComputerName.String) {
+			continue
+		}
+
+		if mac := strings.ToLower(na.MacAddress.String); contains(macs, mac) {
+			err = a.raise(ctx, rule, e.ComputerID, "mac:"+mac,
+				fmt.Sprintf("MAC address %s reported by %s is also recorded on %s.", na.MacAddress.String, e.Name, na.ComputerName.String))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, ip := range parseIPs(na.IPAddress.String) {
+			if contains(ips, ip.String()) {
+				err = a.raise(ctx, rule, e.ComputerID, "ip:"+ip.String(),
+					fmt.Sprintf("IP address %s reported by %s is also held by %s.", ip, e.Name, na.ComputerName.String))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// userSpread raises an alert when the reporting user signed in to more
+// than the threshold of computers during the last day.
+func (a *Alerter) userSpread(ctx context.Context, rule AlertRule, e IngestEvent) error {
+	if e.Username == "" {
+		return nil
+	}
+
+	computers, err := a.alertRepo.UserComputers(ctx, e.Username, time.Now().Add(-time.Hour*24))
+	if err != nil {
+		return err
+	}
+	if int64(len(computers)) <= rule.Threshold.Int64 {
+		return nil
+	}
+
+	names := make([]string, len(computers))
+	for i, c := range computers {
+		names[i] = c.Name.String
+	}
+	return a.raise(ctx, rule, 0, "user:"+strings.ToLower(e.Username),
+		fmt.Sprintf("%s signed in to %d computers during the last day: %s.", e.Username, len(computers), strings.Join(names, ", ")))
+}
+
+// Evaluate runs the scheduled rules.
+func (a *Alerter) Evaluate(ctx context.Context) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	rules, err := a.rules(ctx, AlertStale)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		days := rule.Threshold.Int64
+		stale, err := a.alertRepo.Stale(ctx, time.Now().AddDate(0, 0, -int(days)))
+		if err != nil {
+			return err
+		}
+
+		for _, c := range stale {
+			if rule.excludes(c.Name.String) {
+				continue
+			}
+			err = a.raise(ctx, rule, c.ID.Int64, fmt.Sprintf("computer:%d", c.ID.Int64),
+				fmt.Sprintf("%s has not reported for more than %d days, last seen %s.", c.Name.String, days, c.LastSeen.String))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Run evaluates the scheduled rules every interval until ctx is cancelled.
+func (a *Alerter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Evaluate(ctx); err != nil {
+				a.log.Errorf("alerts: evaluation failed: %s", err)
+			}
+		}
+	}
+}
+
+// raise records an alert of rule for key. An alert of the rule with the
+// same key that has not been resolved is repeated instead, and none is
+// raised during the cooldown after the last one was resolved. computerID
+// is 0 for alerts not about a single computer.
+func (a *Alerter) raise(ctx context.Context, rule AlertRule, computerID int64, key string, message string) error {
+	latest, err := a.alertRepo.Latest(ctx, rule.ID.Int64, key)
+	if err != nil {
+		return err
+	}
+
+	if latest != nil {
+		if latest.State.String != AlertResolved {
+			return a.alertRepo.Repeat(ctx, latest.ID.Int64, message)
+		}
+		resolved, err := time.ParseInLocation("2006-01-02 15:04:05", latest.Resolved.String, time.Local)
+		if err == nil && time.Since(resolved) < time.Duration(rule.Cooldown.Int64)*time.Minute {
+			return nil
+		}
+	}
+
+	alert := &Alert{
+		RuleID:   rule.ID,
+		Kind:     rule.Kind,
+		Severity: rule.Severity,
+		Key:      null.StringFrom(key),
+		Message:  null.StringFrom(message),
+	}
+	if computerID > 0 {
+		alert.ComputerID = null.IntFrom(computerID)
+	}
+
+	id, err := a.alertRepo.Create(ctx, alert)
+	if err != nil {
+		return err
+	}
+
+	alertsTotal.Inc(rule.Kind.String, rule.Severity.String)
+	a.log.Infof("alert: %s", message)
+
+	if alert, err = a.alertRepo.Select(ctx, int(id)); err != nil || alert == nil {
+		return err
+	}
+	event := NewEvent(EventAlertRaised)
+	event.Alert = alert
+	if alert.ComputerID.Valid {
+		event.Computer = &Computer{ID: alert.ComputerID, Name: alert.ComputerName}
+	}
+	if err = a.webhooks.Enqueue(ctx, event); err != nil {
+		return err
+	}
+	return a.outbox.Alert(ctx, alert)
+}
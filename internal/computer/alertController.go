@@ -0,0 +1,253 @@
+package computer
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+// maxAlerts limits the alerts listed on the alerts page.
+const maxAlerts = 500
+
+type alertController struct {
+	log       lumber.Logger
+	ruleRepo  AlertRuleRepository
+	alertRepo AlertRepository
+	lock      sync.Locker
+}
+
+type AlertController interface {
+	Page(http.ResponseWriter, *http.Request)
+	Acknowledge(http.ResponseWriter, *http.Request)
+	Resolve(http.ResponseWriter, *http.Request)
+	CreateRule(http.ResponseWriter, *http.Request)
+	DeleteRule(http.ResponseWriter, *http.Request)
+	EnableRule(http.ResponseWriter, *http.Request)
+	DisableRule(http.ResponseWriter, *http.Request)
+}
+
+// NewAlertController registers the alerts page at /computers/alerts.
+// Alerts are acknowledged and resolved by the helpdesk role, changing the
+// rules takes the admin role.
+func NewAlertController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) AlertController {
+	c := &alertController{
+		log:       log,
+		ruleRepo:  NewAlertRuleRepository(db),
+		alertRepo: NewAlertRepository(db),
+		lock:      writeLock(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+	helpdesk := alice.New(m...).Append(guard(account.RoleHelpdesk))
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
+
+	r := router.PathPrefix("/computers/alerts").Subrouter()
+	r.Handle("", view.ThenFunc(c.Page)).Methods("GET").Name("alerts")
+	r.Handle("/{id:[0-9]+}/acknowledge", helpdesk.ThenFunc(c.Acknowledge)).Methods("POST").Name("alert_acknowledge")
+	r.Handle("/{id:[0-9]+}/resolve", helpdesk.ThenFunc(c.Resolve)).Methods("POST").Name("alert_resolve")
+	r.Handle("/rules", admin.ThenFunc(c.CreateRule)).Methods("POST").Name("alert_rule_create")
+	r.Handle("/rules/{id:[0-9]+}/delete", admin.ThenFunc(c.DeleteRule)).Methods("POST").Name("alert_rule_delete")
+	r.Handle("/rules/{id:[0-9]+}/enable", admin.ThenFunc(c.EnableRule)).Methods("POST").Name("alert_rule_enable")
+	r.Handle("/rules/{id:[0-9]+}/disable", admin.ThenFunc(c.DisableRule)).Methods("POST").Name("alert_rule_disable")
+
+	return c
+}
+
+// alertPageData is rendered by alertPage.
+type alertPageData struct {
+	Title      string
+	Error      string
+	State      string
+	States     []string
+	Alerts     []Alert
+	Rules      []AlertRule
+	Kinds      []struct{ Kind, Description string }
+	Severities []string
+	Account    *account.Account
+	CSRF       string
+}
+
+// alertStates are offered as filters on the alerts page, "all" listing
+// every state.
+var alertStates = []string{AlertActive, AlertOpen, AlertAcknowledged, AlertResolved, "all"}
+
+// alertState returns the state filter of the request, AlertActive by
+// default and "" for every state.
+func alertState(r *http.Request) string {
+	state := r.URL.Query().Get("state")
+	switch state {
+	case "":
+		return AlertActive
+	case "all":
+		return ""
+	case AlertActive, AlertOpen, AlertAcknowledged, AlertResolved:
+		return state
+	}
+	return AlertActive
+}
+
+func (c *alertController) Page(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, http.StatusOK, "")
+}
+
+func (c *alertController) Acknowledge(w http.ResponseWriter, r *http.Request) {
+	c.setState(w, r, AlertAcknowledged)
+}
+
+func (c *alertController) Resolve(w http.ResponseWriter, r *http.Request) {
+	c.setState(w, r, AlertResolved)
+}
+
+func (c *alertController) setState(w http.ResponseWriter, r *http.Request, state string) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	by := ""
+	if acct := AccountFromContext(r.Context()); acct != nil {
+		by = acct.Username.String
+	}
+
+	c.lock.Lock()
+	err := c.alertRepo.SetState(r.Context(), id, state, by)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/alerts", http.StatusSeeOther)
+}
+
+func (c *alertController) CreateRule(w http.ResponseWriter, r *http.Request) {
+	rule := &AlertRule{
+		Name:     null.StringFrom(r.FormValue("name")),
+		Kind:     null.StringFrom(r.FormValue("kind")),
+		Severity: null.StringFrom(r.FormValue("severity")),
+		Pattern:  null.StringFrom(strings.TrimSpace(r.FormValue("pattern"))),
+		Exclude:  null.StringFrom(strings.TrimSpace(r.FormValue("exclude"))),
+	}
+
+	for _, field := range []struct {
+		name  string
+		value *null.Int
+	}{
+		{"threshold", &rule.Threshold},
+		{"cooldown", &rule.Cooldown},
+	} {
+		v := r.FormValue(field.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.render(w, r, http.StatusUnprocessableEntity, "The "+field.name+" must be a number.")
+			return
+		}
+		*field.value = null.IntFrom(n)
+	}
+
+	if err := rule.Validate(); err != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	c.lock.Lock()
+	_, err := c.ruleRepo.Create(r.Context(), rule)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/alerts", http.StatusSeeOther)
+}
+
+func (c *alertController) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.ruleRepo.Delete(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/alerts", http.StatusSeeOther)
+}
+
+func (c *alertController) EnableRule(w http.ResponseWriter, r *http.Request) {
+	c.setDisabled(w, r, false)
+}
+
+func (c *alertController) DisableRule(w http.ResponseWriter, r *http.Request) {
+	c.setDisabled(w, r, true)
+}
+
+func (c *alertController) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.ruleRepo.SetDisabled(r.Context(), id, disabled)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/alerts", http.StatusSeeOther)
+}
+
+func (c *alertController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrAlertState) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *alertController) render(w http.ResponseWriter, r *http.Request, status int, message string) {
+	state := alertState(r)
+	data := &alertPageData{
+		Title:      "Alerts",
+		Error:      message,
+		State:      state,
+		States:     alertStates,
+		Kinds:      AlertKinds,
+		Severities: []string{SeverityInfo, SeverityWarning, SeverityCritical},
+		Account:    AccountFromContext(r.Context()),
+		CSRF:       CSRFFromContext(r.Context()),
+	}
+	if state == "" {
+		data.State = "all"
+	}
+
+	var err error
+	if data.Alerts, err = c.alertRepo.List(r.Context(), state, maxAlerts); err == nil {
+		data.Rules, err = c.ruleRepo.List(r.Context())
+	}
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := alertPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
@@ -0,0 +1,652 @@
+package computer
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// AlertRule raises alerts of its kind, see Alerter.
+type AlertRule struct {
+	ID       null.Int    `db:"id" json:"id"`
+	Created  null.String `db:"created" json:"-"`
+	Deleted  null.String `db:"deleted" json:"-"`
+	Disabled null.String `db:"disabled" json:"disabled"`
+
+	Name     null.String `db:"name" json:"name"`
+	Kind     null.String `db:"kind" json:"kind"`
+	Severity null.String `db:"severity" json:"severity"`
+
+	// Threshold is the number of days for stale rules and of computers for
+	// user spread rules.
+	Threshold null.Int `db:"threshold" json:"threshold"`
+
+	// Pattern matches the usernames of privileged user rules.
+	Pattern null.String `db:"pattern" json:"pattern"`
+
+	// Exclude matches the names of computers the rule ignores.
+	Exclude null.String `db:"exclude" json:"exclude"`
+
+	// Cooldown is the number of minutes after an alert is resolved during
+	// which the rule does not raise it again.
+	Cooldown null.Int `db:"cooldown" json:"cooldown"`
+}
+
+// Alert is raised by a rule. Repeats of an alert that has not been resolved
+// only increase Count.
+type Alert struct {
+	ID         null.Int    `db:"id" json:"id"`
+	Created    null.String `db:"created" json:"created"`
+	Updated    null.String `db:"updated" json:"updated"`
+	RuleID     null.Int    `db:"rule_id" json:"rule_id"`
+	Kind       null.String `db:"kind" json:"kind"`
+	Severity   null.String `db:"severity" json:"severity"`
+	ComputerID null.Int    `db:"computer_id" json:"computer_id"`
+	Key        null.String `db:"key" json:"key"`
+	Message    null.String `db:"message" json:"message"`
+	Count      null.Int    `db:"count" json:"count"`
+	State      null.String `db:"state" json:"state"`
+
+	Acknowledged   null.String `db:"acknowledged" json:"acknowledged"`
+	AcknowledgedBy null.String `db:"acknowledged_by" json:"acknowledged_by"`
+	Resolved       null.String `db:"resolved" json:"resolved"`
+	ResolvedBy     null.String `db:"resolved_by" json:"resolved_by"`
+
+	// RuleName and ComputerName are set by List.
+	RuleName     null.String `db:"rule_name" json:"rule_name,omitempty"`
+	ComputerName null.String `db:"computer_name" json:"computer_name,omitempty"`
+}
+
+// AlertTarget is a computer matched by a scheduled rule.
+type AlertTarget struct {
+	ID       null.Int    `db:"id"`
+	Name     null.String `db:"name"`
+	LastSeen null.String `db:"last_seen"`
+}
+
+type AlertRuleRepository interface {
+	Install(context.Context) error
+	Create(context.Context, *AlertRule) (int64, error)
+	Delete(context.Context, int) error
+	SetDisabled(ctx context.Context, id int, disabled bool) error
+	List(context.Context) ([]AlertRule, error)
+}
+
+type alertRuleRepository struct {
+	db *sqlx.DB
+}
+
+func NewAlertRuleRepository(db *sqlx.DB) AlertRuleRepository {
+	return &alertRuleRepository{
+		db: db,
+	}
+}
+
+func (r *alertRuleRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE alert_rules (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "deleted" TEXT,
+            "disabled" TEXT,
+            "name" TEXT NOT NULL,
+            "kind" TEXT NOT NULL,
+            "severity" TEXT NOT NULL,
+            "threshold" INTEGER,
+            "pattern" TEXT,
+            "exclude" TEXT,
+            "cooldown" INTEGER
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *alertRuleRepository) Create(ctx context.Context, data *AlertRule) (int64, error) {
+	defer observeQuery("alert_rule", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO alert_rules (
+            created,
+            name,
+            kind,
+            severity,
+            threshold,
+            pattern,
+            exclude,
+            cooldown
+        ) VALUES (?,?,?,?,?,?,?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.Name,
+		data.Kind,
+		data.Severity,
+		data.Threshold,
+		data.Pattern,
+		data.Exclude,
+		data.Cooldown,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+func (r *alertRuleRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("alert_rule", "Delete", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE alert_rules SET
+            deleted=?
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+	return err
+}
+
+func (r *alertRuleRepository) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	defer observeQuery("alert_rule", "SetDisabled", time.Now())
+
+	var value null.String
+	if disabled {
+		value = null.StringFrom(time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE alert_rules SET
+            disabled=?
+        WHERE id=?`),
+		value,
+		id,
+	)
+	return err
+}
+
+func (r *alertRuleRepository) List(ctx context.Context) ([]AlertRule, error) {
+	defer observeQuery("alert_rule", "List", time.Now())
+
+	data := []AlertRule{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            disabled,
+            name,
+            kind,
+            severity,
+            threshold,
+            pattern,
+            exclude,
+            cooldown
+        FROM alert_rules
+        WHERE deleted IS NULL
+        ORDER BY name`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+type AlertRepository interface {
+	Install(context.Context) error
+	Create(context.Context, *Alert) (int64, error)
+	Select(context.Context, int) (*Alert, error)
+
+	// Latest returns the newest alert of the rule with the key, nil when
+	// the rule never raised it.
+	Latest(ctx context.Context, ruleID int64, key string) (*Alert, error)
+
+	// Repeat counts another occurrence of an alert.
+	Repeat(ctx context.Context, id int64, message string) error
+
+	// SetState acknowledges or resolves an alert on behalf of by.
+	SetState(ctx context.Context, id int, state string, by string) error
+
+	// ResolveKind resolves the alerts of kind raised for a computer.
+	ResolveKind(ctx context.Context, kind string, computerID int64, by string) error
+
+	// List returns the newest alerts in state, every state when empty and
+	// the open and acknowledged ones for AlertActive.
+	List(ctx context.Context, state string, limit int) ([]Alert, error)
+
+	// CountActive returns the number of open and acknowledged alerts.
+	CountActive(context.Context) (int, error)
+
+	// Stale returns the live computers that last reported before before.
+	Stale(ctx context.Context, before time.Time) ([]AlertTarget, error)
+
+	// UserComputers returns the computers username signed in to since
+	// since.
+	UserComputers(ctx context.Context, username string, since time.Time) ([]AlertTarget, error)
+
+	// SharedAdapters returns the live adapters of other computers having
+	// one of the MAC addresses, or holding an address that contains one
+	// of ips and reported since since.
+	SharedAdapters(ctx context.Context, computerID int64, macs []string, ips []string, since time.Time) ([]NetworkAdapter, error)
+}
+
+type alertRepository struct {
+	db *sqlx.DB
+}
+
+func NewAlertRepository(db *sqlx.DB) AlertRepository {
+	return &alertRepository{
+		db: db,
+	}
+}
+
+func (r *alertRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE alerts (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "updated" TEXT,
+            "rule_id" INTEGER NOT NULL,
+            "kind" TEXT NOT NULL,
+            "severity" TEXT NOT NULL,
+            "computer_id" INTEGER,
+            "key" TEXT NOT NULL,
+            "message" TEXT,
+            "count" INTEGER NOT NULL,
+            "state" TEXT NOT NULL,
+            "acknowledged" TEXT,
+            "acknowledged_by" TEXT,
+            "resolved" TEXT,
+            "resolved_by" TEXT`+d.ForeignKey("rule_id", "alert_rules")+`
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX alerts_rule_key ON alerts (rule_id, "key")`)
+	return err
+}
+
+func (r *alertRepository) Create(ctx context.Context, data *Alert) (int64, error) {
+	defer observeQuery("alert", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO alerts (
+            created,
+            updated,
+            rule_id,
+            kind,
+            severity,
+            computer_id,
+            "key",
+            message,
+            count,
+            state
+        ) VALUES (?,?,?,?,?,?,?,?,?,?)`,
+		now,
+		now,
+		data.RuleID,
+		data.Kind,
+		data.Severity,
+		data.ComputerID,
+		data.Key,
+		data.Message,
+		1,
+		AlertOpen,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+// alertColumns are selected by every query returning alerts.
+const alertColumns = `a.id,
+            a.created,
+            a.updated,
+            a.rule_id,
+            a.kind,
+            a.severity,
+            a.computer_id,
+            a."key",
+            a.message,
+            a.count,
+            a.state,
+            a.acknowledged,
+            a.acknowledged_by,
+            a.resolved,
+            a.resolved_by,
+            r.name AS rule_name,
+            c.name AS computer_name`
+
+func (r *alertRepository) Select(ctx context.Context, id int) (*Alert, error) {
+	defer observeQuery("alert", "Select", time.Now())
+
+	return r.get(ctx, `a.id=?`, id)
+}
+
+func (r *alertRepository) Latest(ctx context.Context, ruleID int64, key string) (*Alert, error) {
+	defer observeQuery("alert", "Latest", time.Now())
+
+	return r.get(ctx, `a.rule_id=? AND a."key"=? ORDER BY a.id DESC LIMIT 1`, ruleID, key)
+}
+
+func (r *alertRepository) get(ctx context.Context, where string, args ...interface{}) (*Alert, error) {
+	data := Alert{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            `+alertColumns+`
+        FROM alerts a
+        LEFT JOIN alert_rules r ON r.id = a.rule_id
+        LEFT JOIN computers c ON c.id = a.computer_id
+        WHERE `+where),
+		args...,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *alertRepository) Repeat(ctx context.Context, id int64, message string) error {
+	defer observeQuery("alert", "Repeat", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE alerts SET
+            updated=?,
+            message=?,
+            count=count+1
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		message,
+		id,
+	)
+	return err
+}
+
+func (r *alertRepository) SetState(ctx context.Context, id int, state string, by string) error {
+	defer observeQuery("alert", "SetState", time.Now())
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+
+	var err error
+	switch state {
+	case AlertAcknowledged:
+		_, err = r.db.ExecContext(
+			ctx,
+			r.db.Rebind(`UPDATE alerts SET
+                state=?,
+                acknowledged=?,
+                acknowledged_by=?
+            WHERE id=?
+            AND state=?`),
+			AlertAcknowledged,
+			now,
+			by,
+			id,
+			AlertOpen,
+		)
+	case AlertResolved:
+		_, err = r.db.ExecContext(
+			ctx,
+			r.db.Rebind(`UPDATE alerts SET
+                state=?,
+                resolved=?,
+                resolved_by=?
+            WHERE id=?
+            AND state<>?`),
+			AlertResolved,
+			now,
+			by,
+			id,
+			AlertResolved,
+		)
+	default:
+		return ErrAlertState
+	}
+	return err
+}
+
+func (r *alertRepository) ResolveKind(ctx context.Context, kind string, computerID int64, by string) error {
+	defer observeQuery("alert", "ResolveKind", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE alerts SET
+            state=?,
+            resolved=?,
+            resolved_by=?
+        WHERE kind=?
+        AND computer_id=?
+        AND state<>?`),
+		AlertResolved,
+		time.Now().Format("2006-01-02 15:04:05"),
+		by,
+		kind,
+		computerID,
+		AlertResolved,
+	)
+	return err
+}
+
+func (r *alertRepository) List(ctx context.Context, state string, limit int) ([]Alert, error) {
+	defer observeQuery("alert", "List", time.Now())
+
+	where := `1=1`
+	var args []interface{}
+
+	switch state {
+	case "":
+	case AlertActive:
+		where = `a.state<>?`
+		args = append(args, AlertResolved)
+	default:
+		where = `a.state=?`
+		args = append(args, state)
+	}
+	args = append(args, limit)
+
+	data := []Alert{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            `+alertColumns+`
+        FROM alerts a
+        LEFT JOIN alert_rules r ON r.id = a.rule_id
+        LEFT JOIN computers c ON c.id = a.computer_id
+        WHERE `+where+`
+        ORDER BY a.updated DESC, a.id DESC
+        LIMIT ?`),
+		args...,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *alertRepository) CountActive(ctx context.Context) (int, error) {
+	defer observeQuery("alert", "CountActive", time.Now())
+
+	var count int
+
+	err := r.db.GetContext(
+		ctx,
+		&count,
+		r.db.Rebind(`SELECT COUNT(*) FROM alerts WHERE state<>?`),
+		AlertResolved,
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+func (r *alertRepository) Stale(ctx context.Context, before time.Time) ([]AlertTarget, error) {
+	defer observeQuery("alert", "Stale", time.Now())
+
+	data := []AlertTarget{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            name,
+            COALESCE(updated, created) AS last_seen
+        FROM computers
+        WHERE deleted IS NULL
+        AND COALESCE(updated, created) < ?
+        ORDER BY id`),
+		before.Format("2006-01-02 15:04:05"),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *alertRepository) UserComputers(ctx context.Context, username string, since time.Time) ([]AlertTarget, error) {
+	defer observeQuery("alert", "UserComputers", time.Now())
+
+	data := []AlertTarget{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            c.id,
+            c.name,
+            MAX(COALESCE(u.updated, u.created)) AS last_seen
+        FROM computer_users u
+        JOIN computers c ON c.id = u.computer_id
+        WHERE u.deleted IS NULL
+        AND c.deleted IS NULL
+        AND LOWER(u.username) = LOWER(?)
+        AND COALESCE(u.updated, u.created) >= ?
+        GROUP BY c.id, c.name
+        ORDER BY c.name`),
+		username,
+		since.Format("2006-01-02 15:04:05"),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *alertRepository) SharedAdapters(ctx context.Context, computerID int64, macs []string, ips []string, since time.Time) ([]NetworkAdapter, error) {
+	defer observeQuery("alert", "SharedAdapters", time.Now())
+
+	if len(macs) == 0 && len(ips) == 0 {
+		return nil, nil
+	}
+
+	var match []string
+	args := []interface{}{computerID}
+
+	if len(macs) > 0 {
+		q, a, err := sqlx.In(`LOWER(na.mac_address) IN (?)`, macs)
+		if err != nil {
+			return nil, err
+		}
+		match = append(match, q)
+		args = append(args, a...)
+	}
+
+	for _, ip := range ips {
+		match = append(match, `(na.ip_address LIKE ? AND COALESCE(c.updated, c.created) >= ?)`)
+		args = append(args, "%"+ip+"%", since.Format("2006-01-02 15:04:05"))
+	}
+
+	data := []NetworkAdapter{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            na.id,
+            na.computer_id,
+            na.name,
+            na.mac_address,
+            na.ip_address,
+            c.name AS computer_name
+        FROM computer_network_adapters na
+        JOIN computers c ON c.id = na.computer_id
+        WHERE na.deleted IS NULL
+        AND c.deleted IS NULL
+        AND na.computer_id <> ?
+        AND (`+strings.Join(match, " OR ")+`)
+        ORDER BY na.id`),
+		args...,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,294 @@
+package computer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+)
+
+type apiController struct {
+	log       lumber.Logger
+	links     *links
+	merger    *Merger
+	alertRepo AlertRepository
+	lock      sync.Locker
+}
+
+type APIController interface {
+	Computers(http.ResponseWriter, *http.Request)
+	Computer(http.ResponseWriter, *http.Request)
+	UpdateComputer(http.ResponseWriter, *http.Request)
+	DeleteComputer(http.ResponseWriter, *http.Request)
+	Tags(http.ResponseWriter, *http.Request)
+	Locations(http.ResponseWriter, *http.Request)
+	Subnets(http.ResponseWriter, *http.Request)
+	Fields(http.ResponseWriter, *http.Request)
+	Duplicates(http.ResponseWriter, *http.Request)
+	Search(http.ResponseWriter, *http.Request)
+	Alerts(http.ResponseWriter, *http.Request)
+	MergeComputer(http.ResponseWriter, *http.Request)
+}
+
+// NewAPIController registers the JSON API below /api/v1. Reading takes the
+// viewer role, changing details the helpdesk role and merging and deleting
+// the admin role.
+func NewAPIController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) APIController {
+	c := &apiController{
+		log:       log,
+		links:     newLinks(db),
+		merger:    NewMerger(db),
+		alertRepo: NewAlertRepository(db),
+		lock:      writeLock(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+	helpdesk := alice.New(m...).Append(guard(account.RoleHelpdesk))
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
+
+	r := router.PathPrefix("/api/v1").Subrouter()
+	r.Handle("/computers", view.ThenFunc(c.Computers)).Methods("GET").Name("api_computers")
+	r.Handle("/computers/{id:[0-9]+}", view.ThenFunc(c.Computer)).Methods("GET").Name("api_computer")
+	r.Handle("/computers/{id:[0-9]+}", helpdesk.ThenFunc(c.UpdateComputer)).Methods("PATCH").Name("api_computer_update")
+	r.Handle("/computers/{id:[0-9]+}", admin.ThenFunc(c.DeleteComputer)).Methods("DELETE").Name("api_computer_delete")
+	r.Handle("/tags", view.ThenFunc(c.Tags)).Methods("GET").Name("api_tags")
+	r.Handle("/locations", view.ThenFunc(c.Locations)).Methods("GET").Name("api_locations")
+	r.Handle("/subnets", view.ThenFunc(c.Subnets)).Methods("GET").Name("api_subnets")
+	r.Handle("/fields", view.ThenFunc(c.Fields)).Methods("GET").Name("api_fields")
+	r.Handle("/search", view.ThenFunc(c.Search)).Methods("GET").Name("api_search")
+	r.Handle("/duplicates", view.ThenFunc(c.Duplicates)).Methods("GET").Name("api_duplicates")
+	r.Handle("/alerts", view.ThenFunc(c.Alerts)).Methods("GET").Name("api_alerts")
+	r.Handle("/computers/{id:[0-9]+}/merge", admin.ThenFunc(c.MergeComputer)).Methods("POST").Name("api_computer_merge")
+
+	return c
+}
+
+// Computers returns a page of computers with their tags and locations,
+// filtered by the q, tag, location, subnet, days and page query parameters.
+func (c *apiController) Computers(w http.ResponseWriter, r *http.Request) {
+	f := FilterFromRequest(r)
+
+	list, err := c.links.summaries(r.Context(), f)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	var response struct {
+		Page      int               `json:"page"`
+		Next      bool              `json:"next"`
+		Computers []ComputerSummary `json:"computers"`
+	}
+	response.Page = f.Page()
+	response.Next = len(list) == f.Count
+	response.Computers = list
+
+	c.write(w, r, http.StatusOK, &response)
+}
+
+func (c *apiController) Computer(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	comp, err := c.links.detail(r.Context(), id)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	if comp == nil {
+		c.write(w, r, http.StatusNotFound, map[string]string{"error": "computer not found"})
+		return
+	}
+
+	c.write(w, r, http.StatusOK, comp)
+}
+
+// UpdateComputer changes the owner, location, notes and custom fields of a
+// computer from a DetailChange, answering with the updated computer.
+func (c *apiController) UpdateComputer(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	var change DetailChange
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&change); err != nil {
+		c.write(w, r, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	c.lock.Lock()
+	found, err := c.links.save(r.Context(), id, change)
+	c.lock.Unlock()
+
+	var invalid *DetailError
+	switch {
+	case errors.As(err, &invalid):
+		c.write(w, r, http.StatusUnprocessableEntity, map[string]string{"error": invalid.Message})
+		return
+	case err != nil:
+		c.failed(w, r, err)
+		return
+	case !found:
+		c.write(w, r, http.StatusNotFound, map[string]string{"error": "computer not found"})
+		return
+	}
+
+	c.Computer(w, r)
+}
+
+// DeleteComputer removes a computer from the inventory, answering 204.
+func (c *apiController) DeleteComputer(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	comp, err := c.links.computerRepo.SelectWithID(r.Context(), id)
+	if err == nil && comp != nil {
+		c.lock.Lock()
+		err = c.links.computerRepo.Delete(r.Context(), id)
+		c.lock.Unlock()
+	}
+
+	switch {
+	case err != nil:
+		c.failed(w, r, err)
+	case comp == nil:
+		c.write(w, r, http.StatusNotFound, map[string]string{"error": "computer not found"})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (c *apiController) Tags(w http.ResponseWriter, r *http.Request) {
+	list, err := c.links.tagRepo.List(r.Context())
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.write(w, r, http.StatusOK, map[string][]Tag{"tags": list})
+}
+
+func (c *apiController) Locations(w http.ResponseWriter, r *http.Request) {
+	list, err := c.links.locationRepo.List(r.Context())
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.write(w, r, http.StatusOK, map[string][]Location{"locations": list})
+}
+
+// Subnets returns the subnets with the number of computers and addresses
+// seen in each.
+func (c *apiController) Subnets(w http.ResponseWriter, r *http.Request) {
+	list, err := c.links.subnetRepo.Usage(r.Context(), time.Now().Add(-StaleAfter))
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	type usage struct {
+		Subnet
+		Size        int     `json:"size"`
+		Utilisation float64 `json:"utilisation"`
+	}
+
+	subnets := make([]usage, len(list))
+	for i, s := range list {
+		subnets[i] = usage{Subnet: s, Size: s.Size(), Utilisation: s.Utilisation()}
+	}
+	c.write(w, r, http.StatusOK, map[string][]usage{"subnets": subnets})
+}
+
+func (c *apiController) Fields(w http.ResponseWriter, r *http.Request) {
+	list, err := c.links.fieldRepo.List(r.Context())
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.write(w, r, http.StatusOK, map[string][]Field{"fields": list})
+}
+
+// Duplicates returns the pairs of computers that are probably the same
+// machine.
+func (c *apiController) Duplicates(w http.ResponseWriter, r *http.Request) {
+	list, err := c.links.mergeRepo.Duplicates(r.Context())
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.write(w, r, http.StatusOK, map[string][]Duplicate{"duplicates": list})
+}
+
+// Search finds computers by host name, user name, MAC or IP address, see
+// ParseSearch. The q parameter holds the query.
+func (c *apiController) Search(w http.ResponseWriter, r *http.Request) {
+	result, err := c.links.searchRepo.Search(r.Context(), ParseSearch(r.URL.Query().Get("q")))
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.write(w, r, http.StatusOK, result)
+}
+
+// Alerts returns the newest alerts, filtered by the state parameter as on
+// the alerts page.
+func (c *apiController) Alerts(w http.ResponseWriter, r *http.Request) {
+	list, err := c.alertRepo.List(r.Context(), alertState(r), maxAlerts)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.write(w, r, http.StatusOK, map[string][]Alert{"alerts": list})
+}
+
+// MergeComputer merges the computer given as duplicate in the JSON body
+// into the one in the path, answering with the merge.
+func (c *apiController) MergeComputer(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+	var body struct {
+		Duplicate int64 `json:"duplicate"`
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		c.write(w, r, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	merge, err := c.merger.Merge(r.Context(), id, body.Duplicate)
+	switch {
+	case errors.Is(err, ErrMergeSelf):
+		c.write(w, r, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	case err != nil:
+		c.failed(w, r, err)
+	case merge == nil:
+		c.write(w, r, http.StatusNotFound, map[string]string{"error": "computer not found"})
+	default:
+		c.write(w, r, http.StatusOK, merge)
+	}
+}
+
+func (c *apiController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	c.write(w, r, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+}
+
+func (c *apiController) write(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
@@ -0,0 +1,229 @@
+package computer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	// checkinWindow is the period the check-in report and the sparklines
+	// look back over.
+	checkinWindow = 30 * 24 * time.Hour
+
+	// checkinTolerance is how many times the expected interval may pass
+	// between check-ins before an agent is reported.
+	checkinTolerance = 2
+
+	// sparklineWidth and sparklineHeight size the check-in sparkline.
+	sparklineWidth  = 120
+	sparklineHeight = 24
+)
+
+// Sparkline charts the daily check-ins of a computer.
+type Sparkline struct {
+	Days   []CheckinCount
+	Total  int64
+	Max    int64
+	Width  int
+	Height int
+
+	// Points are the SVG polyline coordinates.
+	Points string
+}
+
+// sparkline returns the daily counts of the days up to now, including
+// the days without check-ins.
+func sparkline(counts []CheckinCount, now time.Time, days int) *Sparkline {
+	byDay := map[string]int64{}
+	for _, c := range counts {
+		byDay[c.Day.String] = c.Checkins.Int64
+	}
+
+	s := &Sparkline{Width: sparklineWidth, Height: sparklineHeight}
+	for i := days - 1; i >= 0; i-- {
+		day := now.AddDate(0, 0, -i).Format("2006-01-02")
+		n := byDay[day]
+		s.Days = append(s.Days, CheckinCount{Day: null.StringFrom(day), Checkins: null.IntFrom(n)})
+		s.Total += n
+		if n > s.Max {
+			s.Max = n
+		}
+	}
+
+	points := make([]string, len(s.Days))
+	for i, d := range s.Days {
+		x := 0
+		if len(s.Days) > 1 {
+			x = i * s.Width / (len(s.Days) - 1)
+		}
+		y := s.Height
+		if s.Max > 0 {
+			y = s.Height - int(d.Checkins.Int64*int64(s.Height)/s.Max)
+		}
+		points[i] = fmt.Sprintf("%d,%d", x, y)
+	}
+	s.Points = strings.Join(points, " ")
+	return s
+}
+
+// LateAgent is an agent not checking in as often as expected.
+type LateAgent struct {
+	CheckinStats
+
+	// Interval is the average time between the check-ins seen, 0 without
+	// check-ins.
+	Interval time.Duration
+	Expected time.Duration
+	Reason   string
+}
+
+// agentIntervals returns the report interval of the agent configuration
+// of each computer with tags, configs ordered as AgentConfigRepository.List
+// orders them. Computers without a matching configuration are left out.
+func agentIntervals(configs []AgentConfig, tags map[int64][]Tag, ids []int64) map[int64]time.Duration {
+	intervals := make(map[int64]time.Duration)
+	for _, id := range ids {
+		for _, config := range configs {
+			if config.TagID.Valid && !hasTag(tags[id], config.TagID.Int64) {
+				continue
+			}
+			intervals[id] = config.Document().Every()
+			break
+		}
+	}
+	return intervals
+}
+
+// hasTag reports whether tags holds the tag with id.
+func hasTag(tags []Tag, id int64) bool {
+	for _, t := range tags {
+		if t.ID.Int64 == id {
+			return true
+		}
+	}
+	return false
+}
+
+// lateAgents returns the agents that last checked in or on average check
+// in more than checkinTolerance expected intervals apart. The interval
+// expected of a computer is the one in intervals, expected when it has
+// none. Computers are judged over window, or since they were created when
+// that is shorter.
+func lateAgents(stats []CheckinStats, now time.Time, window time.Duration, expected time.Duration, intervals map[int64]time.Duration) []LateAgent {
+	late := []LateAgent{}
+
+	for _, s := range stats {
+		every, found := intervals[s.ComputerID.Int64]
+		if !found {
+			every = expected
+		}
+		limit := every * checkinTolerance
+
+		period := window
+		if created, err := time.ParseInLocation("2006-01-02 15:04:05", s.Created.String, time.Local); err == nil && now.Sub(created) < period {
+			period = now.Sub(created)
+		}
+
+		a := LateAgent{CheckinStats: s, Expected: every}
+		if s.Checkins.Int64 > 0 {
+			a.Interval = period / time.Duration(s.Checkins.Int64)
+		}
+
+		lastSeen, err := time.ParseInLocation("2006-01-02 15:04:05", s.LastSeen.String, time.Local)
+		switch {
+		case err == nil && now.Sub(lastSeen) > limit:
+			a.Reason = "No check-in for " + formatInterval(now.Sub(lastSeen))
+		case period >= limit && a.Interval > limit:
+			a.Reason = "Checks in every " + formatInterval(a.Interval)
+		default:
+			continue
+		}
+		late = append(late, a)
+	}
+	return late
+}
+
+// formatInterval formats d in days, hours and minutes.
+func formatInterval(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	hours := (d % (24 * time.Hour)) / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// Compactor folds old check-ins into the hourly and daily rollups.
+type Compactor struct {
+	log  lumber.Logger
+	repo CheckinRepository
+	lock sync.Locker
+
+	mu        sync.Mutex
+	retention time.Duration
+}
+
+// NewCompactor returns a compactor keeping the check-ins of the last
+// retention.
+func NewCompactor(db *sqlx.DB, log lumber.Logger, retention time.Duration) *Compactor {
+	return &Compactor{
+		log:       log,
+		repo:      NewCheckinRepository(db),
+		lock:      writeLock(db),
+		retention: retention,
+	}
+}
+
+// SetRetention changes how long check-ins are kept, it applies from the
+// next compaction.
+func (c *Compactor) SetRetention(retention time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retention = retention
+}
+
+// Compact rolls up the check-ins older than the retention at now.
+func (c *Compactor) Compact(ctx context.Context, now time.Time) (int64, error) {
+	c.mu.Lock()
+	before := now.Add(-c.retention)
+	c.mu.Unlock()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.repo.Compact(ctx, before)
+}
+
+// Run compacts the check-ins every interval until ctx is cancelled.
+func (c *Compactor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := c.Compact(ctx, now)
+			if err != nil {
+				c.log.Errorf("checkins: compaction failed: %s", err)
+				continue
+			}
+			if n > 0 {
+				c.log.Infof("checkins: compacted %d check-ins", n)
+			}
+		}
+	}
+}
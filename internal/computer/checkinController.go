@@ -0,0 +1,111 @@
+package computer
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+)
+
+type checkinController struct {
+	log         lumber.Logger
+	checkinRepo CheckinRepository
+	configRepo  AgentConfigRepository
+	tagRepo     TagRepository
+	expected    time.Duration
+}
+
+type CheckinController interface {
+	Late(http.ResponseWriter, *http.Request)
+}
+
+// NewCheckinController registers the report of the agents not checking in
+// at the interval of their agent configuration at /computers/checkins.
+// Agents without a configuration are expected every expected interval.
+func NewCheckinController(db *sqlx.DB, log lumber.Logger, router *mux.Router, expected time.Duration, guard Guard, middleware ...alice.Constructor) CheckinController {
+	c := &checkinController{
+		log:         log,
+		checkinRepo: NewCheckinRepository(db),
+		configRepo:  NewAgentConfigRepository(db),
+		tagRepo:     NewTagRepository(db),
+		expected:    expected,
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+
+	router.Handle("/computers/checkins", view.ThenFunc(c.Late)).Methods("GET").Name("checkins")
+
+	return c
+}
+
+// checkinPageData is rendered by checkinPage.
+type checkinPageData struct {
+	Title    string
+	View     string
+	Expected string
+	Days     int
+	Agents   []LateAgent
+	Account  *account.Account
+}
+
+func (c *checkinController) Late(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	stats, err := c.checkinRepo.Stats(r.Context(), now.Add(-checkinWindow))
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	intervals, err := c.intervals(r.Context(), stats)
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data := &checkinPageData{
+		Title:    "Agents Not Checking In",
+		View:     "checkins",
+		Expected: formatInterval(c.expected),
+		Days:     int(checkinWindow / (24 * time.Hour)),
+		Agents:   lateAgents(stats, now, checkinWindow, c.expected, intervals),
+		Account:  AccountFromContext(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := checkinPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
+
+// intervals returns the report interval of the agent configuration of
+// each computer in stats.
+func (c *checkinController) intervals(ctx context.Context, stats []CheckinStats) (map[int64]time.Duration, error) {
+	configs, err := c.configRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(stats))
+	for i, s := range stats {
+		ids[i] = s.ComputerID.Int64
+	}
+	tags, err := c.tagRepo.ListForComputers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	return agentIntervals(configs, tags, ids), nil
+}
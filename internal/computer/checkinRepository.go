@@ -0,0 +1,296 @@
+package computer
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Rollup periods.
+const (
+	RollupHour = "hour"
+	RollupDay  = "day"
+)
+
+// Checkin is a report received from an agent.
+type Checkin struct {
+	ID           null.Int    `db:"id"`
+	Created      null.String `db:"created"`
+	ComputerID   null.Int    `db:"computer_id"`
+	AgentVersion null.String `db:"agent_version"`
+
+	// PayloadBytes is the size of the report, DurationMs the time taken
+	// to store it.
+	PayloadBytes null.Int `db:"payload_bytes"`
+	DurationMs   null.Int `db:"duration_ms"`
+}
+
+// CheckinCount is the number of check-ins of a computer on a day,
+// formatted "2006-01-02".
+type CheckinCount struct {
+	Day      null.String `db:"day"`
+	Checkins null.Int    `db:"checkins"`
+}
+
+// CheckinStats summarises the check-ins of a live computer since a time.
+type CheckinStats struct {
+	ComputerID null.Int    `db:"computer_id"`
+	Name       null.String `db:"name"`
+	Created    null.String `db:"created"`
+	LastSeen   null.String `db:"last_seen"`
+	Checkins   null.Int    `db:"checkins"`
+}
+
+// rollupUpsert adds the rows inserted into checkin_rollups to the existing
+// rollups of the same period, computer and start.
+const rollupUpsert = `ON CONFLICT (period, computer_id, start) DO UPDATE SET
+            checkins=checkin_rollups.checkins+excluded.checkins,
+            payload_bytes=checkin_rollups.payload_bytes+excluded.payload_bytes,
+            duration_ms=checkin_rollups.duration_ms+excluded.duration_ms,
+            max_duration_ms=CASE WHEN excluded.max_duration_ms>checkin_rollups.max_duration_ms
+                THEN excluded.max_duration_ms ELSE checkin_rollups.max_duration_ms END`
+
+type CheckinRepository interface {
+	Install(context.Context) error
+	Create(context.Context, *Checkin) (int64, error)
+
+	// Daily counts the check-ins of a computer since since by day, from
+	// the raw check-ins and the daily rollups. Days without check-ins are
+	// left out.
+	Daily(ctx context.Context, computerID int64, since time.Time) ([]CheckinCount, error)
+
+	// Stats counts the check-ins of every live computer reported by an
+	// agent since since.
+	Stats(ctx context.Context, since time.Time) ([]CheckinStats, error)
+
+	// Compact adds the check-ins created before before to the hourly and
+	// daily rollups and deletes them, it returns the number compacted.
+	Compact(ctx context.Context, before time.Time) (int64, error)
+}
+
+type checkinRepository struct {
+	db *sqlx.DB
+}
+
+func NewCheckinRepository(db *sqlx.DB) CheckinRepository {
+	return &checkinRepository{
+		db: db,
+	}
+}
+
+func (r *checkinRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	for _, ddl := range []string{
+		`CREATE TABLE checkins (
+            ` + d.PrimaryKey + `,
+            "created" TEXT NOT NULL,
+            "computer_id" INTEGER NOT NULL,
+            "agent_version" TEXT,
+            "payload_bytes" INTEGER NOT NULL,
+            "duration_ms" INTEGER NOT NULL` + d.ForeignKey("computer_id", "computers") + `
+        )`,
+		`CREATE INDEX checkins_computer_created ON checkins (computer_id, created)`,
+		`CREATE INDEX checkins_created ON checkins (created)`,
+		`CREATE TABLE checkin_rollups (
+            ` + d.PrimaryKey + `,
+            "period" TEXT NOT NULL,
+            "start" TEXT NOT NULL,
+            "computer_id" INTEGER NOT NULL,
+            "checkins" INTEGER NOT NULL,
+            "payload_bytes" INTEGER NOT NULL,
+            "duration_ms" INTEGER NOT NULL,
+            "max_duration_ms" INTEGER NOT NULL` + d.ForeignKey("computer_id", "computers") + `
+        )`,
+		`CREATE UNIQUE INDEX checkin_rollups_period ON checkin_rollups (period, computer_id, start)`,
+	} {
+		if _, err := r.db.ExecContext(ctx, ddl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *checkinRepository) Create(ctx context.Context, data *Checkin) (int64, error) {
+	defer observeQuery("checkin", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO checkins (
+            created,
+            computer_id,
+            agent_version,
+            payload_bytes,
+            duration_ms
+        ) VALUES (?,?,?,?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.ComputerID,
+		data.AgentVersion,
+		data.PayloadBytes,
+		data.DurationMs,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+func (r *checkinRepository) Daily(ctx context.Context, computerID int64, since time.Time) ([]CheckinCount, error) {
+	defer observeQuery("checkin", "Daily", time.Now())
+
+	data := []CheckinCount{}
+
+	from := since.Format("2006-01-02 15:04:05")
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            day,
+            SUM(checkins) AS checkins
+        FROM (
+            SELECT SUBSTR(created, 1, 10) AS day, COUNT(*) AS checkins
+            FROM checkins
+            WHERE computer_id=?
+            AND created>=?
+            GROUP BY SUBSTR(created, 1, 10)
+            UNION ALL
+            SELECT SUBSTR(start, 1, 10) AS day, checkins
+            FROM checkin_rollups
+            WHERE period=?
+            AND computer_id=?
+            AND start>=?
+        ) d
+        GROUP BY day
+        ORDER BY day`),
+		computerID,
+		from,
+		RollupDay,
+		computerID,
+		since.Format("2006-01-02")+" 00:00:00",
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *checkinRepository) Stats(ctx context.Context, since time.Time) ([]CheckinStats, error) {
+	defer observeQuery("checkin", "Stats", time.Now())
+
+	data := []CheckinStats{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            c.id AS computer_id,
+            c.name,
+            c.created,
+            COALESCE(c.updated, c.created) AS last_seen,
+            COALESCE(SUM(n.checkins), 0) AS checkins
+        FROM computers c
+        LEFT JOIN (
+            SELECT computer_id, COUNT(*) AS checkins
+            FROM checkins
+            WHERE created>=?
+            GROUP BY computer_id
+            UNION ALL
+            SELECT computer_id, SUM(checkins) AS checkins
+            FROM checkin_rollups
+            WHERE period=?
+            AND start>=?
+            GROUP BY computer_id
+        ) n ON n.computer_id = c.id
+        WHERE c.deleted IS NULL
+        AND c.source=?
+        GROUP BY c.id, c.name, c.created, c.updated
+        ORDER BY c.name`),
+		since.Format("2006-01-02 15:04:05"),
+		RollupDay,
+		since.Format("2006-01-02")+" 00:00:00",
+		SourceAgent,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *checkinRepository) Compact(ctx context.Context, before time.Time) (int64, error) {
+	defer observeQuery("checkin", "Compact", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := before.Format("2006-01-02 15:04:05")
+	for _, rollup := range []struct {
+		period string
+		start  string
+	}{
+		{RollupHour, `SUBSTR(created, 1, 13) || ':00:00'`},
+		{RollupDay, `SUBSTR(created, 1, 10) || ' 00:00:00'`},
+	} {
+		_, err = tx.ExecContext(
+			ctx,
+			tx.Rebind(`INSERT INTO checkin_rollups (
+                period,
+                start,
+                computer_id,
+                checkins,
+                payload_bytes,
+                duration_ms,
+                max_duration_ms
+            )
+            SELECT
+                ?,
+                `+rollup.start+`,
+                computer_id,
+                COUNT(*),
+                SUM(payload_bytes),
+                SUM(duration_ms),
+                MAX(duration_ms)
+            FROM checkins
+            WHERE created<?
+            GROUP BY `+rollup.start+`, computer_id
+            `+rollupUpsert),
+			rollup.period,
+			cutoff,
+		)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+
+	res, err := tx.ExecContext(ctx, tx.Rebind(`DELETE FROM checkins WHERE created<?`), cutoff)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
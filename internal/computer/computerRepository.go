@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -15,16 +16,56 @@ type Computer struct {
 	Updated null.String `db:"updated" json:"updated"`
 	Deleted null.String `db:"deleted" json:"deleted"`
 
-	Name null.String `db:"name" json:"name"`
+	Name     null.String `db:"name" json:"name"`
+	Source   null.String `db:"source" json:"source"`
+	Owner    null.String `db:"owner" json:"owner"`
+	Location null.String `db:"location" json:"location"`
+	Notes    null.String `db:"notes" json:"notes"`
+
+	// MergedInto is the computer a duplicate record was merged into.
+	MergedInto null.Int `db:"merged_into" json:"merged_into"`
+
+	// The build and platform of the agent, as of its last report.
+	AgentVersion null.String `db:"agent_version" json:"agent_version"`
+	AgentCommit  null.String `db:"agent_commit" json:"agent_commit"`
+	OS           null.String `db:"os" json:"os"`
+	Arch         null.String `db:"arch" json:"arch"`
+}
+
+// Record sources, agent reports take precedence over imported data.
+const (
+	SourceAgent  = "agent"
+	SourceImport = "import"
+)
+
+// ComputerSummary is a row of the computer list, the computer with the
+// last user seen on it and the number of its network adapters.
+type ComputerSummary struct {
+	Computer
+
+	LastSeen null.String `db:"last_seen" json:"last_seen"`
+	LastUser null.String `db:"last_user" json:"last_user"`
+	Adapters int         `db:"adapters" json:"adapters"`
+
+	// Tags and Locations are not set by EachSummary, see
+	// TagRepository.ListForComputers.
+	Tags      []Tag      `db:"-" json:"tags"`
+	Locations []Location `db:"-" json:"locations"`
 }
 
 type ComputerRepository interface {
 	Install(context.Context) error
 	Select(context.Context, string) (*Computer, error)
+	SelectWithID(context.Context, int) (*Computer, error)
 	Create(context.Context, *Computer) (int64, error)
 	Update(context.Context, *Computer) error
+	UpdateDetails(context.Context, *Computer) error
+	UpdateAgent(context.Context, *Computer) error
 	Delete(context.Context, int) error
 	List(context.Context, int, int) ([]Computer, error)
+	Count(context.Context) (int, error)
+	CountActive(context.Context, time.Time) (int, error)
+	EachSummary(context.Context, Filter, func(ComputerSummary) error) error
 }
 
 type computerRepository struct {
@@ -38,15 +79,25 @@ func NewComputerRepository(db *sqlx.DB) ComputerRepository {
 }
 
 func (r *computerRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
 	_, err := r.db.ExecContext(
 		ctx,
 		`CREATE TABLE computers (
-            "id" INTEGER,
+            `+d.PrimaryKey+`,
             "created" TEXT,
             "updated" TEXT,
             "deleted" TEXT,
             "name" TEXT,
-            PRIMARY KEY("id" AUTOINCREMENT)
+            "source" TEXT,
+            "owner" TEXT,
+            "location" TEXT,
+            "notes" TEXT,
+            "merged_into" INTEGER,
+            "agent_version" TEXT,
+            "agent_commit" TEXT,
+            "os" TEXT,
+            "arch" TEXT
         )`,
 	)
 
@@ -56,28 +107,95 @@ func (r *computerRepository) Install(ctx context.Context) error {
 	return nil
 }
 
-func (r *computerRepository) Select(ctx context.Context, id string) (*Computer, error) {
+// Select returns the computer reporting under name, ignoring case and
+// preferring live records. A report for a computer that has been merged
+// into another is attributed to the surviving computer.
+func (r *computerRepository) Select(ctx context.Context, name string) (*Computer, error) {
+	defer observeQuery("computer", "Select", time.Now())
+	return selectComputer(ctx, r.db, name)
+}
+
+func (r *computerRepository) SelectWithID(ctx context.Context, id int) (*Computer, error) {
+	defer observeQuery("computer", "SelectWithID", time.Now())
+	return selectComputerWithID(ctx, r.db, id)
+}
+
+// queryer is a database or a transaction.
+type queryer interface {
+	sqlx.QueryerContext
+	Rebind(string) string
+}
+
+// selectComputer returns the computer called name, ignoring case, or the
+// computer it was merged into.
+func selectComputer(ctx context.Context, q queryer, name string) (*Computer, error) {
 	data := Computer{}
 
-	stmt, err := r.db.PreparexContext(
+	err := sqlx.GetContext(
 		ctx,
-		`SELECT
+		q,
+		&data,
+		q.Rebind(`SELECT
             id,
             created,
             updated,
             deleted,
-            name
+            name,
+            source,
+            owner,
+            location,
+            notes,
+            merged_into,
+            agent_version,
+            agent_commit,
+            os,
+            arch
         FROM computers
-        WHERE name=?`,
+        WHERE LOWER(name)=LOWER(?)
+        ORDER BY CASE WHEN deleted IS NULL THEN 0 ELSE 1 END, id
+        LIMIT 1`),
+		name,
 	)
 
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
 		return nil, err
 	}
 
-	err = stmt.GetContext(
+	if data.MergedInto.Valid {
+		return selectComputerWithID(ctx, q, int(data.MergedInto.Int64))
+	}
+
+	return &data, nil
+}
+
+func selectComputerWithID(ctx context.Context, q queryer, id int) (*Computer, error) {
+	data := Computer{}
+
+	err := sqlx.GetContext(
 		ctx,
+		q,
 		&data,
+		q.Rebind(`SELECT
+            id,
+            created,
+            updated,
+            deleted,
+            name,
+            source,
+            owner,
+            location,
+            notes,
+            merged_into,
+            agent_version,
+            agent_commit,
+            os,
+            arch
+        FROM computers
+        WHERE id=?
+        AND deleted IS NULL`),
 		id,
 	)
 
@@ -92,41 +210,50 @@ func (r *computerRepository) Select(ctx context.Context, id string) (*Computer,
 }
 
 func (r *computerRepository) Create(ctx context.Context, data *Computer) (int64, error) {
+	defer observeQuery("computer", "Create", time.Now())
+
 	tx, err := r.db.BeginTxx(ctx, nil)
 
 	if err != nil {
 		return -1, err
 	}
 
-	stmt, err := tx.PreparexContext(
-		ctx,
-		`INSERT INTO computers (
-            created,
-            name
-        ) VALUES (?,?)`,
-	)
+	id, err := insertComputer(ctx, tx, data)
 
 	if err != nil {
+		tx.Rollback()
 		return -1, err
 	}
 
-	result, err := stmt.ExecContext(
+	tx.Commit()
+	return id, nil
+}
+
+func insertComputer(ctx context.Context, tx *sqlx.Tx, data *Computer) (int64, error) {
+	return database.Insert(
 		ctx,
+		tx,
+		`INSERT INTO computers (
+            created,
+            name,
+            source,
+            owner,
+            location
+        ) VALUES (?,?,?,?,?)`,
 		time.Now().Format("2006-01-02 15:04:05"),
 		data.Name,
+		data.Source,
+		data.Owner,
+		data.Location,
 	)
-
-	if err != nil {
-		tx.Rollback()
-		return -1, err
-	}
-
-	tx.Commit()
-	id, _ := result.LastInsertId()
-	return id, nil
 }
 
+// Update records a report from the agent. Owner, location and notes are
+// maintained by hand and only written by UpdateDetails, so a report never
+// overwrites them.
 func (r *computerRepository) Update(ctx context.Context, data *Computer) error {
+	defer observeQuery("computer", "Update", time.Now())
+
 	tx, err := r.db.BeginTxx(ctx, nil)
 
 	if err != nil {
@@ -135,10 +262,11 @@ func (r *computerRepository) Update(ctx context.Context, data *Computer) error {
 
 	stmt, err := tx.PreparexContext(
 		ctx,
-		`UPDATE computers SET
+		tx.Rebind(`UPDATE computers SET
             updated=?,
-            name=?
-        WHERE id=?`,
+            name=?,
+            source=?
+        WHERE id=?`),
 	)
 
 	if err != nil {
@@ -149,6 +277,7 @@ func (r *computerRepository) Update(ctx context.Context, data *Computer) error {
 		ctx,
 		time.Now().Format("2006-01-02 15:04:05"),
 		data.Name,
+		data.Source,
 		data.ID,
 	)
 
@@ -161,7 +290,68 @@ func (r *computerRepository) Update(ctx context.Context, data *Computer) error {
 	return nil
 }
 
+// UpdateAgent records the build and platform of the agent reporting for a
+// computer. The platform is kept when the agent does not report it.
+func (r *computerRepository) UpdateAgent(ctx context.Context, data *Computer) error {
+	defer observeQuery("computer", "UpdateAgent", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE computers SET
+            agent_version=?,
+            agent_commit=?,
+            os=COALESCE(?, os),
+            arch=COALESCE(?, arch)
+        WHERE id=?`),
+		data.AgentVersion,
+		data.AgentCommit,
+		data.OS,
+		data.Arch,
+		data.ID,
+	)
+	return err
+}
+
+// UpdateDetails sets the owner, location and notes of a computer. Unlike Update
+// it leaves the updated time alone, which records when the agent last
+// reported.
+func (r *computerRepository) UpdateDetails(ctx context.Context, data *Computer) error {
+	defer observeQuery("computer", "UpdateDetails", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if err = updateComputerDetails(ctx, tx, data); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+	return nil
+}
+
+func updateComputerDetails(ctx context.Context, tx *sqlx.Tx, data *Computer) error {
+	_, err := tx.ExecContext(
+		ctx,
+		tx.Rebind(`UPDATE computers SET
+            owner=?,
+            location=?,
+            notes=?
+        WHERE id=?`),
+		data.Owner,
+		data.Location,
+		data.Notes,
+		data.ID,
+	)
+	return err
+}
+
 func (r *computerRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("computer", "Delete", time.Now())
+
 	tx, err := r.db.BeginTxx(ctx, nil)
 
 	if err != nil {
@@ -170,9 +360,9 @@ func (r *computerRepository) Delete(ctx context.Context, id int) error {
 
 	stmt, err := tx.PreparexContext(
 		ctx,
-		`UPDATE computers SET
+		tx.Rebind(`UPDATE computers SET
             deleted=?
-        WHERE id=?`,
+        WHERE id=?`),
 	)
 
 	if err != nil {
@@ -195,13 +385,16 @@ func (r *computerRepository) Delete(ctx context.Context, id int) error {
 }
 
 func (r *computerRepository) List(ctx context.Context, start int, count int) ([]Computer, error) {
+	defer observeQuery("computer", "List", time.Now())
+
 	data := make([]Computer, 0)
 
 	stmt, err := r.db.PreparexContext(
 		ctx,
-		`SELECT *
+		r.db.Rebind(`SELECT *
         FROM computers
-		LIMIT ? OFFSET ?`,
+        ORDER BY id
+        LIMIT ? OFFSET ?`),
 	)
 
 	if err != nil {
@@ -224,3 +417,143 @@ func (r *computerRepository) List(ctx context.Context, start int, count int) ([]
 
 	return data, nil
 }
+
+func (r *computerRepository) Count(ctx context.Context) (int, error) {
+	defer observeQuery("computer", "Count", time.Now())
+
+	var count int
+
+	err := r.db.GetContext(
+		ctx,
+		&count,
+		r.db.Rebind(`SELECT COUNT(*)
+        FROM computers
+        WHERE deleted IS NULL`),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// CountActive counts the computers that have reported since the given time.
+func (r *computerRepository) CountActive(ctx context.Context, since time.Time) (int, error) {
+	defer observeQuery("computer", "CountActive", time.Now())
+
+	var count int
+
+	err := r.db.GetContext(
+		ctx,
+		&count,
+		r.db.Rebind(`SELECT COUNT(*)
+        FROM computers
+        WHERE deleted IS NULL
+        AND COALESCE(updated, created) >= ?`),
+		since.Format("2006-01-02 15:04:05"),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// EachSummary calls fn for every computer matching f, ordered by name.
+// Rows are streamed so exports of the whole inventory are not held in
+// memory, an error returned by fn stops the iteration.
+func (r *computerRepository) EachSummary(ctx context.Context, f Filter, fn func(ComputerSummary) error) error {
+	defer observeQuery("computer", "EachSummary", time.Now())
+
+	query := `SELECT
+            c.id,
+            c.created,
+            c.updated,
+            c.name,
+            c.source,
+            c.owner,
+            c.location,
+            c.notes,
+            COALESCE(c.updated, c.created) AS last_seen,
+            (SELECT cu.username
+                FROM computer_users cu
+                WHERE cu.computer_id = c.id
+                ORDER BY cu.id DESC
+                LIMIT 1) AS last_user,
+            (SELECT COUNT(*)
+                FROM computer_network_adapters na
+                WHERE na.computer_id = c.id
+                AND na.deleted IS NULL) AS adapters
+        FROM computers c
+        WHERE c.deleted IS NULL`
+	var args []interface{}
+
+	if f.Search != "" {
+		query += ` AND (LOWER(c.name) LIKE ? ESCAPE '\'
+            OR LOWER(c.owner) LIKE ? ESCAPE '\'
+            OR LOWER(c.notes) LIKE ? ESCAPE '\'
+            OR EXISTS (SELECT 1 FROM computer_field_values fv
+                JOIN custom_fields cf ON cf.id = fv.field_id
+                WHERE fv.computer_id = c.id AND cf.deleted IS NULL AND LOWER(fv.value) LIKE ? ESCAPE '\')
+            OR EXISTS (SELECT 1 FROM computer_users cu
+                WHERE cu.computer_id = c.id AND LOWER(cu.username) LIKE ? ESCAPE '\')
+            OR EXISTS (SELECT 1 FROM computer_network_adapters na
+                WHERE na.computer_id = c.id AND (LOWER(na.mac_address) LIKE ? ESCAPE '\' OR na.ip_address LIKE ? ESCAPE '\')))`
+		for i := 0; i < 7; i++ {
+			args = append(args, like(f.Search))
+		}
+	}
+
+	if !f.Before.IsZero() {
+		query += ` AND COALESCE(c.updated, c.created) < ?`
+		args = append(args, f.Before.Format("2006-01-02 15:04:05"))
+	}
+
+	if f.Tag != "" {
+		query += ` AND EXISTS (SELECT 1 FROM computer_tags ct
+            JOIN tags t ON t.id = ct.tag_id
+            WHERE ct.computer_id = c.id AND t.deleted IS NULL AND LOWER(t.name) = LOWER(?))`
+		args = append(args, f.Tag)
+	}
+
+	if f.Location != 0 {
+		query += ` AND EXISTS (SELECT 1 FROM computer_locations cl
+            WHERE cl.computer_id = c.id AND cl.location_id IN (
+                WITH RECURSIVE sub(id) AS (
+                    SELECT CAST(? AS INTEGER)
+                    UNION
+                    SELECT l.id FROM locations l JOIN sub ON l.parent_id = sub.id WHERE l.deleted IS NULL
+                ) SELECT id FROM sub))`
+		args = append(args, f.Location)
+	}
+
+	if f.Subnet != 0 {
+		query += ` AND EXISTS (SELECT 1 FROM computer_network_adapters na
+            WHERE na.computer_id = c.id AND na.deleted IS NULL AND na.subnet_id = ?)`
+		args = append(args, f.Subnet)
+	}
+
+	limit, limitArgs := f.limit()
+	query += ` ORDER BY c.name, c.id` + limit
+	args = append(args, limitArgs...)
+
+	rows, err := r.db.QueryxContext(ctx, r.db.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data ComputerSummary
+		if err = rows.StructScan(&data); err != nil {
+			return err
+		}
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -1,16 +1,42 @@
 package computer
 
 import (
+	"bufio"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
 	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/agentconfig"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"github.com/stockholmr/fpsmonitor/internal/mail"
+	"github.com/stockholmr/lumber"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -45,34 +71,127 @@ func equals(tb testing.TB, exp, act interface{}) {
 	}
 }
 
+// allowAll is a Guard admitting every request as an administrator.
+func allowAll(role string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), UserKey, tokenAccount)))
+		})
+	}
+}
+
+// The tests run against an in-memory SQLite database unless
+// FPSMONITOR_TEST_POSTGRES holds the DSN of a PostgreSQL database, e.g.
+// "postgres://postgres@localhost/fpsmonitor_test?sslmode=disable".
+const postgresEnv = "FPSMONITOR_TEST_POSTGRES"
+
+const postgresSchemaPrefix = "fpsmonitor_test_"
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	if dsn := os.Getenv(postgresEnv); dsn != "" {
+		if err := dropPostgresSchemas(dsn); err != nil {
+			fmt.Println(err)
+		}
+	}
+	os.Exit(code)
+}
+
 func dbSetup() (*sqlx.DB, error) {
+	dbCtx = context.Background()
+
+	if dsn := os.Getenv(postgresEnv); dsn != "" {
+		return postgresSetup(dsn)
+	}
+
 	db, err := sqlx.Open("sqlite3", ":memory:")
 	if err != nil {
 		return nil, err
 	}
-	dbCtx = context.Background()
 	return db, nil
 }
 
-func TestComputerRepositoryInstall(t *testing.T) {
-	db, err := dbSetup()
-	ok(t, err)
+// postgresSetup gives every test an empty schema of its own. The pool is
+// limited to a single connection so the search path applies to every query.
+func postgresSetup(dsn string) (*sqlx.DB, error) {
+	db, err := database.Open(database.Postgres, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	schema := fmt.Sprintf("%s%d", postgresSchemaPrefix, time.Now().UnixNano())
+	if _, err = db.ExecContext(dbCtx, "CREATE SCHEMA "+schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err = db.ExecContext(dbCtx, "SET search_path TO "+schema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func dropPostgresSchemas(dsn string) error {
+	db, err := database.Open(database.Postgres, dsn)
+	if err != nil {
+		return err
+	}
 	defer db.Close()
 
-	repo := NewComputerRepository(db)
-	err = repo.Install(dbCtx)
-	ok(t, err)
+	var schemas []string
+	err = db.SelectContext(
+		context.Background(),
+		&schemas,
+		"SELECT schema_name FROM information_schema.schemata WHERE schema_name LIKE $1",
+		postgresSchemaPrefix+"%",
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, schema := range schemas {
+		if _, err = db.ExecContext(context.Background(), "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assertTable fails the test if table was not created. SQLite keeps the
+// statement that created the table, which is checked as well.
+func assertTable(tb testing.TB, db *sqlx.DB, table string) {
+	exists, err := database.TableExists(dbCtx, db, table)
+	ok(tb, err)
+	assert(tb, exists, "table %s does not exist", table)
+
+	if database.DialectOf(db).Name != database.SQLite {
+		return
+	}
 
 	var data null.String
-	row := db.QueryRowContext(dbCtx, "SELECT sql FROM sqlite_master WHERE name='computers'")
+	row := db.QueryRowContext(dbCtx, "SELECT sql FROM sqlite_master WHERE name=?", table)
 	err = row.Scan(&data)
-	ok(t, err)
+	ok(tb, err)
 
-	schemaPattern := `CREATE TABLE computers`
+	schemaPattern := `CREATE TABLE ` + table
 
 	matched, err := regexp.MatchString(schemaPattern, data.String)
+	ok(tb, err)
+	assert(tb, matched, "invalid table schema", nil)
+}
+
+func TestComputerRepositoryInstall(t *testing.T) {
+	db, err := dbSetup()
 	ok(t, err)
-	assert(t, matched, "invalid table schema", nil)
+	defer db.Close()
+
+	repo := NewComputerRepository(db)
+	err = repo.Install(dbCtx)
+	ok(t, err)
+
+	assertTable(t, db, "computers")
 }
 
 func TestComputerRepositoryCreate(t *testing.T) {
@@ -209,16 +328,7 @@ func TestNetworkAdapterRepositoryInstall(t *testing.T) {
 	err = repo.Install(dbCtx)
 	ok(t, err)
 
-	var data null.String
-	row := db.QueryRowContext(dbCtx, "SELECT sql FROM sqlite_master WHERE name='computer_network_adapters'")
-	err = row.Scan(&data)
-	ok(t, err)
-
-	schemaPattern := `CREATE TABLE computer_network_adapters`
-
-	matched, err := regexp.MatchString(schemaPattern, data.String)
-	ok(t, err)
-	assert(t, matched, "invalid table schema", nil)
+	assertTable(t, db, "computer_network_adapters")
 }
 
 func TestNetworkAdapterRepositoryCreate(t *testing.T) {
@@ -385,16 +495,7 @@ func TestUserRepositoryInstall(t *testing.T) {
 	err = repo.Install(dbCtx)
 	ok(t, err)
 
-	var data null.String
-	row := db.QueryRowContext(dbCtx, "SELECT sql FROM sqlite_master WHERE name='computer_users'")
-	err = row.Scan(&data)
-	ok(t, err)
-
-	schemaPattern := `CREATE TABLE computer_users`
-
-	matched, err := regexp.MatchString(schemaPattern, data.String)
-	ok(t, err)
-	assert(t, matched, "invalid table schema", nil)
+	assertTable(t, db, "computer_users")
 }
 
 func TestUserRepositoryCreate(t *testing.T) {
@@ -574,3 +675,2358 @@ func TestUserRepositoryList(t *testing.T) {
 	equals(t, int64(2), users[0].ID.Int64)
 	equals(t, int64(3), users[1].ID.Int64)
 }
+
+func TestComputerRepositoryCount(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	repo := NewComputerRepository(db)
+	err = repo.Install(dbCtx)
+	ok(t, err)
+
+	for i := 0; i < 10; i++ {
+		computer := &Computer{
+			Name: null.NewString(fmt.Sprintf("Test Computer %d", i), true),
+		}
+
+		_, err := repo.Create(dbCtx, computer)
+		ok(t, err)
+	}
+
+	err = repo.Delete(dbCtx, 4)
+	ok(t, err)
+
+	_, err = db.ExecContext(dbCtx, "UPDATE computers SET created='2000-01-01 00:00:00' WHERE id IN (1, 2)")
+	ok(t, err)
+
+	count, err := repo.Count(dbCtx)
+	ok(t, err)
+	equals(t, 9, count)
+
+	active, err := repo.CountActive(dbCtx, time.Now().Add(-StaleAfter))
+	ok(t, err)
+	equals(t, 7, active)
+}
+
+func TestNetworkAdapterRepositoryCount(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	repo := NewNetworkAdapterRepository(db)
+	err = repo.Install(dbCtx)
+	ok(t, err)
+
+	for i := 0; i < 3; i++ {
+		na := &NetworkAdapter{
+			ComputerID: null.IntFrom(1),
+			Name:       null.NewString(fmt.Sprintf("Network %d", i), true),
+		}
+
+		_, err := repo.Create(dbCtx, na)
+		ok(t, err)
+	}
+
+	count, err := repo.Count(dbCtx)
+	ok(t, err)
+	equals(t, 3, count)
+}
+
+func TestMigrate(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	err = CheckSchema(dbCtx, db)
+	assert(t, err != nil, "expected schema check to fail before migrating")
+
+	err = Migrate(dbCtx, db)
+	ok(t, err)
+
+	version, err := NewSchemaRepository(db).Version(dbCtx)
+	ok(t, err)
+	equals(t, SchemaVersion(), version)
+	ok(t, CheckSchema(dbCtx, db))
+
+	// Running again must be a no-op.
+	err = Migrate(dbCtx, db)
+	ok(t, err)
+}
+
+func TestMigrateUnversionedDatabase(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	ok(t, NewComputerRepository(db).Install(dbCtx))
+	ok(t, NewNetworkAdapterRepository(db).Install(dbCtx))
+	ok(t, NewUserRepository(db).Install(dbCtx))
+
+	err = Migrate(dbCtx, db)
+	ok(t, err)
+	ok(t, CheckSchema(dbCtx, db))
+}
+
+func TestCertificateName(t *testing.T) {
+	r := httptest.NewRequest("POST", "/computers/update", nil)
+	equals(t, "", certificateName(r))
+
+	r.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{
+			{{Subject: pkix.Name{CommonName: "PC01"}}},
+		},
+	}
+	equals(t, "PC01", certificateName(r))
+}
+
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discard) Close() error {
+	return nil
+}
+
+// benchmarkUpdate posts reports for 100 computers from parallel clients
+// against a file backed database tuned the way the server opens it.
+func benchmarkUpdate(b *testing.B, serialize bool) {
+	file := filepath.Join(b.TempDir(), "fpsmonitor.sqlite")
+	db, err := database.Open(database.SQLite, database.SQLiteDSN(file, database.SQLiteOptions{
+		JournalMode: "WAL",
+		BusyTimeout: time.Second * 5,
+		ForeignKeys: true,
+		Synchronous: "NORMAL",
+	}))
+	ok(b, err)
+	defer db.Close()
+
+	ok(b, Migrate(context.Background(), db))
+
+	c := NewComputerController(db, lumber.NewBasicLogger(discard{}, lumber.FATAL), mux.NewRouter(), "", allowAll).(*computerController)
+	if !serialize {
+		c.ingestLock = noopLocker{}
+	}
+
+	var sent, failed int64
+
+	b.SetParallelism(8)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&sent, 1)
+			body := fmt.Sprintf(
+				`{"name":"PC%03d","username":"user%d","adapters":[{"name":"eth0","mac_address":"00:00:00:00:00:%02x","ip_address":"10.0.0.%d"}]}`,
+				i%100, i, i%100, i%100,
+			)
+
+			rec := httptest.NewRecorder()
+			c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(body)))
+			if rec.Code != http.StatusOK {
+				atomic.AddInt64(&failed, 1)
+			}
+		}
+	})
+	b.ReportMetric(float64(failed), "failed")
+}
+
+func BenchmarkUpdateConcurrent(b *testing.B) {
+	b.Run("serialized", func(b *testing.B) {
+		benchmarkUpdate(b, true)
+	})
+	b.Run("unserialized", func(b *testing.B) {
+		benchmarkUpdate(b, false)
+	})
+}
+
+// seedInventory reports three computers through the ingest handler, PC03
+// last reported ten days ago.
+func seedInventory(tb testing.TB, db *sqlx.DB) *computerController {
+	db.SetMaxOpenConns(1)
+	ok(tb, Migrate(dbCtx, db))
+
+	c := NewComputerController(db, lumber.NewBasicLogger(discard{}, lumber.FATAL), mux.NewRouter(), "", allowAll).(*computerController)
+
+	for i, user := range []string{"alice", "bob", "carol"} {
+		body := fmt.Sprintf(
+			`{"name":"PC%02d","username":"%s","adapters":[{"name":"eth0","mac_address":"00:11:22:33:44:%02d","ip_address":"10.0.0.%d"}]}`,
+			i+1, user, i+1, i+1,
+		)
+		rec := httptest.NewRecorder()
+		c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(body)))
+		equals(tb, http.StatusOK, rec.Code)
+	}
+
+	_, err := db.ExecContext(dbCtx, "UPDATE computers SET created='2000-01-01 00:00:00', updated=? WHERE name='PC03'",
+		time.Now().Add(-time.Hour*24*10).Format("2006-01-02 15:04:05"))
+	ok(tb, err)
+
+	return c
+}
+
+func TestComputerRepositoryEachSummary(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	seedInventory(t, db)
+	repo := NewComputerRepository(db)
+
+	names := func(f Filter) []string {
+		list := []string{}
+		ok(t, repo.EachSummary(dbCtx, f, func(s ComputerSummary) error {
+			list = append(list, s.Name.String)
+			return nil
+		}))
+		return list
+	}
+
+	equals(t, []string{"PC01", "PC02", "PC03"}, names(Filter{}))
+	equals(t, []string{"PC02"}, names(Filter{Search: "BOB"}))
+	equals(t, []string{"PC03"}, names(Filter{Search: "00:11:22:33:44:03"}))
+	equals(t, []string{"PC03"}, names(Filter{Before: time.Now().Add(-StaleAfter)}))
+	equals(t, []string{"PC02"}, names(Filter{Start: 1, Count: 1}))
+	equals(t, []string{}, names(Filter{Search: "%"}))
+
+	var summary ComputerSummary
+	ok(t, repo.EachSummary(dbCtx, Filter{Search: "pc01"}, func(s ComputerSummary) error {
+		summary = s
+		return nil
+	}))
+	equals(t, "alice", summary.LastUser.String)
+	equals(t, 1, summary.Adapters)
+}
+
+func TestListViews(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+
+	for _, tc := range []struct {
+		url      string
+		contains string
+		excludes string
+	}{
+		{"/computers/list?q=pc0", "PC02", ""},
+		{"/computers/users?q=carol", "carol", "alice"},
+		{"/computers/adapters?q=10.0.0.2", "00:11:22:33:44:02", "00:11:22:33:44:01"},
+		{"/computers/stale", "PC03", "PC01"},
+	} {
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, httptest.NewRequest("GET", tc.url, nil))
+
+		equals(t, http.StatusOK, rec.Code)
+		assert(t, strings.Contains(rec.Body.String(), tc.contains), "%s: missing %s", tc.url, tc.contains)
+		assert(t, tc.excludes == "" || !strings.Contains(rec.Body.String(), tc.excludes), "%s: unexpected %s", tc.url, tc.excludes)
+		assert(t, strings.Contains(rec.Body.String(), "/computers/export/"), "%s: missing download buttons", tc.url)
+	}
+}
+
+func TestExport(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+
+	rec := httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/export/list.csv?q=pc0&page=2", nil))
+	equals(t, http.StatusOK, rec.Code)
+	equals(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert(t, strings.HasPrefix(rec.Header().Get("Content-Disposition"), `attachment; filename="fpsmonitor-list-`), "unexpected disposition %s", rec.Header().Get("Content-Disposition"))
+
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	equals(t, 4, len(lines))
+	equals(t, "ComputerName,LastSeen,LastUser,Adapters,Created", lines[0])
+	assert(t, strings.HasPrefix(lines[3], "PC03,"), "unexpected row %s", lines[3])
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/export/stale.csv", nil))
+	equals(t, 2, len(strings.Split(strings.TrimSpace(rec.Body.String()), "\n")))
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/export/users.xlsx", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.HasPrefix(rec.Body.String(), "PK"), "xlsx is not a zip archive")
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/export/secrets.csv", nil))
+	equals(t, http.StatusNotFound, rec.Code)
+}
+
+func TestParseImportCSV(t *testing.T) {
+	records, err := ParseImport(strings.NewReader(
+		"name,owner,location,adapter,mac,ip\n"+
+			"PC10,alice,Site A,eth0,00-AA-BB-CC-DD-01,10.1.0.10\n"+
+			"PC10,,,wlan0,00:aa:bb:cc:dd:02,\n"+
+			"PC11,bob,Site B,,,\n",
+	), ImportCSV)
+	ok(t, err)
+
+	equals(t, 2, len(records))
+	equals(t, 2, records[0].Line)
+	equals(t, "Site A", records[0].Location)
+	equals(t, []ImportAdapter{
+		{Name: "eth0", MacAddress: "00:aa:bb:cc:dd:01", IPAddress: "10.1.0.10"},
+		{Name: "wlan0", MacAddress: "00:aa:bb:cc:dd:02"},
+	}, records[0].Adapters)
+	equals(t, 4, records[1].Line)
+
+	_, err = ParseImport(strings.NewReader(
+		"name,mac,ip\n"+
+			"PC10,00:aa:bb:cc:dd:01,10.1.0.10\n"+
+			",00:aa:bb:cc:dd:02,\n"+
+			"PC12,not-a-mac,\n"+
+			"PC13,00:aa:bb:cc:dd:01,10.1.0.999\n",
+	), ImportCSV)
+	errs, isImportErrors := err.(ImportErrors)
+	assert(t, isImportErrors, "expected import errors, got %v", err)
+	equals(t, ImportErrors{
+		{Line: 3, Message: "name is required"},
+		{Line: 4, Message: `"not-a-mac" is not a valid MAC address`},
+		{Line: 5, Message: "MAC address 00:aa:bb:cc:dd:01 is also listed on line 2"},
+		{Line: 5, Message: `"10.1.0.999" is not a valid IP address`},
+	}, errs)
+
+	_, err = ParseImport(strings.NewReader("hostname,mac\n"), ImportCSV)
+	assert(t, strings.Contains(fmt.Sprint(err), `line 1: unknown column "hostname"`), "unexpected error %v", err)
+
+	_, err = ParseImport(strings.NewReader(""), "xml")
+	assert(t, errors.Is(err, ErrImportFormat), "unexpected error %v", err)
+}
+
+func TestParseImportJSON(t *testing.T) {
+	records, err := ParseImport(strings.NewReader(`[
+    {"name": "PC10", "owner": "alice", "adapters": [{"mac_address": "00:aa:bb:cc:dd:01"}]},
+    {
+        "name": "PC11",
+        "location": "Site B"
+    },
+    {"name": "bad name"}
+]`), ImportJSON)
+	assert(t, records == nil, "expected no records")
+	equals(t, ImportErrors{{Line: 7, Message: `"bad name" is not a valid computer name`}}, err)
+
+	records, err = ParseImport(strings.NewReader(`[{"name": "PC10"},
+{"name": "PC11", "adapters": [{"mac_address": "00:aa:bb:cc:dd:02", "ip_address": "10.0.0.5/24,10.1.0.5/16"}]}]`), ImportJSON)
+	ok(t, err)
+	equals(t, 2, records[1].Line)
+
+	// A second entry would overwrite the first.
+	records, err = ParseImport(strings.NewReader(`[
+    {"name": "PC10", "owner": "alice"},
+    {"name": "PC11"},
+    {"name": " PC10 ", "owner": "bob"}
+]`), ImportJSON)
+	assert(t, records == nil, "expected no records")
+	equals(t, ImportErrors{{Line: 4, Message: `entry 2: computer "PC10" is also entry 0 on line 2`}}, err)
+}
+
+func TestImport(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	importer := NewImporter(db)
+
+	records, err := ParseImport(strings.NewReader(
+		"name,owner,location,mac,ip\n"+
+			"PC01,alice,Site A,00:aa:bb:cc:dd:01,10.1.0.1\n"+
+			"PC10,dave,Site B,00:aa:bb:cc:dd:10,10.1.0.10\n"+
+			"PC10,,,00:aa:bb:cc:dd:11,10.1.0.11\n",
+	), ImportCSV)
+	ok(t, err)
+
+	result, err := importer.Import(dbCtx, records, true)
+	ok(t, err)
+	equals(t, &ImportResult{DryRun: true, Created: 1, Updated: 1, Adapters: 2, Skipped: 1}, result)
+
+	count, err := c.computerRepo.Count(dbCtx)
+	ok(t, err)
+	equals(t, 3, count)
+
+	result, err = importer.Import(dbCtx, records, false)
+	ok(t, err)
+	equals(t, &ImportResult{Created: 1, Updated: 1, Adapters: 2, Skipped: 1}, result)
+
+	// The agent adapters of PC01 were left alone, its owner was filled in
+	// without marking it as seen.
+	pc01, err := c.computerRepo.Select(dbCtx, "PC01")
+	ok(t, err)
+	equals(t, "alice", pc01.Owner.String)
+	equals(t, SourceAgent, pc01.Source.String)
+
+	adapters, err := c.networkAdapterRepo.SelectWithComputerID(dbCtx, int(pc01.ID.Int64))
+	ok(t, err)
+	equals(t, 1, len(adapters))
+	equals(t, "00:11:22:33:44:01", adapters[0].MacAddress.String)
+
+	pc10, err := c.computerRepo.Select(dbCtx, "PC10")
+	ok(t, err)
+	equals(t, SourceImport, pc10.Source.String)
+	equals(t, "Site B", pc10.Location.String)
+
+	// The first agent report replaces the imported adapters.
+	rec := httptest.NewRecorder()
+	c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(
+		`{"name":"PC10","username":"dave","adapters":[{"name":"Ethernet","mac_address":"00:aa:bb:cc:dd:10","ip_address":"10.1.0.20/24"}]}`,
+	)))
+	equals(t, http.StatusOK, rec.Code)
+
+	pc10, err = c.computerRepo.Select(dbCtx, "PC10")
+	ok(t, err)
+	equals(t, SourceAgent, pc10.Source.String)
+	equals(t, "Site B", pc10.Location.String)
+
+	adapters, err = c.networkAdapterRepo.SelectWithComputerID(dbCtx, int(pc10.ID.Int64))
+	ok(t, err)
+	equals(t, 1, len(adapters))
+	equals(t, "10.1.0.20/24", adapters[0].IPAddress.String)
+	equals(t, SourceAgent, adapters[0].Source.String)
+
+	// A failing record leaves nothing of the import behind.
+	_, err = db.ExecContext(dbCtx, `CREATE TRIGGER reject_bad BEFORE INSERT ON computers WHEN new.name='BAD' BEGIN SELECT RAISE(ABORT, 'rejected'); END`)
+	ok(t, err)
+	records, err = ParseImport(strings.NewReader("name,owner\nPC11,erin\nPC01,frank\nBAD,\n"), ImportCSV)
+	ok(t, err)
+	_, err = importer.Import(dbCtx, records, false)
+	assert(t, err != nil, "expected the import to fail")
+
+	pc11, err := c.computerRepo.Select(dbCtx, "PC11")
+	ok(t, err)
+	assert(t, pc11 == nil, "PC11 was imported")
+	pc01, err = c.computerRepo.Select(dbCtx, "PC01")
+	ok(t, err)
+	equals(t, "alice", pc01.Owner.String)
+}
+
+func TestImportController(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	seedInventory(t, db)
+
+	router := mux.NewRouter()
+	NewImportController(db, lumber.NewBasicLogger(discard{}, lumber.FATAL), router, allowAll)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/computers/import?format=json&dry_run=1", strings.NewReader(`[{"name":"PC20"}]`)))
+	equals(t, http.StatusOK, rec.Code)
+	equals(t, "{\"result\":{\"dry_run\":true,\"created\":1,\"updated\":0,\"adapters\":0,\"skipped\":0}}\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/computers/import?format=json", strings.NewReader(`[{"name":""}]`)))
+	equals(t, http.StatusUnprocessableEntity, rec.Code)
+	equals(t, "{\"errors\":[{\"line\":1,\"message\":\"name is required\"}]}\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/import", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), `enctype="multipart/form-data"`), "missing upload form")
+}
+
+func TestMigrateRecordSource(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	d := database.DialectOf(db)
+	for _, ddl := range []string{
+		`CREATE TABLE computers (` + d.PrimaryKey + `, "created" TEXT, "updated" TEXT, "deleted" TEXT, "name" TEXT)`,
+		`CREATE TABLE computer_network_adapters (` + d.PrimaryKey + `, "created" TEXT, "updated" TEXT, "deleted" TEXT,
+            "computer_id" INTEGER NOT NULL, "name" TEXT, "mac_address" TEXT, "ip_address" TEXT)`,
+		`CREATE TABLE computer_users (` + d.PrimaryKey + `, "created" TEXT, "updated" TEXT, "deleted" TEXT,
+            "computer_id" INTEGER NOT NULL, "username" TEXT)`,
+		`INSERT INTO computers (name) VALUES ('PC01')`,
+	} {
+		_, err = db.ExecContext(dbCtx, ddl)
+		ok(t, err)
+	}
+
+	repo := NewSchemaRepository(db)
+	ok(t, repo.Install(dbCtx))
+	ok(t, repo.SetVersion(dbCtx, 1))
+
+	ok(t, Migrate(dbCtx, db))
+
+	comp, err := NewComputerRepository(db).Select(dbCtx, "PC01")
+	ok(t, err)
+	equals(t, SourceAgent, comp.Source.String)
+}
+
+func TestParseIPs(t *testing.T) {
+	for _, tc := range []struct {
+		in  string
+		exp []string
+	}{
+		{"10.0.0.5", []string{"10.0.0.5"}},
+		{"10.0.0.5/24", []string{"10.0.0.5"}},
+		{"10.0.0.5/24,10.1.0.5/16", []string{"10.0.0.5", "10.1.0.5"}},
+		{"192.168.1.20/2, 172.16.0.1/32", []string{"192.168.1.20", "172.16.0.1"}},
+		{"10.0.0.5/3;10.1.0.5", []string{"10.0.0.5", "10.1.0.5"}},
+		{"10.0.0.5/33,bad,10.1.0.5/8", []string{"10.1.0.5"}},
+		{"10.0.0.5/2410.1.0.5/16", nil},
+		{"", nil},
+	} {
+		var ips []string
+		for _, ip := range parseIPs(tc.in) {
+			ips = append(ips, ip.String())
+		}
+		equals(t, tc.exp, ips)
+	}
+}
+
+func TestAssignmentRules(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	tags := NewTagRepository(db)
+	locations := NewLocationRepository(db)
+	rules := NewRuleRepository(db)
+
+	labID, err := tags.Create(dbCtx, &Tag{Name: null.StringFrom("lab")})
+	ok(t, err)
+	siteID, err := locations.Create(dbCtx, &Location{Name: null.StringFrom("Site A")})
+	ok(t, err)
+	buildingID, err := locations.Create(dbCtx, &Location{Name: null.StringFrom("Building 2"), ParentID: null.IntFrom(siteID)})
+	ok(t, err)
+
+	for _, rule := range []Rule{
+		{Kind: null.StringFrom(RuleHostnamePrefix), Pattern: null.StringFrom("pc0"), TagID: null.IntFrom(labID)},
+		{Kind: null.StringFrom(RuleSubnet), Pattern: null.StringFrom("10.0.0.2/31"), LocationID: null.IntFrom(buildingID)},
+	} {
+		ok(t, rule.Validate())
+		_, err = rules.Create(dbCtx, &rule)
+		ok(t, err)
+	}
+
+	n, err := NewAssigner(db).ApplyAll(dbCtx)
+	ok(t, err)
+	equals(t, 3, n)
+
+	names := func(f Filter) []string {
+		list := []string{}
+		ok(t, c.computerRepo.EachSummary(dbCtx, f, func(s ComputerSummary) error {
+			list = append(list, s.Name.String)
+			return nil
+		}))
+		return list
+	}
+
+	equals(t, []string{"PC01", "PC02", "PC03"}, names(Filter{Tag: "LAB"}))
+	equals(t, []string{"PC02", "PC03"}, names(Filter{Location: siteID}))
+	equals(t, []string{"PC02", "PC03"}, names(Filter{Location: buildingID}))
+
+	// A manual link survives the rules being recalculated, a rule link
+	// follows the computer.
+	ok(t, locations.Assign(dbCtx, 1, siteID, LinkManual))
+	ok(t, locations.Assign(dbCtx, 3, buildingID, LinkManual))
+
+	rec := httptest.NewRecorder()
+	c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(
+		`{"name":"PC02","username":"bob","adapters":[{"name":"eth0","mac_address":"00:11:22:33:44:02","ip_address":"10.9.0.2/24"}]}`,
+	)))
+	equals(t, http.StatusOK, rec.Code)
+
+	_, err = NewAssigner(db).ApplyAll(dbCtx)
+	ok(t, err)
+
+	equals(t, []string{"PC01", "PC03"}, names(Filter{Location: siteID}))
+
+	linked, err := locations.ListForComputers(dbCtx, []int64{3})
+	ok(t, err)
+	equals(t, LinkManual, linked[3][0].Source.String)
+
+	invalid := Rule{Kind: null.StringFrom(RuleSubnet), Pattern: null.StringFrom("10.0.0.300/24"), TagID: null.IntFrom(labID)}
+	assert(t, invalid.Validate() != nil, "invalid subnet accepted")
+	equals(t, ErrLocationInUse, locations.Delete(dbCtx, int(siteID)))
+}
+
+func TestTagControllerAndAPI(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewTagController(db, log, c.router, allowAll)
+	NewAPIController(db, log, c.router, allowAll)
+
+	post := func(url string, form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", url, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	equals(t, http.StatusSeeOther, post("/computers/tags", "name=kiosk").Code)
+	equals(t, http.StatusUnprocessableEntity, post("/computers/tags", "name=KIOSK").Code)
+	equals(t, http.StatusSeeOther, post("/computers/locations", "name=Site+B").Code)
+	equals(t, http.StatusUnprocessableEntity, post("/computers/rules", "kind=subnet&pattern=nope&tag=1").Code)
+	equals(t, http.StatusSeeOther, post("/computers/2/tags", "tag=1").Code)
+	equals(t, http.StatusSeeOther, post("/computers/2/locations", "location=1").Code)
+	equals(t, http.StatusNotFound, post("/computers/99/tags", "tag=1").Code)
+
+	rec := httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/computers?tag=kiosk", nil))
+	equals(t, http.StatusOK, rec.Code)
+
+	var list struct {
+		Page      int               `json:"page"`
+		Computers []ComputerSummary `json:"computers"`
+	}
+	ok(t, json.NewDecoder(rec.Body).Decode(&list))
+	equals(t, 1, len(list.Computers))
+	equals(t, "PC02", list.Computers[0].Name.String)
+	equals(t, "kiosk", list.Computers[0].Tags[0].Name.String)
+	equals(t, LinkManual, list.Computers[0].Tags[0].Source.String)
+	equals(t, "Site B", list.Computers[0].Locations[0].Path)
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/computers/99", nil))
+	equals(t, http.StatusNotFound, rec.Code)
+
+	for _, url := range []string{"/computers/2", "/computers/tags", "/computers/list?location=1"} {
+		rec = httptest.NewRecorder()
+		c.router.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		equals(t, http.StatusOK, rec.Code)
+		assert(t, strings.Contains(rec.Body.String(), "Site B"), "%s: missing location", url)
+	}
+
+	equals(t, http.StatusSeeOther, post("/computers/2/tags/1/delete", "").Code)
+	equals(t, http.StatusSeeOther, post("/computers/tags/1/delete", "").Code)
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/computers?tag=kiosk", nil))
+	ok(t, json.NewDecoder(rec.Body).Decode(&list))
+	equals(t, 0, len(list.Computers))
+}
+
+func TestSubnetSize(t *testing.T) {
+	for _, tc := range []struct {
+		cidr string
+		size int
+	}{
+		{"10.0.0.0/24", 254},
+		{"10.0.0.0/31", 2},
+		{"10.0.0.1/32", 1},
+		{"nope", 0},
+	} {
+		equals(t, tc.size, Subnet{CIDR: null.StringFrom(tc.cidr)}.Size())
+	}
+
+	subnets := []Subnet{
+		{ID: null.IntFrom(1), CIDR: null.StringFrom("10.0.0.0/8")},
+		{ID: null.IntFrom(2), CIDR: null.StringFrom("10.1.0.0/16")},
+	}
+	equals(t, int64(2), MatchSubnet(subnets, parseIPs("10.1.2.3/16")).ID.Int64)
+	equals(t, int64(1), MatchSubnet(subnets, parseIPs("10.2.2.3/16")).ID.Int64)
+	assert(t, MatchSubnet(subnets, parseIPs("192.168.0.1")) == nil, "unexpected subnet match")
+
+	invalid := Subnet{CIDR: null.StringFrom("10.0.0.0/24"), Name: null.StringFrom("Office"), VLAN: null.IntFrom(5000)}
+	assert(t, invalid.Validate() != nil, "invalid VLAN accepted")
+}
+
+func TestSubnets(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewSubnetController(db, log, c.router, allowAll)
+	NewAPIController(db, log, c.router, allowAll)
+
+	siteID, err := NewLocationRepository(db).Create(dbCtx, &Location{Name: null.StringFrom("Site A")})
+	ok(t, err)
+
+	post := func(form string) int {
+		req := httptest.NewRequest("POST", "/computers/subnets", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	equals(t, http.StatusSeeOther, post(fmt.Sprintf("name=Office&cidr=10.0.0.7/24&location=%d", siteID)))
+	equals(t, http.StatusSeeOther, post("name=Lab&cidr=10.0.0.2/31&vlan=20"))
+	equals(t, http.StatusUnprocessableEntity, post("name=Bad&cidr=10.0.0.0/33"))
+
+	usage, err := NewSubnetRepository(db).Usage(dbCtx, time.Now().Add(-StaleAfter))
+	ok(t, err)
+	equals(t, 2, len(usage))
+	equals(t, "10.0.0.0/24", usage[0].CIDR.String)
+	equals(t, []int{1, 1, 1}, []int{usage[0].Computers, usage[0].Active, usage[0].Addresses})
+	equals(t, "Site A", usage[0].LocationName.String)
+	equals(t, []int{2, 1, 2}, []int{usage[1].Computers, usage[1].Active, usage[1].Addresses})
+
+	names := func(f Filter) []string {
+		list := []string{}
+		ok(t, c.computerRepo.EachSummary(dbCtx, f, func(s ComputerSummary) error {
+			list = append(list, s.Name.String)
+			return nil
+		}))
+		return list
+	}
+	equals(t, []string{"PC02", "PC03"}, names(Filter{Subnet: usage[1].ID.Int64}))
+	equals(t, []string{"PC01"}, names(Filter{Location: siteID}))
+
+	// The site follows the computer when it moves to another network.
+	rec := httptest.NewRecorder()
+	c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(
+		`{"name":"PC01","username":"alice","adapters":[{"name":"eth0","mac_address":"00:11:22:33:44:01","ip_address":"192.168.1.1/24"}]}`,
+	)))
+	equals(t, http.StatusOK, rec.Code)
+	equals(t, []string{}, names(Filter{Location: siteID}))
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/subnets", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), "10.0.0.2/31"), "missing subnet")
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/subnets", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), `"utilisation":100`), "unexpected usage %s", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/export/subnets.csv", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.HasPrefix(rec.Body.String(), "Name,Subnet,VLAN,Site"), "unexpected export %s", rec.Body.String())
+}
+
+func TestFieldCheck(t *testing.T) {
+	equals(t, "warranty_end", FieldKey(" Warranty End! "))
+
+	enum := Field{Label: null.StringFrom("State"), Kind: null.StringFrom(FieldEnum), Options: null.StringFrom("in use, spare ,")}
+	ok(t, enum.Validate())
+	equals(t, "state", enum.Key.String)
+	equals(t, "in use,spare", enum.Options.String)
+
+	for _, tc := range []struct {
+		kind  string
+		in    string
+		exp   string
+		valid bool
+	}{
+		{FieldText, " A-1 ", "A-1", true},
+		{FieldNumber, "1.50", "1.5", true},
+		{FieldNumber, "many", "", false},
+		{FieldDate, "2027-03-31", "2027-03-31", true},
+		{FieldDate, "31/03/2027", "", false},
+		{FieldEnum, "SPARE", "spare", true},
+		{FieldEnum, "broken", "", false},
+		{FieldDate, "", "", true},
+	} {
+		f := enum
+		f.Kind = null.StringFrom(tc.kind)
+		v, err := f.Check(tc.in)
+		equals(t, tc.valid, err == nil)
+		equals(t, tc.exp, v)
+	}
+}
+
+func TestCustomFields(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewFieldController(db, log, c.router, allowAll)
+	NewAPIController(db, log, c.router, allowAll)
+
+	send := func(method string, url string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, req)
+		return rec
+	}
+	form := "application/x-www-form-urlencoded"
+
+	equals(t, http.StatusSeeOther, send("POST", "/computers/fields", form, "label=Asset+Tag&kind=text").Code)
+	equals(t, http.StatusSeeOther, send("POST", "/computers/fields", form, "label=Warranty+End&kind=date").Code)
+	equals(t, http.StatusUnprocessableEntity, send("POST", "/computers/fields", form, "label=asset+tag&kind=text").Code)
+
+	equals(t, http.StatusSeeOther, send("POST", "/computers/1/details", form, "owner=Helpdesk&notes=Spare+keyboard&field_asset_tag=A-1001").Code)
+	equals(t, http.StatusUnprocessableEntity, send("POST", "/computers/1/details", form, "field_warranty_end=soon").Code)
+
+	// An agent report leaves the manual details alone.
+	rec := httptest.NewRecorder()
+	c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(
+		`{"name":"PC01","username":"alice","adapters":[{"name":"eth0","mac_address":"00:11:22:33:44:01","ip_address":"10.0.0.1"}]}`,
+	)))
+	equals(t, http.StatusOK, rec.Code)
+
+	rec = send("PATCH", "/api/v1/computers/1", "application/json", `{"fields":{"warranty_end":"2027-03-31"}}`)
+	equals(t, http.StatusOK, rec.Code)
+
+	var detail ComputerDetail
+	ok(t, json.NewDecoder(rec.Body).Decode(&detail))
+	equals(t, "Helpdesk", detail.Owner.String)
+	equals(t, "Spare keyboard", detail.Notes.String)
+	equals(t, 2, len(detail.Fields))
+	equals(t, "A-1001", detail.Fields[0].Value.String)
+	equals(t, "2027-03-31", detail.Fields[1].Value.String)
+
+	equals(t, http.StatusUnprocessableEntity, send("PATCH", "/api/v1/computers/1", "application/json", `{"fields":{"colour":"red"}}`).Code)
+	equals(t, http.StatusBadRequest, send("PATCH", "/api/v1/computers/1", "application/json", `{"name":"PC99"}`).Code)
+	equals(t, http.StatusNotFound, send("PATCH", "/api/v1/computers/99", "application/json", `{}`).Code)
+
+	for _, search := range []string{"a-1001", "keyboard", "helpdesk"} {
+		var names []string
+		ok(t, c.computerRepo.EachSummary(dbCtx, Filter{Search: search}, func(s ComputerSummary) error {
+			names = append(names, s.Name.String)
+			return nil
+		}))
+		equals(t, []string{"PC01"}, names)
+	}
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/1", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), `value="A-1001"`), "missing field value")
+
+	equals(t, http.StatusSeeOther, send("POST", "/computers/fields/1/delete", form, "").Code)
+	rec = send("GET", "/api/v1/computers/1", "", "")
+	ok(t, json.NewDecoder(rec.Body).Decode(&detail))
+	equals(t, 1, len(detail.Fields))
+}
+
+func TestDeleteComputer(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewAPIController(db, log, c.router, allowAll)
+
+	send := func(method string, url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, httptest.NewRequest(method, url, nil))
+		return rec
+	}
+
+	rec := send("GET", "/computers/1")
+	assert(t, strings.Contains(rec.Body.String(), `action="/computers/1/delete"`), "missing delete form")
+
+	rec = send("POST", "/computers/1/delete")
+	equals(t, http.StatusSeeOther, rec.Code)
+	equals(t, "/computers/list", rec.Header().Get("Location"))
+	equals(t, http.StatusNotFound, send("GET", "/computers/1").Code)
+	equals(t, http.StatusNotFound, send("POST", "/computers/1/delete").Code)
+
+	equals(t, http.StatusNoContent, send("DELETE", "/api/v1/computers/2").Code)
+	equals(t, http.StatusNotFound, send("GET", "/api/v1/computers/2").Code)
+	equals(t, http.StatusNotFound, send("DELETE", "/api/v1/computers/2").Code)
+
+	count, err := c.computerRepo.Count(dbCtx)
+	ok(t, err)
+	equals(t, 1, count)
+}
+
+func TestMergeComputers(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewMergeController(db, log, c.router, allowAll)
+	NewAPIController(db, log, c.router, allowAll)
+
+	send := func(method string, url string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, req)
+		return rec
+	}
+	form := "application/x-www-form-urlencoded"
+
+	// A duplicate of PC01 left by an import, sharing a MAC address with PC02.
+	id, err := c.computerRepo.Create(dbCtx, &Computer{Name: null.StringFrom("pc01"), Owner: null.StringFrom("Finance")})
+	ok(t, err)
+	_, err = c.userRepo.Create(dbCtx, &User{Username: null.StringFrom("dave"), ComputerID: null.IntFrom(id)})
+	ok(t, err)
+	for _, mac := range []string{"00:11:22:33:44:01", "00:11:22:33:44:02", "00:11:22:33:44:99"} {
+		_, err = c.networkAdapterRepo.Create(dbCtx, &NetworkAdapter{ComputerID: null.IntFrom(id), MacAddress: null.StringFrom(mac)})
+		ok(t, err)
+	}
+
+	duplicates, err := NewMergeRepository(db).Duplicates(dbCtx)
+	ok(t, err)
+	equals(t, 2, len(duplicates))
+	equals(t, "PC01", duplicates[0].First.Name)
+	equals(t, []string{"same name", "shared MAC 00:11:22:33:44:01"}, duplicates[0].Reasons)
+	equals(t, "PC02", duplicates[1].First.Name)
+	equals(t, []string{"shared MAC 00:11:22:33:44:02"}, duplicates[1].Reasons)
+
+	rec := send("GET", "/computers/duplicates", "", "")
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), "Keep pc01"), "missing merge form")
+
+	equals(t, http.StatusUnprocessableEntity, send("POST", "/computers/1/merge", form, "duplicate=1").Code)
+	equals(t, http.StatusNotFound, send("POST", "/computers/1/merge", form, "duplicate=99").Code)
+	equals(t, http.StatusSeeOther, send("POST", "/computers/1/merge", form, fmt.Sprintf("duplicate=%d", id)).Code)
+
+	rec = send("GET", "/api/v1/computers/1", "", "")
+	var detail ComputerDetail
+	ok(t, json.NewDecoder(rec.Body).Decode(&detail))
+	equals(t, "Finance", detail.Owner.String)
+	equals(t, 3, len(detail.Adapters))
+	equals(t, 1, len(detail.Merges))
+	equals(t, "pc01", detail.Merges[0].MergedName.String)
+	equals(t, 1, detail.Merges[0].Users)
+	equals(t, 2, detail.Merges[0].Adapters)
+
+	user, err := c.userRepo.SelectWithUsernameAndComputerID(dbCtx, 1, "dave")
+	ok(t, err)
+	assert(t, user != nil, "user not moved")
+
+	// PC01 now shares MAC addresses with PC02, merge it over the API.
+	rec = send("POST", "/api/v1/computers/2/merge", "application/json", `{"duplicate":1}`)
+	equals(t, http.StatusOK, rec.Code)
+	equals(t, http.StatusNotFound, send("POST", "/api/v1/computers/1/merge", "application/json", `{"duplicate":3}`).Code)
+
+	// Reports under a merged name, in any case, go to the survivor.
+	for _, name := range []string{"PC01", "pc01"} {
+		comp, err := c.computerRepo.Select(dbCtx, name)
+		ok(t, err)
+		equals(t, int64(2), comp.ID.Int64)
+	}
+
+	duplicates, err = NewMergeRepository(db).Duplicates(dbCtx)
+	ok(t, err)
+	equals(t, 0, len(duplicates))
+}
+
+func TestParseSearch(t *testing.T) {
+	for _, test := range []struct {
+		q    string
+		kind string
+		term string
+	}{
+		{"PC01", SearchName, "pc01"},
+		{" alice ", SearchName, "alice"},
+		{"10.0.0.1", SearchIP, "10.0.0.1"},
+		{"10.0.", SearchIP, "10.0."},
+		{"00:11:22:33:44:01", SearchMAC, "001122334401"},
+		{"00-11-22", SearchMAC, "001122"},
+		{"0011.2233.4401", SearchMAC, "001122334401"},
+		{"001122334401", SearchMAC, "001122334401"},
+		{"cafe", SearchName, "cafe"},
+	} {
+		q := ParseSearch(test.q)
+		equals(t, test.kind, q.Kind)
+		equals(t, test.term, q.Term)
+	}
+
+	equals(t, `"acc"* "pc01"*`, ParseSearch("ACC-PC01").matchQuery())
+}
+
+func TestSearch(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	NewAPIController(db, lumber.NewBasicLogger(discard{}, lumber.FATAL), c.router, allowAll)
+
+	// A renamed computer is found under its new name only.
+	_, err = db.ExecContext(dbCtx, "UPDATE computers SET name='ACC-PC02' WHERE name='PC02'")
+	ok(t, err)
+
+	search := func(q string) SearchResult {
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/search?q="+url.QueryEscape(q), nil))
+		equals(t, http.StatusOK, rec.Code)
+
+		var result SearchResult
+		ok(t, json.NewDecoder(rec.Body).Decode(&result))
+		return result
+	}
+	names := func(hits []SearchHit) []string {
+		list := []string{}
+		for _, h := range hits {
+			list = append(list, h.ComputerName.String)
+		}
+		return list
+	}
+
+	result := search("pc0")
+	equals(t, SearchName, result.Kind)
+	equals(t, []string{"PC01", "PC03", "ACC-PC02"}, names(result.Computers))
+
+	result = search("acc")
+	equals(t, []string{"ACC-PC02"}, names(result.Computers))
+
+	result = search("pc02")
+	equals(t, []string{"ACC-PC02"}, names(result.Computers))
+
+	result = search("BOB")
+	equals(t, []string{"ACC-PC02"}, names(result.Users))
+	equals(t, "bob", result.Users[0].Value.String)
+
+	result = search("00-11-22-33-44-03")
+	equals(t, SearchMAC, result.Kind)
+	equals(t, []string{"PC03"}, names(result.Adapters))
+
+	result = search("10.0.0.2")
+	equals(t, SearchIP, result.Kind)
+	equals(t, []string{"ACC-PC02"}, names(result.Adapters))
+
+	result = search("10.0.0.")
+	equals(t, 3, len(result.Adapters))
+
+	rec := httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/search?q=carol", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), `href="/computers/3"`), "missing link to PC03")
+}
+
+func TestSearchIndexWithoutFTS(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	ok(t, Migrate(dbCtx, db))
+	fts, err := database.FullTextSearch(dbCtx, db)
+	ok(t, err)
+	if fts {
+		t.Skip("built with FTS5")
+	}
+
+	// A database created by a build with FTS5 is refused.
+	_, err = db.ExecContext(dbCtx, `CREATE TABLE computer_names (name TEXT)`)
+	ok(t, err)
+	err = Migrate(dbCtx, db)
+	assert(t, err != nil && strings.Contains(err.Error(), "sqlite_fts5"), "expected FTS5 error, got %v", err)
+}
+
+func TestAccess(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+
+	accounts := account.NewRepository(db)
+	for _, a := range []struct{ username, role string }{{"vera", account.RoleViewer}, {"hank", account.RoleHelpdesk}} {
+		hash, err := account.HashPassword("correct horse battery")
+		ok(t, err)
+		_, err = accounts.Create(dbCtx, &account.Account{
+			Username: null.StringFrom(a.username),
+			Password: null.StringFrom(hash),
+			Role:     null.StringFrom(a.role),
+		})
+		ok(t, err)
+	}
+
+	access := NewAccess(db, log, sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef")), "admin-secret")
+	router := mux.NewRouter()
+	NewComputerController(db, log, router, "", access.Require)
+	NewFieldController(db, log, router, access.Require)
+	NewAPIController(db, log, router, access.Require)
+	NewLoginController(access, log, router)
+
+	send := func(method string, url string, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func(username string) http.Header {
+		rec := send("POST", "/login", "username="+username+"&password=correct+horse+battery&next=/computers/1", nil)
+		equals(t, http.StatusSeeOther, rec.Code)
+		equals(t, "/computers/1", rec.Header().Get("Location"))
+		return http.Header{"Cookie": {rec.Header().Get("Set-Cookie")}}
+	}
+
+	rec := send("GET", "/computers/list", "", nil)
+	equals(t, http.StatusSeeOther, rec.Code)
+	equals(t, "/login?next=%2Fcomputers%2Flist", rec.Header().Get("Location"))
+	equals(t, http.StatusUnauthorized, send("GET", "/api/v1/computers", "", nil).Code)
+	equals(t, http.StatusUnauthorized, send("POST", "/computers/1/details", "owner=x", nil).Code)
+	equals(t, http.StatusOK, send("GET", "/login", "", nil).Code)
+
+	equals(t, http.StatusOK, send("GET", "/api/v1/computers", "", http.Header{"Authorization": {"Bearer admin-secret"}}).Code)
+	equals(t, http.StatusUnauthorized, send("GET", "/api/v1/computers", "", http.Header{"Authorization": {"Bearer wrong"}}).Code)
+
+	equals(t, http.StatusUnauthorized, send("POST", "/login", "username=vera&password=wrong+password", nil).Code)
+	equals(t, http.StatusUnauthorized, send("POST", "/login", "username=nobody&password=correct+horse+battery", nil).Code)
+
+	viewer := login("vera")
+	rec = send("GET", "/computers/list", "", viewer)
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), "vera (viewer)"), "missing account in navigation")
+	rec = send("GET", "/computers/1", "", viewer)
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, !strings.Contains(rec.Body.String(), `action="/computers/1/details"`), "details form shown to a viewer")
+	equals(t, http.StatusForbidden, send("POST", "/computers/1/details", "owner=Vera", viewer).Code)
+	equals(t, http.StatusForbidden, send("POST", "/computers/fields", "label=Asset&kind=text", viewer).Code)
+
+	helpdesk := login("hank")
+	rec = send("GET", "/computers/1", "", helpdesk)
+	assert(t, strings.Contains(rec.Body.String(), `action="/computers/1/details"`), "details form hidden from helpdesk")
+
+	// Forms posted with the session carry its CSRF token.
+	token := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindStringSubmatch(rec.Body.String())
+	assert(t, token != nil, "details form without a CSRF token")
+	equals(t, http.StatusForbidden, send("POST", "/computers/1/details", "owner=Hank", helpdesk).Code)
+	equals(t, http.StatusForbidden, send("POST", "/computers/1/details", "owner=Hank&csrf_token=forged", helpdesk).Code)
+	equals(t, http.StatusSeeOther, send("POST", "/computers/1/details", "owner=Hank&csrf_token="+token[1], helpdesk).Code)
+	rec = send("PATCH", "/api/v1/computers/1", `{"owner":"Hank"}`, http.Header{"Cookie": helpdesk["Cookie"], "X-Csrf-Token": {token[1]}})
+	equals(t, http.StatusOK, rec.Code)
+	equals(t, http.StatusForbidden, send("POST", "/computers/fields", "label=Asset&kind=text", helpdesk).Code)
+	equals(t, http.StatusForbidden, send("POST", "/computers/1/delete", "", helpdesk).Code)
+	equals(t, http.StatusForbidden, send("DELETE", "/api/v1/computers/1", "", helpdesk).Code)
+
+	acct, err := accounts.SelectWithUsername(dbCtx, "hank")
+	ok(t, err)
+	assert(t, acct.LastLogin.Valid, "last login not recorded")
+
+	rec = send("POST", "/logout", "", helpdesk)
+	equals(t, http.StatusSeeOther, rec.Code)
+	assert(t, strings.Contains(rec.Header().Get("Set-Cookie"), "Max-Age=0"), "session not cleared")
+
+	// Only paths on this server are followed after signing in.
+	rec = send("POST", "/login", "username=hank&password=correct+horse+battery&next=//evil.example", nil)
+	equals(t, "/computers/dashboard", rec.Header().Get("Location"))
+}
+
+func TestAPIKeys(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewKeyController(db, log, c.router, allowAll)
+
+	access := NewAccess(db, log, sessions.NewCookieStore([]byte("0123456789abcdef0123456789abcdef")), "")
+	router := mux.NewRouter()
+	NewComputerController(db, log, router, "", access.Require)
+	NewAPIController(db, log, router, access.Require)
+
+	create := func(form string) string {
+		req := httptest.NewRequest("POST", "/computers/keys", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, req)
+		equals(t, http.StatusCreated, rec.Code)
+		key := regexp.MustCompile(`fpm_[A-Za-z0-9_-]{32}`).FindString(rec.Body.String())
+		assert(t, key != "", "new key not shown")
+		return key
+	}
+	send := func(method string, url string, key string) int {
+		req := httptest.NewRequest(method, url, strings.NewReader(`{"owner":"CMDB"}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	read := create("name=CMDB&scope=read&expires=90")
+	export := create("name=Reports&scope=export&expires=")
+	admin := create("name=Automation&scope=admin&expires=30")
+
+	rec := httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("POST", "/computers/keys", strings.NewReader("")))
+	equals(t, http.StatusUnprocessableEntity, rec.Code)
+
+	equals(t, http.StatusOK, send("GET", "/api/v1/computers", read))
+	equals(t, http.StatusForbidden, send("GET", "/computers/export/list.csv", read))
+	equals(t, http.StatusForbidden, send("PATCH", "/api/v1/computers/1", read))
+	equals(t, http.StatusOK, send("GET", "/computers/export/list.csv", export))
+	equals(t, http.StatusForbidden, send("PATCH", "/api/v1/computers/1", export))
+	equals(t, http.StatusOK, send("PATCH", "/api/v1/computers/1", admin))
+
+	// Keys are not accepted by the pages.
+	equals(t, http.StatusUnauthorized, send("GET", "/computers/list", admin))
+	equals(t, http.StatusUnauthorized, send("GET", "/api/v1/computers", "fpm_unknown"))
+
+	keys, err := account.NewKeyRepository(db).List(dbCtx)
+	ok(t, err)
+	equals(t, 3, len(keys))
+	equals(t, "Automation", keys[0].Name.String)
+	equals(t, "admin token", keys[0].CreatedBy.String)
+	assert(t, keys[0].LastUsed.Valid, "last use not recorded")
+	assert(t, !keys[1].Expires.Valid, "export key expires")
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("POST", fmt.Sprintf("/computers/keys/%d/revoke", keys[2].ID.Int64), nil))
+	equals(t, http.StatusSeeOther, rec.Code)
+	equals(t, http.StatusUnauthorized, send("GET", "/api/v1/computers", read))
+
+	_, err = db.ExecContext(dbCtx, "UPDATE api_keys SET expires='2000-01-01 00:00:00' WHERE name='Reports'")
+	ok(t, err)
+	equals(t, http.StatusUnauthorized, send("GET", "/computers/export/list.csv", export))
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/keys", nil))
+	assert(t, !strings.Contains(rec.Body.String(), read), "key shown again")
+	assert(t, strings.Contains(rec.Body.String(), "Expired"), "expired key not marked")
+
+	// Keys of an account follow its role and stop working once it is
+	// deleted.
+	accounts := account.NewRepository(db)
+	hash, err := account.HashPassword("correct horse battery")
+	ok(t, err)
+	ada := &account.Account{Username: null.StringFrom("ada"), Password: null.StringFrom(hash), Role: null.StringFrom(account.RoleAdmin)}
+	ada.ID.Int64, err = accounts.Create(dbCtx, ada)
+	ok(t, err)
+	ada.ID.Valid = true
+	key, data, err := account.NewAPIKey("Ada's script", account.ScopeAdmin, time.Time{})
+	ok(t, err)
+	data.AccountID = ada.ID
+	_, err = account.NewKeyRepository(db).Create(dbCtx, data)
+	ok(t, err)
+	equals(t, http.StatusOK, send("PATCH", "/api/v1/computers/1", key))
+
+	ada.Role = null.StringFrom(account.RoleViewer)
+	ok(t, accounts.Update(dbCtx, ada))
+	equals(t, http.StatusForbidden, send("PATCH", "/api/v1/computers/1", key))
+	equals(t, http.StatusOK, send("GET", "/api/v1/computers", key))
+
+	ok(t, accounts.Delete(dbCtx, ada.ID.Int64))
+	equals(t, http.StatusUnauthorized, send("GET", "/api/v1/computers", key))
+}
+
+func TestAlertRuleValidate(t *testing.T) {
+	for _, tc := range []struct {
+		rule  AlertRule
+		valid bool
+	}{
+		{AlertRule{Name: null.StringFrom("New"), Kind: null.StringFrom(AlertNewComputer)}, true},
+		{AlertRule{Name: null.StringFrom(" "), Kind: null.StringFrom(AlertNewComputer)}, false},
+		{AlertRule{Name: null.StringFrom("New"), Kind: null.StringFrom("reboot")}, false},
+		{AlertRule{Name: null.StringFrom("New"), Kind: null.StringFrom(AlertNewComputer), Severity: null.StringFrom("loud")}, false},
+		{AlertRule{Name: null.StringFrom("Stale"), Kind: null.StringFrom(AlertStale)}, false},
+		{AlertRule{Name: null.StringFrom("Stale"), Kind: null.StringFrom(AlertStale), Threshold: null.IntFrom(7)}, true},
+		{AlertRule{Name: null.StringFrom("Admins"), Kind: null.StringFrom(AlertPrivilegedUser)}, false},
+		{AlertRule{Name: null.StringFrom("Admins"), Kind: null.StringFrom(AlertPrivilegedUser), Pattern: null.StringFrom("admin(")}, false},
+		{AlertRule{Name: null.StringFrom("Admins"), Kind: null.StringFrom(AlertPrivilegedUser), Pattern: null.StringFrom("admin.*")}, true},
+		{AlertRule{Name: null.StringFrom("New"), Kind: null.StringFrom(AlertNewComputer), Exclude: null.StringFrom("[")}, false},
+		{AlertRule{Name: null.StringFrom("New"), Kind: null.StringFrom(AlertNewComputer), Cooldown: null.IntFrom(-1)}, false},
+	} {
+		err := tc.rule.Validate()
+		assert(t, tc.valid == (err == nil), "%s: %v", tc.rule.Name.String, err)
+	}
+
+	rule := AlertRule{Name: null.StringFrom("New"), Kind: null.StringFrom(AlertNewComputer), Threshold: null.IntFrom(3)}
+	ok(t, rule.Validate())
+	equals(t, SeverityWarning, rule.Severity.String)
+	equals(t, int64(DefaultAlertCooldown), rule.Cooldown.Int64)
+	equals(t, false, rule.Threshold.Valid)
+}
+
+func TestAlerts(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewAlertController(db, log, c.router, allowAll)
+	NewAPIController(db, log, c.router, allowAll)
+
+	post := func(url string, form string) int {
+		req := httptest.NewRequest("POST", url, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	report := func(name string, user string, adapters string) {
+		rec := httptest.NewRecorder()
+		c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(
+			fmt.Sprintf(`{"name":"%s","username":"%s","adapters":[%s]}`, name, user, adapters),
+		)))
+		equals(t, http.StatusOK, rec.Code)
+	}
+	alerts := func(state string) map[string]Alert {
+		list, err := NewAlertRepository(db).List(dbCtx, state, maxAlerts)
+		ok(t, err)
+		byKey := make(map[string]Alert)
+		for _, a := range list {
+			byKey[a.Key.String] = a
+		}
+		return byKey
+	}
+
+	for _, form := range []string{
+		"name=New&kind=new_computer&severity=info",
+		"name=Added&kind=adapter_added",
+		"name=Conflict&kind=address_conflict&severity=critical",
+		"name=Stale&kind=stale&threshold=7",
+		"name=Spread&kind=user_spread&threshold=1",
+		"name=Admins&kind=privileged_user&pattern=admin.*&exclude=^LAB",
+	} {
+		equals(t, http.StatusSeeOther, post("/computers/alerts/rules", form))
+	}
+	equals(t, http.StatusUnprocessableEntity, post("/computers/alerts/rules", "name=Stale&kind=stale"))
+	equals(t, http.StatusUnprocessableEntity, post("/computers/alerts/rules", "name=Stale&kind=stale&threshold=soon"))
+
+	conflicting := `{"name":"eth0","mac_address":"00:11:22:33:44:01","ip_address":"10.0.0.2/24"}`
+	report("PC04", "alice", conflicting)
+	report("PC04", "alice", conflicting)
+	report("PC01", "alice", `{"name":"eth0","mac_address":"00:11:22:33:44:01","ip_address":"10.0.0.1"},{"name":"eth1","mac_address":"00:11:22:33:44:99","ip_address":"10.0.1.1"}`)
+	report("LAB01", "administrator", "")
+	report("PC02", "Administrator", `{"name":"eth0","mac_address":"00:11:22:33:44:02","ip_address":"10.0.0.2"}`)
+
+	got := alerts(AlertActive)
+	equals(t, AlertOpen, got["computer:4"].State.String)
+	equals(t, "info", got["computer:4"].Severity.String)
+	equals(t, "New computer PC04 reported by alice.", got["computer:4"].Message.String)
+	equals(t, "PC04", got["computer:4"].ComputerName.String)
+	// Both computers sharing an address repeat the same alert.
+	equals(t, int64(3), got["mac:00:11:22:33:44:01"].Count.Int64)
+	equals(t, "critical", got["mac:00:11:22:33:44:01"].Severity.String)
+	equals(t, int64(3), got["ip:10.0.0.2"].Count.Int64)
+	assert(t, got["adapter:1:00:11:22:33:44:99"].ID.Valid, "missing adapter alert")
+	assert(t, got["user:alice"].ID.Valid, "missing user spread alert")
+	assert(t, got["user:2:administrator"].ID.Valid, "missing privileged user alert")
+	assert(t, !got["user:5:administrator"].ID.Valid, "excluded computer raised an alert")
+	equals(t, 8, len(got))
+
+	// Reporting the same adapters again raises, queues and publishes
+	// nothing about them.
+	_, err = NewWebhookRepository(db).Create(dbCtx, &Webhook{
+		Name:   null.StringFrom("Adapters"),
+		URL:    null.StringFrom("http://127.0.0.1/hooks"),
+		Secret: null.StringFrom("secret"),
+		Events: null.StringFrom(EventAdapterAdded + "," + EventAdapterRemoved),
+	})
+	ok(t, err)
+	sub, _, _ := hubFor(db).Subscribe(0)
+	defer hubFor(db).Unsubscribe(sub)
+	report("PC01", "alice", `{"name":"eth0","mac_address":"00:11:22:33:44:01","ip_address":"10.0.0.1"},{"name":"eth1","mac_address":"00:11:22:33:44:99","ip_address":"10.0.1.1"}`)
+	again := alerts(AlertActive)
+	equals(t, len(got), len(again))
+	equals(t, int64(1), again["adapter:1:00:11:22:33:44:99"].Count.Int64)
+	deliveries, err := NewDeliveryRepository(db).List(dbCtx, maxDeliveries)
+	ok(t, err)
+	equals(t, 0, len(deliveries))
+	for len(sub.C) > 0 {
+		msg := <-sub.C
+		assert(t, msg.Event.Adapter == nil, "adapter event published again: "+msg.Event.Type)
+	}
+
+	ok(t, NewAlerter(db, log).Evaluate(dbCtx))
+	ok(t, NewAlerter(db, log).Evaluate(dbCtx))
+	stale := alerts(AlertOpen)["computer:3"]
+	equals(t, int64(2), stale.Count.Int64)
+
+	report("PC03", "carol", `{"name":"eth0","mac_address":"00:11:22:33:44:03","ip_address":"10.0.0.3"}`)
+	stale = alerts(AlertResolved)["computer:3"]
+	equals(t, "system", stale.ResolvedBy.String)
+
+	id := got["mac:00:11:22:33:44:01"].ID.Int64
+	equals(t, http.StatusSeeOther, post(fmt.Sprintf("/computers/alerts/%d/acknowledge", id), ""))
+	equals(t, AlertAcknowledged, alerts("")["mac:00:11:22:33:44:01"].State.String)
+	equals(t, "admin token", alerts("")["mac:00:11:22:33:44:01"].AcknowledgedBy.String)
+	equals(t, http.StatusSeeOther, post(fmt.Sprintf("/computers/alerts/%d/resolve", id), ""))
+
+	// The cooldown keeps the resolved conflict from being raised again.
+	report("PC04", "alice", conflicting)
+	equals(t, AlertResolved, alerts("")["mac:00:11:22:33:44:01"].State.String)
+
+	rules, err := NewAlertRuleRepository(db).List(dbCtx)
+	ok(t, err)
+	equals(t, 6, len(rules))
+	equals(t, "Added", rules[0].Name.String)
+	equals(t, http.StatusSeeOther, post(fmt.Sprintf("/computers/alerts/rules/%d/disable", rules[0].ID.Int64), ""))
+	report("PC01", "alice", `{"name":"eth2","mac_address":"00:11:22:33:44:98","ip_address":"10.0.1.2"}`)
+	_, found := alerts("")["adapter:1:00:11:22:33:44:98"]
+	equals(t, false, found)
+
+	rec := httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/alerts?state=resolved", nil))
+	equals(t, http.StatusOK, rec.Code)
+	var response struct{ Alerts []Alert }
+	ok(t, json.NewDecoder(rec.Body).Decode(&response))
+	equals(t, 2, len(response.Alerts))
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/alerts", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), "New computer PC04 reported by alice."), "alert missing from page")
+}
+
+func TestIngestEvent(t *testing.T) {
+	adapter := func(mac string, source string) NetworkAdapter {
+		return NetworkAdapter{MacAddress: null.StringFrom(mac), Source: null.StringFrom(source)}
+	}
+	types := func(events []Event) []string {
+		var list []string
+		for _, e := range events {
+			list = append(list, e.Type)
+		}
+		return list
+	}
+
+	e := IngestEvent{
+		ComputerID:   1,
+		Name:         "PC01",
+		Username:     "dave",
+		PreviousUser: "alice",
+		Adapters:     []NetworkAdapter{adapter("00:11:22:33:44:01", SourceAgent), adapter("00:11:22:33:44:99", SourceAgent)},
+		Previous:     []NetworkAdapter{adapter("00:11:22:33:44:01", SourceAgent), adapter("00:11:22:33:44:02", SourceAgent)},
+	}
+	equals(t, []string{EventUserChanged, EventAdapterAdded, EventAdapterRemoved}, types(e.Events()))
+	equals(t, "00:11:22:33:44:99", e.Added()[0].MacAddress.String)
+	equals(t, int64(1), e.Added()[0].ComputerID.Int64)
+	equals(t, "00:11:22:33:44:02", e.Removed()[0].MacAddress.String)
+
+	// Usernames differing in case are the same user, reports without
+	// adapters change none.
+	e.PreviousUser = "DAVE"
+	e.Adapters = nil
+	equals(t, 0, len(e.Events()))
+
+	e.Previous = []NetworkAdapter{adapter("00:11:22:33:44:01", SourceImport)}
+	e.Adapters = []NetworkAdapter{adapter("00:11:22:33:44:99", SourceAgent)}
+	equals(t, 0, len(e.Events()))
+
+	e = IngestEvent{ComputerID: 5, Name: "PC05", Username: "eve", New: true, Adapters: e.Adapters}
+	equals(t, []string{EventComputerCreated}, types(e.Events()))
+	equals(t, "PC05", e.Events()[0].Computer.Name.String)
+}
+
+func TestWebhooks(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewWebhookController(db, log, c.router, allowAll)
+
+	type received struct {
+		path      string
+		event     string
+		signature string
+		body      []byte
+	}
+	var (
+		mu       sync.Mutex
+		requests []received
+		failing  int32 = 1
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		requests = append(requests, received{r.URL.Path, r.Header.Get(HeaderEvent), r.Header.Get(HeaderSignature), body})
+		mu.Unlock()
+
+		if r.URL.Path == "/tickets" && atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	post := func(url string, form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", url, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, req)
+		return rec
+	}
+	report := func(name string, user string, adapters string) {
+		rec := httptest.NewRecorder()
+		c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(
+			fmt.Sprintf(`{"name":"%s","username":"%s","adapters":[%s]}`, name, user, adapters),
+		)))
+		equals(t, http.StatusOK, rec.Code)
+	}
+	deliveries := func() map[string]WebhookDelivery {
+		list, err := NewDeliveryRepository(db).List(dbCtx, maxDeliveries)
+		ok(t, err)
+		byKey := make(map[string]WebhookDelivery)
+		for _, d := range list {
+			byKey[d.WebhookName.String+" "+d.EventType.String] = d
+		}
+		return byKey
+	}
+
+	equals(t, http.StatusSeeOther, post("/computers/webhooks", "name=Chat&url="+url.QueryEscape(srv.URL+"/chat")+"&secret=s3cret&events=user.changed&events=alert.raised").Code)
+	rec := post("/computers/webhooks", "name=Tickets&url="+url.QueryEscape(srv.URL+"/tickets"))
+	equals(t, http.StatusCreated, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), "Copy the signing secret now"), "generated secret not shown")
+	equals(t, http.StatusUnprocessableEntity, post("/computers/webhooks", "name=Bad&url=ftp://example.com").Code)
+	equals(t, http.StatusUnprocessableEntity, post("/computers/webhooks", "name=Bad&url=http://example.com&events=computer.deleted").Code)
+
+	_, err = NewAlertRuleRepository(db).Create(dbCtx, &AlertRule{
+		Name:     null.StringFrom("New"),
+		Kind:     null.StringFrom(AlertNewComputer),
+		Severity: null.StringFrom(SeverityInfo),
+		Cooldown: null.IntFrom(DefaultAlertCooldown),
+	})
+	ok(t, err)
+
+	report("PC01", "dave", `{"name":"eth0","mac_address":"00:11:22:33:44:01","ip_address":"10.0.0.1"}`)
+	report("PC05", "eve", "")
+
+	got := deliveries()
+	equals(t, 5, len(got))
+	for _, key := range []string{"Chat user.changed", "Chat alert.raised", "Tickets user.changed", "Tickets computer.created", "Tickets alert.raised"} {
+		equals(t, DeliveryPending, got[key].State.String)
+	}
+
+	webhooks := NewWebhooks(db, log)
+	ok(t, webhooks.Deliver(dbCtx))
+
+	got = deliveries()
+	equals(t, DeliveryDelivered, got["Chat user.changed"].State.String)
+	equals(t, int64(http.StatusNoContent), got["Chat user.changed"].StatusCode.Int64)
+	equals(t, DeliveryPending, got["Tickets computer.created"].State.String)
+	equals(t, int64(1), got["Tickets computer.created"].Attempts.Int64)
+	equals(t, int64(http.StatusInternalServerError), got["Tickets computer.created"].StatusCode.Int64)
+	assert(t, got["Tickets computer.created"].NextAttempt.String > got["Tickets computer.created"].LastAttempt.String, "retry not delayed")
+
+	mu.Lock()
+	equals(t, 5, len(requests))
+	for _, req := range requests {
+		if req.path != "/chat" {
+			continue
+		}
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(req.body)
+		equals(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), req.signature)
+
+		var event Event
+		ok(t, json.Unmarshal(req.body, &event))
+		equals(t, req.event, event.Type)
+		switch event.Type {
+		case EventUserChanged:
+			equals(t, "dave", event.User.Username.String)
+			equals(t, "PC01", event.Computer.Name.String)
+		case EventAlertRaised:
+			equals(t, "New computer PC05 reported by eve.", event.Alert.Message.String)
+			equals(t, "PC05", event.Computer.Name.String)
+		default:
+			t.Fatalf("unsubscribed event %s posted", event.Type)
+		}
+	}
+	requests = nil
+	mu.Unlock()
+
+	// Retries wait for their backoff.
+	ok(t, webhooks.Deliver(dbCtx))
+	equals(t, 0, len(requests))
+
+	atomic.StoreInt32(&failing, 0)
+	_, err = db.Exec(`UPDATE webhook_deliveries SET next_attempt='2000-01-01 00:00:00' WHERE state='pending'`)
+	ok(t, err)
+	ok(t, webhooks.Deliver(dbCtx))
+	got = deliveries()
+	equals(t, DeliveryDelivered, got["Tickets computer.created"].State.String)
+	equals(t, int64(2), got["Tickets computer.created"].Attempts.Int64)
+
+	// Deliveries fail after the last attempt and can be queued again.
+	atomic.StoreInt32(&failing, 1)
+	equals(t, http.StatusSeeOther, post(fmt.Sprintf("/computers/webhooks/%d/ping", got["Tickets computer.created"].WebhookID.Int64), "").Code)
+	_, err = db.Exec(fmt.Sprintf(`UPDATE webhook_deliveries SET attempts=%d WHERE state='pending'`, maxDeliveryAttempts-1))
+	ok(t, err)
+	ok(t, webhooks.Deliver(dbCtx))
+	ping := deliveries()["Tickets ping"]
+	equals(t, DeliveryFailed, ping.State.String)
+	equals(t, int64(maxDeliveryAttempts), ping.Attempts.Int64)
+
+	equals(t, http.StatusSeeOther, post(fmt.Sprintf("/computers/webhooks/deliveries/%d/retry", ping.ID.Int64), "").Code)
+	ping = deliveries()["Tickets ping"]
+	equals(t, DeliveryPending, ping.State.String)
+	equals(t, int64(0), ping.Attempts.Int64)
+
+	// Delivered and failed deliveries are pruned after the retention,
+	// pending ones are kept.
+	webhooks.SetRetention(time.Hour)
+	n, err := webhooks.Prune(dbCtx, time.Now())
+	ok(t, err)
+	equals(t, int64(0), n)
+	var finished, pending int64
+	ok(t, db.Get(&finished, `SELECT COUNT(*) FROM webhook_deliveries WHERE state<>'pending'`))
+	ok(t, db.Get(&pending, `SELECT COUNT(*) FROM webhook_deliveries WHERE state='pending'`))
+	assert(t, finished > 0 && pending > 0, "expected finished and pending deliveries, got %d and %d", finished, pending)
+	n, err = webhooks.Prune(dbCtx, time.Now().Add(2*time.Hour))
+	ok(t, err)
+	equals(t, finished, n)
+	for _, d := range deliveries() {
+		equals(t, DeliveryPending, d.State.String)
+	}
+
+	equals(t, 30*time.Second, deliveryBackoff(1))
+	equals(t, 4*time.Minute, deliveryBackoff(4))
+	equals(t, 6*time.Hour, deliveryBackoff(20))
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/webhooks", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), srv.URL+"/chat"), "webhook missing from page")
+	assert(t, strings.Contains(rec.Body.String(), "unexpected status 500"), "delivery error missing from page")
+}
+
+func TestDigestSchedule(t *testing.T) {
+	s := DigestSchedule{Hour: 7, Weekday: time.Monday}
+	at := func(day int, hour int) time.Time {
+		return time.Date(2026, 10, day, hour, 0, 0, 0, time.Local)
+	}
+
+	equals(t, at(20, 7), s.last(TopicDigestDaily, at(21, 6)))
+	equals(t, at(21, 7), s.last(TopicDigestDaily, at(21, 7)))
+	equals(t, at(19, 7), s.last(TopicDigestWeekly, at(21, 6)))
+	equals(t, at(12, 7), s.last(TopicDigestWeekly, at(19, 6)))
+	equals(t, at(19, 7), s.last(TopicDigestWeekly, at(19, 8)))
+}
+
+func TestNotifications(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+
+	hash, err := account.HashPassword("correct horse battery")
+	ok(t, err)
+	accounts := account.NewRepository(db)
+	id, err := accounts.Create(dbCtx, &account.Account{
+		Username: null.StringFrom("dana"),
+		Password: null.StringFrom(hash),
+		Role:     null.StringFrom(account.RoleViewer),
+	})
+	ok(t, err)
+
+	dana := &account.Account{ID: null.IntFrom(id), Username: null.StringFrom("dana"), Role: null.StringFrom(account.RoleViewer)}
+	allowDana := func(role string) alice.Constructor {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), UserKey, dana)))
+			})
+		}
+	}
+	router := mux.NewRouter()
+	NewNotificationController(db, log, router, true, allowDana)
+
+	post := func(form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/computers/notifications", strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	equals(t, http.StatusUnprocessableEntity, post("topics=digest:daily").Code)
+	equals(t, http.StatusUnprocessableEntity, post("email=dana&topics=digest:daily").Code)
+	equals(t, http.StatusUnprocessableEntity, post("email=dana@example.com&topics=alert:lunch").Code)
+	rec := post("email=" + url.QueryEscape("Dana <dana@example.com>") + "&topics=alert:new_computer&topics=digest:daily")
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), "Your notifications were saved."), "save not confirmed")
+
+	topics, err := NewSubscriptionRepository(db).Topics(dbCtx, id)
+	ok(t, err)
+	equals(t, []string{"alert:new_computer", "digest:daily"}, topics)
+	saved, err := accounts.Select(dbCtx, id)
+	ok(t, err)
+	equals(t, "dana@example.com", saved.Email.String)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/notifications", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), `value="digest:daily" id="topic-digest:daily" checked`), "subscription not checked")
+
+	// Tokens and API keys have no account to subscribe with.
+	tokenRouter := mux.NewRouter()
+	NewNotificationController(db, log, tokenRouter, true, allowAll)
+	rec = httptest.NewRecorder()
+	tokenRouter.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/notifications", nil))
+	equals(t, http.StatusForbidden, rec.Code)
+
+	_, err = NewAlertRuleRepository(db).Create(dbCtx, &AlertRule{
+		Name:     null.StringFrom("New"),
+		Kind:     null.StringFrom(AlertNewComputer),
+		Severity: null.StringFrom(SeverityInfo),
+		Cooldown: null.IntFrom(DefaultAlertCooldown),
+	})
+	ok(t, err)
+	rec = httptest.NewRecorder()
+	c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(`{"name":"PC05","username":"eve","adapters":[]}`)))
+	equals(t, http.StatusOK, rec.Code)
+
+	var sent []mail.Message
+	fail := true
+	mailer := NewMailer(db, log, mail.Config{Host: "mail.example.com", Port: 25, From: "fpsmonitor@example.com"}, DigestSchedule{Hour: 7, Weekday: time.Monday})
+	mailer.send = func(cfg mail.Config, m mail.Message) error {
+		if fail {
+			return errors.New("421 try again later")
+		}
+		sent = append(sent, m)
+		return nil
+	}
+
+	ok(t, mailer.Deliver(dbCtx))
+	due, err := NewMailRepository(db).Due(dbCtx, time.Now().Add(time.Hour), mailBatch)
+	ok(t, err)
+	equals(t, 1, len(due))
+	equals(t, int64(1), due[0].Attempts.Int64)
+	equals(t, "421 try again later", due[0].Error.String)
+
+	fail = false
+	_, err = db.Exec(`UPDATE mails SET next_attempt='2000-01-01 00:00:00' WHERE state='pending'`)
+	ok(t, err)
+	ok(t, mailer.Deliver(dbCtx))
+	equals(t, 1, len(sent))
+	equals(t, []string{"dana@example.com"}, sent[0].To)
+	equals(t, "[fpsmonitor] info: New computer PC05 reported by eve.", sent[0].Subject)
+	assert(t, strings.Contains(sent[0].HTML, "<td>PC05</td>"), "computer missing from alert mail")
+
+	// Only the daily digest has a subscriber, and it is built and queued
+	// once.
+	digests := &countingDigests{DigestRepository: mailer.digestRepo}
+	mailer.digestRepo = digests
+	ok(t, mailer.Digests(dbCtx, time.Now()))
+	ok(t, mailer.Digests(dbCtx, time.Now()))
+	equals(t, 1, digests.built)
+	ok(t, mailer.Deliver(dbCtx))
+	equals(t, 2, len(sent))
+	digest := sent[1]
+	equals(t, "[fpsmonitor] Daily digest: 3 new, 1 stale, 0 conflicts", digest.Subject)
+	for _, want := range []string{"<td>PC05</td>", "<td>PC03</td>", "<td>alice</td>"} {
+		assert(t, strings.Contains(digest.HTML, want), "%s missing from digest", want)
+	}
+}
+
+// countingDigests counts the digests built with it.
+type countingDigests struct {
+	DigestRepository
+	built int
+}
+
+func (d *countingDigests) NewComputers(ctx context.Context, since time.Time) ([]Computer, error) {
+	d.built++
+	return d.DigestRepository.NewComputers(ctx, since)
+}
+
+func TestHub(t *testing.T) {
+	h := NewHub()
+	event := func(name string) Event {
+		e := NewEvent(EventComputerReported)
+		e.Computer = &Computer{Name: null.StringFrom(name)}
+		return e
+	}
+
+	fast, _, _ := h.Subscribe(0)
+	slow, _, _ := h.Subscribe(0)
+	equals(t, 2, h.Subscribers())
+
+	for i := 0; i < hubBuffer; i++ {
+		h.Publish(event(fmt.Sprintf("PC%02d", i)))
+		<-fast.C
+	}
+	equals(t, 2, h.Subscribers())
+
+	// The slow subscriber is dropped instead of blocking the publisher.
+	dropped := hubDropped.Value()
+	h.Publish(event("PC99"))
+	equals(t, dropped+1, hubDropped.Value())
+	equals(t, 1, h.Subscribers())
+	msg := <-fast.C
+	equals(t, int64(hubBuffer+1), msg.ID)
+	equals(t, "PC99", msg.Event.Computer.Name.String)
+	for range slow.C {
+	}
+
+	// Reconnecting with the last ID received replays the rest.
+	again, backlog, complete := h.Subscribe(int64(hubBuffer - 1))
+	equals(t, true, complete)
+	equals(t, int64(hubBuffer+1), again.Seq)
+	equals(t, 2, len(backlog))
+	equals(t, int64(hubBuffer), backlog[0].ID)
+
+	for i := 0; i < hubReplay; i++ {
+		h.Publish(event("PC"))
+	}
+	_, _, complete = h.Subscribe(1)
+	equals(t, false, complete)
+	_, _, complete = h.Subscribe(int64(hubBuffer + 1 + hubReplay + 10))
+	equals(t, false, complete)
+
+	h.Unsubscribe(fast)
+	h.Unsubscribe(fast)
+}
+
+func TestEventStream(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewEventController(db, log, c.router, 0, allowAll)
+
+	srv := httptest.NewServer(c.router)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(dbCtx, 10*time.Second)
+	defer cancel()
+
+	type message struct{ id, event, data string }
+	stream := func(query string, lastID string) (<-chan message, func()) {
+		ctx, stop := context.WithCancel(ctx)
+		req, err := http.NewRequestWithContext(ctx, "GET", srv.URL+"/computers/events"+query, nil)
+		ok(t, err)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		ok(t, err)
+		equals(t, http.StatusOK, resp.StatusCode)
+		equals(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		messages := make(chan message, 16)
+		go func() {
+			defer resp.Body.Close()
+			defer close(messages)
+			var m message
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				line := scanner.Text()
+				switch {
+				case line == "":
+					if m.event != "" || m.id != "" {
+						messages <- m
+					}
+					m = message{}
+				case strings.HasPrefix(line, "id: "):
+					m.id = line[4:]
+				case strings.HasPrefix(line, "event: "):
+					m.event = line[7:]
+				case strings.HasPrefix(line, "data: "):
+					m.data = line[6:]
+				}
+			}
+		}()
+		return messages, stop
+	}
+	report := func(body string) {
+		rec := httptest.NewRecorder()
+		c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(body)))
+		equals(t, http.StatusOK, rec.Code)
+	}
+	next := func(messages <-chan message) message {
+		select {
+		case m := <-messages:
+			return m
+		case <-ctx.Done():
+			t.Fatal("no event received")
+		}
+		return message{}
+	}
+
+	// The seed published a report and a new computer for each of PC01 to
+	// PC03.
+	all, stopAll := stream("", "")
+	equals(t, message{id: "6"}, next(all))
+	pc02, stopPC02 := stream("?computer=2", "")
+	equals(t, message{id: "6"}, next(pc02))
+	for hub := hubFor(db); hub.Subscribers() < 2; {
+		time.Sleep(time.Millisecond)
+	}
+
+	report(`{"name":"PC01","username":"dave","adapters":[{"name":"eth0","mac_address":"00:11:22:33:44:01","ip_address":"10.0.0.1"}]}`)
+	report(`{"name":"PC02","username":"bob","adapters":[{"name":"eth0","mac_address":"00:11:22:33:44:02","ip_address":"10.0.0.2"}]}`)
+
+	m := next(all)
+	equals(t, "7", m.id)
+	equals(t, EventComputerReported, m.event)
+	var event Event
+	ok(t, json.Unmarshal([]byte(m.data), &event))
+	equals(t, "PC01", event.Computer.Name.String)
+	equals(t, "dave", event.User.Username.String)
+	equals(t, EventUserChanged, next(all).event)
+	equals(t, EventComputerReported, next(all).event)
+
+	// The filtered stream only sees PC02.
+	m = next(pc02)
+	equals(t, "9", m.id)
+	ok(t, json.Unmarshal([]byte(m.data), &event))
+	equals(t, "PC02", event.Computer.Name.String)
+	stopPC02()
+	stopAll()
+
+	// Reconnecting replays what was missed, or asks for a reload.
+	replay, stopReplay := stream("", "7")
+	equals(t, "8", next(replay).id)
+	equals(t, "9", next(replay).id)
+	stopReplay()
+	resync, stopResync := stream("", "99")
+	equals(t, message{id: "9", event: eventResync, data: "{}"}, next(resync))
+	stopResync()
+
+	rec := httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/events?computer=PC01", nil))
+	equals(t, http.StatusBadRequest, rec.Code)
+
+	// Streams shorter than the heartbeat send it before they end.
+	router := mux.NewRouter()
+	NewEventController(db, log, router, 100*time.Millisecond, allowAll)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/events", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), ": heartbeat\n\n"), "no heartbeat in %q", rec.Body.String())
+}
+
+func TestDashboard(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	NewDashboardController(db, lumber.NewBasicLogger(discard{}, lumber.FATAL), c.router, allowAll)
+
+	repo := NewDashboardRepository(db)
+	now := time.Now()
+
+	counts, err := repo.Counts(dbCtx, now.Add(-time.Hour), now.Add(-StaleAfter))
+	ok(t, err)
+	equals(t, &InventoryCounts{Total: 3, Active: 2, Stale: 1}, counts)
+
+	hours, err := repo.ReportsPerHour(dbCtx, now.Add(-time.Hour))
+	ok(t, err)
+	bars := hourBars(hours, now, 24)
+	equals(t, 24, len(bars))
+	equals(t, HourBar{Label: now.Format("15:00"), Reports: 3, Percent: 100, Tick: now.Hour()%3 == 0}, bars[23])
+	equals(t, int64(0), bars[0].Reports)
+
+	prefixes, err := repo.AdapterPrefixes(dbCtx)
+	ok(t, err)
+	equals(t, []VendorCount{{"00:11:22", 3}}, vendors(prefixes, 10))
+
+	equals(t, []VendorCount{{"VMware", 3}, {"Locally administered", 2}, {"Other", 1}}, vendors([]PrefixCount{
+		{null.StringFrom("00:50:56"), null.IntFrom(2)},
+		{null.StringFrom("00:0C:29"), null.IntFrom(1)},
+		{null.StringFrom("02:42:AC"), null.IntFrom(2)},
+		{null.StringFrom("00:11:22"), null.IntFrom(1)},
+	}, 2))
+
+	rec := httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	equals(t, http.StatusFound, rec.Code)
+	equals(t, "/computers/dashboard", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/dashboard", nil))
+	equals(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert(t, strings.Contains(body, `id="count-total">3<`), "total missing")
+	assert(t, strings.Contains(body, `id="count-stale">1<`), "stale count missing")
+	assert(t, strings.Contains(body, `>alice</a>`), "top user missing")
+	assert(t, strings.Contains(body, `height: 100%`), "chart missing")
+}
+
+func TestCheckins(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	NewCheckinController(db, lumber.NewBasicLogger(discard{}, lumber.FATAL), c.router, 24*time.Hour, allowAll)
+
+	rec := httptest.NewRecorder()
+	body := `{"name":"PC01","username":"alice","version":"1.2.3","adapters":[]}`
+	c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(body)))
+	equals(t, http.StatusOK, rec.Code)
+
+	var checkin Checkin
+	ok(t, db.GetContext(dbCtx, &checkin, `SELECT * FROM checkins ORDER BY id DESC LIMIT 1`))
+	equals(t, int64(1), checkin.ComputerID.Int64)
+	equals(t, "1.2.3", checkin.AgentVersion.String)
+	equals(t, int64(len(body)), checkin.PayloadBytes.Int64)
+
+	// Two check-ins of PC01 ten days ago are compacted, twice.
+	now := time.Now()
+	old := now.AddDate(0, 0, -10).Format("2006-01-02 15:04:05")
+	repo := NewCheckinRepository(db)
+	compactor := NewCompactor(db, lumber.NewBasicLogger(discard{}, lumber.FATAL), 30*24*time.Hour)
+	for i := 0; i < 2; i++ {
+		for _, ms := range []int{5, 9} {
+			_, err = db.ExecContext(dbCtx, `INSERT INTO checkins (created, computer_id, payload_bytes, duration_ms) VALUES (?, 1, 100, ?)`, old, ms)
+			ok(t, err)
+		}
+		if i == 0 {
+			// Kept until the retention is reloaded.
+			n, err := compactor.Compact(dbCtx, now)
+			ok(t, err)
+			equals(t, int64(0), n)
+			compactor.SetRetention(7 * 24 * time.Hour)
+		}
+		n, err := compactor.Compact(dbCtx, now)
+		ok(t, err)
+		equals(t, int64(2), n)
+	}
+
+	var rollups []struct {
+		Period   string `db:"period"`
+		Checkins int64  `db:"checkins"`
+		Bytes    int64  `db:"payload_bytes"`
+		Max      int64  `db:"max_duration_ms"`
+	}
+	ok(t, db.SelectContext(dbCtx, &rollups, `SELECT period, checkins, payload_bytes, max_duration_ms FROM checkin_rollups ORDER BY period`))
+	equals(t, 2, len(rollups))
+	equals(t, RollupDay, rollups[0].Period)
+	equals(t, RollupHour, rollups[1].Period)
+	equals(t, int64(4), rollups[1].Checkins)
+	equals(t, int64(400), rollups[1].Bytes)
+	equals(t, int64(9), rollups[1].Max)
+
+	daily, err := repo.Daily(dbCtx, 1, now.AddDate(0, 0, -29))
+	ok(t, err)
+	equals(t, []CheckinCount{
+		{null.StringFrom(old[:10]), null.IntFrom(4)},
+		{null.StringFrom(now.Format("2006-01-02")), null.IntFrom(2)},
+	}, daily)
+
+	s := sparkline(daily, now, 30)
+	equals(t, 30, len(s.Days))
+	equals(t, int64(6), s.Total)
+	equals(t, "120,12", s.Points[strings.LastIndex(s.Points, " ")+1:])
+
+	// PC03 last reported ten days ago.
+	stats, err := repo.Stats(dbCtx, now.Add(-checkinWindow))
+	ok(t, err)
+	equals(t, 3, len(stats))
+	late := lateAgents(stats, now, checkinWindow, 24*time.Hour, nil)
+	equals(t, 1, len(late))
+	equals(t, "PC03", late[0].Name.String)
+	equals(t, "No check-in for 10d 0h", late[0].Reason)
+
+	late = lateAgents([]CheckinStats{{
+		Name:     null.StringFrom("PC09"),
+		Created:  null.StringFrom(now.Add(-checkinWindow).Format("2006-01-02 15:04:05")),
+		LastSeen: null.StringFrom(now.Add(-time.Hour).Format("2006-01-02 15:04:05")),
+		Checkins: null.IntFrom(5),
+	}}, now, checkinWindow, 24*time.Hour, nil)
+	equals(t, 1, len(late))
+	equals(t, "Checks in every 6d 0h", late[0].Reason)
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/checkins", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), `>PC03</a>`), "late agent missing")
+
+	// PC03 reports weekly by the configuration of its tag, ten days without
+	// a check-in are fine but one check-in in thirty days is not. The
+	// untagged configuration expects the others hourly.
+	tags := NewTagRepository(db)
+	tagID, err := tags.Create(dbCtx, &Tag{Name: null.StringFrom("kiosks")})
+	ok(t, err)
+	ok(t, tags.Assign(dbCtx, 3, tagID, LinkManual))
+	configs := NewAgentConfigRepository(db)
+	_, err = configs.Create(dbCtx, &AgentConfig{Name: null.StringFrom("Kiosks"), TagID: null.IntFrom(tagID), Interval: null.StringFrom("168h")})
+	ok(t, err)
+	_, err = configs.Create(dbCtx, &AgentConfig{Name: null.StringFrom("Everyone"), Priority: null.IntFrom(5), Interval: null.StringFrom("1h")})
+	ok(t, err)
+
+	list, err := configs.List(dbCtx)
+	ok(t, err)
+	tagged, err := tags.ListForComputers(dbCtx, []int64{1, 3})
+	ok(t, err)
+	intervals := agentIntervals(list, tagged, []int64{1, 3})
+	equals(t, time.Hour, intervals[1])
+	equals(t, 168*time.Hour, intervals[3])
+	equals(t, map[int64]time.Duration{}, agentIntervals(nil, tagged, []int64{1, 3}))
+
+	late = lateAgents(stats, now, checkinWindow, 24*time.Hour, intervals)
+	reasons := map[string]string{}
+	for _, a := range late {
+		reasons[a.Name.String] = a.Reason
+	}
+	equals(t, "Checks in every 30d 0h", reasons["PC03"])
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/checkins", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), `<td>7d 0h</td>`), "expected interval missing")
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/1", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), `6 in 30 days`), "sparkline missing")
+}
+
+func TestVersionBelow(t *testing.T) {
+	for _, c := range []struct {
+		version, min string
+		below        bool
+	}{
+		{"1.4.2", "1.4", false},
+		{"v1.4.0", "1.4.1", true},
+		{"1.10", "1.9.9", false},
+		{"v2.0.0-3-gabc1234", "2", false},
+		{"1.3.9-dirty", "v1.4", true},
+		{"dev", "1.0", true},
+		{"", "1.0", true},
+		{"1.2.3.4", "1.0", true},
+		{"dev", "", false},
+	} {
+		equals(t, c.below, versionBelow(c.version, c.min))
+	}
+}
+
+func TestAgentVersions(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	router := mux.NewRouter()
+	c := NewComputerController(db, log, router, "1.4", allowAll).(*computerController)
+	NewVersionController(db, log, router, "1.4", allowAll)
+	NewDashboardController(db, log, router, allowAll)
+
+	report := func(name string, version string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(fmt.Sprintf(
+			`{"name":"%s","username":"alice","version":"%s","commit":"abc1234","os":"windows","arch":"amd64","adapters":[]}`,
+			name, version,
+		))))
+		return rec
+	}
+
+	equals(t, http.StatusOK, report("PC01", "1.4.2").Code)
+
+	rec := report("PC02", "1.3.0")
+	equals(t, http.StatusUpgradeRequired, rec.Code)
+	equals(t, "1.4", rec.Header().Get(HeaderMinVersion))
+
+	comp, err := NewComputerRepository(db).Select(dbCtx, "PC02")
+	ok(t, err)
+	equals(t, "1.3.0", comp.AgentVersion.String)
+	equals(t, "abc1234", comp.AgentCommit.String)
+	equals(t, "windows", comp.OS.String)
+	equals(t, "amd64", comp.Arch.String)
+
+	repo := NewDashboardRepository(db)
+	versions, err := repo.Versions(dbCtx)
+	ok(t, err)
+	equals(t, []VersionCount{
+		{null.StringFrom("1.4.2"), null.IntFrom(1)},
+		{null.StringFrom("1.3.0"), null.IntFrom(1)},
+		{null.StringFrom(""), null.IntFrom(1)},
+	}, versions)
+
+	platforms, err := repo.Platforms(dbCtx)
+	ok(t, err)
+	equals(t, []PlatformCount{
+		{null.StringFrom("windows"), null.StringFrom("amd64"), null.IntFrom(2)},
+		{null.StringFrom(""), null.StringFrom(""), null.IntFrom(1)},
+	}, platforms)
+
+	unknown, err := repo.WithVersion(dbCtx, "")
+	ok(t, err)
+	equals(t, 1, len(unknown))
+	equals(t, "PC03", unknown[0].Name.String)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/versions?version=1.3.0", nil))
+	equals(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert(t, strings.Contains(body, "2 still run one"), "outdated count missing")
+	assert(t, strings.Contains(body, `>PC02</a>`), "computer of the version missing")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/dashboard", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), "<td>windows</td>"), "operating system missing")
+}
+
+func TestAgentConfig(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	log := lumber.NewBasicLogger(discard{}, lumber.FATAL)
+	NewAgentConfigController(db, log, c.router, allowAll)
+
+	tags := NewTagRepository(db)
+	laptops, err := tags.Create(dbCtx, &Tag{Name: null.StringFrom("laptops")})
+	ok(t, err)
+	ok(t, tags.Assign(dbCtx, 2, laptops, "manual"))
+
+	post := func(url string, form string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", url, strings.NewReader(form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		c.router.ServeHTTP(rec, req)
+		return rec
+	}
+	report := func(name string) *agentconfig.Config {
+		rec := httptest.NewRecorder()
+		c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(
+			fmt.Sprintf(`{"name":"%s","username":"alice","adapters":[]}`, name),
+		)))
+		equals(t, http.StatusOK, rec.Code)
+		equals(t, "application/json", rec.Header().Get("Content-Type"))
+		config := &agentconfig.Config{}
+		ok(t, json.Unmarshal(rec.Body.Bytes(), config))
+		return config
+	}
+
+	equals(t, agentconfig.Default(), report("PC01"))
+
+	rec := post("/computers/agent-config", "name=Everyone&interval=30s&collectors=user")
+	equals(t, http.StatusUnprocessableEntity, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), "interval must be between"), "interval error missing")
+
+	rec = post("/computers/agent-config", "name=Everyone&interval=2h&collectors=user&collectors=adapters&exclude=*bluetooth*")
+	equals(t, http.StatusSeeOther, rec.Code)
+	rec = post("/computers/agent-config", fmt.Sprintf(
+		"name=Laptops&tag=%d&priority=-5&interval=15m&collectors=adapters&include=wi-fi%%0Aeth*", laptops,
+	))
+	equals(t, http.StatusSeeOther, rec.Code)
+
+	equals(t, &agentconfig.Config{
+		Version:    1,
+		Interval:   "2h",
+		Collectors: []string{"user", "adapters"},
+		Include:    []string{},
+		Exclude:    []string{"*bluetooth*"},
+	}, report("PC01"))
+
+	// A tagged configuration wins over one for every agent whatever its
+	// priority.
+	equals(t, &agentconfig.Config{
+		Version:    2,
+		Interval:   "15m",
+		Collectors: []string{"adapters"},
+		Include:    []string{"wi-fi", "eth*"},
+		Exclude:    []string{},
+	}, report("PC02"))
+
+	repo := NewAgentConfigRepository(db)
+	list, err := repo.List(dbCtx)
+	ok(t, err)
+	equals(t, 2, len(list))
+	equals(t, "Laptops", list[0].Name.String)
+	equals(t, "laptops", list[0].TagName.String)
+
+	rec = post(fmt.Sprintf("/computers/agent-config/%d", list[1].ID.Int64), "name=Everyone&interval=4h&collectors=user")
+	equals(t, http.StatusSeeOther, rec.Code)
+	config := report("PC03")
+	equals(t, int64(3), config.Version)
+	equals(t, "4h", config.Interval)
+
+	rec = httptest.NewRecorder()
+	c.router.ServeHTTP(rec, httptest.NewRequest("GET", fmt.Sprintf("/computers/agent-config?edit=%d", list[0].ID.Int64), nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), "Edit Laptops"), "edit form missing")
+
+	rec = post(fmt.Sprintf("/computers/agent-config/%d/delete", list[0].ID.Int64), "")
+	equals(t, http.StatusSeeOther, rec.Code)
+	equals(t, int64(3), report("PC02").Version)
+}
+
+func TestDisabledCollectors(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	report := func(body string) {
+		rec := httptest.NewRecorder()
+		c.Update(rec, httptest.NewRequest("POST", "/computers/update", strings.NewReader(body)))
+		equals(t, http.StatusOK, rec.Code)
+	}
+
+	report(`{"name":"PC01","username":"alice","version":"1.5.0","os":"windows","arch":"amd64","adapters":[{"name":"eth0","mac_address":"00:11:22:33:44:01","ip_address":"10.0.0.1"}]}`)
+
+	sub, _, _ := hubFor(db).Subscribe(0)
+	defer hubFor(db).Unsubscribe(sub)
+
+	// Without the user, platform and adapters collectors.
+	report(`{"name":"PC01","version":"1.5.0","adapters":[]}`)
+
+	user, err := NewUserRepository(db).Latest(dbCtx, 1)
+	ok(t, err)
+	equals(t, "alice", user.Username.String)
+
+	comp, err := NewComputerRepository(db).SelectWithID(dbCtx, 1)
+	ok(t, err)
+	equals(t, "windows", comp.OS.String)
+	equals(t, "amd64", comp.Arch.String)
+
+	adapters, err := NewNetworkAdapterRepository(db).SelectWithComputerID(dbCtx, 1)
+	ok(t, err)
+	equals(t, 1, len(adapters))
+
+	equals(t, 1, len(sub.C))
+	msg := <-sub.C
+	equals(t, EventComputerReported, msg.Event.Type)
+	assert(t, msg.Event.User == nil, "reported event carries an empty user")
+
+	// A report without adapters removes the imported adapters of a
+	// computer that also has agent ones, but leaves the agent ones.
+	_, err = NewNetworkAdapterRepository(db).Create(dbCtx, &NetworkAdapter{
+		ComputerID: null.IntFrom(1),
+		MacAddress: null.StringFrom("00:11:22:33:44:99"),
+		Source:     null.StringFrom(SourceImport),
+	})
+	ok(t, err)
+	report(`{"name":"PC01","version":"1.5.0","adapters":[]}`)
+	adapters, err = NewNetworkAdapterRepository(db).SelectWithComputerID(dbCtx, 1)
+	ok(t, err)
+	equals(t, 1, len(adapters))
+	equals(t, "00:11:22:33:44:01", adapters[0].MacAddress.String)
+	equals(t, SourceAgent, adapters[0].Source.String)
+}
+
+func TestSyncAdaptersKeepsPrevious(t *testing.T) {
+	db, err := dbSetup()
+	ok(t, err)
+	defer db.Close()
+
+	c := seedInventory(t, db)
+	existing, err := c.networkAdapterRepo.SelectWithComputerID(dbCtx, 1)
+	ok(t, err)
+	assert(t, len(existing) > 0, "PC01 has no adapters")
+	existing[0].Source = null.StringFrom(SourceImport)
+	before := existing[0]
+
+	reported := []NetworkAdapter{{
+		Name:       null.StringFrom("renamed"),
+		MacAddress: existing[0].MacAddress,
+		IPAddress:  null.StringFrom("10.9.9.9"),
+	}}
+	ok(t, c.syncAdapters(dbCtx, 1, existing, reported))
+	equals(t, before, existing[0])
+
+	saved, err := c.networkAdapterRepo.Select(dbCtx, int(before.ID.Int64))
+	ok(t, err)
+	equals(t, "renamed", saved.Name.String)
+	equals(t, SourceAgent, saved.Source.String)
+}
@@ -4,15 +4,25 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
-	"github.com/jcelliott/lumber"
 	"github.com/jmoiron/sqlx"
 	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/agentconfig"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"github.com/stockholmr/fpsmonitor/internal/export"
+	"github.com/stockholmr/fpsmonitor/internal/metrics"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -23,29 +33,103 @@ type computerController struct {
 	computerRepo       ComputerRepository
 	networkAdapterRepo NetworkAdapterRepository
 	userRepo           UserRepository
+	checkinRepo        CheckinRepository
+	agentConfigRepo    AgentConfigRepository
+	links              *links
+	assigner           *Assigner
+	alerter            *Alerter
+	webhooks           *Webhooks
+	hub                *Hub
+
+	// minVersion is the oldest agent version accepted without asking for
+	// an upgrade, "" accepts every version.
+	minVersion string
+
+	// ingestLock serialises the writes of concurrent reports and imports,
+	// see writeLock.
+	ingestLock sync.Locker
+}
+
+type noopLocker struct{}
+
+func (noopLocker) Lock()   {}
+func (noopLocker) Unlock() {}
+
+var (
+	writeLocksMu sync.Mutex
+	writeLocks   = make(map[*sqlx.DB]*sync.Mutex)
+)
+
+// writeLock returns the lock shared by every controller writing to db.
+// SQLite only allows a single writer, queueing in process avoids busy
+// retries. Other databases are not serialised.
+func writeLock(db *sqlx.DB) sync.Locker {
+	if database.DialectOf(db).Name != database.SQLite {
+		return noopLocker{}
+	}
+
+	writeLocksMu.Lock()
+	defer writeLocksMu.Unlock()
+
+	if _, ok := writeLocks[db]; !ok {
+		writeLocks[db] = &sync.Mutex{}
+	}
+	return writeLocks[db]
 }
 
 type ComputerController interface {
 	Update(http.ResponseWriter, *http.Request)
+	List(http.ResponseWriter, *http.Request)
+	Users(http.ResponseWriter, *http.Request)
+	Adapters(http.ResponseWriter, *http.Request)
+	Stale(http.ResponseWriter, *http.Request)
+	Export(http.ResponseWriter, *http.Request)
+	Detail(http.ResponseWriter, *http.Request)
+	Delete(http.ResponseWriter, *http.Request)
+	Search(http.ResponseWriter, *http.Request)
 }
 
-func NewComputerController(db *sqlx.DB, log lumber.Logger, router *mux.Router, middleware ...alice.Constructor) ComputerController {
+// NewComputerController registers the inventory views below /computers,
+// guarded to accounts holding the viewer role, and /computers/update which
+// takes the reports of the agents. Agents older than minVersion are asked
+// to upgrade.
+func NewComputerController(db *sqlx.DB, log lumber.Logger, router *mux.Router, minVersion string, guard Guard, middleware ...alice.Constructor) ComputerController {
 	c := &computerController{
 		log:                log,
 		router:             router,
 		computerRepo:       NewComputerRepository(db),
 		networkAdapterRepo: NewNetworkAdapterRepository(db),
 		userRepo:           NewUserRepository(db),
+		checkinRepo:        NewCheckinRepository(db),
+		agentConfigRepo:    NewAgentConfigRepository(db),
+		links:              newLinks(db),
+		assigner:           NewAssigner(db),
+		alerter:            NewAlerter(db, log),
+		webhooks:           NewWebhooks(db, log),
+		hub:                hubFor(db),
+		minVersion:         minVersion,
+		ingestLock:         writeLock(db),
 	}
 
+	metrics.Default.Collect("inventory", c.collectInventory)
+
 	m := []alice.Constructor{
 		c.LoggingMiddleware,
 	}
 	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
 
 	r := c.router.PathPrefix("/computers").Subrouter()
 	r.Handle("/update", alice.New(m...).ThenFunc(c.Update)).Methods("POST").Name("update")
-	r.Handle("/list", alice.New(m...).ThenFunc(c.Update)).Methods("POST").Name("list")
+	r.Handle("/list", view.ThenFunc(c.List)).Methods("GET").Name("list")
+	r.Handle("/users", view.ThenFunc(c.Users)).Methods("GET").Name("users")
+	r.Handle("/adapters", view.ThenFunc(c.Adapters)).Methods("GET").Name("adapters")
+	r.Handle("/stale", view.ThenFunc(c.Stale)).Methods("GET").Name("stale")
+	r.Handle("/export/{view:[a-z]+}.{format:csv|xlsx}", view.ThenFunc(c.Export)).Methods("GET").Name("export")
+	r.Handle("/{id:[0-9]+}", view.ThenFunc(c.Detail)).Methods("GET").Name("detail")
+	r.Handle("/{id:[0-9]+}/delete", admin.ThenFunc(c.Delete)).Methods("POST").Name("delete")
+	r.Handle("/search", view.ThenFunc(c.Search)).Methods("GET").Name("search")
 	r.Handle("/stylesheet", alice.New(m...).ThenFunc(c.Stylesheet)).Methods("GET")
 
 	return c
@@ -58,13 +142,13 @@ func (c *computerController) Stylesheet(w http.ResponseWriter, r *http.Request)
 }
 
 func (c *computerController) Update(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
 	ctx, cancel := context.WithTimeout(context.Background(), (time.Second * 10))
 	defer cancel()
 
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		c.log.Error("%s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		c.ingestFailed(w, r, ingestErrRead, err)
 		return
 	}
 
@@ -73,21 +157,41 @@ func (c *computerController) Update(w http.ResponseWriter, r *http.Request) {
 		Name     null.String      `json:"name"`
 		Username null.String      `json:"username"`
 		Adapters []NetworkAdapter `json:"adapters"`
+		Version  null.String      `json:"version"`
+		Commit   null.String      `json:"commit"`
+		OS       null.String      `json:"os"`
+		Arch     null.String      `json:"arch"`
 	}
 
 	err = json.Unmarshal(data, &record)
 	if err != nil {
-		c.log.Error("%s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		c.ingestFailed(w, r, ingestErrDecode, err)
 		return
 	}
 
+	requestlog.Set(r.Context(), "computer", record.Name.String)
+	requestlog.Set(r.Context(), "agent_version", record.Version.String)
+
+	// Agents presenting a verified client certificate may only report for
+	// the computer named in its subject.
+	if subject := certificateName(r); subject != "" {
+		requestlog.Set(r.Context(), "client_cert", subject)
+		if !strings.EqualFold(subject, record.Name.String) {
+			ingestTotal.Inc("failure", ingestErrIdentity)
+			requestlog.Set(r.Context(), "error_class", ingestErrIdentity)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+	}
+
+	c.ingestLock.Lock()
+	defer c.ingestLock.Unlock()
+
 	var compID int64
 
 	comp, err := c.computerRepo.Select(ctx, record.Name.String)
 	if err != nil {
-		c.log.Error("%s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		c.ingestFailed(w, r, ingestErrDatabase, err)
 		return
 	}
 
@@ -95,10 +199,10 @@ func (c *computerController) Update(w http.ResponseWriter, r *http.Request) {
 
 		// Computer record exists update the updated date field
 		compID = comp.ID.Int64
+		comp.Source = null.StringFrom(SourceAgent)
 		err := c.computerRepo.Update(ctx, comp)
 		if err != nil {
-			c.log.Error("%s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			c.ingestFailed(w, r, ingestErrDatabase, err)
 			return
 		}
 
@@ -106,86 +210,520 @@ func (c *computerController) Update(w http.ResponseWriter, r *http.Request) {
 
 		// Create new computer record
 		compID, err = c.computerRepo.Create(ctx, &Computer{
-			Name: record.Name,
+			Name:   record.Name,
+			Source: null.StringFrom(SourceAgent),
+		})
+
+		if err != nil {
+			c.ingestFailed(w, r, ingestErrDatabase, err)
+			return
+		}
+
+	}
+
+	var previousUser *User
+	if comp != nil {
+		if previousUser, err = c.userRepo.Latest(ctx, compID); err != nil {
+			c.ingestFailed(w, r, ingestErrDatabase, err)
+			return
+		}
+	}
+
+	// Create new user record, agents told not to collect the user report
+	// none and leave the current user alone.
+	if record.Username.Valid {
+		_, err = c.userRepo.Create(ctx, &User{
+			Username:   record.Username,
+			ComputerID: null.IntFrom(compID),
 		})
 
 		if err != nil {
-			c.log.Error("%s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			c.ingestFailed(w, r, ingestErrDatabase, err)
 			return
 		}
+	}
+
+	networkAdapters, err := c.networkAdapterRepo.SelectWithComputerID(ctx, int(compID))
+	if err != nil {
+		c.ingestFailed(w, r, ingestErrDatabase, err)
+		return
+	}
 
+	if err = c.syncAdapters(ctx, compID, networkAdapters, record.Adapters); err != nil {
+		c.ingestFailed(w, r, ingestErrDatabase, err)
+		return
 	}
 
-	// Create new user record
-	_, err = c.userRepo.Create(ctx, &User{
-		Username:   record.Username,
-		ComputerID: null.IntFrom(compID),
+	err = c.computerRepo.UpdateAgent(ctx, &Computer{
+		ID:           null.IntFrom(compID),
+		AgentVersion: record.Version,
+		AgentCommit:  record.Commit,
+		OS:           record.OS,
+		Arch:         record.Arch,
 	})
+	if err != nil {
+		c.ingestFailed(w, r, ingestErrDatabase, err)
+		return
+	}
+
+	if err = c.assigner.Apply(ctx, compID, record.Name.String); err != nil {
+		c.ingestFailed(w, r, ingestErrDatabase, err)
+		return
+	}
+
+	event := IngestEvent{
+		ComputerID: compID,
+		Name:       record.Name.String,
+		Username:   record.Username.String,
+		New:        comp == nil,
+		Adapters:   record.Adapters,
+		Previous:   networkAdapters,
+	}
+	if previousUser != nil {
+		event.PreviousUser = previousUser.Username.String
+	}
 
+	// The report is stored, failing alert rules or webhooks must not make
+	// the agent send it again.
+	if err = c.alerter.Ingest(ctx, event); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+	if event.Computer, err = c.computerRepo.SelectWithID(ctx, int(compID)); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+	events := event.Events()
+	if err = c.webhooks.Enqueue(ctx, events...); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+
+	_, err = c.checkinRepo.Create(ctx, &Checkin{
+		ComputerID:   null.IntFrom(compID),
+		AgentVersion: record.Version,
+		PayloadBytes: null.IntFrom(int64(len(data))),
+		DurationMs:   null.IntFrom(time.Since(start).Milliseconds()),
+	})
 	if err != nil {
-		c.log.Error("%s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		requestlog.Error(r.Context(), c.log, err)
+	}
+
+	reported := NewEvent(EventComputerReported)
+	reported.Computer = event.Computer
+	if reported.Computer == nil {
+		reported.Computer = &Computer{ID: null.IntFrom(compID), Name: record.Name}
+	}
+	if record.Username.Valid {
+		reported.User = &User{
+			ComputerID:   null.IntFrom(compID),
+			ComputerName: record.Name,
+			Username:     record.Username,
+		}
+	}
+	c.hub.Publish(append([]Event{reported}, events...)...)
+
+	ingestTotal.Inc("success", "none")
+
+	// The report of an outdated agent is kept, the status tells it to
+	// upgrade.
+	if c.minVersion != "" && versionBelow(record.Version.String, c.minVersion) {
+		requestlog.Set(r.Context(), "agent_outdated", true)
+		w.Header().Set(HeaderMinVersion, c.minVersion)
+		http.Error(w, fmt.Sprintf("agent version %q is below the minimum version %s", record.Version.String, c.minVersion), http.StatusUpgradeRequired)
 		return
 	}
 
-	networkAdapters, err := c.networkAdapterRepo.SelectWithComputerID(ctx, int(compID))
+	// The agent applies the configuration when its version changes. Without
+	// one the agent keeps the configuration it has.
+	config, err := c.agentConfig(ctx, compID)
 	if err != nil {
-		c.log.Error("%s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
+	requestlog.Set(r.Context(), "agent_config", config.Version)
 
-	if len(networkAdapters) > 0 {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(config); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
 
-		for _, na := range networkAdapters {
-			for _, nar := range record.Adapters {
-				if na.MacAddress == nar.MacAddress {
-					na.IPAddress = nar.IPAddress
-					na.Name = nar.Name
-					err = c.networkAdapterRepo.Update(ctx, &na)
-					if err != nil {
-						c.log.Error("%s", err)
-						w.WriteHeader(http.StatusInternalServerError)
-						return
-					}
-				}
-			}
+// agentConfig returns the configuration of the agent of a computer, the
+// default when the server has none for it.
+func (c *computerController) agentConfig(ctx context.Context, compID int64) (*agentconfig.Config, error) {
+	config, err := c.agentConfigRepo.ForComputer(ctx, compID)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return agentconfig.Default(), nil
+	}
+	return config.Document(), nil
+}
+
+// imported reports whether any of the adapters came from an import.
+func imported(adapters []NetworkAdapter) bool {
+	for _, na := range adapters {
+		if na.Source.String == SourceImport {
+			return true
 		}
+	}
+	return false
+}
 
-	} else {
+// syncAdapters records the reported adapters of a computer: known MAC
+// addresses are updated, new ones created and the recorded adapters that
+// are no longer reported deleted. A report without adapters only removes
+// imported ones, agents may be told not to collect them. existing is left
+// unchanged, it is the state before the report.
+func (c *computerController) syncAdapters(ctx context.Context, compID int64, existing []NetworkAdapter, reported []NetworkAdapter) error {
+	seen := make(map[string]bool)
+
+	for _, nar := range reported {
+		mac := strings.ToLower(nar.MacAddress.String)
+		if seen[mac] {
+			continue
+		}
+		seen[mac] = true
+
+		var err error
+		if na := findAdapter(existing, nar.MacAddress.String); na != nil {
+			updated := *na
+			updated.Name = nar.Name
+			updated.IPAddress = nar.IPAddress
+			updated.Source = null.StringFrom(SourceAgent)
+			err = c.networkAdapterRepo.Update(ctx, &updated)
+		} else {
+			nar.ComputerID = null.IntFrom(compID)
+			nar.Source = null.StringFrom(SourceAgent)
+			_, err = c.networkAdapterRepo.Create(ctx, &nar)
+		}
+		if err != nil {
+			return err
+		}
+	}
 
-		for _, na := range record.Adapters {
-			na.ComputerID = null.IntFrom(compID)
-			if _, err = c.networkAdapterRepo.Create(ctx, &na); err != nil {
-				c.log.Error("%s", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+	for _, na := range existing {
+		if len(reported) == 0 && na.Source.String != SourceImport {
+			continue
+		}
+		if !seen[strings.ToLower(na.MacAddress.String)] {
+			if err := c.networkAdapterRepo.Delete(ctx, int(na.ID.Int64)); err != nil {
+				return err
 			}
 		}
+	}
+	return nil
+}
 
+// findAdapter returns the adapter with the MAC address mac, ignoring case.
+func findAdapter(adapters []NetworkAdapter, mac string) *NetworkAdapter {
+	for i := range adapters {
+		if strings.EqualFold(adapters[i].MacAddress.String, mac) {
+			return &adapters[i]
+		}
 	}
+	return nil
+}
 
-	w.WriteHeader(http.StatusOK)
+func (c *computerController) ingestFailed(w http.ResponseWriter, r *http.Request, class string, err error) {
+	ingestTotal.Inc("failure", class)
+	requestlog.Set(r.Context(), "error_class", class)
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// certificateName returns the common name of the verified client
+// certificate of r, or an empty string when none was presented.
+func certificateName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+// listPage is the data rendered by the list view templates.
+type listPage struct {
+	Title   string
+	View    string
+	Filter  Filter
+	Records interface{}
+
+	// Tags and Locations are the choices of the filter form.
+	Tags      []Tag
+	Locations []Location
+
+	// Prev and Next are the neighbouring page numbers, 0 when there is
+	// none.
+	Prev int
+	Next int
+
+	// Account is the signed in account, see AccountFromContext.
+	Account *account.Account
+}
+
+func (c *computerController) render(w http.ResponseWriter, r *http.Request, t *template.Template, data *listPage, rows int) {
+	data.Account = AccountFromContext(r.Context())
+	if page := data.Filter.Page(); page > 1 {
+		data.Prev = page - 1
+	}
+	if rows == data.Filter.Count {
+		data.Next = data.Filter.Page() + 1
+	}
+
+	var err error
+	if data.Tags, err = c.links.tagRepo.List(r.Context()); err == nil {
+		data.Locations, err = c.links.locationRepo.List(r.Context())
+	}
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
 }
 
 func (c *computerController) List(w http.ResponseWriter, r *http.Request) {
+	f := FilterFromRequest(r)
 
-	list, err := c.userRepo.ListWithComputerNames(r.Context(), 0, 20)
+	list, err := c.links.summaries(r.Context(), f)
 	if err != nil {
-		c.log.Error("%s", err)
-		c.log.Trace("%s", err.(*ErrorEx).Func)
+		requestlog.Error(r.Context(), c.log, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	data := struct {
-		Title   string
-		Records []User
-	}{
+	c.render(w, r, computerListPage(), &listPage{
 		Title:   "Computer List",
+		View:    "list",
+		Filter:  f,
+		Records: list,
+	}, len(list))
+}
+
+func (c *computerController) Users(w http.ResponseWriter, r *http.Request) {
+	f := FilterFromRequest(r)
+
+	list := []User{}
+	err := c.userRepo.EachWithComputerName(r.Context(), f, func(u User) error {
+		list = append(list, u)
+		return nil
+	})
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	c.render(w, r, editorPage(), &listPage{
+		Title:   "User History",
+		View:    "users",
+		Filter:  f,
+		Records: list,
+	}, len(list))
+}
+
+func (c *computerController) Adapters(w http.ResponseWriter, r *http.Request) {
+	f := FilterFromRequest(r)
+
+	list := []NetworkAdapter{}
+	err := c.networkAdapterRepo.EachWithComputerName(r.Context(), f, func(na NetworkAdapter) error {
+		list = append(list, na)
+		return nil
+	})
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	c.render(w, r, adapterListPage(), &listPage{
+		Title:   "Network Adapters",
+		View:    "adapters",
+		Filter:  f,
+		Records: list,
+	}, len(list))
+}
+
+// Stale lists the computers that have not reported for the number of days
+// given in the filter, StaleAfter by default.
+func (c *computerController) Stale(w http.ResponseWriter, r *http.Request) {
+	f := staleFilter(FilterFromRequest(r))
+
+	list, err := c.links.summaries(r.Context(), f)
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	c.render(w, r, computerListPage(), &listPage{
+		Title:   "Stale Computers",
+		View:    "stale",
+		Filter:  f,
 		Records: list,
+	}, len(list))
+}
+
+// Detail shows a computer with its network adapters, tags, locations and
+// custom fields.
+func (c *computerController) Detail(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+	renderDetail(w, r, c.log, c.links, id, http.StatusOK, "")
+}
+
+// Delete removes a computer from the inventory. A computer whose agent
+// reports again is recorded as a new one.
+func (c *computerController) Delete(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	comp, err := c.computerRepo.SelectWithID(r.Context(), id)
+	if err == nil && comp != nil {
+		c.ingestLock.Lock()
+		err = c.computerRepo.Delete(r.Context(), id)
+		c.ingestLock.Unlock()
 	}
 
-	editorPage().ExecuteTemplate(w, "page", &data)
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if comp == nil {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, "/computers/list", http.StatusSeeOther)
+}
+
+// searchPageData is rendered by searchPage.
+type searchPageData struct {
+	*SearchResult
+
+	Account *account.Account
+}
+
+// Search shows the computers matching the q parameter, whether it holds a
+// host name, user name, MAC or IP address.
+func (c *computerController) Search(w http.ResponseWriter, r *http.Request) {
+	result, err := c.links.searchRepo.Search(r.Context(), ParseSearch(r.URL.Query().Get("q")))
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	requestlog.Set(r.Context(), "search_kind", result.Kind)
+
+	data := &searchPageData{
+		SearchResult: result,
+		Account:      AccountFromContext(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := searchPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
+
+func staleFilter(f Filter) Filter {
+	if f.Days == 0 {
+		f.Days = int(StaleAfter / (time.Hour * 24))
+		f.Before = time.Now().Add(-StaleAfter)
+	}
+	return f
+}
+
+// Export streams the rows of a list view, matching the same filter, as a
+// CSV or XLSX download.
+func (c *computerController) Export(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	view, format := vars["view"], vars["format"]
+
+	f := FilterFromRequest(r)
+	f.Start, f.Count = 0, 0
+
+	var (
+		header []string
+		each   func(func([]string) error) error
+	)
+
+	switch view {
+	case "list", "stale":
+		if view == "stale" {
+			f = staleFilter(f)
+		}
+		header = []string{"ComputerName", "LastSeen", "LastUser", "Adapters", "Created"}
+		each = func(write func([]string) error) error {
+			return c.computerRepo.EachSummary(r.Context(), f, func(s ComputerSummary) error {
+				return write([]string{s.Name.String, s.LastSeen.String, s.LastUser.String, strconv.Itoa(s.Adapters), s.Created.String})
+			})
+		}
+	case "subnets":
+		header = []string{"Name", "Subnet", "VLAN", "Site", "Computers", "Active", "Addresses", "Size", "Utilisation"}
+		each = func(write func([]string) error) error {
+			list, err := c.links.subnetRepo.Usage(r.Context(), time.Now().Add(-StaleAfter))
+			if err != nil {
+				return err
+			}
+			for _, s := range list {
+				vlan := ""
+				if s.VLAN.Valid {
+					vlan = strconv.FormatInt(s.VLAN.Int64, 10)
+				}
+				err = write([]string{
+					s.Name.String, s.CIDR.String, vlan, s.LocationName.String,
+					strconv.Itoa(s.Computers), strconv.Itoa(s.Active), strconv.Itoa(s.Addresses),
+					strconv.Itoa(s.Size()), strconv.FormatFloat(s.Utilisation(), 'f', 1, 64),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	case "users":
+		header = []string{"Date", "ComputerName", "Username"}
+		each = func(write func([]string) error) error {
+			return c.userRepo.EachWithComputerName(r.Context(), f, func(u User) error {
+				return write([]string{u.Created.String, u.ComputerName.String, u.Username.String})
+			})
+		}
+	case "adapters":
+		header = []string{"ComputerName", "Adapter", "MacAddress", "IPAddress", "Created", "Updated"}
+		each = func(write func([]string) error) error {
+			return c.networkAdapterRepo.EachWithComputerName(r.Context(), f, func(na NetworkAdapter) error {
+				return write([]string{na.ComputerName.String, na.Name.String, na.MacAddress.String, na.IPAddress.String, na.Created.String, na.Updated.String})
+			})
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	name := fmt.Sprintf("fpsmonitor-%s-%s.%s", view, time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, name))
+
+	ew, err := export.New(format, w, view)
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Once rows have been sent the status can no longer change, a failure
+	// part way leaves a truncated download and the error in the log.
+	if err = ew.Write(header); err == nil {
+		err = each(ew.Write)
+	}
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
 }
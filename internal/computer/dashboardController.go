@@ -0,0 +1,263 @@
+package computer
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	// dashboardHours is the number of hours charted on the dashboard.
+	dashboardHours = 24
+
+	// dashboardUserDays is the period the top users are counted over.
+	dashboardUserDays = 30
+
+	// dashboardLimit limits the users, vendors and alerts listed on the
+	// dashboard.
+	dashboardLimit = 10
+)
+
+// ouiVendors names the vendors of common MAC address prefixes. Adapters of
+// other vendors are listed by their prefix.
+var ouiVendors = map[string]string{
+	"00:05:69": "VMware",
+	"00:0C:29": "VMware",
+	"00:50:56": "VMware",
+	"00:15:5D": "Microsoft Hyper-V",
+	"08:00:27": "VirtualBox",
+	"52:54:00": "QEMU/KVM",
+	"00:16:3E": "Xen",
+	"00:1C:42": "Parallels",
+	"00:E0:4C": "Realtek",
+	"00:14:22": "Dell",
+	"00:1B:21": "Intel",
+	"B8:27:EB": "Raspberry Pi",
+	"DC:A6:32": "Raspberry Pi",
+}
+
+// VendorCount is the number of adapters of a vendor.
+type VendorCount struct {
+	Vendor   string
+	Adapters int64
+}
+
+// vendorOf returns the vendor of a MAC address prefix.
+func vendorOf(prefix string) string {
+	if v, ok := ouiVendors[prefix]; ok {
+		return v
+	}
+	if len(prefix) >= 2 {
+		if b, err := strconv.ParseUint(prefix[:2], 16, 8); err == nil && b&0x02 != 0 {
+			return "Locally administered"
+		}
+	}
+	return prefix
+}
+
+// vendors totals the prefix counts by vendor, largest first. Vendors past
+// limit are summed up as "Other".
+func vendors(prefixes []PrefixCount, limit int) []VendorCount {
+	totals := map[string]int64{}
+	for _, p := range prefixes {
+		totals[vendorOf(p.Prefix.String)] += p.Adapters.Int64
+	}
+
+	list := []VendorCount{}
+	for v, n := range totals {
+		list = append(list, VendorCount{Vendor: v, Adapters: n})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Adapters != list[j].Adapters {
+			return list[i].Adapters > list[j].Adapters
+		}
+		return list[i].Vendor < list[j].Vendor
+	})
+
+	if len(list) > limit {
+		other := VendorCount{Vendor: "Other"}
+		for _, v := range list[limit:] {
+			other.Adapters += v.Adapters
+		}
+		list = append(list[:limit], other)
+	}
+	return list
+}
+
+// systems totals the platform counts by operating system, largest first.
+func systems(platforms []PlatformCount) []PlatformCount {
+	list := []PlatformCount{}
+	index := map[string]int{}
+	for _, p := range platforms {
+		i, ok := index[p.OS.String]
+		if !ok {
+			i = len(list)
+			index[p.OS.String] = i
+			list = append(list, PlatformCount{OS: p.OS, Computers: null.IntFrom(0)})
+		}
+		list[i].Computers.Int64 += p.Computers.Int64
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Computers.Int64 > list[j].Computers.Int64
+	})
+	return list
+}
+
+// HourBar is an hour of the reports chart, Percent is its height relative
+// to the busiest hour. Tick marks the hours labelled on the axis.
+type HourBar struct {
+	Label   string
+	Reports int64
+	Percent int64
+	Tick    bool
+}
+
+// hourBars returns a bar for each of the hours hours up to now, including
+// the hours without reports.
+func hourBars(counts []HourCount, now time.Time, hours int) []HourBar {
+	byHour := map[string]int64{}
+	var max int64
+	for _, c := range counts {
+		byHour[c.Hour.String] = c.Reports.Int64
+		if c.Reports.Int64 > max {
+			max = c.Reports.Int64
+		}
+	}
+
+	bars := make([]HourBar, hours)
+	start := now.Truncate(time.Hour).Add(-time.Duration(hours-1) * time.Hour)
+	for i := range bars {
+		hour := start.Add(time.Duration(i) * time.Hour)
+		bars[i] = HourBar{
+			Label:   hour.Format("15:00"),
+			Reports: byHour[hour.Format("2006-01-02 15")],
+			Tick:    hour.Hour()%3 == 0,
+		}
+		if max > 0 {
+			bars[i].Percent = bars[i].Reports * 100 / max
+		}
+	}
+	return bars
+}
+
+type dashboardController struct {
+	log           lumber.Logger
+	dashboardRepo DashboardRepository
+	digestRepo    DigestRepository
+	alertRepo     AlertRepository
+}
+
+type DashboardController interface {
+	Dashboard(http.ResponseWriter, *http.Request)
+}
+
+// NewDashboardController registers the dashboard at /computers/dashboard,
+// the page shown after signing in, and redirects / to it.
+func NewDashboardController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) DashboardController {
+	c := &dashboardController{
+		log:           log,
+		dashboardRepo: NewDashboardRepository(db),
+		digestRepo:    NewDigestRepository(db),
+		alertRepo:     NewAlertRepository(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+
+	router.Handle("/", http.RedirectHandler("/computers/dashboard", http.StatusFound)).Methods("GET")
+	router.Handle("/computers/dashboard", view.ThenFunc(c.Dashboard)).Methods("GET").Name("dashboard")
+
+	return c
+}
+
+// dashboardPageData is rendered by dashboardPage.
+type dashboardPageData struct {
+	Title        string
+	View         string
+	Counts       *InventoryCounts
+	ActiveAlerts int
+	Hours        []HourBar
+	TopUsers     []UserCount
+	UserDays     int
+	Vendors      []VendorCount
+	Systems      []PlatformCount
+	Alerts       []Alert
+	Account      *account.Account
+}
+
+func (c *dashboardController) Dashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	data := &dashboardPageData{
+		Title:    "Dashboard",
+		View:     "dashboard",
+		UserDays: dashboardUserDays,
+		Account:  AccountFromContext(ctx),
+	}
+
+	var err error
+	if data.Counts, err = c.dashboardRepo.Counts(ctx, today, now.Add(-StaleAfter)); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	if data.ActiveAlerts, err = c.alertRepo.CountActive(ctx); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	hours, err := c.dashboardRepo.ReportsPerHour(ctx, now.Truncate(time.Hour).Add(-(dashboardHours-1)*time.Hour))
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	data.Hours = hourBars(hours, now, dashboardHours)
+
+	if data.TopUsers, err = c.digestRepo.TopUsers(ctx, now.AddDate(0, 0, -dashboardUserDays), dashboardLimit); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	prefixes, err := c.dashboardRepo.AdapterPrefixes(ctx)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	data.Vendors = vendors(prefixes, dashboardLimit)
+
+	platforms, err := c.dashboardRepo.Platforms(ctx)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	data.Systems = systems(platforms)
+
+	if data.Alerts, err = c.alertRepo.List(ctx, AlertActive, dashboardLimit); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := dashboardPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(ctx, c.log, err)
+	}
+}
+
+func (c *dashboardController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
@@ -0,0 +1,256 @@
+package computer
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/guregu/null.v3"
+)
+
+// InventoryCounts are the computer totals shown on the dashboard.
+type InventoryCounts struct {
+	Total  int `db:"total"`
+	Active int `db:"active"`
+	Stale  int `db:"stale"`
+}
+
+// HourCount is the number of reports received in an hour, Hour is
+// formatted "2006-01-02 15".
+type HourCount struct {
+	Hour    null.String `db:"hour"`
+	Reports null.Int    `db:"reports"`
+}
+
+// PrefixCount is the number of live network adapters sharing a MAC address
+// prefix, formatted "00:11:22".
+type PrefixCount struct {
+	Prefix   null.String `db:"prefix"`
+	Adapters null.Int    `db:"adapters"`
+}
+
+// VersionCount is the number of live agents running a version, "" for the
+// agents not reporting one.
+type VersionCount struct {
+	Version   null.String `db:"version"`
+	Computers null.Int    `db:"computers"`
+}
+
+// PlatformCount is the number of live agents running on an operating
+// system and architecture.
+type PlatformCount struct {
+	OS        null.String `db:"os"`
+	Arch      null.String `db:"arch"`
+	Computers null.Int    `db:"computers"`
+}
+
+type DashboardRepository interface {
+	Install(context.Context) error
+
+	// Counts counts the live computers, those that reported since
+	// activeSince and those that last reported before staleBefore.
+	Counts(ctx context.Context, activeSince time.Time, staleBefore time.Time) (*InventoryCounts, error)
+
+	// ReportsPerHour counts the reports received since since by hour,
+	// hours without reports are left out.
+	ReportsPerHour(ctx context.Context, since time.Time) ([]HourCount, error)
+
+	// AdapterPrefixes counts the adapters of the live computers by the
+	// vendor prefix of their MAC address.
+	AdapterPrefixes(ctx context.Context) ([]PrefixCount, error)
+
+	// Versions counts the live computers reported by an agent by the
+	// agent version.
+	Versions(ctx context.Context) ([]VersionCount, error)
+
+	// Platforms counts the live computers reported by an agent by the
+	// operating system and architecture of the agent.
+	Platforms(ctx context.Context) ([]PlatformCount, error)
+
+	// WithVersion returns the live computers reported by an agent running
+	// version, "" for the agents not reporting one.
+	WithVersion(ctx context.Context, version string) ([]Computer, error)
+}
+
+type dashboardRepository struct {
+	db *sqlx.DB
+}
+
+func NewDashboardRepository(db *sqlx.DB) DashboardRepository {
+	return &dashboardRepository{
+		db: db,
+	}
+}
+
+// Install indexes the user records by creation, every report adds one.
+func (r *dashboardRepository) Install(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `CREATE INDEX computer_users_created ON computer_users (created)`)
+	return err
+}
+
+func (r *dashboardRepository) Counts(ctx context.Context, activeSince time.Time, staleBefore time.Time) (*InventoryCounts, error) {
+	defer observeQuery("dashboard", "Counts", time.Now())
+
+	data := InventoryCounts{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            COUNT(*) AS total,
+            COALESCE(SUM(CASE WHEN COALESCE(updated, created) >= ? THEN 1 ELSE 0 END), 0) AS active,
+            COALESCE(SUM(CASE WHEN COALESCE(updated, created) < ? THEN 1 ELSE 0 END), 0) AS stale
+        FROM computers
+        WHERE deleted IS NULL`),
+		activeSince.Format("2006-01-02 15:04:05"),
+		staleBefore.Format("2006-01-02 15:04:05"),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *dashboardRepository) ReportsPerHour(ctx context.Context, since time.Time) ([]HourCount, error) {
+	defer observeQuery("dashboard", "ReportsPerHour", time.Now())
+
+	data := []HourCount{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            SUBSTR(created, 1, 13) AS hour,
+            COUNT(*) AS reports
+        FROM computer_users
+        WHERE created >= ?
+        GROUP BY SUBSTR(created, 1, 13)
+        ORDER BY hour`),
+		since.Format("2006-01-02 15:04:05"),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *dashboardRepository) AdapterPrefixes(ctx context.Context) ([]PrefixCount, error) {
+	defer observeQuery("dashboard", "AdapterPrefixes", time.Now())
+
+	data := []PrefixCount{}
+
+	prefix := `UPPER(SUBSTR(REPLACE(na.mac_address, '-', ':'), 1, 8))`
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            `+prefix+` AS prefix,
+            COUNT(*) AS adapters
+        FROM computer_network_adapters na
+        INNER JOIN computers c ON c.id = na.computer_id
+        WHERE na.deleted IS NULL
+        AND c.deleted IS NULL
+        AND na.mac_address IS NOT NULL
+        AND na.mac_address<>''
+        GROUP BY `+prefix+`
+        ORDER BY adapters DESC, prefix`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *dashboardRepository) Versions(ctx context.Context) ([]VersionCount, error) {
+	defer observeQuery("dashboard", "Versions", time.Now())
+
+	data := []VersionCount{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            COALESCE(agent_version, '') AS version,
+            COUNT(*) AS computers
+        FROM computers
+        WHERE deleted IS NULL
+        AND source=?
+        GROUP BY COALESCE(agent_version, '')
+        ORDER BY computers DESC, version DESC`),
+		SourceAgent,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *dashboardRepository) Platforms(ctx context.Context) ([]PlatformCount, error) {
+	defer observeQuery("dashboard", "Platforms", time.Now())
+
+	data := []PlatformCount{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            COALESCE(os, '') AS os,
+            COALESCE(arch, '') AS arch,
+            COUNT(*) AS computers
+        FROM computers
+        WHERE deleted IS NULL
+        AND source=?
+        GROUP BY COALESCE(os, ''), COALESCE(arch, '')
+        ORDER BY computers DESC, os, arch`),
+		SourceAgent,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *dashboardRepository) WithVersion(ctx context.Context, version string) ([]Computer, error) {
+	defer observeQuery("dashboard", "WithVersion", time.Now())
+
+	data := []Computer{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            updated,
+            name,
+            source,
+            agent_version,
+            agent_commit,
+            os,
+            arch
+        FROM computers
+        WHERE deleted IS NULL
+        AND source=?
+        AND COALESCE(agent_version, '')=?
+        ORDER BY name`),
+		SourceAgent,
+		version,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -0,0 +1,313 @@
+package computer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+// ComputerDetail is a computer with everything linked to it, shown on the
+// detail page and returned by the API.
+type ComputerDetail struct {
+	Computer
+
+	Adapters  []NetworkAdapter `json:"adapters"`
+	Tags      []Tag            `json:"tags"`
+	Locations []Location       `json:"locations"`
+	Fields    []FieldValue     `json:"fields"`
+	Merges    []Merge          `json:"merges"`
+}
+
+// DetailChange changes the manually maintained details of a computer, nil
+// values are left alone and empty ones cleared. Fields are keyed by
+// Field.Key.
+type DetailChange struct {
+	Owner    *string           `json:"owner"`
+	Location *string           `json:"location"`
+	Notes    *string           `json:"notes"`
+	Fields   map[string]string `json:"fields"`
+}
+
+// DetailError is a change rejected because of its content.
+type DetailError struct {
+	Message string
+}
+
+func (e *DetailError) Error() string {
+	return e.Message
+}
+
+// links loads tags, locations and subnets for the list views and the API.
+type links struct {
+	computerRepo       ComputerRepository
+	networkAdapterRepo NetworkAdapterRepository
+	tagRepo            TagRepository
+	locationRepo       LocationRepository
+	subnetRepo         SubnetRepository
+	fieldRepo          FieldRepository
+	mergeRepo          MergeRepository
+	searchRepo         SearchRepository
+	checkinRepo        CheckinRepository
+}
+
+func newLinks(db *sqlx.DB) *links {
+	return &links{
+		computerRepo:       NewComputerRepository(db),
+		networkAdapterRepo: NewNetworkAdapterRepository(db),
+		tagRepo:            NewTagRepository(db),
+		locationRepo:       NewLocationRepository(db),
+		subnetRepo:         NewSubnetRepository(db),
+		fieldRepo:          NewFieldRepository(db),
+		mergeRepo:          NewMergeRepository(db),
+		searchRepo:         NewSearchRepository(db),
+		checkinRepo:        NewCheckinRepository(db),
+	}
+}
+
+// summaries returns the computers matching f with their tags and locations.
+func (l *links) summaries(ctx context.Context, f Filter) ([]ComputerSummary, error) {
+	list := []ComputerSummary{}
+	err := l.computerRepo.EachSummary(ctx, f, func(s ComputerSummary) error {
+		list = append(list, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(list))
+	for i, s := range list {
+		ids[i] = s.ID.Int64
+	}
+
+	tags, err := l.tagRepo.ListForComputers(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	locations, err := l.locations(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range list {
+		list[i].Tags = nonNilTags(tags[list[i].ID.Int64])
+		list[i].Locations = nonNilLocations(locations[list[i].ID.Int64])
+	}
+	return list, nil
+}
+
+// detail returns the computer with the given id and everything linked to
+// it, nil when it does not exist.
+func (l *links) detail(ctx context.Context, id int) (*ComputerDetail, error) {
+	comp, err := l.computerRepo.SelectWithID(ctx, id)
+	if err != nil || comp == nil {
+		return nil, err
+	}
+
+	adapters, err := l.networkAdapterRepo.SelectWithComputerID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := l.tagRepo.ListForComputers(ctx, []int64{comp.ID.Int64})
+	if err != nil {
+		return nil, err
+	}
+
+	locations, err := l.locations(ctx, []int64{comp.ID.Int64})
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := l.fieldRepo.Values(ctx, comp.ID.Int64)
+	if err != nil {
+		return nil, err
+	}
+
+	merges, err := l.mergeRepo.ListForComputer(ctx, comp.ID.Int64)
+	if err != nil {
+		return nil, err
+	}
+
+	if adapters == nil {
+		adapters = []NetworkAdapter{}
+	}
+
+	return &ComputerDetail{
+		Computer:  *comp,
+		Adapters:  adapters,
+		Tags:      nonNilTags(tags[comp.ID.Int64]),
+		Locations: nonNilLocations(locations[comp.ID.Int64]),
+		Fields:    fields,
+		Merges:    merges,
+	}, nil
+}
+
+// sparkline charts the daily check-ins of the computer with the given id
+// over the checkinWindow up to now.
+func (l *links) sparkline(ctx context.Context, id int64, now time.Time) (*Sparkline, error) {
+	days := int(checkinWindow / (24 * time.Hour))
+	counts, err := l.checkinRepo.Daily(ctx, id, now.AddDate(0, 0, 1-days))
+	if err != nil {
+		return nil, err
+	}
+	return sparkline(counts, now, days), nil
+}
+
+// save applies change to the computer with the given id after checking
+// every value, nothing is written when one is rejected. It returns false
+// when the computer does not exist. The caller is expected to hold the
+// write lock.
+func (l *links) save(ctx context.Context, id int, change DetailChange) (bool, error) {
+	comp, err := l.computerRepo.SelectWithID(ctx, id)
+	if err != nil || comp == nil {
+		return false, err
+	}
+
+	fields, err := l.fieldRepo.List(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	byKey := make(map[string]Field, len(fields))
+	for _, f := range fields {
+		byKey[f.Key.String] = f
+	}
+
+	values := make(map[int64]string, len(change.Fields))
+	for key, value := range change.Fields {
+		f, ok := byKey[key]
+		if !ok {
+			return true, &DetailError{Message: fmt.Sprintf("unknown field %q", key)}
+		}
+		if values[f.ID.Int64], err = f.Check(value); err != nil {
+			return true, &DetailError{Message: err.Error()}
+		}
+	}
+
+	for _, v := range []*string{change.Owner, change.Location, change.Notes} {
+		if v != nil && len(*v) > maxFieldValue {
+			return true, &DetailError{Message: fmt.Sprintf("values are limited to %d characters", maxFieldValue)}
+		}
+	}
+
+	set := func(dst *null.String, v *string) {
+		if v != nil {
+			s := strings.TrimSpace(*v)
+			*dst = null.NewString(s, s != "")
+		}
+	}
+	set(&comp.Owner, change.Owner)
+	set(&comp.Location, change.Location)
+	set(&comp.Notes, change.Notes)
+
+	if err = l.computerRepo.UpdateDetails(ctx, comp); err != nil {
+		return true, err
+	}
+
+	for fieldID, value := range values {
+		if err = l.fieldRepo.SetValue(ctx, comp.ID.Int64, fieldID, value); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// locations returns the locations of the computers with their Path set.
+func (l *links) locations(ctx context.Context, ids []int64) (map[int64][]Location, error) {
+	result, err := l.locationRepo.ListForComputers(ctx, ids)
+	if err != nil || len(result) == 0 {
+		return result, err
+	}
+
+	all, err := l.locationRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[int64]string, len(all))
+	for _, loc := range all {
+		paths[loc.ID.Int64] = loc.Path
+	}
+
+	for _, list := range result {
+		for i := range list {
+			list[i].Path = paths[list[i].ID.Int64]
+		}
+	}
+	return result, nil
+}
+
+func nonNilTags(list []Tag) []Tag {
+	if list == nil {
+		return []Tag{}
+	}
+	return list
+}
+
+func nonNilLocations(list []Location) []Location {
+	if list == nil {
+		return []Location{}
+	}
+	return list
+}
+
+// detailPageData is rendered by detailPage, Tags and Locations are the
+// choices of the assign forms.
+type detailPageData struct {
+	Computer  *ComputerDetail
+	Tags      []Tag
+	Locations []Location
+	Checkins  *Sparkline
+	Error     string
+	Account   *account.Account
+	CSRF      string
+}
+
+// renderDetail renders the detail page of the computer with the given id,
+// message is shown as an error.
+func renderDetail(w http.ResponseWriter, r *http.Request, log lumber.Logger, l *links, id int, status int, message string) {
+	comp, err := l.detail(r.Context(), id)
+	if err != nil {
+		requestlog.Error(r.Context(), log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if comp == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := &detailPageData{
+		Computer: comp,
+		Error:    message,
+		Account:  AccountFromContext(r.Context()),
+		CSRF:     CSRFFromContext(r.Context()),
+	}
+	if data.Tags, err = l.tagRepo.List(r.Context()); err == nil {
+		data.Locations, err = l.locationRepo.List(r.Context())
+	}
+	if err == nil {
+		data.Checkins, err = l.sparkline(r.Context(), comp.ID.Int64, time.Now())
+	}
+	if err != nil {
+		requestlog.Error(r.Context(), log, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := detailPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), log, err)
+	}
+}
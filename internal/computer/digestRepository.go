@@ -0,0 +1,93 @@
+package computer
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/guregu/null.v3"
+)
+
+// UserCount is a user with the number of computers they signed in to.
+type UserCount struct {
+	Username  null.String `db:"username"`
+	Computers null.Int    `db:"computers"`
+}
+
+type DigestRepository interface {
+	// NewComputers returns the live computers created since since.
+	NewComputers(ctx context.Context, since time.Time) ([]Computer, error)
+
+	// TopUsers returns the users who signed in to the most computers since
+	// since.
+	TopUsers(ctx context.Context, since time.Time, limit int) ([]UserCount, error)
+}
+
+type digestRepository struct {
+	db *sqlx.DB
+}
+
+func NewDigestRepository(db *sqlx.DB) DigestRepository {
+	return &digestRepository{
+		db: db,
+	}
+}
+
+func (r *digestRepository) NewComputers(ctx context.Context, since time.Time) ([]Computer, error) {
+	defer observeQuery("digest", "NewComputers", time.Now())
+
+	data := []Computer{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            updated,
+            name,
+            source
+        FROM computers
+        WHERE deleted IS NULL
+        AND created>=?
+        ORDER BY name`),
+		since.Format("2006-01-02 15:04:05"),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *digestRepository) TopUsers(ctx context.Context, since time.Time, limit int) ([]UserCount, error) {
+	defer observeQuery("digest", "TopUsers", time.Now())
+
+	data := []UserCount{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            LOWER(u.username) AS username,
+            COUNT(DISTINCT u.computer_id) AS computers
+        FROM computer_users u
+        INNER JOIN computers c ON c.id = u.computer_id
+        WHERE u.deleted IS NULL
+        AND c.deleted IS NULL
+        AND u.created>=?
+        AND u.username<>''
+        GROUP BY LOWER(u.username)
+        ORDER BY computers DESC, username
+        LIMIT ?`),
+		since.Format("2006-01-02 15:04:05"),
+		limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -8,3 +8,8 @@ type ErrorEx struct {
 func (e *ErrorEx) Error() string {
 	return e.ErrorMsg.Error()
 }
+
+// Function returns the name of the function the error was raised in.
+func (e *ErrorEx) Function() string {
+	return e.Func
+}
@@ -0,0 +1,137 @@
+package computer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// Event types published about the inventory. Reports are only published
+// to the event streams, see Hub.
+const (
+	EventComputerReported = "computer.reported"
+	EventComputerCreated  = "computer.created"
+	EventUserChanged      = "user.changed"
+	EventAdapterAdded     = "adapter.added"
+	EventAdapterRemoved   = "adapter.removed"
+	EventAlertRaised      = "alert.raised"
+	EventPing             = "ping"
+)
+
+// EventTypes lists the event types that can be subscribed to.
+func EventTypes() []string {
+	return []string{EventComputerCreated, EventUserChanged, EventAdapterAdded, EventAdapterRemoved, EventAlertRaised}
+}
+
+// Event describes a change of the inventory and the records it affects.
+type Event struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	Time     string          `json:"time"`
+	Computer *Computer       `json:"computer,omitempty"`
+	User     *User           `json:"user,omitempty"`
+	Adapter  *NetworkAdapter `json:"adapter,omitempty"`
+	Alert    *Alert          `json:"alert,omitempty"`
+}
+
+// NewEvent returns an event of type with a random ID.
+func NewEvent(typ string) Event {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return Event{
+		ID:   hex.EncodeToString(id),
+		Type: typ,
+		Time: time.Now().Format(time.RFC3339),
+	}
+}
+
+// IngestEvent describes a report of an agent, Previous holding the
+// adapters and PreviousUser the user recorded before it.
+type IngestEvent struct {
+	ComputerID   int64
+	Name         string
+	Username     string
+	New          bool
+	Adapters     []NetworkAdapter
+	Previous     []NetworkAdapter
+	PreviousUser string
+
+	// Computer is the record after the report.
+	Computer *Computer
+}
+
+// Added returns the reported adapters whose MAC address was not recorded.
+// New computers and computers whose adapters were imported, which the
+// first report replaces, have none.
+func (e IngestEvent) Added() []NetworkAdapter {
+	if e.New {
+		return nil
+	}
+	return e.missing(e.Adapters, e.Previous)
+}
+
+// Removed returns the recorded adapters whose MAC address was not reported.
+// Reports without adapters remove none.
+func (e IngestEvent) Removed() []NetworkAdapter {
+	if len(e.Adapters) == 0 {
+		return nil
+	}
+	return e.missing(e.Previous, e.Adapters)
+}
+
+func (e IngestEvent) missing(from []NetworkAdapter, to []NetworkAdapter) []NetworkAdapter {
+	if imported(e.Previous) {
+		return nil
+	}
+
+	var list []NetworkAdapter
+	for _, na := range from {
+		if na.MacAddress.String != "" && findAdapter(to, na.MacAddress.String) == nil {
+			na.ComputerID = null.IntFrom(e.ComputerID)
+			list = append(list, na)
+		}
+	}
+	return list
+}
+
+// Events returns the events published about the report.
+func (e IngestEvent) Events() []Event {
+	computer := e.Computer
+	if computer == nil {
+		computer = &Computer{ID: null.IntFrom(e.ComputerID), Name: null.StringFrom(e.Name)}
+	}
+
+	var events []Event
+	add := func(typ string, user *User, adapter *NetworkAdapter) {
+		event := NewEvent(typ)
+		event.Computer = computer
+		event.User = user
+		event.Adapter = adapter
+		events = append(events, event)
+	}
+
+	if e.New {
+		add(EventComputerCreated, nil, nil)
+	}
+
+	if !e.New && e.PreviousUser != "" && e.Username != "" && !strings.EqualFold(e.PreviousUser, e.Username) {
+		add(EventUserChanged, &User{
+			ComputerID:   null.IntFrom(e.ComputerID),
+			ComputerName: null.StringFrom(e.Name),
+			Username:     null.StringFrom(e.Username),
+		}, nil)
+	}
+
+	for _, na := range e.Added() {
+		na := na
+		add(EventAdapterAdded, nil, &na)
+	}
+	for _, na := range e.Removed() {
+		na := na
+		add(EventAdapterRemoved, nil, &na)
+	}
+	return events
+}
@@ -0,0 +1,150 @@
+package computer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+)
+
+const (
+	// eventHeartbeat is how often an idle stream sends a comment, keeping
+	// proxies from closing it. Streams with a shorter lifetime send it
+	// halfway through.
+	eventHeartbeat = 10 * time.Second
+
+	// eventRetry is the reconnection delay in milliseconds sent to the
+	// browser.
+	eventRetry = 2000
+
+	// eventResync is sent to streams that missed events, the page has to
+	// be reloaded.
+	eventResync = "resync"
+)
+
+type eventController struct {
+	log      lumber.Logger
+	hub      *Hub
+	lifetime time.Duration
+}
+
+type EventController interface {
+	Stream(http.ResponseWriter, *http.Request)
+}
+
+// NewEventController registers the server-sent event stream of the
+// reports received by this process at /computers/events. Streams end
+// after lifetime, which has to be shorter than the write timeout of the
+// server, and browsers reconnect with the ID of the last event they
+// received after eventRetry, so every open page reconnects once per
+// lifetime plus two seconds without missing events. A lifetime of 0 keeps
+// streams open.
+func NewEventController(db *sqlx.DB, log lumber.Logger, router *mux.Router, lifetime time.Duration, guard Guard, middleware ...alice.Constructor) EventController {
+	c := &eventController{
+		log:      log,
+		hub:      hubFor(db),
+		lifetime: lifetime,
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+
+	r := router.PathPrefix("/computers").Subrouter()
+	r.Handle("/events", view.ThenFunc(c.Stream)).Methods("GET").Name("events")
+
+	return c
+}
+
+// Stream sends the published events as they arrive, only those about one
+// computer when the computer parameter holds its ID. Events published
+// after the Last-Event-ID are sent first, or a resync event when they are
+// no longer kept. Streams falling behind are closed by the hub.
+func (c *eventController) Stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	var computerID int64
+	if v := r.URL.Query().Get("computer"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "The computer must be an ID.", http.StatusBadRequest)
+			return
+		}
+		computerID = id
+	}
+
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	sub, backlog, complete := c.hub.Subscribe(lastID)
+	defer c.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprintf(w, "retry: %d\n\n", eventRetry)
+	if !complete {
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {}\n\n", sub.Seq, eventResync)
+	} else if len(backlog) == 0 && sub.Seq > lastID {
+		// The ID lets browsers reconnecting before the first event ask
+		// for the events they missed.
+		fmt.Fprintf(w, "id: %d\n\n", sub.Seq)
+	}
+
+	send := func(msg HubMessage) {
+		if computerID > 0 && (msg.Event.Computer == nil || msg.Event.Computer.ID.Int64 != computerID) {
+			return
+		}
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", msg.ID, msg.Event.Type, msg.Data)
+	}
+	for _, msg := range backlog {
+		send(msg)
+	}
+	flusher.Flush()
+
+	interval := eventHeartbeat
+	if c.lifetime > 0 && c.lifetime <= interval {
+		interval = c.lifetime / 2
+	}
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+
+	var end <-chan time.Time
+	if c.lifetime > 0 {
+		timer := time.NewTimer(c.lifetime)
+		defer timer.Stop()
+		end = timer.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-end:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case msg, ok := <-sub.C:
+			if !ok {
+				requestlog.Set(r.Context(), "error_class", "slow_subscriber")
+				return
+			}
+			send(msg)
+			flusher.Flush()
+		}
+	}
+}
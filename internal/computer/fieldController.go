@@ -0,0 +1,199 @@
+package computer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+type fieldController struct {
+	log       lumber.Logger
+	fieldRepo FieldRepository
+	links     *links
+	lock      sync.Locker
+}
+
+type FieldController interface {
+	Page(http.ResponseWriter, *http.Request)
+	Create(http.ResponseWriter, *http.Request)
+	Delete(http.ResponseWriter, *http.Request)
+	Save(http.ResponseWriter, *http.Request)
+}
+
+// NewFieldController registers the page defining custom fields at
+// /computers/fields and the form saving the details of a computer from
+// its detail page. Details are saved by the helpdesk role, defining fields
+// takes the admin role.
+func NewFieldController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) FieldController {
+	c := &fieldController{
+		log:       log,
+		fieldRepo: NewFieldRepository(db),
+		links:     newLinks(db),
+		lock:      writeLock(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+	helpdesk := alice.New(m...).Append(guard(account.RoleHelpdesk))
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
+
+	r := router.PathPrefix("/computers").Subrouter()
+	r.Handle("/fields", view.ThenFunc(c.Page)).Methods("GET").Name("fields")
+	r.Handle("/fields", admin.ThenFunc(c.Create)).Methods("POST").Name("field_create")
+	r.Handle("/fields/{id:[0-9]+}/delete", admin.ThenFunc(c.Delete)).Methods("POST").Name("field_delete")
+	r.Handle("/{id:[0-9]+}/details", helpdesk.ThenFunc(c.Save)).Methods("POST").Name("details")
+
+	return c
+}
+
+// fieldPageData is rendered by fieldPage.
+type fieldPageData struct {
+	Title   string
+	Error   string
+	Fields  []Field
+	Account *account.Account
+	CSRF    string
+}
+
+func (c *fieldController) Page(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, http.StatusOK, "")
+}
+
+func (c *fieldController) Create(w http.ResponseWriter, r *http.Request) {
+	field := &Field{
+		Key:     null.StringFrom(r.FormValue("key")),
+		Label:   null.StringFrom(r.FormValue("label")),
+		Kind:    null.StringFrom(r.FormValue("kind")),
+		Options: null.StringFrom(r.FormValue("options")),
+	}
+
+	if err := field.Validate(); err != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	existing, err := c.fieldRepo.SelectWithKey(r.Context(), field.Key.String)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	if existing != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("A field with the key %q already exists.", field.Key.String))
+		return
+	}
+
+	if _, err = c.fieldRepo.Create(r.Context(), field); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/fields", http.StatusSeeOther)
+}
+
+// Delete removes a field together with its values on every computer.
+func (c *fieldController) Delete(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.fieldRepo.Delete(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/fields", http.StatusSeeOther)
+}
+
+// Save stores the details posted from the detail page. Only the values
+// present in the form are changed.
+func (c *fieldController) Save(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	value := func(name string) *string {
+		if v, ok := r.PostForm[name]; ok && len(v) > 0 {
+			return &v[0]
+		}
+		return nil
+	}
+
+	change := DetailChange{
+		Owner:    value("owner"),
+		Location: value("location"),
+		Notes:    value("notes"),
+		Fields:   make(map[string]string),
+	}
+
+	fields, err := c.fieldRepo.List(r.Context())
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	for _, f := range fields {
+		if v := value("field_" + f.Key.String); v != nil {
+			change.Fields[f.Key.String] = *v
+		}
+	}
+
+	c.lock.Lock()
+	found, err := c.links.save(r.Context(), id, change)
+	c.lock.Unlock()
+
+	var invalid *DetailError
+	switch {
+	case errors.As(err, &invalid):
+		renderDetail(w, r, c.log, c.links, id, http.StatusUnprocessableEntity, invalid.Message)
+	case err != nil:
+		c.failed(w, r, err)
+	case !found:
+		http.NotFound(w, r)
+	default:
+		http.Redirect(w, r, fmt.Sprintf("/computers/%d", id), http.StatusSeeOther)
+	}
+}
+
+func (c *fieldController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *fieldController) render(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data := &fieldPageData{
+		Title:   "Custom Fields",
+		Error:   message,
+		Account: AccountFromContext(r.Context()),
+		CSRF:    CSRFFromContext(r.Context()),
+	}
+
+	var err error
+	if data.Fields, err = c.fieldRepo.List(r.Context()); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := fieldPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
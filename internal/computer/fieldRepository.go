@@ -0,0 +1,439 @@
+package computer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Custom field kinds.
+const (
+	FieldText   = "text"
+	FieldNumber = "number"
+	FieldDate   = "date"
+	FieldEnum   = "enum"
+)
+
+// maxFieldValue limits the length of custom field values.
+const maxFieldValue = 4000
+
+var fieldKey = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// Field is an administrator defined property of computers, such as an
+// asset tag or the end of the warranty. Values are maintained by hand and
+// never touched by agent reports.
+type Field struct {
+	ID      null.Int    `db:"id" json:"id"`
+	Created null.String `db:"created" json:"-"`
+	Deleted null.String `db:"deleted" json:"-"`
+
+	// Key names the field in the API, Label on the pages.
+	Key   null.String `db:"key" json:"key"`
+	Label null.String `db:"label" json:"label"`
+	Kind  null.String `db:"kind" json:"kind"`
+
+	// Options are the comma separated choices of an enum field.
+	Options null.String `db:"options" json:"options,omitempty"`
+}
+
+// FieldKey derives a field key from its label, "Warranty End" becomes
+// "warranty_end".
+func FieldKey(label string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(label)) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "_"):
+			b.WriteRune('_')
+		}
+	}
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// Choices returns the options of an enum field.
+func (f Field) Choices() []string {
+	var list []string
+	for _, o := range strings.Split(f.Options.String, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			list = append(list, o)
+		}
+	}
+	return list
+}
+
+// Validate checks the field definition, deriving the key from the label
+// when it is missing.
+func (f *Field) Validate() error {
+	label := strings.TrimSpace(f.Label.String)
+	if label == "" {
+		return errors.New("field label is required")
+	}
+	f.Label.SetValid(label)
+
+	if f.Key.String == "" {
+		f.Key.SetValid(FieldKey(label))
+	}
+	if !fieldKey.MatchString(f.Key.String) {
+		return fmt.Errorf("%q is not a valid field key, use lower case letters, digits and underscores", f.Key.String)
+	}
+
+	switch f.Kind.String {
+	case FieldText, FieldNumber, FieldDate:
+		f.Options = null.String{}
+	case FieldEnum:
+		choices := f.Choices()
+		if len(choices) == 0 {
+			return errors.New("enum fields need at least one option")
+		}
+		f.Options.SetValid(strings.Join(choices, ","))
+	default:
+		return fmt.Errorf("unknown field kind %q", f.Kind.String)
+	}
+	return nil
+}
+
+// Check validates a value of the field and returns it normalised. An empty
+// value clears the field.
+func (f Field) Check(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return "", nil
+	}
+
+	switch f.Kind.String {
+	case FieldText:
+		if len(value) > maxFieldValue {
+			return "", fmt.Errorf("%s is longer than %d characters", f.Label.String, maxFieldValue)
+		}
+	case FieldNumber:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a number", f.Label.String)
+		}
+		value = strconv.FormatFloat(n, 'f', -1, 64)
+	case FieldDate:
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return "", fmt.Errorf("%s must be a date like 2006-01-02", f.Label.String)
+		}
+	case FieldEnum:
+		for _, c := range f.Choices() {
+			if strings.EqualFold(c, value) {
+				return c, nil
+			}
+		}
+		return "", fmt.Errorf("%s must be one of %s", f.Label.String, strings.Join(f.Choices(), ", "))
+	}
+	return value, nil
+}
+
+// FieldValue is a custom field with its value for one computer, Value is
+// null when it has not been set.
+type FieldValue struct {
+	Field
+
+	Value null.String `db:"value" json:"value"`
+}
+
+type FieldRepository interface {
+	Install(context.Context) error
+	Select(context.Context, int) (*Field, error)
+	SelectWithKey(context.Context, string) (*Field, error)
+	Create(context.Context, *Field) (int64, error)
+	Delete(context.Context, int) error
+	List(context.Context) ([]Field, error)
+
+	Values(ctx context.Context, computerID int64) ([]FieldValue, error)
+	SetValue(ctx context.Context, computerID int64, fieldID int64, value string) error
+}
+
+type fieldRepository struct {
+	db *sqlx.DB
+}
+
+func NewFieldRepository(db *sqlx.DB) FieldRepository {
+	return &fieldRepository{
+		db: db,
+	}
+}
+
+func (r *fieldRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE custom_fields (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "deleted" TEXT,
+            "key" TEXT NOT NULL,
+            "label" TEXT NOT NULL,
+            "kind" TEXT NOT NULL,
+            "options" TEXT
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(
+		ctx,
+		`CREATE TABLE computer_field_values (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "updated" TEXT,
+            "computer_id" INTEGER NOT NULL,
+            "field_id" INTEGER NOT NULL,
+            "value" TEXT NOT NULL`+d.ForeignKey("computer_id", "computers")+d.ForeignKey("field_id", "custom_fields")+`
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *fieldRepository) Select(ctx context.Context, id int) (*Field, error) {
+	defer observeQuery("field", "Select", time.Now())
+
+	return r.get(ctx, `id=?`, id)
+}
+
+func (r *fieldRepository) SelectWithKey(ctx context.Context, key string) (*Field, error) {
+	defer observeQuery("field", "SelectWithKey", time.Now())
+
+	return r.get(ctx, `key=?`, key)
+}
+
+func (r *fieldRepository) get(ctx context.Context, where string, arg interface{}) (*Field, error) {
+	data := Field{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            key,
+            label,
+            kind,
+            options
+        FROM custom_fields
+        WHERE `+where+`
+        AND deleted IS NULL`),
+		arg,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *fieldRepository) Create(ctx context.Context, data *Field) (int64, error) {
+	defer observeQuery("field", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO custom_fields (
+            created,
+            key,
+            label,
+            kind,
+            options
+        ) VALUES (?,?,?,?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.Key,
+		data.Label,
+		data.Kind,
+		data.Options,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+// Delete removes the field and its values.
+func (r *fieldRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("field", "Delete", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		tx.Rebind(`UPDATE custom_fields SET
+            deleted=?
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`DELETE FROM computer_field_values WHERE field_id=?`), id)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *fieldRepository) List(ctx context.Context) ([]Field, error) {
+	defer observeQuery("field", "List", time.Now())
+
+	data := []Field{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            key,
+            label,
+            kind,
+            options
+        FROM custom_fields
+        WHERE deleted IS NULL
+        ORDER BY id`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Values returns every field with its value for the computer.
+func (r *fieldRepository) Values(ctx context.Context, computerID int64) ([]FieldValue, error) {
+	defer observeQuery("field", "Values", time.Now())
+
+	data := []FieldValue{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            f.id,
+            f.key,
+            f.label,
+            f.kind,
+            f.options,
+            v.value
+        FROM custom_fields f
+        LEFT JOIN computer_field_values v ON v.field_id = f.id AND v.computer_id = ?
+        WHERE f.deleted IS NULL
+        ORDER BY f.id`),
+		computerID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// SetValue stores the value of a field for a computer, an empty value
+// removes it. The value is expected to have passed Field.Check.
+func (r *fieldRepository) SetValue(ctx context.Context, computerID int64, fieldID int64, value string) error {
+	defer observeQuery("field", "SetValue", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if value == "" {
+		_, err = tx.ExecContext(
+			ctx,
+			tx.Rebind(`DELETE FROM computer_field_values WHERE computer_id=? AND field_id=?`),
+			computerID,
+			fieldID,
+		)
+	} else {
+		now := time.Now().Format("2006-01-02 15:04:05")
+
+		var res sql.Result
+		res, err = tx.ExecContext(
+			ctx,
+			tx.Rebind(`UPDATE computer_field_values SET
+                updated=?,
+                value=?
+            WHERE computer_id=? AND field_id=?`),
+			now,
+			value,
+			computerID,
+			fieldID,
+		)
+
+		var n int64
+		if err == nil {
+			n, err = res.RowsAffected()
+		}
+		if err == nil && n == 0 {
+			_, err = tx.ExecContext(
+				ctx,
+				tx.Rebind(`INSERT INTO computer_field_values (
+                    created,
+                    updated,
+                    computer_id,
+                    field_id,
+                    value
+                ) VALUES (?,?,?,?,?)`),
+				now,
+				now,
+				computerID,
+				fieldID,
+				value,
+			)
+		}
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
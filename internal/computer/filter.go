@@ -0,0 +1,90 @@
+package computer
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PageSize is the number of rows shown per page of the list views.
+const PageSize = 50
+
+// Filter narrows the rows of the list views and their exports.
+type Filter struct {
+	// Search matches part of the computer name, owner, notes, custom
+	// field values, username, MAC or IP address, ignoring case.
+	Search string
+
+	// Days selects computers that have not reported for that many days,
+	// Before is the cut-off derived from it.
+	Days   int
+	Before time.Time
+
+	// Tag selects computers with the tag of that name, Location those
+	// assigned to the location with that id or any location below it.
+	Tag      string
+	Location int64
+
+	// Subnet selects computers with a network adapter in the subnet with
+	// that id.
+	Subnet int64
+
+	// Start and Count select a page, a Count of 0 returns every row.
+	Start int
+	Count int
+}
+
+// FilterFromRequest reads the filter from the query string of r, q for
+// the search, days, tag, location, subnet and page.
+func FilterFromRequest(r *http.Request) Filter {
+	q := r.URL.Query()
+
+	f := Filter{
+		Search: strings.TrimSpace(q.Get("q")),
+		Tag:    strings.TrimSpace(q.Get("tag")),
+		Count:  PageSize,
+	}
+
+	if location, err := strconv.ParseInt(q.Get("location"), 10, 64); err == nil && location > 0 {
+		f.Location = location
+	}
+
+	if subnet, err := strconv.ParseInt(q.Get("subnet"), 10, 64); err == nil && subnet > 0 {
+		f.Subnet = subnet
+	}
+
+	if page, err := strconv.Atoi(q.Get("page")); err == nil && page > 1 {
+		f.Start = (page - 1) * PageSize
+	}
+
+	if days, err := strconv.Atoi(q.Get("days")); err == nil && days > 0 {
+		f.Days = days
+		f.Before = time.Now().Add(-time.Hour * 24 * time.Duration(days))
+	}
+
+	return f
+}
+
+// Page returns the one based page number selected by the filter.
+func (f Filter) Page() int {
+	if f.Count == 0 {
+		return 1
+	}
+	return f.Start/f.Count + 1
+}
+
+// limit returns the LIMIT clause of the filter and its arguments.
+func (f Filter) limit() (string, []interface{}) {
+	if f.Count == 0 {
+		return "", nil
+	}
+	return ` LIMIT ? OFFSET ?`, []interface{}{f.Count, f.Start}
+}
+
+// like returns a LIKE pattern matching s anywhere in a lower cased column,
+// used with ESCAPE '\'.
+func like(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(s))
+	return "%" + s + "%"
+}
@@ -0,0 +1,146 @@
+package computer
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// hubBuffer is the number of messages a subscriber may fall behind
+	// before it is dropped.
+	hubBuffer = 32
+
+	// hubReplay is the number of recent messages kept for subscribers
+	// reconnecting with the ID of the last message they received.
+	hubReplay = 256
+)
+
+// HubMessage is an event published through a Hub, numbered in the order it
+// was published.
+type HubMessage struct {
+	ID    int64
+	Event Event
+	Data  []byte
+}
+
+// Subscription receives the messages published after it was made. C is
+// closed when the subscriber fell more than hubBuffer messages behind, so
+// publishing never waits for slow subscribers.
+type Subscription struct {
+	C <-chan HubMessage
+	c chan HubMessage
+
+	// Seq is the ID of the last message published before the
+	// subscription.
+	Seq int64
+}
+
+// Hub broadcasts the events of the reports received by this process to
+// the subscribed event streams.
+type Hub struct {
+	mu     sync.Mutex
+	seq    int64
+	recent []HubMessage
+	subs   map[*Subscription]struct{}
+}
+
+var (
+	hubsMu sync.Mutex
+	hubs   = make(map[*sqlx.DB]*Hub)
+)
+
+// hubFor returns the hub shared by every controller of db, like writeLock.
+func hubFor(db *sqlx.DB) *Hub {
+	hubsMu.Lock()
+	defer hubsMu.Unlock()
+
+	h, ok := hubs[db]
+	if !ok {
+		h = NewHub()
+		hubs[db] = h
+	}
+	return h
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish numbers the events and hands them to every subscriber. Events
+// that can not be encoded are skipped.
+func (h *Hub) Publish(events ...Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+
+		h.seq++
+		msg := HubMessage{ID: h.seq, Event: event, Data: data}
+
+		h.recent = append(h.recent, msg)
+		if len(h.recent) > hubReplay {
+			h.recent = h.recent[len(h.recent)-hubReplay:]
+		}
+
+		for s := range h.subs {
+			select {
+			case s.c <- msg:
+			default:
+				hubDropped.Inc()
+				delete(h.subs, s)
+				close(s.c)
+			}
+		}
+	}
+}
+
+// Subscribe returns a subscription and the recent messages published after
+// the message lastID, 0 for none. complete is false when messages after
+// lastID are no longer kept.
+func (h *Hub) Subscribe(lastID int64) (s *Subscription, backlog []HubMessage, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := make(chan HubMessage, hubBuffer)
+	s = &Subscription{C: c, c: c, Seq: h.seq}
+	h.subs[s] = struct{}{}
+
+	complete = true
+	if lastID > 0 {
+		if lastID > h.seq || (len(h.recent) > 0 && h.recent[0].ID > lastID+1) || (len(h.recent) == 0 && h.seq > lastID) {
+			complete = false
+		}
+		for _, msg := range h.recent {
+			if msg.ID > lastID {
+				backlog = append(backlog, msg)
+			}
+		}
+	}
+	return s, backlog, complete
+}
+
+// Unsubscribe stops the messages to s.
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// Subscribers returns the number of subscriptions.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
@@ -0,0 +1,467 @@
+package computer
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Import file formats.
+const (
+	ImportCSV  = "csv"
+	ImportJSON = "json"
+)
+
+var ErrImportFormat = errors.New("unsupported import format, expected csv or json")
+
+// ImportRecord is a computer read from an import file.
+type ImportRecord struct {
+	Line     int             `json:"-"`
+	Name     string          `json:"name"`
+	Owner    string          `json:"owner"`
+	Location string          `json:"location"`
+	Adapters []ImportAdapter `json:"adapters"`
+}
+
+type ImportAdapter struct {
+	Name       string `json:"name"`
+	MacAddress string `json:"mac_address"`
+	IPAddress  string `json:"ip_address"`
+}
+
+// ImportError is a problem with the record starting on Line.
+type ImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e ImportError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// ImportErrors holds every problem found in an import file.
+type ImportErrors []ImportError
+
+func (e ImportErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ImportFormat returns the format of the import file called name from its
+// extension, or an empty string when it is not recognised.
+func ImportFormat(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		return ImportCSV
+	case ".json":
+		return ImportJSON
+	}
+	return ""
+}
+
+// ParseImport reads and validates the records of an import file. Problems
+// with the content are returned together as ImportErrors.
+//
+// CSV files need a header row naming their columns: name, owner, location,
+// adapter, mac and ip. Only name is required, a computer with several
+// adapters is listed on one row per adapter. JSON files hold an array of
+// ImportRecord objects, one per computer name.
+func ParseImport(r io.Reader, format string) ([]ImportRecord, error) {
+	var records []ImportRecord
+	var err error
+
+	switch format {
+	case ImportCSV:
+		records, err = parseImportCSV(r)
+	case ImportJSON:
+		records, err = parseImportJSON(r)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrImportFormat, format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if errs := validateImport(records); len(errs) > 0 {
+		return nil, errs
+	}
+	return records, nil
+}
+
+var importColumns = []string{"name", "owner", "location", "adapter", "mac", "ip"}
+
+func parseImportCSV(r io.Reader) ([]ImportRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, ImportErrors{{Line: 1, Message: "missing header row"}}
+	}
+	if err != nil {
+		return nil, ImportErrors{{Line: 1, Message: err.Error()}}
+	}
+
+	columns := make(map[string]int)
+	var errs ImportErrors
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !contains(importColumns, name) {
+			errs = append(errs, ImportError{Line: 1, Message: fmt.Sprintf("unknown column %q, expected %s", name, strings.Join(importColumns, ", "))})
+			continue
+		}
+		columns[name] = i
+	}
+	if _, found := columns["name"]; !found {
+		errs = append(errs, ImportError{Line: 1, Message: "missing name column"})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	var records []ImportRecord
+	index := make(map[string]int)
+
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := cr.FieldPos(0)
+		if err != nil {
+			if pe, ok := err.(*csv.ParseError); ok {
+				line = pe.Line
+			}
+			errs = append(errs, ImportError{Line: line, Message: err.Error()})
+			continue
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		name := field("name")
+		i, found := index[name]
+		if !found || name == "" {
+			records = append(records, ImportRecord{Line: line, Name: name})
+			i = len(records) - 1
+			index[name] = i
+		}
+
+		rec := &records[i]
+		if rec.Owner == "" {
+			rec.Owner = field("owner")
+		}
+		if rec.Location == "" {
+			rec.Location = field("location")
+		}
+
+		if field("mac") != "" || field("ip") != "" || field("adapter") != "" {
+			rec.Adapters = append(rec.Adapters, ImportAdapter{
+				Name:       field("adapter"),
+				MacAddress: field("mac"),
+				IPAddress:  field("ip"),
+			})
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return records, nil
+}
+
+func parseImportJSON(r io.Reader) ([]ImportRecord, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, ImportErrors{{Line: lineAt(data, 0), Message: "expected an array of computers"}}
+	}
+
+	var records []ImportRecord
+	var errs ImportErrors
+	index := make(map[string]int)
+
+	for dec.More() {
+		line := lineAt(data, dec.InputOffset())
+
+		var rec ImportRecord
+		if err := dec.Decode(&rec); err != nil {
+			return nil, ImportErrors{{Line: line, Message: err.Error()}}
+		}
+		rec.Line = line
+		rec.Name = strings.TrimSpace(rec.Name)
+
+		// Unlike CSV rows, entries are never merged, a second entry would
+		// overwrite the first on import.
+		if i, found := index[rec.Name]; found && rec.Name != "" {
+			errs = append(errs, ImportError{Line: line, Message: fmt.Sprintf("entry %d: computer %q is also entry %d on line %d", len(records), rec.Name, i, records[i].Line)})
+		} else {
+			index[rec.Name] = len(records)
+		}
+		records = append(records, rec)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, ImportErrors{{Line: lineAt(data, dec.InputOffset()), Message: err.Error()}}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return records, nil
+}
+
+// lineAt returns the line of the first value at or after offset in data.
+func lineAt(data []byte, offset int64) int {
+	for offset < int64(len(data)) && strings.IndexByte(" \t\r\n,", data[offset]) >= 0 {
+		offset++
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+func validateImport(records []ImportRecord) ImportErrors {
+	var errs ImportErrors
+	macs := make(map[string]int)
+
+	for i := range records {
+		rec := &records[i]
+		fail := func(format string, v ...interface{}) {
+			errs = append(errs, ImportError{Line: rec.Line, Message: fmt.Sprintf(format, v...)})
+		}
+
+		switch {
+		case rec.Name == "":
+			fail("name is required")
+		case len(rec.Name) > 255 || strings.ContainsAny(rec.Name, " \t\r\n"):
+			fail("%q is not a valid computer name", rec.Name)
+		}
+
+		for j := range rec.Adapters {
+			na := &rec.Adapters[j]
+
+			if na.MacAddress == "" {
+				fail("adapter %q has no MAC address", na.Name)
+				continue
+			}
+
+			mac, err := net.ParseMAC(na.MacAddress)
+			if err != nil {
+				fail("%q is not a valid MAC address", na.MacAddress)
+				continue
+			}
+			na.MacAddress = mac.String()
+
+			if line, found := macs[na.MacAddress]; found {
+				fail("MAC address %s is also listed on line %d", na.MacAddress, line)
+			}
+			macs[na.MacAddress] = rec.Line
+
+			for _, ip := range strings.FieldsFunc(na.IPAddress, isIPSeparator) {
+				if len(parseIPs(ip)) == 0 {
+					fail("%q is not a valid IP address", ip)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportResult counts the changes made, or that would have been made on a
+// dry run.
+type ImportResult struct {
+	DryRun   bool `json:"dry_run"`
+	Created  int  `json:"created"`
+	Updated  int  `json:"updated"`
+	Adapters int  `json:"adapters"`
+
+	// Skipped counts the adapters left alone because an agent has
+	// reported the adapters of that computer, agent data takes
+	// precedence.
+	Skipped int `json:"skipped"`
+}
+
+// Importer upserts imported records, marking the rows it creates with
+// SourceImport.
+type Importer struct {
+	db       *sqlx.DB
+	assigner *Assigner
+	lock     sync.Locker
+}
+
+func NewImporter(db *sqlx.DB) *Importer {
+	return &Importer{
+		db:       db,
+		assigner: NewAssigner(db),
+		lock:     writeLock(db),
+	}
+}
+
+// importedComputer is a computer written by an import, its rules are
+// applied once the import is committed.
+type importedComputer struct {
+	id   int64
+	name string
+}
+
+// Import writes records to the database in one transaction, an error
+// leaves the database as it was. With dryRun set nothing is written but
+// the result reports what would have changed.
+func (i *Importer) Import(ctx context.Context, records []ImportRecord, dryRun bool) (*ImportResult, error) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	tx, err := i.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result, computers, err := i.importRecords(ctx, tx, records, dryRun)
+	if err != nil || dryRun {
+		tx.Rollback()
+		return result, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, comp := range computers {
+		if err = i.assigner.Apply(ctx, comp.id, comp.name); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (i *Importer) importRecords(ctx context.Context, tx *sqlx.Tx, records []ImportRecord, dryRun bool) (*ImportResult, []importedComputer, error) {
+	result := &ImportResult{DryRun: dryRun}
+	var computers []importedComputer
+
+	for _, rec := range records {
+		comp, err := selectComputer(ctx, tx, rec.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if comp == nil {
+			result.Created++
+			result.Adapters += len(rec.Adapters)
+			if dryRun {
+				continue
+			}
+
+			id, err := insertComputer(ctx, tx, &Computer{
+				Name:     null.StringFrom(rec.Name),
+				Source:   null.StringFrom(SourceImport),
+				Owner:    null.NewString(rec.Owner, rec.Owner != ""),
+				Location: null.NewString(rec.Location, rec.Location != ""),
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+
+			for _, na := range rec.Adapters {
+				if err = createImportedAdapter(ctx, tx, id, na); err != nil {
+					return nil, nil, err
+				}
+			}
+
+			computers = append(computers, importedComputer{id, rec.Name})
+			continue
+		}
+
+		result.Updated++
+
+		// Owner and location are never reported by agents and are
+		// only replaced when the import provides a value.
+		if rec.Owner != "" {
+			comp.Owner = null.StringFrom(rec.Owner)
+		}
+		if rec.Location != "" {
+			comp.Location = null.StringFrom(rec.Location)
+		}
+		if !dryRun {
+			if err = updateComputerDetails(ctx, tx, comp); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		existing, err := selectAdapters(ctx, tx, int(comp.ID.Int64))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(existing) > 0 && !imported(existing) {
+			result.Skipped += len(rec.Adapters)
+			continue
+		}
+
+		result.Adapters += len(rec.Adapters)
+		if dryRun {
+			continue
+		}
+
+		for _, na := range rec.Adapters {
+			if found := findAdapter(existing, na.MacAddress); found != nil {
+				found.Name = null.NewString(na.Name, na.Name != "")
+				found.IPAddress = null.NewString(na.IPAddress, na.IPAddress != "")
+				found.Source = null.StringFrom(SourceImport)
+				err = updateAdapter(ctx, tx, found)
+			} else {
+				err = createImportedAdapter(ctx, tx, comp.ID.Int64, na)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		computers = append(computers, importedComputer{comp.ID.Int64, comp.Name.String})
+	}
+
+	return result, computers, nil
+}
+
+func createImportedAdapter(ctx context.Context, tx *sqlx.Tx, compID int64, na ImportAdapter) error {
+	_, err := insertAdapter(ctx, tx, &NetworkAdapter{
+		ComputerID: null.IntFrom(compID),
+		Name:       null.NewString(na.Name, na.Name != ""),
+		MacAddress: null.StringFrom(na.MacAddress),
+		IPAddress:  null.NewString(na.IPAddress, na.IPAddress != ""),
+		Source:     null.StringFrom(SourceImport),
+	})
+	return err
+}
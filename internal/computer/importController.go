@@ -0,0 +1,156 @@
+package computer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+)
+
+// maxImportSize limits the size of uploaded import files.
+const maxImportSize = 32 << 20
+
+type importController struct {
+	log      lumber.Logger
+	importer *Importer
+}
+
+type ImportController interface {
+	Form(http.ResponseWriter, *http.Request)
+	Import(http.ResponseWriter, *http.Request)
+}
+
+// NewImportController registers the import page at /computers/import,
+// which takes the admin role.
+func NewImportController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) ImportController {
+	c := &importController{
+		log:      log,
+		importer: NewImporter(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
+
+	// The body is limited before the guard reads the CSRF token of the
+	// form.
+	upload := alice.New(m...).Append(limitBody(maxImportSize), guard(account.RoleAdmin))
+
+	router.Handle("/computers/import", admin.ThenFunc(c.Form)).Methods("GET").Name("import_form")
+	router.Handle("/computers/import", upload.ThenFunc(c.Import)).Methods("POST").Name("import")
+
+	return c
+}
+
+// importPageData is rendered by importPage.
+type importPageData struct {
+	Title   string
+	Result  *ImportResult
+	Errors  ImportErrors
+	Error   string
+	Account *account.Account
+	CSRF    string
+}
+
+func (c *importController) Form(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, http.StatusOK, &importPageData{})
+}
+
+// Import accepts either a multipart form posted by the import page, which
+// is answered with the page, or the file as the request body with the
+// format and dry_run query parameters, which is answered with JSON.
+func (c *importController) Import(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			c.render(w, r, http.StatusBadRequest, &importPageData{Error: err.Error()})
+			return
+		}
+		defer file.Close()
+
+		format := r.FormValue("format")
+		if format == "" {
+			format = ImportFormat(header.Filename)
+		}
+
+		data := &importPageData{}
+		status := http.StatusOK
+
+		records, err := ParseImport(file, format)
+		if err == nil {
+			data.Result, err = c.importer.Import(r.Context(), records, r.FormValue("dry_run") != "")
+		}
+
+		if err != nil {
+			status = c.failed(r, err)
+			if errs, ok := err.(ImportErrors); ok {
+				data.Errors = errs
+			} else {
+				data.Error = err.Error()
+			}
+		}
+
+		c.render(w, r, status, data)
+		return
+	}
+
+	var response struct {
+		Result *ImportResult `json:"result,omitempty"`
+		Errors ImportErrors  `json:"errors,omitempty"`
+		Error  string        `json:"error,omitempty"`
+	}
+
+	status := http.StatusOK
+	records, err := ParseImport(r.Body, r.URL.Query().Get("format"))
+	if err == nil {
+		response.Result, err = c.importer.Import(r.Context(), records, r.URL.Query().Get("dry_run") != "")
+	}
+
+	if err != nil {
+		status = c.failed(r, err)
+		if errs, ok := err.(ImportErrors); ok {
+			response.Errors = errs
+		} else {
+			response.Error = err.Error()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&response)
+}
+
+// failed logs err and returns the status answering it, invalid files are
+// the client's problem.
+func (c *importController) failed(r *http.Request, err error) int {
+	if errs, ok := err.(ImportErrors); ok {
+		requestlog.Set(r.Context(), "import_errors", len(errs))
+		return http.StatusUnprocessableEntity
+	}
+	if errors.Is(err, ErrImportFormat) {
+		return http.StatusBadRequest
+	}
+	requestlog.Error(r.Context(), c.log, err)
+	return http.StatusInternalServerError
+}
+
+func (c *importController) render(w http.ResponseWriter, r *http.Request, status int, data *importPageData) {
+	data.Account = AccountFromContext(r.Context())
+	data.CSRF = CSRFFromContext(r.Context())
+	data.Title = "Import Computers"
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := importPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
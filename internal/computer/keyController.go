@@ -0,0 +1,147 @@
+package computer
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+// maxKeyDays is the longest lifetime that can be given to an API key.
+const maxKeyDays = 3650
+
+type keyController struct {
+	log     lumber.Logger
+	keyRepo account.KeyRepository
+	lock    sync.Locker
+}
+
+type KeyController interface {
+	Page(http.ResponseWriter, *http.Request)
+	Create(http.ResponseWriter, *http.Request)
+	Revoke(http.ResponseWriter, *http.Request)
+}
+
+// NewKeyController registers the page creating and revoking API keys at
+// /computers/keys, which takes the admin role.
+func NewKeyController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) KeyController {
+	c := &keyController{
+		log:     log,
+		keyRepo: account.NewKeyRepository(db),
+		lock:    writeLock(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
+
+	r := router.PathPrefix("/computers").Subrouter()
+	r.Handle("/keys", admin.ThenFunc(c.Page)).Methods("GET").Name("keys")
+	r.Handle("/keys", admin.ThenFunc(c.Create)).Methods("POST").Name("key_create")
+	r.Handle("/keys/{id:[0-9]+}/revoke", admin.ThenFunc(c.Revoke)).Methods("POST").Name("key_revoke")
+
+	return c
+}
+
+// keyPageData is rendered by keyPage.
+type keyPageData struct {
+	Title   string
+	Error   string
+	Key     string
+	Keys    []account.APIKey
+	Scopes  []string
+	Now     string
+	Account *account.Account
+	CSRF    string
+}
+
+func (c *keyController) Page(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, http.StatusOK, &keyPageData{})
+}
+
+// Create adds a key and shows it, the only time it can be seen. The
+// expires form value is the lifetime in days, empty for keys that never
+// expire.
+func (c *keyController) Create(w http.ResponseWriter, r *http.Request) {
+	var expires time.Time
+	if v := r.FormValue("expires"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 || days > maxKeyDays {
+			c.render(w, r, http.StatusUnprocessableEntity, &keyPageData{Error: fmt.Sprintf("Expiry must be between 1 and %d days.", maxKeyDays)})
+			return
+		}
+		expires = time.Now().AddDate(0, 0, days)
+	}
+
+	key, data, err := account.NewAPIKey(r.FormValue("name"), r.FormValue("scope"), expires)
+	if err != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, &keyPageData{Error: err.Error()})
+		return
+	}
+	if acct := AccountFromContext(r.Context()); acct != nil {
+		data.CreatedBy = null.StringFrom(acct.Username.String)
+		data.AccountID = acct.ID
+	}
+
+	c.lock.Lock()
+	_, err = c.keyRepo.Create(r.Context(), data)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	requestlog.Set(r.Context(), "api_key", data.Prefix.String)
+	c.render(w, r, http.StatusCreated, &keyPageData{Key: key})
+}
+
+func (c *keyController) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+	c.lock.Lock()
+	err := c.keyRepo.Revoke(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/keys", http.StatusSeeOther)
+}
+
+func (c *keyController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *keyController) render(w http.ResponseWriter, r *http.Request, status int, data *keyPageData) {
+	data.Title = "API Keys"
+	data.Scopes = account.Scopes()
+	data.Now = time.Now().Format("2006-01-02 15:04:05")
+	data.Account = AccountFromContext(r.Context())
+	data.CSRF = CSRFFromContext(r.Context())
+
+	var err error
+	if data.Keys, err = c.keyRepo.List(r.Context()); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := keyPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
@@ -0,0 +1,332 @@
+package computer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// ErrLocationInUse is returned when deleting a location that still has
+// child locations.
+var ErrLocationInUse = errors.New("location has child locations")
+
+// Location is a site, building, department or any other place computers
+// are assigned to. Locations form a tree through ParentID.
+type Location struct {
+	ID       null.Int    `db:"id" json:"id"`
+	Created  null.String `db:"created" json:"-"`
+	Deleted  null.String `db:"deleted" json:"-"`
+	ParentID null.Int    `db:"parent_id" json:"parent_id"`
+
+	Name null.String `db:"name" json:"name"`
+
+	// Path is the names of the location and its parents, set by
+	// LocationPaths.
+	Path string `db:"-" json:"path,omitempty"`
+
+	// ComputerID and Source are only set by ListForComputers.
+	ComputerID null.Int    `db:"computer_id" json:"-"`
+	Source     null.String `db:"source" json:"source,omitempty"`
+}
+
+type LocationRepository interface {
+	Install(context.Context) error
+	Select(context.Context, int) (*Location, error)
+	Create(context.Context, *Location) (int64, error)
+	Delete(context.Context, int) error
+	List(context.Context) ([]Location, error)
+
+	ListForComputers(context.Context, []int64) (map[int64][]Location, error)
+	Assign(ctx context.Context, computerID int64, locationID int64, source string) error
+	Unassign(ctx context.Context, computerID int64, locationID int64) error
+	ClearRules(ctx context.Context, computerID int64) error
+}
+
+type locationRepository struct {
+	db *sqlx.DB
+}
+
+func NewLocationRepository(db *sqlx.DB) LocationRepository {
+	return &locationRepository{
+		db: db,
+	}
+}
+
+func (r *locationRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE locations (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "deleted" TEXT,
+            "parent_id" INTEGER,
+            "name" TEXT NOT NULL
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(
+		ctx,
+		`CREATE TABLE computer_locations (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "computer_id" INTEGER NOT NULL,
+            "location_id" INTEGER NOT NULL,
+            "source" TEXT NOT NULL`+d.ForeignKey("computer_id", "computers")+d.ForeignKey("location_id", "locations")+`
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *locationRepository) Select(ctx context.Context, id int) (*Location, error) {
+	defer observeQuery("location", "Select", time.Now())
+
+	data := Location{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            parent_id,
+            name
+        FROM locations
+        WHERE id=?
+        AND deleted IS NULL`),
+		id,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *locationRepository) Create(ctx context.Context, data *Location) (int64, error) {
+	defer observeQuery("location", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO locations (
+            created,
+            parent_id,
+            name
+        ) VALUES (?,?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.ParentID,
+		data.Name,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+// Delete removes a location without children and its links to computers.
+func (r *locationRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("location", "Delete", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	var children int
+	err = tx.GetContext(
+		ctx,
+		&children,
+		tx.Rebind(`SELECT COUNT(*) FROM locations WHERE parent_id=? AND deleted IS NULL`),
+		id,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if children > 0 {
+		tx.Rollback()
+		return ErrLocationInUse
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		tx.Rebind(`UPDATE locations SET
+            deleted=?
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`DELETE FROM computer_locations WHERE location_id=?`), id)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// List returns every location with its Path set, ordered by path.
+func (r *locationRepository) List(ctx context.Context) ([]Location, error) {
+	defer observeQuery("location", "List", time.Now())
+
+	data := []Location{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            parent_id,
+            name
+        FROM locations
+        WHERE deleted IS NULL`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return LocationPaths(data), nil
+}
+
+// ListForComputers returns the locations of each of the given computers.
+// Their Path is not set.
+func (r *locationRepository) ListForComputers(ctx context.Context, ids []int64) (map[int64][]Location, error) {
+	defer observeQuery("location", "ListForComputers", time.Now())
+
+	result := make(map[int64][]Location)
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	query, args, err := sqlx.In(`SELECT
+            l.id,
+            l.parent_id,
+            l.name,
+            cl.computer_id,
+            cl.source
+        FROM computer_locations cl
+        JOIN locations l ON l.id = cl.location_id
+        WHERE cl.computer_id IN (?)
+        AND l.deleted IS NULL
+        ORDER BY l.name`, ids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data := []Location{}
+	if err = r.db.SelectContext(ctx, &data, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	for _, l := range data {
+		result[l.ComputerID.Int64] = append(result[l.ComputerID.Int64], l)
+	}
+	return result, nil
+}
+
+// Assign links a location to a computer, see TagRepository.Assign.
+func (r *locationRepository) Assign(ctx context.Context, computerID int64, locationID int64, source string) error {
+	defer observeQuery("location", "Assign", time.Now())
+
+	return assignLink(ctx, r.db, "computer_locations", "location_id", computerID, locationID, source)
+}
+
+func (r *locationRepository) Unassign(ctx context.Context, computerID int64, locationID int64) error {
+	defer observeQuery("location", "Unassign", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`DELETE FROM computer_locations WHERE computer_id=? AND location_id=?`),
+		computerID,
+		locationID,
+	)
+	return err
+}
+
+// ClearRules removes the locations of a computer that were not assigned
+// manually.
+func (r *locationRepository) ClearRules(ctx context.Context, computerID int64) error {
+	defer observeQuery("location", "ClearRules", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`DELETE FROM computer_locations WHERE computer_id=? AND source<>?`),
+		computerID,
+		LinkManual,
+	)
+	return err
+}
+
+// LocationPaths sets the Path of every location, e.g. "Site A / Building
+// 2", and sorts them by it so children follow their parent.
+func LocationPaths(list []Location) []Location {
+	byID := make(map[int64]*Location, len(list))
+	for i := range list {
+		byID[list[i].ID.Int64] = &list[i]
+	}
+
+	for i := range list {
+		names := []string{}
+		seen := make(map[int64]bool)
+		for l := &list[i]; l != nil && !seen[l.ID.Int64]; {
+			seen[l.ID.Int64] = true
+			names = append([]string{l.Name.String}, names...)
+			if !l.ParentID.Valid {
+				break
+			}
+			l = byID[l.ParentID.Int64]
+		}
+		list[i].Path = strings.Join(names, " / ")
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToLower(list[i].Path) < strings.ToLower(list[j].Path)
+	})
+	return list
+}
@@ -0,0 +1,111 @@
+package computer
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+)
+
+type loginController struct {
+	log    lumber.Logger
+	access *Access
+}
+
+type LoginController interface {
+	Form(http.ResponseWriter, *http.Request)
+	Login(http.ResponseWriter, *http.Request)
+	Logout(http.ResponseWriter, *http.Request)
+}
+
+// NewLoginController registers the login page at /login and /logout ending
+// the session.
+func NewLoginController(access *Access, log lumber.Logger, router *mux.Router, middleware ...alice.Constructor) LoginController {
+	c := &loginController{
+		log:    log,
+		access: access,
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+
+	router.Handle("/login", alice.New(m...).ThenFunc(c.Form)).Methods("GET").Name("login_form")
+	router.Handle("/login", alice.New(m...).ThenFunc(c.Login)).Methods("POST").Name("login")
+	router.Handle("/logout", alice.New(m...).ThenFunc(c.Logout)).Methods("POST").Name("logout")
+
+	return c
+}
+
+// loginPageData is rendered by loginPage.
+type loginPageData struct {
+	Username string
+	Next     string
+	Error    string
+}
+
+func (c *loginController) Form(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, http.StatusOK, &loginPageData{Next: nextPage(r)})
+}
+
+func (c *loginController) Login(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	username := r.FormValue("username")
+
+	acct, err := c.access.accounts.Authenticate(r.Context(), username, r.FormValue("password"))
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	requestlog.Set(r.Context(), "account", username)
+	if acct == nil {
+		c.render(w, r, http.StatusUnauthorized, &loginPageData{
+			Username: username,
+			Next:     nextPage(r),
+			Error:    "Wrong username or password.",
+		})
+		return
+	}
+
+	if err = c.access.signIn(w, r, acct); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, nextPage(r), http.StatusSeeOther)
+}
+
+func (c *loginController) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := c.access.signOut(w, r); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// nextPage returns the page to show after signing in, only paths on this
+// server are followed.
+func nextPage(r *http.Request) string {
+	next := r.FormValue("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, `/\`) {
+		return "/computers/dashboard"
+	}
+	return next
+}
+
+func (c *loginController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *loginController) render(w http.ResponseWriter, r *http.Request, status int, data *loginPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := loginPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
@@ -0,0 +1,42 @@
+package computer
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Merger folds duplicate computer records into a surviving one, see
+// MergeRepository.Merge.
+type Merger struct {
+	mergeRepo MergeRepository
+	assigner  *Assigner
+	lock      sync.Locker
+}
+
+func NewMerger(db *sqlx.DB) *Merger {
+	return &Merger{
+		mergeRepo: NewMergeRepository(db),
+		assigner:  NewAssigner(db),
+		lock:      writeLock(db),
+	}
+}
+
+// Merge merges the duplicate into the survivor and recalculates the links
+// of the survivor, whose network adapters may have changed. It returns nil
+// when either computer does not exist.
+func (m *Merger) Merge(ctx context.Context, survivorID int64, duplicateID int64) (*Merge, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	merge, err := m.mergeRepo.Merge(ctx, survivorID, duplicateID)
+	if err != nil || merge == nil {
+		return nil, err
+	}
+
+	if err = m.assigner.Apply(ctx, survivorID, merge.ComputerName.String); err != nil {
+		return nil, err
+	}
+	return merge, nil
+}
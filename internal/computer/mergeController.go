@@ -0,0 +1,116 @@
+package computer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+)
+
+type mergeController struct {
+	log       lumber.Logger
+	mergeRepo MergeRepository
+	merger    *Merger
+}
+
+type MergeController interface {
+	Page(http.ResponseWriter, *http.Request)
+	Merge(http.ResponseWriter, *http.Request)
+}
+
+// NewMergeController registers the possible duplicates report at
+// /computers/duplicates and the form merging a duplicate into a computer.
+// Merges take the admin role.
+func NewMergeController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) MergeController {
+	c := &mergeController{
+		log:       log,
+		mergeRepo: NewMergeRepository(db),
+		merger:    NewMerger(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+
+	r := router.PathPrefix("/computers").Subrouter()
+	r.Handle("/duplicates", alice.New(m...).Append(guard(account.RoleViewer)).ThenFunc(c.Page)).Methods("GET").Name("duplicates")
+	r.Handle("/{id:[0-9]+}/merge", alice.New(m...).Append(guard(account.RoleAdmin)).ThenFunc(c.Merge)).Methods("POST").Name("merge")
+
+	return c
+}
+
+// mergePageData is rendered by mergePage.
+type mergePageData struct {
+	Title      string
+	Error      string
+	Duplicates []Duplicate
+	Merges     []Merge
+	Account    *account.Account
+	CSRF       string
+}
+
+func (c *mergeController) Page(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, http.StatusOK, "")
+}
+
+// Merge merges the computer posted as duplicate into the one in the path
+// and shows the survivor.
+func (c *mergeController) Merge(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+
+	duplicate, err := strconv.ParseInt(r.FormValue("duplicate"), 10, 64)
+	if err != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, "Choose the computer to merge.")
+		return
+	}
+
+	merge, err := c.merger.Merge(r.Context(), id, duplicate)
+	switch {
+	case errors.Is(err, ErrMergeSelf):
+		c.render(w, r, http.StatusUnprocessableEntity, "A computer cannot be merged into itself.")
+	case err != nil:
+		c.failed(w, r, err)
+	case merge == nil:
+		http.NotFound(w, r)
+	default:
+		requestlog.Set(r.Context(), "merged", merge.MergedName.String)
+		http.Redirect(w, r, fmt.Sprintf("/computers/%d", id), http.StatusSeeOther)
+	}
+}
+
+func (c *mergeController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *mergeController) render(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data := &mergePageData{
+		Title:   "Possible Duplicates",
+		Error:   message,
+		Account: AccountFromContext(r.Context()),
+		CSRF:    CSRFFromContext(r.Context()),
+	}
+
+	var err error
+	if data.Duplicates, err = c.mergeRepo.Duplicates(r.Context()); err == nil {
+		data.Merges, err = c.mergeRepo.List(r.Context())
+	}
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := mergePage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
@@ -0,0 +1,505 @@
+package computer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// ErrMergeSelf is returned when a computer is merged into itself.
+var ErrMergeSelf = errors.New("a computer cannot be merged into itself")
+
+// Merge records a duplicate computer merged into the surviving one.
+type Merge struct {
+	ID         null.Int    `db:"id" json:"id"`
+	Created    null.String `db:"created" json:"created"`
+	ComputerID null.Int    `db:"computer_id" json:"computer_id"`
+	MergedID   null.Int    `db:"merged_id" json:"merged_id"`
+	MergedName null.String `db:"merged_name" json:"merged_name"`
+
+	// Users and Adapters count the rows moved to the surviving computer.
+	Users    int `db:"users" json:"users"`
+	Adapters int `db:"adapters" json:"adapters"`
+
+	// ComputerName is set by List.
+	ComputerName null.String `db:"computer_name" json:"computer_name,omitempty"`
+}
+
+// DuplicateComputer is one side of a Duplicate.
+type DuplicateComputer struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	LastSeen string `json:"last_seen"`
+}
+
+// Duplicate is a pair of computers that are probably the same machine,
+// Reasons says why.
+type Duplicate struct {
+	First   DuplicateComputer `json:"first"`
+	Second  DuplicateComputer `json:"second"`
+	Reasons []string          `json:"reasons"`
+}
+
+type MergeRepository interface {
+	Install(context.Context) error
+	Merge(ctx context.Context, survivorID int64, duplicateID int64) (*Merge, error)
+	List(context.Context) ([]Merge, error)
+	ListForComputer(ctx context.Context, computerID int64) ([]Merge, error)
+	Duplicates(context.Context) ([]Duplicate, error)
+}
+
+type mergeRepository struct {
+	db *sqlx.DB
+}
+
+func NewMergeRepository(db *sqlx.DB) MergeRepository {
+	return &mergeRepository{
+		db: db,
+	}
+}
+
+func (r *mergeRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE computer_merges (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "computer_id" INTEGER NOT NULL,
+            "merged_id" INTEGER NOT NULL,
+            "merged_name" TEXT,
+            "users" INTEGER NOT NULL,
+            "adapters" INTEGER NOT NULL`+d.ForeignKey("computer_id", "computers")+d.ForeignKey("merged_id", "computers")+`
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Merge moves users, network adapters, tags, locations and custom field
+// values of the duplicate to the survivor and deletes the duplicate. The
+// survivor keeps its own links, values and details where both have one.
+// Adapters whose MAC address the survivor already has are deleted. It
+// returns nil when either computer does not exist. The caller is expected
+// to hold the write lock.
+func (r *mergeRepository) Merge(ctx context.Context, survivorID int64, duplicateID int64) (*Merge, error) {
+	defer observeQuery("merge", "Merge", time.Now())
+
+	if survivorID == duplicateID {
+		return nil, ErrMergeSelf
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	survivor, err := mergeComputer(ctx, tx, survivorID)
+	if err != nil || survivor == nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	duplicate, err := mergeComputer(ctx, tx, duplicateID)
+	if err != nil || duplicate == nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	merge := &Merge{
+		Created:    null.StringFrom(now),
+		ComputerID: survivor.ID,
+		MergedID:   duplicate.ID,
+		MergedName: duplicate.Name,
+	}
+
+	if merge.Users, err = mergeExec(ctx, tx, `UPDATE computer_users SET
+            computer_id=?
+        WHERE computer_id=?`,
+		survivorID, duplicateID,
+	); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err = mergeExec(ctx, tx, `UPDATE computer_network_adapters SET
+            deleted=?
+        WHERE computer_id=?
+        AND deleted IS NULL
+        AND LOWER(mac_address) IN (SELECT LOWER(mac_address)
+            FROM computer_network_adapters
+            WHERE computer_id=?
+            AND deleted IS NULL)`,
+		now, duplicateID, survivorID,
+	); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if merge.Adapters, err = mergeExec(ctx, tx, `UPDATE computer_network_adapters SET
+            computer_id=?
+        WHERE computer_id=?
+        AND deleted IS NULL`,
+		survivorID, duplicateID,
+	); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Deleted adapters follow too so nothing refers to the duplicate.
+	if _, err = mergeExec(ctx, tx, `UPDATE computer_network_adapters SET
+            computer_id=?
+        WHERE computer_id=?`,
+		survivorID, duplicateID,
+	); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err = mergeExec(ctx, tx, `UPDATE checkins SET
+            computer_id=?
+        WHERE computer_id=?`,
+		survivorID, duplicateID,
+	); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err = mergeExec(ctx, tx, `INSERT INTO checkin_rollups (
+            period,
+            start,
+            computer_id,
+            checkins,
+            payload_bytes,
+            duration_ms,
+            max_duration_ms
+        )
+        SELECT
+            period,
+            start,
+            ?,
+            checkins,
+            payload_bytes,
+            duration_ms,
+            max_duration_ms
+        FROM checkin_rollups
+        WHERE computer_id=?
+        `+rollupUpsert,
+		survivorID, duplicateID,
+	); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err = mergeExec(ctx, tx, `DELETE FROM checkin_rollups WHERE computer_id=?`, duplicateID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, link := range []struct{ table, column string }{
+		{"computer_tags", "tag_id"},
+		{"computer_locations", "location_id"},
+		{"computer_field_values", "field_id"},
+	} {
+		if _, err = mergeExec(ctx, tx, `DELETE FROM `+link.table+`
+            WHERE computer_id=?
+            AND `+link.column+` IN (SELECT `+link.column+`
+                FROM `+link.table+`
+                WHERE computer_id=?)`,
+			duplicateID, survivorID,
+		); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		if _, err = mergeExec(ctx, tx, `UPDATE `+link.table+` SET
+                computer_id=?
+            WHERE computer_id=?`,
+			survivorID, duplicateID,
+		); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	// The survivor is known since the earlier of both records were created
+	// and seen when either was last seen.
+	created, updated := survivor.Created, survivor.Updated
+	if duplicate.Created.Valid && (!created.Valid || duplicate.Created.String < created.String) {
+		created = duplicate.Created
+	}
+	if duplicate.Updated.Valid && (!updated.Valid || duplicate.Updated.String > updated.String) {
+		updated = duplicate.Updated
+	}
+
+	if _, err = mergeExec(ctx, tx, `UPDATE computers SET
+            created=?,
+            updated=?,
+            owner=?,
+            location=?,
+            notes=?
+        WHERE id=?`,
+		created,
+		updated,
+		mergeDetail(survivor.Owner, duplicate.Owner),
+		mergeDetail(survivor.Location, duplicate.Location),
+		mergeDetail(survivor.Notes, duplicate.Notes),
+		survivorID,
+	); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Earlier merges into the duplicate now point at the survivor, so
+	// following MergedInto never takes more than one step.
+	if _, err = mergeExec(ctx, tx, `UPDATE computers SET
+            deleted=?,
+            merged_into=?
+        WHERE id=?
+        OR merged_into=?`,
+		now, survivorID, duplicateID, duplicateID,
+	); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO computer_merges (
+            created,
+            computer_id,
+            merged_id,
+            merged_name,
+            users,
+            adapters
+        ) VALUES (?,?,?,?,?,?)`,
+		merge.Created,
+		merge.ComputerID,
+		merge.MergedID,
+		merge.MergedName,
+		merge.Users,
+		merge.Adapters,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	merge.ID = null.IntFrom(id)
+	merge.ComputerName = survivor.Name
+	return merge, nil
+}
+
+// mergeComputer returns the live computer with the given id, nil when it
+// does not exist.
+func mergeComputer(ctx context.Context, tx *sqlx.Tx, id int64) (*Computer, error) {
+	data := Computer{}
+
+	err := tx.GetContext(
+		ctx,
+		&data,
+		tx.Rebind(`SELECT
+            id,
+            created,
+            updated,
+            deleted,
+            name,
+            source,
+            owner,
+            location,
+            notes,
+            merged_into
+        FROM computers
+        WHERE id=?
+        AND deleted IS NULL`),
+		id,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// mergeExec runs query in tx and returns the number of rows changed.
+func mergeExec(ctx context.Context, tx *sqlx.Tx, query string, args ...interface{}) (int, error) {
+	res, err := tx.ExecContext(ctx, tx.Rebind(query), args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// mergeDetail keeps the survivor's value unless it is empty.
+func mergeDetail(survivor, duplicate null.String) null.String {
+	if survivor.String != "" {
+		return survivor
+	}
+	return duplicate
+}
+
+// List returns the most recent merges, newest first.
+func (r *mergeRepository) List(ctx context.Context) ([]Merge, error) {
+	defer observeQuery("merge", "List", time.Now())
+
+	return r.list(ctx, ``)
+}
+
+// ListForComputer returns the computers merged into the given one, newest
+// first.
+func (r *mergeRepository) ListForComputer(ctx context.Context, computerID int64) ([]Merge, error) {
+	defer observeQuery("merge", "ListForComputer", time.Now())
+
+	return r.list(ctx, `WHERE m.computer_id=?`, computerID)
+}
+
+func (r *mergeRepository) list(ctx context.Context, where string, args ...interface{}) ([]Merge, error) {
+	data := []Merge{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            m.id,
+            m.created,
+            m.computer_id,
+            m.merged_id,
+            m.merged_name,
+            m.users,
+            m.adapters,
+            c.name AS computer_name
+        FROM computer_merges m
+        JOIN computers c ON c.id = m.computer_id
+        `+where+`
+        ORDER BY m.id DESC
+        LIMIT 100`),
+		args...,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// duplicateRow is a pair of computers found by one of the Duplicates
+// queries, MAC is empty for a name match.
+type duplicateRow struct {
+	FirstID    int64          `db:"first_id"`
+	FirstName  sql.NullString `db:"first_name"`
+	FirstSeen  sql.NullString `db:"first_seen"`
+	SecondID   int64          `db:"second_id"`
+	SecondName sql.NullString `db:"second_name"`
+	SecondSeen sql.NullString `db:"second_seen"`
+	MAC        sql.NullString `db:"mac"`
+}
+
+// Duplicates returns the pairs of live computers whose names differ only
+// in case or which share the MAC address of a network adapter.
+func (r *mergeRepository) Duplicates(ctx context.Context) ([]Duplicate, error) {
+	defer observeQuery("merge", "Duplicates", time.Now())
+
+	rows := []duplicateRow{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&rows,
+		r.db.Rebind(`SELECT
+            a.id AS first_id,
+            a.name AS first_name,
+            COALESCE(a.updated, a.created) AS first_seen,
+            b.id AS second_id,
+            b.name AS second_name,
+            COALESCE(b.updated, b.created) AS second_seen,
+            '' AS mac
+        FROM computers a
+        JOIN computers b ON LOWER(b.name) = LOWER(a.name) AND b.id > a.id
+        WHERE a.deleted IS NULL
+        AND b.deleted IS NULL
+        UNION
+        SELECT
+            a.id,
+            a.name,
+            COALESCE(a.updated, a.created),
+            b.id,
+            b.name,
+            COALESCE(b.updated, b.created),
+            LOWER(na.mac_address)
+        FROM computer_network_adapters na
+        JOIN computer_network_adapters nb ON LOWER(nb.mac_address) = LOWER(na.mac_address) AND nb.computer_id > na.computer_id
+        JOIN computers a ON a.id = na.computer_id
+        JOIN computers b ON b.id = nb.computer_id
+        WHERE na.deleted IS NULL
+        AND nb.deleted IS NULL
+        AND na.mac_address <> ''
+        AND a.deleted IS NULL
+        AND b.deleted IS NULL`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	type pair struct{ first, second int64 }
+	found := make(map[pair]*Duplicate)
+	list := []Duplicate{}
+	for _, row := range rows {
+		key := pair{row.FirstID, row.SecondID}
+		d, ok := found[key]
+		if !ok {
+			d = &Duplicate{
+				First:  DuplicateComputer{ID: row.FirstID, Name: row.FirstName.String, LastSeen: row.FirstSeen.String},
+				Second: DuplicateComputer{ID: row.SecondID, Name: row.SecondName.String, LastSeen: row.SecondSeen.String},
+			}
+			found[key] = d
+		}
+		if row.MAC.String == "" {
+			d.Reasons = append(d.Reasons, "same name")
+		} else {
+			d.Reasons = append(d.Reasons, "shared MAC "+row.MAC.String)
+		}
+	}
+
+	for _, d := range found {
+		sort.Strings(d.Reasons)
+		list = append(list, *d)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		a, b := strings.ToLower(list[i].First.Name), strings.ToLower(list[j].First.Name)
+		if a != b {
+			return a < b
+		}
+		if list[i].First.ID != list[j].First.ID {
+			return list[i].First.ID < list[j].First.ID
+		}
+		return list[i].Second.ID < list[j].Second.ID
+	})
+	return list, nil
+}
@@ -0,0 +1,90 @@
+package computer
+
+import (
+	"context"
+	"time"
+
+	"github.com/stockholmr/fpsmonitor/internal/metrics"
+)
+
+// StaleAfter is the period after which a computer that has not reported is
+// counted as stale.
+const StaleAfter = time.Hour * 24
+
+var (
+	ingestTotal = metrics.NewCounterVec(
+		"fpsmonitor_ingest_total",
+		"Number of computer reports received by result and error class.",
+		"result", "class",
+	)
+
+	alertsTotal = metrics.NewCounterVec(
+		"fpsmonitor_alerts_total",
+		"Number of alerts raised by kind and severity.",
+		"kind", "severity",
+	)
+
+	hubDropped = metrics.NewCounterVec(
+		"fpsmonitor_event_stream_dropped_total",
+		"Number of event stream subscribers dropped for falling behind.",
+	)
+
+	webhookDeliveries = metrics.NewCounterVec(
+		"fpsmonitor_webhook_deliveries_total",
+		"Number of webhook delivery attempts by result.",
+		"result",
+	)
+
+	queryDuration = metrics.NewHistogramVec(
+		"fpsmonitor_db_query_duration_seconds",
+		"Database query durations by repository and method.",
+		[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		"repository", "method",
+	)
+
+	inventoryComputers = metrics.NewGaugeVec(
+		"fpsmonitor_inventory_computers",
+		"Number of known computers by state.",
+		"state",
+	)
+
+	inventoryAdapters = metrics.NewGaugeVec(
+		"fpsmonitor_inventory_network_adapters",
+		"Number of known network adapters.",
+	)
+)
+
+// Error classes used to label failed reports.
+const (
+	ingestErrRead     = "read"
+	ingestErrDecode   = "decode"
+	ingestErrDatabase = "database"
+	ingestErrIdentity = "identity"
+)
+
+func observeQuery(repository string, method string, start time.Time) {
+	queryDuration.Since(start, repository, method)
+}
+
+func (c *computerController) collectInventory(ctx context.Context) error {
+	total, err := c.computerRepo.Count(ctx)
+	if err != nil {
+		return err
+	}
+
+	active, err := c.computerRepo.CountActive(ctx, time.Now().Add(-StaleAfter))
+	if err != nil {
+		return err
+	}
+
+	adapters, err := c.networkAdapterRepo.Count(ctx)
+	if err != nil {
+		return err
+	}
+
+	inventoryComputers.Set(float64(total), "total")
+	inventoryComputers.Set(float64(active), "active")
+	inventoryComputers.Set(float64(total-active), "stale")
+	inventoryAdapters.Set(float64(adapters))
+	return nil
+}
@@ -1,17 +1,276 @@
 package computer
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/sessions"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
 )
 
 type Key string
 
 const UserKey Key = "user"
 const SessionKey Key = "session"
+const CSRFKey Key = "csrf"
+
+// Requests changing something with a session cookie carry the CSRF token
+// of the session in csrfField of the form or in csrfHeader.
+const (
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// sessionName names the cookie holding the session of a signed in account.
+const sessionName = "fpsmonitor"
 
 func (c *computerController) LoggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		c.log.Trace("%s|%s|%s", r.Method, r.RequestURI, r.RemoteAddr)
-		next.ServeHTTP(w, r)
-	})
+	return requestlog.Middleware(c.log)(next)
+}
+
+// Guard returns middleware admitting only requests made by an account
+// holding at least role, see account.Account.Allows.
+type Guard func(role string) alice.Constructor
+
+// Access authenticates requests from the session cookie set by the login
+// page or, for scripts, the admin token or an API key as an
+// "Authorization: Bearer" header. Its Require method is the Guard of the
+// controllers.
+type Access struct {
+	log      lumber.Logger
+	store    sessions.Store
+	accounts account.Repository
+	keys     account.KeyRepository
+	token    string
+}
+
+// NewAccess returns the Access of accounts in db. An empty adminToken
+// disables token access.
+func NewAccess(db *sqlx.DB, log lumber.Logger, store sessions.Store, adminToken string) *Access {
+	return &Access{
+		log:      log,
+		store:    store,
+		accounts: account.NewRepository(db),
+		keys:     account.NewKeyRepository(db),
+		token:    adminToken,
+	}
+}
+
+// tokenAccount is the account of requests made with the admin token.
+var tokenAccount = &account.Account{
+	Username: null.StringFrom("admin token"),
+	Role:     null.StringFrom(account.RoleAdmin),
+}
+
+// errKeyScope is returned by authenticate for API keys used outside of
+// their scope.
+var errKeyScope = errors.New("the API key does not cover this request")
+
+// Require is a Guard. Requests without an account are sent to the login
+// page when they are page views from a browser and answered with 401
+// otherwise, accounts lacking the role and API keys lacking the scope get
+// a 403, as do requests changing something with a session cookie but
+// without its CSRF token. Admitted requests carry the account under
+// UserKey, the session under SessionKey and its CSRF token under CSRFKey.
+func (a *Access) Require(role string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			acct, session, err := a.authenticate(r)
+			if errors.Is(err, errKeyScope) {
+				denied(w, r, http.StatusForbidden, err.Error())
+				return
+			}
+			if err != nil {
+				requestlog.Error(r.Context(), a.log, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			if acct == nil {
+				if r.Method == "GET" && !isAPI(r) && r.Header.Get("Authorization") == "" {
+					http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer realm="fpsmonitor"`)
+				denied(w, r, http.StatusUnauthorized, "sign in required")
+				return
+			}
+
+			requestlog.Set(r.Context(), "account", acct.Username.String)
+			if !acct.Allows(role) {
+				denied(w, r, http.StatusForbidden, "the "+role+" role is required")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserKey, acct)
+			ctx = context.WithValue(ctx, SessionKey, session)
+
+			// Tokens and API keys are sent by scripts, not by browsers.
+			if session != nil {
+				token, err := a.csrfToken(w, r, session)
+				if err != nil {
+					requestlog.Error(r.Context(), a.log, err)
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				if !safeMethod(r) && !validCSRF(r, token) {
+					denied(w, r, http.StatusForbidden, "the form has expired, reload the page and try again")
+					return
+				}
+				ctx = context.WithValue(ctx, CSRFKey, token)
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authenticate returns the account making the request, nil when there is
+// none. API keys are only accepted by the JSON API and the exports.
+func (a *Access) authenticate(r *http.Request) (*account.Account, *sessions.Session, error) {
+	if given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "); given != "" {
+		if a.token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(a.token)) == 1 {
+			return tokenAccount, nil, nil
+		}
+		if !isAPI(r) && !isExport(r) {
+			return nil, nil, nil
+		}
+
+		key, err := a.keys.Authenticate(r.Context(), given)
+		if err != nil || key == nil {
+			return nil, nil, err
+		}
+
+		requestlog.Set(r.Context(), "api_key", key.Prefix.String)
+		if !keyPermits(key, r) {
+			return nil, nil, errKeyScope
+		}
+		return key.Account(), nil, nil
+	}
+
+	// A cookie that no longer decodes, say after the session key changed,
+	// is treated as no session at all.
+	session, _ := a.store.Get(r, sessionName)
+
+	id, ok := session.Values["account"].(int64)
+	if !ok {
+		return nil, session, nil
+	}
+
+	acct, err := a.accounts.Select(r.Context(), id)
+	return acct, session, err
+}
+
+// signIn starts a session for acct with a new CSRF token.
+func (a *Access) signIn(w http.ResponseWriter, r *http.Request, acct *account.Account) error {
+	session, _ := a.store.Get(r, sessionName)
+	session.Values["account"] = acct.ID.Int64
+	session.Values["csrf"] = newCSRFToken()
+	return session.Save(r, w)
+}
+
+// csrfToken returns the CSRF token of session, adding one to sessions
+// started before tokens were issued.
+func (a *Access) csrfToken(w http.ResponseWriter, r *http.Request, session *sessions.Session) (string, error) {
+	if token, ok := session.Values["csrf"].(string); ok && token != "" {
+		return token, nil
+	}
+	token := newCSRFToken()
+	session.Values["csrf"] = token
+	return token, session.Save(r, w)
+}
+
+func newCSRFToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// validCSRF reports whether r carries token.
+func validCSRF(r *http.Request, token string) bool {
+	given := r.Header.Get(csrfHeader)
+	if given == "" {
+		given = r.FormValue(csrfField)
+	}
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// limitBody returns middleware limiting request bodies to n bytes.
+func limitBody(n int64) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func safeMethod(r *http.Request) bool {
+	return r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS"
+}
+
+// signOut ends the session of the request.
+func (a *Access) signOut(w http.ResponseWriter, r *http.Request) error {
+	session, _ := a.store.Get(r, sessionName)
+	delete(session.Values, "account")
+	session.Options.MaxAge = -1
+	return session.Save(r, w)
+}
+
+// AccountFromContext returns the account admitted by Access.Require, nil
+// outside of guarded routes.
+func AccountFromContext(ctx context.Context) *account.Account {
+	acct, _ := ctx.Value(UserKey).(*account.Account)
+	return acct
+}
+
+// CSRFFromContext returns the CSRF token forms posted with the session
+// admitted by Access.Require have to carry, empty without a session.
+func CSRFFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(CSRFKey).(string)
+	return token
+}
+
+// keyPermits reports whether the scope of key covers the request. Read
+// keys may only read the API, downloading exports takes the export scope
+// and changes the admin scope.
+func keyPermits(key *account.APIKey, r *http.Request) bool {
+	switch {
+	case isExport(r):
+		return key.Allows(account.ScopeExport)
+	case r.Method == "GET" || r.Method == "HEAD":
+		return key.Allows(account.ScopeRead)
+	default:
+		return key.Allows(account.ScopeAdmin)
+	}
+}
+
+func isAPI(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/")
+}
+
+func isExport(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/computers/export/")
+}
+
+func denied(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if isAPI(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+		return
+	}
+	http.Error(w, http.StatusText(status)+": "+message, status)
 }
@@ -0,0 +1,385 @@
+package computer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+)
+
+// Migration upgrades the database schema to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(context.Context, *sqlx.DB) error
+}
+
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "computers, users and network adapters",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			if err := NewComputerRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			if err := NewNetworkAdapterRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			if err := NewUserRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			if err := database.AddForeignKey(ctx, db, "computer_network_adapters", "computer_id", "computers"); err != nil {
+				return err
+			}
+			return database.AddForeignKey(ctx, db, "computer_users", "computer_id", "computers")
+		},
+	},
+	{
+		Version:     2,
+		Description: "record source, owner and location",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			for _, c := range []struct{ table, column string }{
+				{"computers", "source"},
+				{"computers", "owner"},
+				{"computers", "location"},
+				{"computer_network_adapters", "source"},
+			} {
+				if err := database.AddColumn(ctx, db, c.table, c.column, "TEXT"); err != nil {
+					return err
+				}
+			}
+
+			for _, table := range []string{"computers", "computer_network_adapters"} {
+				_, err := db.ExecContext(ctx, db.Rebind(`UPDATE `+table+` SET source=? WHERE source IS NULL`), SourceAgent)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     3,
+		Description: "tags, locations and assignment rules",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			if err := NewTagRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			if err := NewLocationRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			if err := NewRuleRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			for _, fk := range []struct{ table, column, references string }{
+				{"computer_tags", "computer_id", "computers"},
+				{"computer_tags", "tag_id", "tags"},
+				{"computer_locations", "computer_id", "computers"},
+				{"computer_locations", "location_id", "locations"},
+			} {
+				if err := database.AddForeignKey(ctx, db, fk.table, fk.column, fk.references); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     4,
+		Description: "subnets",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			if err := NewSubnetRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			return database.AddColumn(ctx, db, "computer_network_adapters", "subnet_id", "INTEGER")
+		},
+	},
+	{
+		Version:     5,
+		Description: "notes and custom fields",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			if err := database.AddColumn(ctx, db, "computers", "notes", "TEXT"); err != nil {
+				return err
+			}
+			if err := NewFieldRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			if err := database.AddForeignKey(ctx, db, "computer_field_values", "computer_id", "computers"); err != nil {
+				return err
+			}
+			return database.AddForeignKey(ctx, db, "computer_field_values", "field_id", "custom_fields")
+		},
+	},
+	{
+		Version:     6,
+		Description: "merging duplicate computers",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			if err := database.AddColumn(ctx, db, "computers", "merged_into", "INTEGER"); err != nil {
+				return err
+			}
+			if err := NewMergeRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			if err := database.AddForeignKey(ctx, db, "computer_merges", "computer_id", "computers"); err != nil {
+				return err
+			}
+			return database.AddForeignKey(ctx, db, "computer_merges", "merged_id", "computers")
+		},
+	},
+	{
+		Version:     7,
+		Description: "full text index of computer names",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			return NewSearchRepository(db).Install(ctx)
+		},
+	},
+	{
+		Version:     8,
+		Description: "accounts",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			return account.NewRepository(db).Install(ctx)
+		},
+	},
+	{
+		Version:     9,
+		Description: "API keys",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			return account.NewKeyRepository(db).Install(ctx)
+		},
+	},
+	{
+		Version:     10,
+		Description: "alert rules and alerts",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			if err := NewAlertRuleRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			return NewAlertRepository(db).Install(ctx)
+		},
+	},
+	{
+		Version:     11,
+		Description: "webhooks",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			if err := NewWebhookRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			return NewDeliveryRepository(db).Install(ctx)
+		},
+	},
+	{
+		Version:     12,
+		Description: "email notifications",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			if err := database.AddColumn(ctx, db, "accounts", "email", "TEXT"); err != nil {
+				return err
+			}
+			if err := NewSubscriptionRepository(db).Install(ctx); err != nil {
+				return err
+			}
+			return NewMailRepository(db).Install(ctx)
+		},
+	},
+	{
+		Version:     13,
+		Description: "dashboard index",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			return NewDashboardRepository(db).Install(ctx)
+		},
+	},
+	{
+		Version:     14,
+		Description: "agent check-ins",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			return NewCheckinRepository(db).Install(ctx)
+		},
+	},
+	{
+		Version:     15,
+		Description: "agent versions and platforms",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			for _, column := range []string{"agent_version", "agent_commit", "os", "arch"} {
+				if err := database.AddColumn(ctx, db, "computers", column, "TEXT"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version:     16,
+		Description: "agent configuration",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			return NewAgentConfigRepository(db).Install(ctx)
+		},
+	},
+	{
+		Version:     17,
+		Description: "API key accounts",
+		Up: func(ctx context.Context, db *sqlx.DB) error {
+			return database.AddColumn(ctx, db, "api_keys", "account_id", "INTEGER")
+		},
+	},
+}
+
+// SchemaVersion returns the schema version expected by this build.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+type SchemaRepository interface {
+	Install(context.Context) error
+	Version(context.Context) (int, error)
+	SetVersion(context.Context, int) error
+}
+
+type schemaRepository struct {
+	db *sqlx.DB
+}
+
+func NewSchemaRepository(db *sqlx.DB) SchemaRepository {
+	return &schemaRepository{
+		db: db,
+	}
+}
+
+func (r *schemaRepository) Install(ctx context.Context) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE IF NOT EXISTS schema_version (
+            "version" INTEGER NOT NULL,
+            "updated" TEXT
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Version returns the current schema version, 0 when nothing has been
+// installed yet.
+func (r *schemaRepository) Version(ctx context.Context) (int, error) {
+	defer observeQuery("schema", "Version", time.Now())
+
+	var version int
+
+	err := r.db.GetContext(
+		ctx,
+		&version,
+		r.db.Rebind(`SELECT COALESCE(MAX(version), 0)
+        FROM schema_version`),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func (r *schemaRepository) SetVersion(ctx context.Context, version int) error {
+	defer observeQuery("schema", "SetVersion", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM schema_version`)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		tx.Rebind(`INSERT INTO schema_version (
+            version,
+            updated
+        ) VALUES (?,?)`),
+		version,
+		time.Now().Format("2006-01-02 15:04:05"),
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Migrate brings the database schema up to SchemaVersion. Databases created
+// before the schema was versioned already hold the version 1 tables and are
+// adopted as such.
+func Migrate(ctx context.Context, db *sqlx.DB) error {
+	repo := NewSchemaRepository(db)
+
+	if err := repo.Install(ctx); err != nil {
+		return err
+	}
+
+	version, err := repo.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		exists, err := database.TableExists(ctx, db, "computers")
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			version = 1
+			if err = repo.SetVersion(ctx, version); err != nil {
+				return err
+			}
+		}
+	}
+
+	if version > SchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than supported version %d", version, SchemaVersion())
+	}
+
+	if err = checkFullTextSearch(ctx, db); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+
+		if err = m.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+
+		if err = repo.SetVersion(ctx, m.Version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckSchema returns an error when the database schema does not match the
+// version expected by this build.
+func CheckSchema(ctx context.Context, db *sqlx.DB) error {
+	version, err := NewSchemaRepository(db).Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version != SchemaVersion() {
+		return fmt.Errorf("schema version %d, expected %d", version, SchemaVersion())
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -19,6 +20,12 @@ type NetworkAdapter struct {
 	Name       null.String `db:"name" json:"name"`
 	MacAddress null.String `db:"mac_address" json:"mac_address"`
 	IPAddress  null.String `db:"ip_address" json:"ip_address"`
+	Source     null.String `db:"source" json:"-"`
+	SubnetID   null.Int    `db:"subnet_id" json:"subnet_id"`
+
+	// ComputerName is only set by EachWithComputerName and
+	// AlertRepository.SharedAdapters.
+	ComputerName null.String `db:"computer_name" json:"computer_name,omitempty"`
 }
 
 type NetworkAdapterRepository interface {
@@ -28,7 +35,10 @@ type NetworkAdapterRepository interface {
 	Create(context.Context, *NetworkAdapter) (int64, error)
 	Update(context.Context, *NetworkAdapter) error
 	Delete(context.Context, int) error
+	SetSubnet(context.Context, int64, null.Int) error
 	List(context.Context, int, int) ([]NetworkAdapter, error)
+	Count(context.Context) (int, error)
+	EachWithComputerName(context.Context, Filter, func(NetworkAdapter) error) error
 }
 
 type networkAdapterRepository struct {
@@ -42,19 +52,21 @@ func NewNetworkAdapterRepository(db *sqlx.DB) NetworkAdapterRepository {
 }
 
 func (r *networkAdapterRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
 	_, err := r.db.ExecContext(
 		ctx,
 		`CREATE TABLE computer_network_adapters (
-            "id" INTEGER,
+            `+d.PrimaryKey+`,
             "created" TEXT,
             "updated" TEXT,
             "deleted" TEXT,
             "computer_id" INTEGER NOT NULL,
-			"name" TEXT,
+            "name" TEXT,
             "mac_address" TEXT,
-			"ip_address" TEXT,
-			FOREIGN KEY("computer_id") REFERENCES "computers"("id") ON DELETE CASCADE ON UPDATE NO ACTION,
-            PRIMARY KEY("id" AUTOINCREMENT)
+            "ip_address" TEXT,
+            "source" TEXT,
+            "subnet_id" INTEGER`+d.ForeignKey("computer_id", "computers")+`
         )`,
 	)
 
@@ -65,11 +77,13 @@ func (r *networkAdapterRepository) Install(ctx context.Context) error {
 }
 
 func (r *networkAdapterRepository) Select(ctx context.Context, id int) (*NetworkAdapter, error) {
+	defer observeQuery("network_adapter", "Select", time.Now())
+
 	data := NetworkAdapter{}
 
 	stmt, err := r.db.PreparexContext(
 		ctx,
-		`SELECT 
+		r.db.Rebind(`SELECT 
             id,
             created,
             updated,
@@ -77,9 +91,11 @@ func (r *networkAdapterRepository) Select(ctx context.Context, id int) (*Network
 			computer_id,
             name,
 			mac_address,
-            ip_address
+            ip_address,
+            source,
+            subnet_id
         FROM computer_network_adapters
-        WHERE id=?`,
+        WHERE id=?`),
 	)
 
 	if err != nil {
@@ -103,11 +119,19 @@ func (r *networkAdapterRepository) Select(ctx context.Context, id int) (*Network
 }
 
 func (r *networkAdapterRepository) SelectWithComputerID(ctx context.Context, id int) ([]NetworkAdapter, error) {
+	defer observeQuery("network_adapter", "SelectWithComputerID", time.Now())
+	return selectAdapters(ctx, r.db, id)
+}
+
+// selectAdapters returns the adapters of the computer with id.
+func selectAdapters(ctx context.Context, q queryer, id int) ([]NetworkAdapter, error) {
 	data := []NetworkAdapter{}
 
-	stmt, err := r.db.PreparexContext(
+	err := sqlx.SelectContext(
 		ctx,
-		`SELECT 
+		q,
+		&data,
+		q.Rebind(`SELECT 
             id,
             created,
             updated,
@@ -115,25 +139,16 @@ func (r *networkAdapterRepository) SelectWithComputerID(ctx context.Context, id
 			computer_id,
             name,
 			mac_address,
-            ip_address
+            ip_address,
+            source,
+            subnet_id
         FROM computer_network_adapters
-        WHERE computer_id=?`,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	err = stmt.SelectContext(
-		ctx,
-		&data,
+        WHERE computer_id=?
+        AND deleted IS NULL`),
 		id,
 	)
 
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
 		return nil, err
 	}
 
@@ -141,84 +156,85 @@ func (r *networkAdapterRepository) SelectWithComputerID(ctx context.Context, id
 }
 
 func (r *networkAdapterRepository) Create(ctx context.Context, data *NetworkAdapter) (int64, error) {
+	defer observeQuery("network_adapter", "Create", time.Now())
+
 	tx, err := r.db.BeginTxx(ctx, nil)
 
 	if err != nil {
 		return -1, err
 	}
 
-	stmt, err := tx.PreparexContext(
-		ctx,
-		`INSERT INTO computer_network_adapters (
-            created,
-            computer_id,
-            name,
-			mac_address,
-            ip_address
-        ) VALUES (?,?,?,?,?)`,
-	)
+	id, err := insertAdapter(ctx, tx, data)
 
 	if err != nil {
+		tx.Rollback()
 		return -1, err
 	}
 
-	result, err := stmt.ExecContext(
+	tx.Commit()
+	return id, nil
+}
+
+func insertAdapter(ctx context.Context, tx *sqlx.Tx, data *NetworkAdapter) (int64, error) {
+	return database.Insert(
 		ctx,
+		tx,
+		`INSERT INTO computer_network_adapters (
+            created,
+            computer_id,
+            name,
+			mac_address,
+            ip_address,
+            source
+        ) VALUES (?,?,?,?,?,?)`,
 		time.Now().Format("2006-01-02 15:04:05"),
 		data.ComputerID,
 		data.Name,
 		data.MacAddress,
 		data.IPAddress,
+		data.Source,
 	)
-
-	if err != nil {
-		tx.Rollback()
-		return -1, err
-	}
-
-	tx.Commit()
-	id, _ := result.LastInsertId()
-	return id, nil
 }
 
 func (r *networkAdapterRepository) Update(ctx context.Context, data *NetworkAdapter) error {
+	defer observeQuery("network_adapter", "Update", time.Now())
+
 	tx, err := r.db.BeginTxx(ctx, nil)
 
 	if err != nil {
 		return err
 	}
 
-	stmt, err := tx.PreparexContext(
-		ctx,
-		`UPDATE computer_network_adapters SET
-            updated=?,
-            name=?,
-            ip_address=?
-        WHERE id=?`,
-	)
-
-	if err != nil {
+	if err = updateAdapter(ctx, tx, data); err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	_, err = stmt.ExecContext(
+	tx.Commit()
+	return nil
+}
+
+func updateAdapter(ctx context.Context, tx *sqlx.Tx, data *NetworkAdapter) error {
+	_, err := tx.ExecContext(
 		ctx,
+		tx.Rebind(`UPDATE computer_network_adapters SET
+            updated=?,
+            name=?,
+            ip_address=?,
+            source=?
+        WHERE id=?`),
 		time.Now().Format("2006-01-02 15:04:05"),
 		data.Name,
 		data.IPAddress,
+		data.Source,
 		data.ID,
 	)
-
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	tx.Commit()
-	return nil
+	return err
 }
 
 func (r *networkAdapterRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("network_adapter", "Delete", time.Now())
+
 	tx, err := r.db.BeginTxx(ctx, nil)
 
 	if err != nil {
@@ -227,9 +243,9 @@ func (r *networkAdapterRepository) Delete(ctx context.Context, id int) error {
 
 	stmt, err := tx.PreparexContext(
 		ctx,
-		`UPDATE computer_network_adapters SET
+		tx.Rebind(`UPDATE computer_network_adapters SET
             deleted=?
-        WHERE id=?`,
+        WHERE id=?`),
 	)
 
 	if err != nil {
@@ -251,12 +267,30 @@ func (r *networkAdapterRepository) Delete(ctx context.Context, id int) error {
 	return nil
 }
 
+// SetSubnet records the subnet the adapter was mapped to, without marking
+// the adapter as updated.
+func (r *networkAdapterRepository) SetSubnet(ctx context.Context, id int64, subnetID null.Int) error {
+	defer observeQuery("network_adapter", "SetSubnet", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE computer_network_adapters SET
+            subnet_id=?
+        WHERE id=?`),
+		subnetID,
+		id,
+	)
+	return err
+}
+
 func (r *networkAdapterRepository) List(ctx context.Context, start int, count int) ([]NetworkAdapter, error) {
+	defer observeQuery("network_adapter", "List", time.Now())
+
 	data := []NetworkAdapter{}
 
 	stmt, err := r.db.PreparexContext(
 		ctx,
-		`SELECT
+		r.db.Rebind(`SELECT
             id,
             created,
             updated,
@@ -265,7 +299,8 @@ func (r *networkAdapterRepository) List(ctx context.Context, start int, count in
             mac_address,
             ip_address
         FROM computer_network_adapters
-        LIMIT ?, ?`,
+        ORDER BY id
+        LIMIT ? OFFSET ?`),
 	)
 
 	if err != nil {
@@ -275,8 +310,8 @@ func (r *networkAdapterRepository) List(ctx context.Context, start int, count in
 	err = stmt.SelectContext(
 		ctx,
 		&data,
-		start,
 		count,
+		start,
 	)
 
 	if err != nil {
@@ -288,3 +323,75 @@ func (r *networkAdapterRepository) List(ctx context.Context, start int, count in
 
 	return data, nil
 }
+
+func (r *networkAdapterRepository) Count(ctx context.Context) (int, error) {
+	defer observeQuery("network_adapter", "Count", time.Now())
+
+	var count int
+
+	err := r.db.GetContext(
+		ctx,
+		&count,
+		r.db.Rebind(`SELECT COUNT(*)
+        FROM computer_network_adapters
+        WHERE deleted IS NULL`),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// EachWithComputerName calls fn for every network adapter matching f,
+// ordered by computer name. Rows are streamed, an error returned by fn
+// stops the iteration.
+func (r *networkAdapterRepository) EachWithComputerName(ctx context.Context, f Filter, fn func(NetworkAdapter) error) error {
+	defer observeQuery("network_adapter", "EachWithComputerName", time.Now())
+
+	query := `SELECT
+            na.id,
+            na.created,
+            na.updated,
+            na.computer_id,
+            na.name,
+            na.mac_address,
+            na.ip_address,
+            na.source,
+            c.name AS computer_name
+        FROM computer_network_adapters na
+        LEFT JOIN computers c ON na.computer_id = c.id
+        WHERE na.deleted IS NULL`
+	var args []interface{}
+
+	if f.Search != "" {
+		query += ` AND (LOWER(c.name) LIKE ? ESCAPE '\'
+            OR LOWER(na.name) LIKE ? ESCAPE '\'
+            OR LOWER(na.mac_address) LIKE ? ESCAPE '\'
+            OR na.ip_address LIKE ? ESCAPE '\')`
+		args = append(args, like(f.Search), like(f.Search), like(f.Search), like(f.Search))
+	}
+
+	limit, limitArgs := f.limit()
+	query += ` ORDER BY c.name, na.id` + limit
+	args = append(args, limitArgs...)
+
+	rows, err := r.db.QueryxContext(ctx, r.db.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data NetworkAdapter
+		if err = rows.StructScan(&data); err != nil {
+			return err
+		}
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
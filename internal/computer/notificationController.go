@@ -0,0 +1,191 @@
+package computer
+
+import (
+	"net/http"
+	netmail "net/mail"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+type notificationController struct {
+	log      lumber.Logger
+	accounts account.Repository
+	subRepo  SubscriptionRepository
+	enabled  bool
+	lock     sync.Locker
+}
+
+type NotificationController interface {
+	Page(http.ResponseWriter, *http.Request)
+	Save(http.ResponseWriter, *http.Request)
+}
+
+// NewNotificationController registers the page where signed in accounts
+// set their email address and the alerts and digests they receive at
+// /computers/notifications. enabled tells whether an SMTP server is
+// configured.
+func NewNotificationController(db *sqlx.DB, log lumber.Logger, router *mux.Router, enabled bool, guard Guard, middleware ...alice.Constructor) NotificationController {
+	c := &notificationController{
+		log:      log,
+		accounts: account.NewRepository(db),
+		subRepo:  NewSubscriptionRepository(db),
+		enabled:  enabled,
+		lock:     writeLock(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+
+	r := router.PathPrefix("/computers").Subrouter()
+	r.Handle("/notifications", view.ThenFunc(c.Page)).Methods("GET").Name("notifications")
+	r.Handle("/notifications", view.ThenFunc(c.Save)).Methods("POST").Name("notifications_save")
+
+	return c
+}
+
+// notificationPageData is rendered by notificationPage.
+type notificationPageData struct {
+	Title      string
+	Error      string
+	Saved      bool
+	Enabled    bool
+	Email      string
+	Topics     []struct{ Topic, Description string }
+	Subscribed map[string]bool
+	Account    *account.Account
+	CSRF       string
+}
+
+func (c *notificationController) Page(w http.ResponseWriter, r *http.Request) {
+	acct := c.account(w, r)
+	if acct == nil {
+		return
+	}
+
+	topics, err := c.subRepo.Topics(r.Context(), acct.ID.Int64)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	data := &notificationPageData{
+		Email:      acct.Email.String,
+		Subscribed: make(map[string]bool),
+	}
+	for _, t := range topics {
+		data.Subscribed[t] = true
+	}
+	c.render(w, r, http.StatusOK, data)
+}
+
+// Save stores the email address and replaces the subscriptions of the
+// account. Subscribing takes an email address.
+func (c *notificationController) Save(w http.ResponseWriter, r *http.Request) {
+	acct := c.account(w, r)
+	if acct == nil {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		c.render(w, r, http.StatusBadRequest, &notificationPageData{Error: "The form could not be read."})
+		return
+	}
+
+	data := &notificationPageData{
+		Email:      strings.TrimSpace(r.FormValue("email")),
+		Subscribed: make(map[string]bool),
+	}
+
+	var topics []string
+	for _, t := range r.Form["topics"] {
+		if !ValidTopic(t) {
+			data.Error = "Unknown notification " + t + "."
+			c.render(w, r, http.StatusUnprocessableEntity, data)
+			return
+		}
+		if !data.Subscribed[t] {
+			topics = append(topics, t)
+		}
+		data.Subscribed[t] = true
+	}
+
+	if data.Email != "" {
+		addr, err := netmail.ParseAddress(data.Email)
+		if err != nil {
+			data.Error = "The email address is not valid."
+			c.render(w, r, http.StatusUnprocessableEntity, data)
+			return
+		}
+		data.Email = addr.Address
+	} else if len(topics) > 0 {
+		data.Error = "An email address is required to receive notifications."
+		c.render(w, r, http.StatusUnprocessableEntity, data)
+		return
+	}
+
+	acct.Email = null.NewString(data.Email, data.Email != "")
+
+	c.lock.Lock()
+	err := c.accounts.Update(r.Context(), acct)
+	if err == nil {
+		err = c.subRepo.SetTopics(r.Context(), acct.ID.Int64, topics)
+	}
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	data.Saved = true
+	c.render(w, r, http.StatusOK, data)
+}
+
+// account returns the stored account of the request. Tokens and API keys
+// have none and are refused.
+func (c *notificationController) account(w http.ResponseWriter, r *http.Request) *account.Account {
+	current := AccountFromContext(r.Context())
+	if current == nil || !current.ID.Valid {
+		http.Error(w, "Notifications are only available to user accounts.", http.StatusForbidden)
+		return nil
+	}
+
+	acct, err := c.accounts.Select(r.Context(), current.ID.Int64)
+	if err != nil {
+		c.failed(w, r, err)
+		return nil
+	}
+	if acct == nil {
+		http.Error(w, "Notifications are only available to user accounts.", http.StatusForbidden)
+	}
+	return acct
+}
+
+func (c *notificationController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *notificationController) render(w http.ResponseWriter, r *http.Request, status int, data *notificationPageData) {
+	data.Title = "Notifications"
+	data.Enabled = c.enabled
+	data.Topics = Topics()
+	data.Account = AccountFromContext(r.Context())
+	data.CSRF = CSRFFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := notificationPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
@@ -0,0 +1,362 @@
+package computer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Subscriber is an account with an email address subscribed to a topic.
+type Subscriber struct {
+	AccountID null.Int    `db:"account_id"`
+	Username  null.String `db:"username"`
+	Email     null.String `db:"email"`
+}
+
+type SubscriptionRepository interface {
+	Install(context.Context) error
+
+	// Topics returns the topics the account subscribed to.
+	Topics(ctx context.Context, accountID int64) ([]string, error)
+
+	// SetTopics replaces the topics the account subscribed to.
+	SetTopics(ctx context.Context, accountID int64, topics []string) error
+
+	// Subscribers returns the live accounts with an email address
+	// subscribed to topic.
+	Subscribers(ctx context.Context, topic string) ([]Subscriber, error)
+}
+
+type subscriptionRepository struct {
+	db *sqlx.DB
+}
+
+func NewSubscriptionRepository(db *sqlx.DB) SubscriptionRepository {
+	return &subscriptionRepository{
+		db: db,
+	}
+}
+
+func (r *subscriptionRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE subscriptions (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "account_id" INTEGER NOT NULL,
+            "topic" TEXT NOT NULL`+d.ForeignKey("account_id", "accounts")+`
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `CREATE UNIQUE INDEX subscriptions_account_topic ON subscriptions (account_id, topic)`)
+	return err
+}
+
+func (r *subscriptionRepository) Topics(ctx context.Context, accountID int64) ([]string, error) {
+	defer observeQuery("subscription", "Topics", time.Now())
+
+	data := []string{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT topic
+        FROM subscriptions
+        WHERE account_id=?
+        ORDER BY topic`),
+		accountID,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *subscriptionRepository) SetTopics(ctx context.Context, accountID int64, topics []string) error {
+	defer observeQuery("subscription", "SetTopics", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`DELETE FROM subscriptions WHERE account_id=?`), accountID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	for _, topic := range topics {
+		_, err = tx.ExecContext(
+			ctx,
+			tx.Rebind(`INSERT INTO subscriptions (
+                created,
+                account_id,
+                topic
+            ) VALUES (?,?,?)`),
+			now,
+			accountID,
+			topic,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *subscriptionRepository) Subscribers(ctx context.Context, topic string) ([]Subscriber, error) {
+	defer observeQuery("subscription", "Subscribers", time.Now())
+
+	data := []Subscriber{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            a.id AS account_id,
+            a.username,
+            a.email
+        FROM subscriptions s
+        INNER JOIN accounts a ON a.id = s.account_id
+        WHERE s.topic=?
+        AND a.deleted IS NULL
+        AND a.email IS NOT NULL
+        AND a.email<>''
+        ORDER BY a.username`),
+		topic,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Mail states.
+const (
+	MailPending = "pending"
+	MailSent    = "sent"
+	MailFailed  = "failed"
+)
+
+// Mail is a queued email to a single recipient.
+type Mail struct {
+	ID        null.Int    `db:"id"`
+	Created   null.String `db:"created"`
+	Topic     null.String `db:"topic"`
+	Recipient null.String `db:"recipient"`
+	Subject   null.String `db:"subject"`
+	Body      null.String `db:"body"`
+	State     null.String `db:"state"`
+	Attempts  null.Int    `db:"attempts"`
+
+	// NextAttempt is when a pending mail is sent again.
+	NextAttempt null.String `db:"next_attempt"`
+	Sent        null.String `db:"sent"`
+	Error       null.String `db:"error"`
+}
+
+type MailRepository interface {
+	Install(context.Context) error
+	Create(context.Context, *Mail) (int64, error)
+
+	// Due returns the pending mails whose next attempt is before now,
+	// oldest first.
+	Due(ctx context.Context, now time.Time, limit int) ([]Mail, error)
+
+	// Attempted records the outcome of sending a mail. Pending mails are
+	// sent again at next.
+	Attempted(ctx context.Context, id int64, state string, message string, next time.Time) error
+
+	// Latest returns when the newest mail of topic was queued, "" when
+	// there is none.
+	Latest(ctx context.Context, topic string) (string, error)
+}
+
+type mailRepository struct {
+	db *sqlx.DB
+}
+
+func NewMailRepository(db *sqlx.DB) MailRepository {
+	return &mailRepository{
+		db: db,
+	}
+}
+
+func (r *mailRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE mails (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "topic" TEXT NOT NULL,
+            "recipient" TEXT NOT NULL,
+            "subject" TEXT NOT NULL,
+            "body" TEXT NOT NULL,
+            "state" TEXT NOT NULL,
+            "attempts" INTEGER NOT NULL,
+            "next_attempt" TEXT,
+            "sent" TEXT,
+            "error" TEXT
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX mails_due ON mails (state, next_attempt)`)
+	return err
+}
+
+func (r *mailRepository) Create(ctx context.Context, data *Mail) (int64, error) {
+	defer observeQuery("mail", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO mails (
+            created,
+            topic,
+            recipient,
+            subject,
+            body,
+            state,
+            attempts,
+            next_attempt
+        ) VALUES (?,?,?,?,?,?,?,?)`,
+		now,
+		data.Topic,
+		data.Recipient,
+		data.Subject,
+		data.Body,
+		MailPending,
+		0,
+		now,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+func (r *mailRepository) Due(ctx context.Context, now time.Time, limit int) ([]Mail, error) {
+	defer observeQuery("mail", "Due", time.Now())
+
+	data := []Mail{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            topic,
+            recipient,
+            subject,
+            body,
+            state,
+            attempts,
+            next_attempt,
+            sent,
+            error
+        FROM mails
+        WHERE state=?
+        AND next_attempt<=?
+        ORDER BY next_attempt, id
+        LIMIT ?`),
+		MailPending,
+		now.Format("2006-01-02 15:04:05"),
+		limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *mailRepository) Attempted(ctx context.Context, id int64, state string, message string, next time.Time) error {
+	defer observeQuery("mail", "Attempted", time.Now())
+
+	var sent, nextAttempt, errMessage null.String
+	switch state {
+	case MailSent:
+		sent = null.StringFrom(time.Now().Format("2006-01-02 15:04:05"))
+	case MailPending:
+		nextAttempt = null.StringFrom(next.Format("2006-01-02 15:04:05"))
+	}
+	if message != "" {
+		errMessage = null.StringFrom(message)
+	}
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE mails SET
+            state=?,
+            attempts=attempts+1,
+            next_attempt=?,
+            sent=?,
+            error=?
+        WHERE id=?`),
+		state,
+		nextAttempt,
+		sent,
+		errMessage,
+		id,
+	)
+	return err
+}
+
+func (r *mailRepository) Latest(ctx context.Context, topic string) (string, error) {
+	defer observeQuery("mail", "Latest", time.Now())
+
+	var latest null.String
+
+	err := r.db.GetContext(
+		ctx,
+		&latest,
+		r.db.Rebind(`SELECT MAX(created)
+        FROM mails
+        WHERE topic=?`),
+		topic,
+	)
+
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+
+	return latest.String, nil
+}
@@ -0,0 +1,307 @@
+package computer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/mail"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Digest topics, alerts are subscribed to by AlertTopic.
+const (
+	TopicDigestDaily  = "digest:daily"
+	TopicDigestWeekly = "digest:weekly"
+)
+
+const (
+	// maxMailAttempts is the number of attempts after which a mail fails.
+	maxMailAttempts = 5
+
+	// mailBatch limits the mails sent by one pass of Mailer.Deliver.
+	mailBatch = 50
+
+	// digestTopUsers limits the users listed by a digest.
+	digestTopUsers = 10
+)
+
+// AlertTopic returns the topic of the alerts of kind.
+func AlertTopic(kind string) string {
+	return "alert:" + kind
+}
+
+// Topics lists the topics that can be subscribed to with their
+// descriptions.
+func Topics() []struct{ Topic, Description string } {
+	list := []struct{ Topic, Description string }{
+		{TopicDigestDaily, "Daily digest"},
+		{TopicDigestWeekly, "Weekly digest"},
+	}
+	for _, k := range AlertKinds {
+		list = append(list, struct{ Topic, Description string }{AlertTopic(k.Kind), "Alert: " + k.Description})
+	}
+	return list
+}
+
+// ValidTopic reports whether topic can be subscribed to.
+func ValidTopic(topic string) bool {
+	for _, t := range Topics() {
+		if t.Topic == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Outbox queues mail for the accounts subscribed to its topic, it is sent
+// by Mailer.
+type Outbox struct {
+	subRepo  SubscriptionRepository
+	mailRepo MailRepository
+}
+
+func NewOutbox(db *sqlx.DB) *Outbox {
+	return &Outbox{
+		subRepo:  NewSubscriptionRepository(db),
+		mailRepo: NewMailRepository(db),
+	}
+}
+
+// Alert queues a mail about a raised alert. The caller holds the write
+// lock.
+func (o *Outbox) Alert(ctx context.Context, alert *Alert) error {
+	subject := fmt.Sprintf("[fpsmonitor] %s: %s", alert.Severity.String, alert.Message.String)
+	return o.Queue(ctx, AlertTopic(alert.Kind.String), subject, alertMail(), alert)
+}
+
+// Queue renders tmpl with data and queues it for every subscriber of
+// topic. The caller holds the write lock.
+func (o *Outbox) Queue(ctx context.Context, topic string, subject string, tmpl *template.Template, data interface{}) error {
+	subscribers, err := o.subRepo.Subscribers(ctx, topic)
+	if err != nil || len(subscribers) == 0 {
+		return err
+	}
+
+	var body bytes.Buffer
+	if err = tmpl.ExecuteTemplate(&body, "mail", data); err != nil {
+		return err
+	}
+
+	for _, s := range subscribers {
+		_, err = o.mailRepo.Create(ctx, &Mail{
+			Topic:     null.StringFrom(topic),
+			Recipient: s.Email,
+			Subject:   null.StringFrom(subject),
+			Body:      null.StringFrom(body.String()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DigestSchedule is when digests are sent. Daily digests are sent at Hour,
+// weekly ones at Hour on Weekday.
+type DigestSchedule struct {
+	Hour    int
+	Weekday time.Weekday
+}
+
+// last returns the newest time digests of topic were due before now.
+func (s DigestSchedule) last(topic string, now time.Time) time.Time {
+	due := time.Date(now.Year(), now.Month(), now.Day(), s.Hour, 0, 0, 0, now.Location())
+	if topic == TopicDigestWeekly {
+		due = due.AddDate(0, 0, -int((now.Weekday()-s.Weekday+7)%7))
+		if due.After(now) {
+			due = due.AddDate(0, 0, -7)
+		}
+		return due
+	}
+	if due.After(now) {
+		due = due.AddDate(0, 0, -1)
+	}
+	return due
+}
+
+// Digest summarises the inventory for a digest mail.
+type Digest struct {
+	Title        string
+	Since        string
+	Until        string
+	NewComputers []Computer
+	Stale        []AlertTarget
+	Conflicts    []Duplicate
+	TopUsers     []UserCount
+}
+
+// Mailer sends queued mail and queues the digests on their schedule.
+type Mailer struct {
+	log        lumber.Logger
+	config     mail.Config
+	schedule   DigestSchedule
+	outbox     *Outbox
+	mailRepo   MailRepository
+	digestRepo DigestRepository
+	alertRepo  AlertRepository
+	mergeRepo  MergeRepository
+	lock       sync.Locker
+
+	// send is replaced by tests.
+	send func(mail.Config, mail.Message) error
+}
+
+func NewMailer(db *sqlx.DB, log lumber.Logger, config mail.Config, schedule DigestSchedule) *Mailer {
+	return &Mailer{
+		log:        log,
+		config:     config,
+		schedule:   schedule,
+		outbox:     NewOutbox(db),
+		mailRepo:   NewMailRepository(db),
+		digestRepo: NewDigestRepository(db),
+		alertRepo:  NewAlertRepository(db),
+		mergeRepo:  NewMergeRepository(db),
+		lock:       writeLock(db),
+		send:       mail.Send,
+	}
+}
+
+// Digests queues the daily and weekly digests that are due at now and
+// were not queued yet. Digests without subscribers are not built.
+func (m *Mailer) Digests(ctx context.Context, now time.Time) error {
+	for _, d := range []struct {
+		topic  string
+		title  string
+		period time.Duration
+	}{
+		{TopicDigestDaily, "Daily digest", 24 * time.Hour},
+		{TopicDigestWeekly, "Weekly digest", 7 * 24 * time.Hour},
+	} {
+		due := m.schedule.last(d.topic, now)
+
+		latest, err := m.mailRepo.Latest(ctx, d.topic)
+		if err != nil {
+			return err
+		}
+		if latest >= due.Format("2006-01-02 15:04:05") {
+			continue
+		}
+
+		subscribers, err := m.outbox.subRepo.Subscribers(ctx, d.topic)
+		if err != nil {
+			return err
+		}
+		if len(subscribers) == 0 {
+			continue
+		}
+
+		digest, err := m.Digest(ctx, d.title, now.Add(-d.period), now)
+		if err != nil {
+			return err
+		}
+		subject := fmt.Sprintf("[fpsmonitor] %s: %d new, %d stale, %d conflicts",
+			d.title, len(digest.NewComputers), len(digest.Stale), len(digest.Conflicts))
+
+		m.lock.Lock()
+		err = m.outbox.Queue(ctx, d.topic, subject, digestMail(), digest)
+		m.lock.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Digest collects the computers created and the users seen since since,
+// the computers stale at until and the current conflicts.
+func (m *Mailer) Digest(ctx context.Context, title string, since time.Time, until time.Time) (*Digest, error) {
+	d := &Digest{
+		Title: title,
+		Since: since.Format("2006-01-02 15:04"),
+		Until: until.Format("2006-01-02 15:04"),
+	}
+
+	var err error
+	if d.NewComputers, err = m.digestRepo.NewComputers(ctx, since); err != nil {
+		return nil, err
+	}
+	if d.Stale, err = m.alertRepo.Stale(ctx, until.Add(-StaleAfter)); err != nil {
+		return nil, err
+	}
+	if d.Conflicts, err = m.mergeRepo.Duplicates(ctx); err != nil {
+		return nil, err
+	}
+	if d.TopUsers, err = m.digestRepo.TopUsers(ctx, since, digestTopUsers); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Deliver sends the mails that are due. Failed mails are retried with
+// deliveryBackoff until maxMailAttempts. The write lock is only held while
+// recording the outcome.
+func (m *Mailer) Deliver(ctx context.Context) error {
+	due, err := m.mailRepo.Due(ctx, time.Now(), mailBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range due {
+		err := m.send(m.config, mail.Message{
+			To:      []string{msg.Recipient.String},
+			Subject: msg.Subject.String,
+			HTML:    msg.Body.String,
+		})
+
+		state := MailSent
+		message := ""
+		var next time.Time
+		if err != nil {
+			message = err.Error()
+			state = MailPending
+			attempts := int(msg.Attempts.Int64) + 1
+			if attempts >= maxMailAttempts {
+				state = MailFailed
+			}
+			next = time.Now().Add(deliveryBackoff(attempts))
+			m.log.Errorf("mail: sending %q to %s failed: %s", msg.Subject.String, msg.Recipient.String, message)
+		}
+
+		m.lock.Lock()
+		err = m.mailRepo.Attempted(ctx, msg.ID.Int64, state, message, next)
+		m.lock.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run queues the digests and sends the queued mail every interval until
+// ctx is cancelled.
+func (m *Mailer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := m.Digests(ctx, now); err != nil {
+				m.log.Errorf("mail: digest failed: %s", err)
+			}
+			if err := m.Deliver(ctx); err != nil {
+				m.log.Errorf("mail: delivery failed: %s", err)
+			}
+		}
+	}
+}
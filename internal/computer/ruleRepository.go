@@ -0,0 +1,149 @@
+package computer
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Rule assigns a tag and/or a location to every computer it matches.
+type Rule struct {
+	ID      null.Int    `db:"id" json:"id"`
+	Created null.String `db:"created" json:"-"`
+	Deleted null.String `db:"deleted" json:"-"`
+
+	Kind       null.String `db:"kind" json:"kind"`
+	Pattern    null.String `db:"pattern" json:"pattern"`
+	TagID      null.Int    `db:"tag_id" json:"tag_id"`
+	LocationID null.Int    `db:"location_id" json:"location_id"`
+
+	// TagName and LocationName are set by List.
+	TagName      null.String `db:"tag_name" json:"tag_name,omitempty"`
+	LocationName null.String `db:"location_name" json:"location_name,omitempty"`
+}
+
+type RuleRepository interface {
+	Install(context.Context) error
+	Create(context.Context, *Rule) (int64, error)
+	Delete(context.Context, int) error
+	List(context.Context) ([]Rule, error)
+}
+
+type ruleRepository struct {
+	db *sqlx.DB
+}
+
+func NewRuleRepository(db *sqlx.DB) RuleRepository {
+	return &ruleRepository{
+		db: db,
+	}
+}
+
+func (r *ruleRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE assignment_rules (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "deleted" TEXT,
+            "kind" TEXT NOT NULL,
+            "pattern" TEXT NOT NULL,
+            "tag_id" INTEGER,
+            "location_id" INTEGER
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *ruleRepository) Create(ctx context.Context, data *Rule) (int64, error) {
+	defer observeQuery("rule", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO assignment_rules (
+            created,
+            kind,
+            pattern,
+            tag_id,
+            location_id
+        ) VALUES (?,?,?,?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.Kind,
+		data.Pattern,
+		data.TagID,
+		data.LocationID,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+func (r *ruleRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("rule", "Delete", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE assignment_rules SET
+            deleted=?
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+	return err
+}
+
+// List returns the rules with the names of the tag and location they
+// assign. Rules whose tag and location have both been deleted are left
+// out.
+func (r *ruleRepository) List(ctx context.Context) ([]Rule, error) {
+	defer observeQuery("rule", "List", time.Now())
+
+	data := []Rule{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            r.id,
+            r.created,
+            r.kind,
+            r.pattern,
+            t.id AS tag_id,
+            t.name AS tag_name,
+            l.id AS location_id,
+            l.name AS location_name
+        FROM assignment_rules r
+        LEFT JOIN tags t ON t.id = r.tag_id AND t.deleted IS NULL
+        LEFT JOIN locations l ON l.id = r.location_id AND l.deleted IS NULL
+        WHERE r.deleted IS NULL
+        AND (t.id IS NOT NULL OR l.id IS NOT NULL)
+        ORDER BY r.id`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
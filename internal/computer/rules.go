@@ -0,0 +1,220 @@
+package computer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Rule kinds, a hostname prefix is matched ignoring case and a subnet in
+// CIDR notation against the addresses of every network adapter.
+const (
+	RuleHostnamePrefix = "hostname_prefix"
+	RuleSubnet         = "subnet"
+)
+
+var ErrRuleTarget = errors.New("rule needs a tag or a location")
+
+// Validate checks the rule and normalises its pattern.
+func (r *Rule) Validate() error {
+	pattern := strings.TrimSpace(r.Pattern.String)
+
+	switch r.Kind.String {
+	case RuleHostnamePrefix:
+		if pattern == "" {
+			return errors.New("hostname prefix is required")
+		}
+	case RuleSubnet:
+		_, subnet, err := net.ParseCIDR(pattern)
+		if err != nil {
+			return fmt.Errorf("%q is not a subnet in CIDR notation", pattern)
+		}
+		pattern = subnet.String()
+	default:
+		return fmt.Errorf("unknown rule kind %q", r.Kind.String)
+	}
+
+	if !r.TagID.Valid && !r.LocationID.Valid {
+		return ErrRuleTarget
+	}
+
+	r.Pattern.SetValid(pattern)
+	return nil
+}
+
+// Matches reports whether the rule applies to the computer called name
+// with the given network adapters.
+func (r Rule) Matches(name string, adapters []NetworkAdapter) bool {
+	switch r.Kind.String {
+	case RuleHostnamePrefix:
+		return strings.HasPrefix(strings.ToLower(name), strings.ToLower(r.Pattern.String))
+	case RuleSubnet:
+		_, subnet, err := net.ParseCIDR(r.Pattern.String)
+		if err != nil {
+			return false
+		}
+		for _, na := range adapters {
+			for _, ip := range parseIPs(na.IPAddress.String) {
+				if subnet.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parseIPs returns the addresses in the address field of an adapter. Agents
+// separate the addresses with commas, each with or without its prefix
+// length, e.g. "10.0.0.5/24,10.1.0.5/16". Anything else is left out.
+func parseIPs(s string) []net.IP {
+	var ips []net.IP
+	for _, field := range strings.FieldsFunc(s, isIPSeparator) {
+		if ip, _, err := net.ParseCIDR(field); err == nil {
+			ips = append(ips, ip)
+		} else if ip := net.ParseIP(field); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// isIPSeparator reports whether r separates the addresses of an adapter.
+func isIPSeparator(r rune) bool {
+	return r == ',' || r == ';' || unicode.IsSpace(r)
+}
+
+// Assigner links computers to the tags and locations of the rules they
+// match and maps their network adapters to subnets, placing the computer
+// at the site of its subnets. Links made by rules and subnets are
+// recalculated every time, manual links are left alone.
+type Assigner struct {
+	computerRepo       ComputerRepository
+	networkAdapterRepo NetworkAdapterRepository
+	ruleRepo           RuleRepository
+	subnetRepo         SubnetRepository
+	tagRepo            TagRepository
+	locationRepo       LocationRepository
+	lock               sync.Locker
+}
+
+func NewAssigner(db *sqlx.DB) *Assigner {
+	return &Assigner{
+		computerRepo:       NewComputerRepository(db),
+		networkAdapterRepo: NewNetworkAdapterRepository(db),
+		ruleRepo:           NewRuleRepository(db),
+		subnetRepo:         NewSubnetRepository(db),
+		tagRepo:            NewTagRepository(db),
+		locationRepo:       NewLocationRepository(db),
+		lock:               writeLock(db),
+	}
+}
+
+// Apply recalculates the links of a single computer. The caller is
+// expected to hold the write lock.
+func (a *Assigner) Apply(ctx context.Context, compID int64, name string) error {
+	rules, subnets, err := a.load(ctx)
+	if err != nil {
+		return err
+	}
+	return a.apply(ctx, rules, subnets, compID, name)
+}
+
+// ApplyAll recalculates the links of every computer, used after the rules
+// or subnets have changed. It returns the number of computers processed.
+func (a *Assigner) ApplyAll(ctx context.Context) (int, error) {
+	rules, subnets, err := a.load(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var list []Computer
+	err = a.computerRepo.EachSummary(ctx, Filter{}, func(s ComputerSummary) error {
+		list = append(list, s.Computer)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, comp := range list {
+		if err = a.apply(ctx, rules, subnets, comp.ID.Int64, comp.Name.String); err != nil {
+			return 0, err
+		}
+	}
+	return len(list), nil
+}
+
+func (a *Assigner) load(ctx context.Context) ([]Rule, []Subnet, error) {
+	rules, err := a.ruleRepo.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	subnets, err := a.subnetRepo.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rules, subnets, nil
+}
+
+func (a *Assigner) apply(ctx context.Context, rules []Rule, subnets []Subnet, compID int64, name string) error {
+	adapters, err := a.networkAdapterRepo.SelectWithComputerID(ctx, int(compID))
+	if err != nil {
+		return err
+	}
+
+	if err = a.tagRepo.ClearRules(ctx, compID); err != nil {
+		return err
+	}
+	if err = a.locationRepo.ClearRules(ctx, compID); err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if !rule.Matches(name, adapters) {
+			continue
+		}
+		if rule.TagID.Valid {
+			if err = a.tagRepo.Assign(ctx, compID, rule.TagID.Int64, LinkRule); err != nil {
+				return err
+			}
+		}
+		if rule.LocationID.Valid {
+			if err = a.locationRepo.Assign(ctx, compID, rule.LocationID.Int64, LinkRule); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, na := range adapters {
+		var subnetID null.Int
+		subnet := MatchSubnet(subnets, parseIPs(na.IPAddress.String))
+		if subnet != nil {
+			subnetID = subnet.ID
+		}
+
+		if subnetID != na.SubnetID {
+			if err = a.networkAdapterRepo.SetSubnet(ctx, na.ID.Int64, subnetID); err != nil {
+				return err
+			}
+		}
+
+		if subnet != nil && subnet.LocationID.Valid {
+			if err = a.locationRepo.Assign(ctx, compID, subnet.LocationID.Int64, LinkSubnet); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package computer
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/guregu/null.v3"
+)
+
+// Search query kinds, see ParseSearch.
+const (
+	SearchName = "name"
+	SearchMAC  = "mac"
+	SearchIP   = "ip"
+)
+
+var (
+	ipQuery  = regexp.MustCompile(`^\d{1,3}(?:\.\d{1,3}){1,3}\.?$`)
+	macQuery = regexp.MustCompile(`^(?:[0-9a-f]{2}[:-]){1,5}[0-9a-f]{0,2}$|^(?:[0-9a-f]{4}\.){1,2}[0-9a-f]{0,4}$|^[0-9a-f]{12}$`)
+)
+
+// SearchQuery is a parsed search, Term is normalised for the Kind: MAC
+// addresses lose their separators and everything is lower case.
+type SearchQuery struct {
+	Text string
+	Kind string
+	Term string
+}
+
+// ParseSearch detects whether q is (part of) an IP address, a MAC address
+// in one of the usual notations, or else a host or user name.
+func ParseSearch(q string) SearchQuery {
+	q = strings.TrimSpace(q)
+	term := strings.ToLower(q)
+
+	switch {
+	case ipQuery.MatchString(term):
+		return SearchQuery{Text: q, Kind: SearchIP, Term: term}
+	case macQuery.MatchString(term):
+		return SearchQuery{Text: q, Kind: SearchMAC, Term: strings.NewReplacer(":", "", "-", "", ".", "").Replace(term)}
+	}
+	return SearchQuery{Text: q, Kind: SearchName, Term: term}
+}
+
+// matchQuery returns the FTS5 query finding names with words starting with
+// the words of the term, empty when the term has none.
+func (q SearchQuery) matchQuery() string {
+	words := strings.FieldsFunc(q.Term, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r > 127)
+	})
+
+	for i, w := range words {
+		words[i] = `"` + w + `"*`
+	}
+	return strings.Join(words, " ")
+}
+
+// SearchHit is a computer found by a search, Value is what matched: its
+// name, a user name, or the MAC or IP address of an adapter.
+type SearchHit struct {
+	ComputerID   null.Int    `db:"computer_id" json:"computer_id"`
+	ComputerName null.String `db:"computer_name" json:"computer_name"`
+	Value        null.String `db:"value" json:"value"`
+	LastSeen     null.String `db:"last_seen" json:"last_seen"`
+}
+
+// SearchResult holds the hits of a search grouped by what matched, best
+// matches first.
+type SearchResult struct {
+	Query     string      `json:"query"`
+	Kind      string      `json:"kind"`
+	Computers []SearchHit `json:"computers"`
+	Users     []SearchHit `json:"users"`
+	Adapters  []SearchHit `json:"adapters"`
+}
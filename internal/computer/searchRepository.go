@@ -0,0 +1,293 @@
+package computer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+)
+
+// maxSearchHits limits each group of a SearchResult.
+const maxSearchHits = 50
+
+type SearchRepository interface {
+	Install(context.Context) error
+	Search(context.Context, SearchQuery) (*SearchResult, error)
+}
+
+type searchRepository struct {
+	db *sqlx.DB
+}
+
+func NewSearchRepository(db *sqlx.DB) SearchRepository {
+	return &searchRepository{
+		db: db,
+	}
+}
+
+// Install creates the computer_names full text index kept up to date by
+// triggers on computers. Without FTS5 support nothing is installed and
+// names are searched with LIKE.
+func (r *searchRepository) Install(ctx context.Context) error {
+	fts, err := database.FullTextSearch(ctx, r.db)
+	if err != nil || !fts {
+		return err
+	}
+
+	for _, stmt := range []string{
+		`CREATE VIRTUAL TABLE computer_names USING fts5(
+            name,
+            content='computers',
+            content_rowid='id'
+        )`,
+		`CREATE TRIGGER computer_names_insert AFTER INSERT ON computers BEGIN
+            INSERT INTO computer_names (rowid, name) VALUES (new.id, new.name);
+        END`,
+		`CREATE TRIGGER computer_names_delete AFTER DELETE ON computers BEGIN
+            INSERT INTO computer_names (computer_names, rowid, name) VALUES ('delete', old.id, old.name);
+        END`,
+		`CREATE TRIGGER computer_names_update AFTER UPDATE OF name ON computers WHEN old.name IS NOT new.name BEGIN
+            INSERT INTO computer_names (computer_names, rowid, name) VALUES ('delete', old.id, old.name);
+            INSERT INTO computer_names (rowid, name) VALUES (new.id, new.name);
+        END`,
+		`INSERT INTO computer_names (computer_names) VALUES ('rebuild')`,
+	} {
+		if _, err = r.db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFullTextSearch fails when the database has the computer_names index
+// but this build lacks FTS5. The triggers maintaining the index would then
+// fail every write to computers, agent reports included.
+func checkFullTextSearch(ctx context.Context, db *sqlx.DB) error {
+	if database.DialectOf(db).Name != database.SQLite {
+		return nil
+	}
+
+	fts, err := database.FullTextSearch(ctx, db)
+	if err != nil || fts {
+		return err
+	}
+
+	exists, err := database.TableExists(ctx, db, "computer_names")
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.New("the database has a full text search index, which this build can not update: build with -tags sqlite_fts5")
+	}
+	return nil
+}
+
+// Search looks up computers by name and user name, or by the MAC or IP
+// address of their network adapters, depending on the kind of query.
+func (r *searchRepository) Search(ctx context.Context, q SearchQuery) (*SearchResult, error) {
+	defer observeQuery("search", "Search", time.Now())
+
+	result := &SearchResult{
+		Query:     q.Text,
+		Kind:      q.Kind,
+		Computers: []SearchHit{},
+		Users:     []SearchHit{},
+		Adapters:  []SearchHit{},
+	}
+	if q.Term == "" {
+		return result, nil
+	}
+
+	var err error
+	switch q.Kind {
+	case SearchMAC:
+		result.Adapters, err = r.macs(ctx, q)
+	case SearchIP:
+		result.Adapters, err = r.ips(ctx, q)
+	default:
+		if result.Computers, err = r.names(ctx, q); err == nil {
+			result.Users, err = r.users(ctx, q)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// names returns the computers whose names contain the term. With the full
+// text index names with words starting with the words of the term come
+// first, ranked by bm25.
+func (r *searchRepository) names(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	hits := []SearchHit{}
+
+	fts, err := database.FullTextSearch(ctx, r.db)
+	if err == nil && fts {
+		fts, err = database.TableExists(ctx, r.db, "computer_names")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if match := q.matchQuery(); fts && match != "" {
+		err = r.db.SelectContext(
+			ctx,
+			&hits,
+			`SELECT
+                c.id AS computer_id,
+                c.name AS computer_name,
+                c.name AS value,
+                COALESCE(c.updated, c.created) AS last_seen
+            FROM computer_names
+            JOIN computers c ON c.id = computer_names.rowid
+            WHERE computer_names MATCH ?
+            AND c.deleted IS NULL
+            ORDER BY computer_names.rank
+            LIMIT ?`,
+			match,
+			maxSearchHits,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	more := []SearchHit{}
+	err = r.db.SelectContext(
+		ctx,
+		&more,
+		r.db.Rebind(`SELECT
+            c.id AS computer_id,
+            c.name AS computer_name,
+            c.name AS value,
+            COALESCE(c.updated, c.created) AS last_seen
+        FROM computers c
+        WHERE LOWER(c.name) LIKE ? ESCAPE '\'
+        AND c.deleted IS NULL
+        ORDER BY CASE WHEN LOWER(c.name) = ? THEN 0 WHEN LOWER(c.name) LIKE ? ESCAPE '\' THEN 1 ELSE 2 END, c.name
+        LIMIT ?`),
+		like(q.Term),
+		q.Term,
+		strings.TrimPrefix(like(q.Term), "%"),
+		maxSearchHits,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[int64]bool, len(hits))
+	for _, h := range hits {
+		found[h.ComputerID.Int64] = true
+	}
+	for _, h := range more {
+		if len(hits) < maxSearchHits && !found[h.ComputerID.Int64] {
+			hits = append(hits, h)
+		}
+	}
+	return hits, nil
+}
+
+// users returns the computers on which user names containing the term
+// have been seen, the most recent first.
+func (r *searchRepository) users(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	hits := []SearchHit{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&hits,
+		r.db.Rebind(`SELECT
+            c.id AS computer_id,
+            c.name AS computer_name,
+            cu.username AS value,
+            MAX(COALESCE(cu.updated, cu.created)) AS last_seen
+        FROM computer_users cu
+        JOIN computers c ON c.id = cu.computer_id
+        WHERE LOWER(cu.username) LIKE ? ESCAPE '\'
+        AND c.deleted IS NULL
+        GROUP BY c.id, c.name, cu.username
+        ORDER BY CASE WHEN LOWER(cu.username) = ? THEN 0 WHEN LOWER(cu.username) LIKE ? ESCAPE '\' THEN 1 ELSE 2 END, last_seen DESC
+        LIMIT ?`),
+		like(q.Term),
+		q.Term,
+		strings.TrimPrefix(like(q.Term), "%"),
+		maxSearchHits,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+// macs returns the adapters whose MAC address contains the term, ignoring
+// separators.
+func (r *searchRepository) macs(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	hits := []SearchHit{}
+
+	mac := `REPLACE(REPLACE(REPLACE(LOWER(na.mac_address), ':', ''), '-', ''), '.', '')`
+
+	err := r.db.SelectContext(
+		ctx,
+		&hits,
+		r.db.Rebind(`SELECT
+            c.id AS computer_id,
+            c.name AS computer_name,
+            na.mac_address AS value,
+            COALESCE(c.updated, c.created) AS last_seen
+        FROM computer_network_adapters na
+        JOIN computers c ON c.id = na.computer_id
+        WHERE `+mac+` LIKE ?
+        AND na.deleted IS NULL
+        AND c.deleted IS NULL
+        ORDER BY CASE WHEN `+mac+` = ? THEN 0 WHEN `+mac+` LIKE ? THEN 1 ELSE 2 END, last_seen DESC
+        LIMIT ?`),
+		"%"+q.Term+"%",
+		q.Term,
+		q.Term+"%",
+		maxSearchHits,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+// ips returns the adapters whose addresses contain the term, exact matches
+// first.
+func (r *searchRepository) ips(ctx context.Context, q SearchQuery) ([]SearchHit, error) {
+	hits := []SearchHit{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&hits,
+		r.db.Rebind(`SELECT
+            c.id AS computer_id,
+            c.name AS computer_name,
+            na.ip_address AS value,
+            COALESCE(c.updated, c.created) AS last_seen
+        FROM computer_network_adapters na
+        JOIN computers c ON c.id = na.computer_id
+        WHERE na.ip_address LIKE ?
+        AND na.deleted IS NULL
+        AND c.deleted IS NULL
+        ORDER BY CASE WHEN na.ip_address = ? OR na.ip_address LIKE ? THEN 0 WHEN na.ip_address LIKE ? THEN 1 ELSE 2 END, last_seen DESC
+        LIMIT ?`),
+		"%"+q.Term+"%",
+		q.Term,
+		q.Term+"/%",
+		q.Term+"%",
+		maxSearchHits,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
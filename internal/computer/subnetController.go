@@ -0,0 +1,170 @@
+package computer
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+type subnetController struct {
+	log          lumber.Logger
+	subnetRepo   SubnetRepository
+	locationRepo LocationRepository
+	assigner     *Assigner
+	lock         sync.Locker
+}
+
+type SubnetController interface {
+	Page(http.ResponseWriter, *http.Request)
+	Create(http.ResponseWriter, *http.Request)
+	Delete(http.ResponseWriter, *http.Request)
+}
+
+// NewSubnetController registers the subnet view at /computers/subnets.
+// Changes take the admin role.
+func NewSubnetController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) SubnetController {
+	c := &subnetController{
+		log:          log,
+		subnetRepo:   NewSubnetRepository(db),
+		locationRepo: NewLocationRepository(db),
+		assigner:     NewAssigner(db),
+		lock:         writeLock(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
+
+	r := router.PathPrefix("/computers").Subrouter()
+	r.Handle("/subnets", view.ThenFunc(c.Page)).Methods("GET").Name("subnets")
+	r.Handle("/subnets", admin.ThenFunc(c.Create)).Methods("POST").Name("subnet_create")
+	r.Handle("/subnets/{id:[0-9]+}/delete", admin.ThenFunc(c.Delete)).Methods("POST").Name("subnet_delete")
+
+	return c
+}
+
+// subnetPageData is rendered by subnetPage.
+type subnetPageData struct {
+	Title     string
+	Error     string
+	Subnets   []Subnet
+	Locations []Location
+	Account   *account.Account
+	CSRF      string
+}
+
+// Page lists the subnets with the computers currently seen in them.
+func (c *subnetController) Page(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, http.StatusOK, "")
+}
+
+// Create adds a subnet and maps every computer again.
+func (c *subnetController) Create(w http.ResponseWriter, r *http.Request) {
+	subnet := &Subnet{
+		CIDR: null.StringFrom(r.FormValue("cidr")),
+		Name: null.StringFrom(r.FormValue("name")),
+	}
+
+	if v := r.FormValue("vlan"); v != "" {
+		vlan, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.render(w, r, http.StatusUnprocessableEntity, "VLAN must be a number.")
+			return
+		}
+		subnet.VLAN = null.IntFrom(vlan)
+	}
+
+	if v := r.FormValue("location"); v != "" {
+		id, _ := strconv.Atoi(v)
+		loc, err := c.locationRepo.Select(r.Context(), id)
+		if err != nil {
+			c.failed(w, r, err)
+			return
+		}
+		if loc == nil {
+			c.render(w, r, http.StatusUnprocessableEntity, "Site does not exist.")
+			return
+		}
+		subnet.LocationID = loc.ID
+	}
+
+	if err := subnet.Validate(); err != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	c.lock.Lock()
+	_, err := c.subnetRepo.Create(r.Context(), subnet)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.applyAll(w, r)
+}
+
+func (c *subnetController) Delete(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.subnetRepo.Delete(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.applyAll(w, r)
+}
+
+func (c *subnetController) applyAll(w http.ResponseWriter, r *http.Request) {
+	n, err := c.assigner.ApplyAll(r.Context())
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	requestlog.Set(r.Context(), "computers", n)
+	http.Redirect(w, r, "/computers/subnets", http.StatusSeeOther)
+}
+
+func (c *subnetController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *subnetController) render(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data := &subnetPageData{
+		Title:   "Subnets",
+		Error:   message,
+		Account: AccountFromContext(r.Context()),
+		CSRF:    CSRFFromContext(r.Context()),
+	}
+
+	var err error
+	if data.Subnets, err = c.subnetRepo.Usage(r.Context(), time.Now().Add(-StaleAfter)); err == nil {
+		data.Locations, err = c.locationRepo.List(r.Context())
+	}
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := subnetPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
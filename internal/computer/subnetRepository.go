@@ -0,0 +1,346 @@
+package computer
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Subnet is an IPv4 range of the IP plan. Reports are mapped to the most
+// specific subnet containing one of their addresses, the computer is then
+// placed at the subnet's site.
+type Subnet struct {
+	ID      null.Int    `db:"id" json:"id"`
+	Created null.String `db:"created" json:"-"`
+	Deleted null.String `db:"deleted" json:"-"`
+
+	CIDR       null.String `db:"cidr" json:"cidr"`
+	Name       null.String `db:"name" json:"name"`
+	VLAN       null.Int    `db:"vlan" json:"vlan"`
+	LocationID null.Int    `db:"location_id" json:"location_id"`
+
+	// The remaining fields are only set by Usage. Computers counts the
+	// computers with an adapter in the subnet, Active those that reported
+	// since the given time and Addresses the adapters in the subnet.
+	LocationName null.String `db:"location_name" json:"location_name,omitempty"`
+	Computers    int         `db:"computers" json:"computers"`
+	Active       int         `db:"active" json:"active"`
+	Addresses    int         `db:"addresses" json:"addresses"`
+}
+
+// Validate checks the subnet and normalises its CIDR.
+func (s *Subnet) Validate() error {
+	ip, subnet, err := net.ParseCIDR(strings.TrimSpace(s.CIDR.String))
+	if err != nil || ip.To4() == nil {
+		return fmt.Errorf("%q is not an IPv4 subnet in CIDR notation", s.CIDR.String)
+	}
+
+	if strings.TrimSpace(s.Name.String) == "" {
+		return errors.New("subnet name is required")
+	}
+
+	if s.VLAN.Valid && (s.VLAN.Int64 < 1 || s.VLAN.Int64 > 4094) {
+		return fmt.Errorf("VLAN %d is not between 1 and 4094", s.VLAN.Int64)
+	}
+
+	s.CIDR.SetValid(subnet.String())
+	s.Name.SetValid(strings.TrimSpace(s.Name.String))
+	return nil
+}
+
+// Size returns the number of host addresses in the subnet.
+func (s Subnet) Size() int {
+	_, subnet, err := net.ParseCIDR(s.CIDR.String)
+	if err != nil {
+		return 0
+	}
+
+	ones, bits := subnet.Mask.Size()
+	size := 1 << uint(bits-ones)
+	if bits-ones >= 2 {
+		// Network and broadcast address.
+		size -= 2
+	}
+	return size
+}
+
+// Utilisation returns the share of the host addresses in use, in percent.
+func (s Subnet) Utilisation() float64 {
+	if s.Size() == 0 {
+		return 0
+	}
+	return float64(s.Addresses) * 100 / float64(s.Size())
+}
+
+// MatchSubnet returns the most specific of the subnets containing one of
+// the addresses, nil when there is none.
+func MatchSubnet(subnets []Subnet, ips []net.IP) *Subnet {
+	var (
+		best *Subnet
+		size = -1
+	)
+
+	for i := range subnets {
+		_, subnet, err := net.ParseCIDR(subnets[i].CIDR.String)
+		if err != nil {
+			continue
+		}
+		ones, _ := subnet.Mask.Size()
+		if ones <= size {
+			continue
+		}
+		for _, ip := range ips {
+			if subnet.Contains(ip) {
+				best, size = &subnets[i], ones
+				break
+			}
+		}
+	}
+	return best
+}
+
+type SubnetRepository interface {
+	Install(context.Context) error
+	Select(context.Context, int) (*Subnet, error)
+	Create(context.Context, *Subnet) (int64, error)
+	Delete(context.Context, int) error
+	List(context.Context) ([]Subnet, error)
+	Usage(context.Context, time.Time) ([]Subnet, error)
+}
+
+type subnetRepository struct {
+	db *sqlx.DB
+}
+
+func NewSubnetRepository(db *sqlx.DB) SubnetRepository {
+	return &subnetRepository{
+		db: db,
+	}
+}
+
+func (r *subnetRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE subnets (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "deleted" TEXT,
+            "cidr" TEXT NOT NULL,
+            "name" TEXT NOT NULL,
+            "vlan" INTEGER,
+            "location_id" INTEGER
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *subnetRepository) Select(ctx context.Context, id int) (*Subnet, error) {
+	defer observeQuery("subnet", "Select", time.Now())
+
+	data := Subnet{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            cidr,
+            name,
+            vlan,
+            location_id
+        FROM subnets
+        WHERE id=?
+        AND deleted IS NULL`),
+		id,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *subnetRepository) Create(ctx context.Context, data *Subnet) (int64, error) {
+	defer observeQuery("subnet", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO subnets (
+            created,
+            cidr,
+            name,
+            vlan,
+            location_id
+        ) VALUES (?,?,?,?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.CIDR,
+		data.Name,
+		data.VLAN,
+		data.LocationID,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+// Delete removes the subnet and unmaps the adapters in it.
+func (r *subnetRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("subnet", "Delete", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		tx.Rebind(`UPDATE subnets SET
+            deleted=?
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`UPDATE computer_network_adapters SET subnet_id=NULL WHERE subnet_id=?`), id)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *subnetRepository) List(ctx context.Context) ([]Subnet, error) {
+	defer observeQuery("subnet", "List", time.Now())
+
+	data := []Subnet{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            cidr,
+            name,
+            vlan,
+            location_id
+        FROM subnets
+        WHERE deleted IS NULL`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sortSubnets(data), nil
+}
+
+// Usage returns every subnet with the number of computers and addresses
+// seen in it, computers that reported since active count as active.
+func (r *subnetRepository) Usage(ctx context.Context, active time.Time) ([]Subnet, error) {
+	defer observeQuery("subnet", "Usage", time.Now())
+
+	data := []Subnet{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            s.id,
+            s.created,
+            s.cidr,
+            s.name,
+            s.vlan,
+            l.id AS location_id,
+            l.name AS location_name,
+            (SELECT COUNT(DISTINCT na.computer_id)
+                FROM computer_network_adapters na
+                JOIN computers c ON c.id = na.computer_id
+                WHERE na.subnet_id = s.id
+                AND na.deleted IS NULL
+                AND c.deleted IS NULL) AS computers,
+            (SELECT COUNT(DISTINCT na.computer_id)
+                FROM computer_network_adapters na
+                JOIN computers c ON c.id = na.computer_id
+                WHERE na.subnet_id = s.id
+                AND na.deleted IS NULL
+                AND c.deleted IS NULL
+                AND COALESCE(c.updated, c.created) >= ?) AS active,
+            (SELECT COUNT(*)
+                FROM computer_network_adapters na
+                JOIN computers c ON c.id = na.computer_id
+                WHERE na.subnet_id = s.id
+                AND na.deleted IS NULL
+                AND c.deleted IS NULL) AS addresses
+        FROM subnets s
+        LEFT JOIN locations l ON l.id = s.location_id AND l.deleted IS NULL
+        WHERE s.deleted IS NULL`),
+		active.Format("2006-01-02 15:04:05"),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return sortSubnets(data), nil
+}
+
+// sortSubnets orders subnets by network address, larger ranges first.
+func sortSubnets(list []Subnet) []Subnet {
+	key := func(s Subnet) []byte {
+		_, subnet, err := net.ParseCIDR(s.CIDR.String)
+		if err != nil {
+			return nil
+		}
+		ones, _ := subnet.Mask.Size()
+		return append([]byte(subnet.IP.To4()), byte(ones))
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return bytes.Compare(key(list[i]), key(list[j])) < 0
+	})
+	return list
+}
@@ -0,0 +1,378 @@
+package computer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+type tagController struct {
+	log          lumber.Logger
+	computerRepo ComputerRepository
+	tagRepo      TagRepository
+	locationRepo LocationRepository
+	ruleRepo     RuleRepository
+	assigner     *Assigner
+	lock         sync.Locker
+}
+
+type TagController interface {
+	Page(http.ResponseWriter, *http.Request)
+	CreateTag(http.ResponseWriter, *http.Request)
+	DeleteTag(http.ResponseWriter, *http.Request)
+	CreateLocation(http.ResponseWriter, *http.Request)
+	DeleteLocation(http.ResponseWriter, *http.Request)
+	CreateRule(http.ResponseWriter, *http.Request)
+	DeleteRule(http.ResponseWriter, *http.Request)
+	AssignTag(http.ResponseWriter, *http.Request)
+	UnassignTag(http.ResponseWriter, *http.Request)
+	AssignLocation(http.ResponseWriter, *http.Request)
+	UnassignLocation(http.ResponseWriter, *http.Request)
+}
+
+// NewTagController registers the page managing tags, locations and rules
+// at /computers/tags and the forms assigning them on the computer detail
+// page. Tags and locations are assigned by the helpdesk role, changing the
+// tags, locations and rules themselves takes the admin role.
+func NewTagController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) TagController {
+	c := &tagController{
+		log:          log,
+		computerRepo: NewComputerRepository(db),
+		tagRepo:      NewTagRepository(db),
+		locationRepo: NewLocationRepository(db),
+		ruleRepo:     NewRuleRepository(db),
+		assigner:     NewAssigner(db),
+		lock:         writeLock(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+	helpdesk := alice.New(m...).Append(guard(account.RoleHelpdesk))
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
+
+	r := router.PathPrefix("/computers").Subrouter()
+	r.Handle("/tags", view.ThenFunc(c.Page)).Methods("GET").Name("tags")
+	r.Handle("/tags", admin.ThenFunc(c.CreateTag)).Methods("POST").Name("tag_create")
+	r.Handle("/tags/{id:[0-9]+}/delete", admin.ThenFunc(c.DeleteTag)).Methods("POST").Name("tag_delete")
+	r.Handle("/locations", admin.ThenFunc(c.CreateLocation)).Methods("POST").Name("location_create")
+	r.Handle("/locations/{id:[0-9]+}/delete", admin.ThenFunc(c.DeleteLocation)).Methods("POST").Name("location_delete")
+	r.Handle("/rules", admin.ThenFunc(c.CreateRule)).Methods("POST").Name("rule_create")
+	r.Handle("/rules/{id:[0-9]+}/delete", admin.ThenFunc(c.DeleteRule)).Methods("POST").Name("rule_delete")
+	r.Handle("/{id:[0-9]+}/tags", helpdesk.ThenFunc(c.AssignTag)).Methods("POST").Name("tag_assign")
+	r.Handle("/{id:[0-9]+}/tags/{tag:[0-9]+}/delete", helpdesk.ThenFunc(c.UnassignTag)).Methods("POST").Name("tag_unassign")
+	r.Handle("/{id:[0-9]+}/locations", helpdesk.ThenFunc(c.AssignLocation)).Methods("POST").Name("location_assign")
+	r.Handle("/{id:[0-9]+}/locations/{location:[0-9]+}/delete", helpdesk.ThenFunc(c.UnassignLocation)).Methods("POST").Name("location_unassign")
+
+	return c
+}
+
+// tagPageData is rendered by tagPage.
+type tagPageData struct {
+	Title     string
+	Error     string
+	Tags      []Tag
+	Locations []Location
+	Rules     []Rule
+	Account   *account.Account
+	CSRF      string
+}
+
+func (c *tagController) Page(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, http.StatusOK, "")
+}
+
+func (c *tagController) CreateTag(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		c.render(w, r, http.StatusUnprocessableEntity, "Tag name is required.")
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	existing, err := c.tagRepo.SelectWithName(r.Context(), name)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	if existing != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("Tag %q already exists.", name))
+		return
+	}
+
+	if _, err = c.tagRepo.Create(r.Context(), &Tag{Name: null.StringFrom(name)}); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/tags", http.StatusSeeOther)
+}
+
+func (c *tagController) DeleteTag(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.tagRepo.Delete(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/tags", http.StatusSeeOther)
+}
+
+func (c *tagController) CreateLocation(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		c.render(w, r, http.StatusUnprocessableEntity, "Location name is required.")
+		return
+	}
+
+	loc := &Location{Name: null.StringFrom(name)}
+
+	if v := r.FormValue("parent"); v != "" {
+		parent, err := c.selectLocation(r, v)
+		if err != nil {
+			c.failed(w, r, err)
+			return
+		}
+		if parent == nil {
+			c.render(w, r, http.StatusUnprocessableEntity, "Parent location does not exist.")
+			return
+		}
+		loc.ParentID = parent.ID
+	}
+
+	c.lock.Lock()
+	_, err := c.locationRepo.Create(r.Context(), loc)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/tags", http.StatusSeeOther)
+}
+
+func (c *tagController) DeleteLocation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.locationRepo.Delete(r.Context(), id)
+	c.lock.Unlock()
+
+	if errors.Is(err, ErrLocationInUse) {
+		c.render(w, r, http.StatusConflict, "Delete the locations below it first.")
+		return
+	}
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/tags", http.StatusSeeOther)
+}
+
+// CreateRule adds a rule and applies the rules to every computer.
+func (c *tagController) CreateRule(w http.ResponseWriter, r *http.Request) {
+	rule := &Rule{
+		Kind:    null.StringFrom(r.FormValue("kind")),
+		Pattern: null.StringFrom(r.FormValue("pattern")),
+	}
+
+	if v := r.FormValue("tag"); v != "" {
+		id, _ := strconv.Atoi(v)
+		tag, err := c.tagRepo.Select(r.Context(), id)
+		if err != nil {
+			c.failed(w, r, err)
+			return
+		}
+		if tag == nil {
+			c.render(w, r, http.StatusUnprocessableEntity, "Tag does not exist.")
+			return
+		}
+		rule.TagID = tag.ID
+	}
+
+	if v := r.FormValue("location"); v != "" {
+		loc, err := c.selectLocation(r, v)
+		if err != nil {
+			c.failed(w, r, err)
+			return
+		}
+		if loc == nil {
+			c.render(w, r, http.StatusUnprocessableEntity, "Location does not exist.")
+			return
+		}
+		rule.LocationID = loc.ID
+	}
+
+	if err := rule.Validate(); err != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	c.lock.Lock()
+	_, err := c.ruleRepo.Create(r.Context(), rule)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.applyAll(w, r)
+}
+
+// DeleteRule removes a rule and the links it made.
+func (c *tagController) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.ruleRepo.Delete(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	c.applyAll(w, r)
+}
+
+func (c *tagController) applyAll(w http.ResponseWriter, r *http.Request) {
+	n, err := c.assigner.ApplyAll(r.Context())
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	requestlog.Set(r.Context(), "computers", n)
+	http.Redirect(w, r, "/computers/tags", http.StatusSeeOther)
+}
+
+func (c *tagController) AssignTag(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.FormValue("tag"))
+
+	tag, err := c.tagRepo.Select(r.Context(), id)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	if tag == nil {
+		http.Error(w, "tag does not exist", http.StatusUnprocessableEntity)
+		return
+	}
+
+	c.link(w, r, func(compID int64) error {
+		return c.tagRepo.Assign(r.Context(), compID, tag.ID.Int64, LinkManual)
+	})
+}
+
+func (c *tagController) UnassignTag(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["tag"], 10, 64)
+
+	c.link(w, r, func(compID int64) error {
+		return c.tagRepo.Unassign(r.Context(), compID, id)
+	})
+}
+
+func (c *tagController) AssignLocation(w http.ResponseWriter, r *http.Request) {
+	loc, err := c.selectLocation(r, r.FormValue("location"))
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	if loc == nil {
+		http.Error(w, "location does not exist", http.StatusUnprocessableEntity)
+		return
+	}
+
+	c.link(w, r, func(compID int64) error {
+		return c.locationRepo.Assign(r.Context(), compID, loc.ID.Int64, LinkManual)
+	})
+}
+
+func (c *tagController) UnassignLocation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.ParseInt(mux.Vars(r)["location"], 10, 64)
+
+	c.link(w, r, func(compID int64) error {
+		return c.locationRepo.Unassign(r.Context(), compID, id)
+	})
+}
+
+// link runs change for the computer named in the path and returns to its
+// detail page. A removed rule link comes back on the next report.
+func (c *tagController) link(w http.ResponseWriter, r *http.Request, change func(int64) error) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	comp, err := c.computerRepo.SelectWithID(r.Context(), id)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	if comp == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	c.lock.Lock()
+	err = change(comp.ID.Int64)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/computers/%d", comp.ID.Int64), http.StatusSeeOther)
+}
+
+func (c *tagController) selectLocation(r *http.Request, v string) (*Location, error) {
+	id, err := strconv.Atoi(v)
+	if err != nil {
+		return nil, nil
+	}
+	return c.locationRepo.Select(r.Context(), id)
+}
+
+func (c *tagController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *tagController) render(w http.ResponseWriter, r *http.Request, status int, message string) {
+	data := &tagPageData{
+		Title:   "Tags & Locations",
+		Error:   message,
+		Account: AccountFromContext(r.Context()),
+		CSRF:    CSRFFromContext(r.Context()),
+	}
+
+	var err error
+	if data.Tags, err = c.tagRepo.List(r.Context()); err == nil {
+		if data.Locations, err = c.locationRepo.List(r.Context()); err == nil {
+			data.Rules, err = c.ruleRepo.List(r.Context())
+		}
+	}
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := tagPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
@@ -0,0 +1,357 @@
+package computer
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Link sources, links created by assignment rules and subnets are
+// recalculated on every report while manual links are only removed by an
+// administrator.
+const (
+	LinkManual = "manual"
+	LinkRule   = "rule"
+	LinkSubnet = "subnet"
+)
+
+type Tag struct {
+	ID      null.Int    `db:"id" json:"id"`
+	Created null.String `db:"created" json:"-"`
+	Deleted null.String `db:"deleted" json:"-"`
+
+	Name null.String `db:"name" json:"name"`
+
+	// ComputerID and Source are only set by ListForComputers.
+	ComputerID null.Int    `db:"computer_id" json:"-"`
+	Source     null.String `db:"source" json:"source,omitempty"`
+}
+
+type TagRepository interface {
+	Install(context.Context) error
+	Select(context.Context, int) (*Tag, error)
+	SelectWithName(context.Context, string) (*Tag, error)
+	Create(context.Context, *Tag) (int64, error)
+	Delete(context.Context, int) error
+	List(context.Context) ([]Tag, error)
+
+	ListForComputers(context.Context, []int64) (map[int64][]Tag, error)
+	Assign(ctx context.Context, computerID int64, tagID int64, source string) error
+	Unassign(ctx context.Context, computerID int64, tagID int64) error
+	ClearRules(ctx context.Context, computerID int64) error
+}
+
+type tagRepository struct {
+	db *sqlx.DB
+}
+
+func NewTagRepository(db *sqlx.DB) TagRepository {
+	return &tagRepository{
+		db: db,
+	}
+}
+
+func (r *tagRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE tags (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "deleted" TEXT,
+            "name" TEXT NOT NULL
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(
+		ctx,
+		`CREATE TABLE computer_tags (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "computer_id" INTEGER NOT NULL,
+            "tag_id" INTEGER NOT NULL,
+            "source" TEXT NOT NULL`+d.ForeignKey("computer_id", "computers")+d.ForeignKey("tag_id", "tags")+`
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *tagRepository) Select(ctx context.Context, id int) (*Tag, error) {
+	defer observeQuery("tag", "Select", time.Now())
+
+	data := Tag{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            name
+        FROM tags
+        WHERE id=?
+        AND deleted IS NULL`),
+		id,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+// SelectWithName returns the tag called name, ignoring case.
+func (r *tagRepository) SelectWithName(ctx context.Context, name string) (*Tag, error) {
+	defer observeQuery("tag", "SelectWithName", time.Now())
+
+	data := Tag{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            name
+        FROM tags
+        WHERE LOWER(name)=LOWER(?)
+        AND deleted IS NULL`),
+		name,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *tagRepository) Create(ctx context.Context, data *Tag) (int64, error) {
+	defer observeQuery("tag", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO tags (
+            created,
+            name
+        ) VALUES (?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.Name,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+// Delete removes the tag and its links to computers.
+func (r *tagRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("tag", "Delete", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		tx.Rebind(`UPDATE tags SET
+            deleted=?
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`DELETE FROM computer_tags WHERE tag_id=?`), id)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *tagRepository) List(ctx context.Context) ([]Tag, error) {
+	defer observeQuery("tag", "List", time.Now())
+
+	data := []Tag{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            deleted,
+            name
+        FROM tags
+        WHERE deleted IS NULL
+        ORDER BY name`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// ListForComputers returns the tags of each of the given computers.
+func (r *tagRepository) ListForComputers(ctx context.Context, ids []int64) (map[int64][]Tag, error) {
+	defer observeQuery("tag", "ListForComputers", time.Now())
+
+	result := make(map[int64][]Tag)
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	query, args, err := sqlx.In(`SELECT
+            t.id,
+            t.name,
+            ct.computer_id,
+            ct.source
+        FROM computer_tags ct
+        JOIN tags t ON t.id = ct.tag_id
+        WHERE ct.computer_id IN (?)
+        AND t.deleted IS NULL
+        ORDER BY t.name`, ids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data := []Tag{}
+	if err = r.db.SelectContext(ctx, &data, r.db.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	for _, t := range data {
+		result[t.ComputerID.Int64] = append(result[t.ComputerID.Int64], t)
+	}
+	return result, nil
+}
+
+// Assign links a tag to a computer. A manual assignment replaces a link
+// made by a rule, a rule never replaces a manual one.
+func (r *tagRepository) Assign(ctx context.Context, computerID int64, tagID int64, source string) error {
+	defer observeQuery("tag", "Assign", time.Now())
+
+	return assignLink(ctx, r.db, "computer_tags", "tag_id", computerID, tagID, source)
+}
+
+func (r *tagRepository) Unassign(ctx context.Context, computerID int64, tagID int64) error {
+	defer observeQuery("tag", "Unassign", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`DELETE FROM computer_tags WHERE computer_id=? AND tag_id=?`),
+		computerID,
+		tagID,
+	)
+	return err
+}
+
+// ClearRules removes the tags of a computer that were not assigned
+// manually.
+func (r *tagRepository) ClearRules(ctx context.Context, computerID int64) error {
+	defer observeQuery("tag", "ClearRules", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`DELETE FROM computer_tags WHERE computer_id=? AND source<>?`),
+		computerID,
+		LinkManual,
+	)
+	return err
+}
+
+// assignLink inserts the link between a computer and the row id references
+// in a link table unless it exists, see TagRepository.Assign.
+func assignLink(ctx context.Context, db *sqlx.DB, table string, column string, computerID int64, id int64, source string) error {
+	tx, err := db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return err
+	}
+
+	var existing []string
+	err = tx.SelectContext(
+		ctx,
+		&existing,
+		tx.Rebind(`SELECT source FROM `+table+` WHERE computer_id=? AND `+column+`=?`),
+		computerID,
+		id,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	switch {
+	case len(existing) == 0:
+		_, err = tx.ExecContext(
+			ctx,
+			tx.Rebind(`INSERT INTO `+table+` (created, computer_id, `+column+`, source) VALUES (?,?,?,?)`),
+			time.Now().Format("2006-01-02 15:04:05"),
+			computerID,
+			id,
+			source,
+		)
+	case source == LinkManual && existing[0] != LinkManual:
+		_, err = tx.ExecContext(
+			ctx,
+			tx.Rebind(`UPDATE `+table+` SET source=? WHERE computer_id=? AND `+column+`=?`),
+			source,
+			computerID,
+			id,
+		)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
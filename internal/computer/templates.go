@@ -1,8 +1,562 @@
 package computer
 
-import "html/template"
+import (
+	"fmt"
+	"html/template"
+)
 
+// navTabs is the navigation shared by the list views and the dashboard,
+// .View names the active tab.
+const navTabs = `<<define "nav">>
+					<ul class="nav nav-tabs my-3">
+						<li class="nav-item"><a class="nav-link <<if eq .View "dashboard">>active<<end>>" href="/computers/dashboard">Dashboard</a></li>
+						<li class="nav-item"><a class="nav-link <<if eq .View "list">>active<<end>>" href="/computers/list">Computers</a></li>
+						<li class="nav-item"><a class="nav-link <<if eq .View "users">>active<<end>>" href="/computers/users">Users</a></li>
+						<li class="nav-item"><a class="nav-link <<if eq .View "adapters">>active<<end>>" href="/computers/adapters">Network Adapters</a></li>
+						<li class="nav-item"><a class="nav-link <<if eq .View "stale">>active<<end>>" href="/computers/stale">Stale</a></li>
+						<li class="nav-item"><a class="nav-link <<if eq .View "checkins">>active<<end>>" href="/computers/checkins">Check-ins</a></li>
+						<li class="nav-item"><a class="nav-link <<if eq .View "versions">>active<<end>>" href="/computers/versions">Agent Versions</a></li>
+						<li class="nav-item"><a class="nav-link" href="/computers/subnets">Subnets</a></li>
+						<li class="nav-item"><a class="nav-link" href="/computers/tags">Tags &amp; Locations</a></li>
+						<li class="nav-item"><a class="nav-link" href="/computers/fields">Custom Fields</a></li>
+						<li class="nav-item"><a class="nav-link" href="/computers/duplicates">Duplicates</a></li>
+						<li class="nav-item"><a class="nav-link" href="/computers/alerts">Alerts</a></li>
+						<<if .Account.Allows "admin">>
+							<li class="nav-item"><a class="nav-link" href="/computers/keys">API Keys</a></li>
+							<li class="nav-item"><a class="nav-link" href="/computers/webhooks">Webhooks</a></li>
+							<li class="nav-item"><a class="nav-link" href="/computers/agent-config">Agent Config</a></li>
+						<<end>>
+						<li class="nav-item ml-auto">
+							<form class="form-inline" method="GET" action="/computers/search">
+								<input class="form-control form-control-sm" type="search" name="q" placeholder="Host, user, MAC or IP" />
+							</form>
+						</li>
+						<<if .Account>>
+							<<if .Account.ID.Valid>>
+								<li class="nav-item"><a class="nav-link" href="/computers/notifications">Notifications</a></li>
+							<<end>>
+							<li class="nav-item">
+								<form class="form-inline ml-2" method="POST" action="/logout">
+									<span class="small text-muted mr-2"><< .Account.Username.String >> (<< .Account.Role.String >>)</span>
+									<button class="btn btn-sm btn-outline-secondary" type="submit">Sign Out</button>
+								</form>
+							</li>
+						<<end>>
+					</ul>
+<<end>>`
+
+// layout is the page shared by the list views. Every view defines the
+// "table" template rendering its records.
+func layout() *template.Template {
+	t := template.Must(template.New("page").Delims("<<", ">>").Parse(navTabs))
+	return template.Must(t.Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<<template "nav" .>>
+
+					<div class="d-flex justify-content-between mb-3">
+						<form class="form-inline" method="GET" action="/computers/<<.View>>">
+							<input class="form-control mr-2" type="search" name="q" value="<<.Filter.Search>>" placeholder="Search" />
+							<<if or (eq .View "list") (eq .View "stale")>>
+								<select class="form-control mr-2" name="tag">
+									<option value="">All tags</option>
+									<<range .Tags>>
+										<option value="<< .Name.String >>" <<if eq .Name.String $.Filter.Tag>>selected<<end>>><< .Name.String >></option>
+									<<end>>
+								</select>
+								<select class="form-control mr-2" name="location">
+									<option value="">All locations</option>
+									<<range .Locations>>
+										<option value="<< .ID.Int64 >>" <<if eq .ID.Int64 $.Filter.Location>>selected<<end>>><< .Path >></option>
+									<<end>>
+								</select>
+							<<end>>
+							<<if .Filter.Subnet>>
+								<input type="hidden" name="subnet" value="<<.Filter.Subnet>>" />
+							<<end>>
+							<<if eq .View "stale">>
+								<input class="form-control mr-2" type="number" min="1" name="days" value="<<.Filter.Days>>" title="Days without a report" />
+							<<end>>
+							<button class="btn btn-primary" type="submit">Filter</button>
+						</form>
+						<div>
+							<a class="btn btn-secondary" href="/computers/export/<<.View>>.csv?q=<<.Filter.Search>>&days=<<.Filter.Days>>&tag=<<.Filter.Tag>>&location=<<.Filter.Location>>&subnet=<<.Filter.Subnet>>">Download CSV</a>
+							<a class="btn btn-secondary" href="/computers/export/<<.View>>.xlsx?q=<<.Filter.Search>>&days=<<.Filter.Days>>&tag=<<.Filter.Tag>>&location=<<.Filter.Location>>&subnet=<<.Filter.Subnet>>">Download XLSX</a>
+						</div>
+					</div>
+
+					<div id="live-notice" class="alert alert-info d-none"></div>
+
+					<<template "table" .>>
+
+					<nav>
+						<ul class="pagination">
+							<<if .Prev>>
+								<li class="page-item"><a class="page-link" href="/computers/<<.View>>?q=<<.Filter.Search>>&days=<<.Filter.Days>>&tag=<<.Filter.Tag>>&location=<<.Filter.Location>>&subnet=<<.Filter.Subnet>>&page=<<.Prev>>">Previous</a></li>
+							<<end>>
+							<<if .Next>>
+								<li class="page-item"><a class="page-link" href="/computers/<<.View>>?q=<<.Filter.Search>>&days=<<.Filter.Days>>&tag=<<.Filter.Tag>>&location=<<.Filter.Location>>&subnet=<<.Filter.Subnet>>&page=<<.Next>>">Next</a></li>
+							<<end>>
+						</ul>
+					</nav>
+				</div>
+				<<block "script" .>><<end>>
+			</body>
+		</html>
+	`))
+}
+
+func computerListPage() *template.Template {
+	return template.Must(layout().Parse(`
+		<<define "table">>
+			<table class="table table-dark">
+				<thead>
+					<tr>
+						<th scope="col">ComputerName</th>
+						<th scope="col">Last Seen</th>
+						<th scope="col">Last User</th>
+						<th scope="col">Adapters</th>
+						<th scope="col">Tags</th>
+						<th scope="col">Locations</th>
+						<th scope="col">Created</th>
+					</tr>
+				</thead>
+				<tbody>
+					<<range .Records>>
+						<tr data-computer="<< .ID.Int64 >>">
+							<td><a href="/computers/<< .ID.Int64 >>"><< .Name.String >></a></td>
+							<td class="last-seen"><< .LastSeen.String >></td>
+							<td class="last-user"><< .LastUser.String >></td>
+							<td><< .Adapters >></td>
+							<td><<range .Tags>><span class="badge badge-info mr-1"><< .Name.String >></span><<end>></td>
+							<td><<range .Locations>><div><< .Path >></div><<end>></td>
+							<td><< .Created.String >></td>
+						</tr>
+					<<end>>
+				</tbody>
+			</table>
+		<<end>>
+		<<define "script">>
+			<script src="/jquery"></script>
+			<script>
+				// Rows of computers reporting while the page is open are
+				// updated from the event stream, other changes ask for a
+				// reload.
+				$(function () {
+					if (!window.EventSource) {
+						return;
+					}
+					var notice = $("#live-notice");
+					var reload = function (text) {
+						notice.empty().append(document.createTextNode(text + " "))
+							.append($("<a>").attr("href", window.location.href).text("Reload"))
+							.removeClass("d-none");
+					};
+					var source = new EventSource("/computers/events");
+
+					source.addEventListener("computer.reported", function (e) {
+						var event = JSON.parse(e.data);
+						var row = $('tr[data-computer="' + event.computer.id + '"]');
+						if (!row.length) {
+							return;
+						}
+						row.find(".last-seen").text(event.computer.updated || event.computer.created || "");
+						if (event.user) {
+							row.find(".last-user").text(event.user.username || "");
+						}
+						row.addClass("table-active");
+						setTimeout(function () { row.removeClass("table-active"); }, 2000);
+					});
+					source.addEventListener("computer.created", function (e) {
+						reload("New computer " + JSON.parse(e.data).computer.name + " reported.");
+					});
+					source.addEventListener("adapter.added", function () {
+						reload("Network adapters changed.");
+					});
+					source.addEventListener("adapter.removed", function () {
+						reload("Network adapters changed.");
+					});
+					source.addEventListener("resync", function () {
+						reload("Some updates were missed.");
+					});
+				});
+			</script>
+		<<end>>
+	`))
+}
+
+// editorPage lists the users seen on each computer.
 func editorPage() *template.Template {
+	return template.Must(layout().Parse(`
+		<<define "table">>
+			<table class="table table-dark">
+				<thead>
+					<tr>
+						<th scope="col">#</th>
+						<th scope="col">Date</th>
+						<th scope="col">ComputerName</th>
+						<th scope="col">Username</th>
+					</tr>
+				</thead>
+				<tbody>
+					<<range .Records>>
+						<tr>
+							<td>
+								<< .ID.Int64 >>
+							</td>
+							<td>
+								<< .Created.String >>
+							</td>
+							<td>
+								<< .ComputerName.String >>
+							</td>
+							<td>
+								<< .Username.String >>
+							</td>
+						</tr>
+					<<end>>
+				</tbody>
+			</table>
+		<<end>>
+	`))
+}
+
+func adapterListPage() *template.Template {
+	return template.Must(layout().Parse(`
+		<<define "table">>
+			<table class="table table-dark">
+				<thead>
+					<tr>
+						<th scope="col">ComputerName</th>
+						<th scope="col">Adapter</th>
+						<th scope="col">MAC Address</th>
+						<th scope="col">IP Address</th>
+						<th scope="col">Updated</th>
+					</tr>
+				</thead>
+				<tbody>
+					<<range .Records>>
+						<tr>
+							<td><< .ComputerName.String >></td>
+							<td><< .Name.String >></td>
+							<td><< .MacAddress.String >></td>
+							<td><< .IPAddress.String >></td>
+							<td><<if .Updated.Valid>><< .Updated.String >><<else>><< .Created.String >><<end>></td>
+						</tr>
+					<<end>>
+				</tbody>
+			</table>
+		<<end>>
+	`))
+}
+
+func importPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+
+					<<with .Result>>
+						<div class="alert alert-success">
+							<<if .DryRun>>Dry run, nothing was written. Would have created<<else>>Created<<end>>
+							<< .Created >> computers, updated << .Updated >> and imported << .Adapters >> network adapters.
+							<<if .Skipped>><< .Skipped >> adapters were skipped because the agent already reported them.<<end>>
+						</div>
+					<<end>>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<<if .Errors>>
+						<div class="alert alert-danger">
+							The file was not imported:
+							<ul>
+								<<range .Errors>>
+									<li>Line << .Line >>: << .Message >></li>
+								<<end>>
+							</ul>
+						</div>
+					<<end>>
+
+					<form method="POST" action="/computers/import" enctype="multipart/form-data">
+						<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+						<div class="form-group">
+							<label for="file">CSV or JSON file</label>
+							<input class="form-control-file" type="file" id="file" name="file" accept=".csv,.json" required />
+							<small class="form-text text-muted">
+								CSV files need a header row with the columns name, owner, location, adapter, mac and ip,
+								list a computer on one row per network adapter.
+							</small>
+						</div>
+						<div class="form-group">
+							<label for="format">Format</label>
+							<select class="form-control" id="format" name="format">
+								<option value="">From file extension</option>
+								<option value="csv">CSV</option>
+								<option value="json">JSON</option>
+							</select>
+						</div>
+						<div class="form-check mb-3">
+							<input class="form-check-input" type="checkbox" id="dry_run" name="dry_run" value="1" checked />
+							<label class="form-check-label" for="dry_run">Dry run, only validate and report the changes</label>
+						</div>
+						<button class="btn btn-primary" type="submit">Import</button>
+					</form>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// detailPage shows a single computer, tags and locations are assigned
+// manually from it.
+func detailPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Computer.Name.String>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Computer.Name.String>></h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<div id="live-notice" class="alert alert-info d-none"></div>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<dl class="row">
+						<dt class="col-sm-3">Last Seen</dt>
+						<dd class="col-sm-9"><<if .Computer.Updated.Valid>><<.Computer.Updated.String>><<else>><<.Computer.Created.String>><<end>></dd>
+						<dt class="col-sm-3">Source</dt>
+						<dd class="col-sm-9"><<.Computer.Source.String>></dd>
+						<<if .Computer.AgentVersion.Valid>>
+							<dt class="col-sm-3">Agent</dt>
+							<dd class="col-sm-9">
+								<< .Computer.AgentVersion.String >><<if .Computer.AgentCommit.String>> (<< .Computer.AgentCommit.String >>)<<end>>
+								on << .Computer.OS.String >>/<< .Computer.Arch.String >>
+							</dd>
+						<<end>>
+						<<with .Checkins>>
+							<dt class="col-sm-3">Check-ins</dt>
+							<dd class="col-sm-9">
+								<svg class="sparkline mr-2" width="<< .Width >>" height="<< .Height >>" viewBox="0 -1 << .Width >> << .Height >>">
+									<title><< .Total >> check-ins in << len .Days >> days</title>
+									<polyline fill="none" stroke="currentColor" stroke-width="1.5" points="<< .Points >>" />
+								</svg>
+								<small class="text-muted"><< .Total >> in << len .Days >> days</small>
+							</dd>
+						<<end>>
+					</dl>
+
+					<h2>Details</h2>
+					<<if .Account.Allows "helpdesk">>
+						<form class="mb-4" method="POST" action="/computers/<<.Computer.ID.Int64>>/details">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<div class="form-group">
+								<label for="owner">Owner</label>
+								<input class="form-control" type="text" id="owner" name="owner" value="<<.Computer.Owner.String>>" />
+							</div>
+							<div class="form-group">
+								<label for="location">Location</label>
+								<input class="form-control" type="text" id="location" name="location" value="<<.Computer.Location.String>>" />
+							</div>
+							<<range .Computer.Fields>>
+								<div class="form-group">
+									<label for="field_<< .Key.String >>"><< .Label.String >></label>
+									<<if eq .Kind.String "enum">>
+										<select class="form-control" id="field_<< .Key.String >>" name="field_<< .Key.String >>">
+											<option value=""></option>
+											<<$value := .Value.String>>
+											<<range .Choices>><option <<if eq . $value>>selected<<end>>><< . >></option><<end>>
+										</select>
+									<<else if eq .Kind.String "number">>
+										<input class="form-control" type="number" step="any" id="field_<< .Key.String >>" name="field_<< .Key.String >>" value="<< .Value.String >>" />
+									<<else if eq .Kind.String "date">>
+										<input class="form-control" type="date" id="field_<< .Key.String >>" name="field_<< .Key.String >>" value="<< .Value.String >>" />
+									<<else>>
+										<input class="form-control" type="text" id="field_<< .Key.String >>" name="field_<< .Key.String >>" value="<< .Value.String >>" />
+									<<end>>
+								</div>
+							<<end>>
+							<div class="form-group">
+								<label for="notes">Notes</label>
+								<textarea class="form-control" id="notes" name="notes" rows="4"><<.Computer.Notes.String>></textarea>
+							</div>
+							<div class="form-inline">
+								<button class="btn btn-primary" type="submit">Save Details</button>
+							</div>
+						</form>
+					<<else>>
+						<dl class="row">
+							<dt class="col-sm-3">Owner</dt>
+							<dd class="col-sm-9"><<.Computer.Owner.String>></dd>
+							<dt class="col-sm-3">Location</dt>
+							<dd class="col-sm-9"><<.Computer.Location.String>></dd>
+							<<range .Computer.Fields>>
+								<dt class="col-sm-3"><< .Label.String >></dt>
+								<dd class="col-sm-9"><< .Value.String >></dd>
+							<<end>>
+							<dt class="col-sm-3">Notes</dt>
+							<dd class="col-sm-9"><<.Computer.Notes.String>></dd>
+						</dl>
+					<<end>>
+
+					<h2>Network Adapters</h2>
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Adapter</th>
+								<th scope="col">MAC Address</th>
+								<th scope="col">IP Address</th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Computer.Adapters>>
+								<tr>
+									<td><< .Name.String >></td>
+									<td><< .MacAddress.String >></td>
+									<td><< .IPAddress.String >></td>
+								</tr>
+							<<end>>
+						</tbody>
+					</table>
+
+					<h2>Tags</h2>
+					<ul class="list-group mb-3">
+						<<range .Computer.Tags>>
+							<li class="list-group-item d-flex justify-content-between">
+								<span><< .Name.String >> <small class="text-muted"><< .Source.String >></small></span>
+								<<if $.Account.Allows "helpdesk">>
+									<form class="form-inline" method="POST" action="/computers/<<$.Computer.ID.Int64>>/tags/<<.ID.Int64>>/delete">
+										<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+										<button class="btn btn-sm btn-danger" type="submit">Remove</button>
+									</form>
+								<<end>>
+							</li>
+						<<end>>
+					</ul>
+					<<if $.Account.Allows "helpdesk">>
+						<form class="form-inline mb-4" method="POST" action="/computers/<<.Computer.ID.Int64>>/tags">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<select class="form-control mr-2" name="tag" required>
+								<<range .Tags>><option value="<< .ID.Int64 >>"><< .Name.String >></option><<end>>
+							</select>
+							<button class="btn btn-primary" type="submit">Add Tag</button>
+						</form>
+					<<end>>
+
+					<h2>Locations</h2>
+					<ul class="list-group mb-3">
+						<<range .Computer.Locations>>
+							<li class="list-group-item d-flex justify-content-between">
+								<span><< .Path >> <small class="text-muted"><< .Source.String >></small></span>
+								<<if $.Account.Allows "helpdesk">>
+									<form class="form-inline" method="POST" action="/computers/<<$.Computer.ID.Int64>>/locations/<<.ID.Int64>>/delete">
+										<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+										<button class="btn btn-sm btn-danger" type="submit">Remove</button>
+									</form>
+								<<end>>
+							</li>
+						<<end>>
+					</ul>
+					<<if $.Account.Allows "helpdesk">>
+						<form class="form-inline mb-4" method="POST" action="/computers/<<.Computer.ID.Int64>>/locations">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<select class="form-control mr-2" name="location" required>
+								<<range .Locations>><option value="<< .ID.Int64 >>"><< .Path >></option><<end>>
+							</select>
+							<button class="btn btn-primary" type="submit">Add Location</button>
+						</form>
+					<<end>>
+
+					<h2>Merged Computers</h2>
+					<ul class="list-group mb-3">
+						<<range .Computer.Merges>>
+							<li class="list-group-item">
+								<< .MergedName.String >> <small class="text-muted">merged << .Created.String >>, << .Users >> users and << .Adapters >> adapters moved</small>
+							</li>
+						<<end>>
+					</ul>
+					<<if $.Account.Allows "admin">>
+						<form class="form-inline mb-4" method="POST" action="/computers/<<.Computer.ID.Int64>>/merge">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<input class="form-control mr-2" type="number" name="duplicate" placeholder="ID of the duplicate" required />
+							<button class="btn btn-danger" type="submit">Merge Into This Computer</button>
+						</form>
+						<form class="form-inline mb-4" method="POST" action="/computers/<<.Computer.ID.Int64>>/delete" onsubmit="return confirm('Delete << .Computer.Name.String >>?');">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<button class="btn btn-outline-danger" type="submit">Delete Computer</button>
+						</form>
+					<<end>>
+				</div>
+				<script src="/jquery"></script>
+				<script>
+					// Reports of this computer while the page is open ask for
+					// a reload.
+					$(function () {
+						if (!window.EventSource) {
+							return;
+						}
+						var notice = $("#live-notice");
+						var reload = function (text) {
+							notice.empty().append(document.createTextNode(text + " "))
+								.append($("<a>").attr("href", window.location.href).text("Reload"))
+								.removeClass("d-none");
+						};
+						var source = new EventSource("/computers/events?computer=<<.Computer.ID.Int64>>");
+
+						source.addEventListener("computer.reported", function (e) {
+							var event = JSON.parse(e.data);
+							reload(event.computer.name + " reported" + (event.user ? " with user " + event.user.username : "") + ".");
+						});
+						source.addEventListener("resync", function () {
+							reload("Some updates were missed.");
+						});
+					});
+				</script>
+			</body>
+		</html>
+	`))
+}
+
+// tagPage manages tags, locations and the rules assigning them.
+func tagPage() *template.Template {
 	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
 		<!DOCTYPE html>
 		<html lang="en">
@@ -18,29 +572,384 @@ func editorPage() *template.Template {
 
 			<body>
 				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<h2>Tags</h2>
+					<ul class="list-group mb-3">
+						<<range .Tags>>
+							<li class="list-group-item d-flex justify-content-between">
+								<a href="/computers/list?tag=<< .Name.String >>"><< .Name.String >></a>
+								<<if $.Account.Allows "admin">>
+									<form class="form-inline" method="POST" action="/computers/tags/<< .ID.Int64 >>/delete">
+										<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+										<button class="btn btn-sm btn-danger" type="submit">Delete</button>
+									</form>
+								<<end>>
+							</li>
+						<<end>>
+					</ul>
+					<<if $.Account.Allows "admin">>
+						<form class="form-inline mb-4" method="POST" action="/computers/tags">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<input class="form-control mr-2" type="text" name="name" placeholder="Name" required />
+							<button class="btn btn-primary" type="submit">Create Tag</button>
+						</form>
+					<<end>>
+
+					<h2>Locations</h2>
+					<ul class="list-group mb-3">
+						<<range .Locations>>
+							<li class="list-group-item d-flex justify-content-between">
+								<a href="/computers/list?location=<< .ID.Int64 >>"><< .Path >></a>
+								<<if $.Account.Allows "admin">>
+									<form class="form-inline" method="POST" action="/computers/locations/<< .ID.Int64 >>/delete">
+										<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+										<button class="btn btn-sm btn-danger" type="submit">Delete</button>
+									</form>
+								<<end>>
+							</li>
+						<<end>>
+					</ul>
+					<<if $.Account.Allows "admin">>
+						<form class="form-inline mb-4" method="POST" action="/computers/locations">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<input class="form-control mr-2" type="text" name="name" placeholder="Name" required />
+							<select class="form-control mr-2" name="parent">
+								<option value="">No parent</option>
+								<<range .Locations>><option value="<< .ID.Int64 >>"><< .Path >></option><<end>>
+							</select>
+							<button class="btn btn-primary" type="submit">Create Location</button>
+						</form>
+					<<end>>
+
+					<h2>Rules</h2>
 					<table class="table table-dark">
 						<thead>
 							<tr>
-								<th scope="col">#</th>
-								<th scope="col">Date</th>
-								<th scope="col">ComputerName</th>
-								<th scope="col">Username</th>
+								<th scope="col">Kind</th>
+								<th scope="col">Pattern</th>
+								<th scope="col">Tag</th>
+								<th scope="col">Location</th>
+								<th scope="col"></th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Rules>>
+								<tr>
+									<td><<if eq .Kind.String "subnet">>Subnet<<else>>Hostname prefix<<end>></td>
+									<td><< .Pattern.String >></td>
+									<td><< .TagName.String >></td>
+									<td><< .LocationName.String >></td>
+									<td>
+										<<if $.Account.Allows "admin">>
+											<form class="form-inline" method="POST" action="/computers/rules/<< .ID.Int64 >>/delete">
+												<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+												<button class="btn btn-sm btn-danger" type="submit">Delete</button>
+											</form>
+										<<end>>
+									</td>
+								</tr>
+							<<end>>
+						</tbody>
+					</table>
+					<<if $.Account.Allows "admin">>
+						<form class="form-inline mb-4" method="POST" action="/computers/rules">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<select class="form-control mr-2" name="kind">
+								<option value="hostname_prefix">Hostname prefix</option>
+								<option value="subnet">Subnet</option>
+							</select>
+							<input class="form-control mr-2" type="text" name="pattern" placeholder="PC- or 10.1.0.0/16" required />
+							<select class="form-control mr-2" name="tag">
+								<option value="">No tag</option>
+								<<range .Tags>><option value="<< .ID.Int64 >>"><< .Name.String >></option><<end>>
+							</select>
+							<select class="form-control mr-2" name="location">
+								<option value="">No location</option>
+								<<range .Locations>><option value="<< .ID.Int64 >>"><< .Path >></option><<end>>
+							</select>
+							<button class="btn btn-primary" type="submit">Create Rule</button>
+						</form>
+					<<end>>
+					<p class="text-muted">
+						Rules are applied whenever a computer reports or is imported, and to every computer when a rule is
+						created or deleted. Tags and locations assigned by hand are never removed by rules.
+					</p>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// subnetPage lists the IP plan with the utilisation of every subnet.
+func subnetPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Funcs(template.FuncMap{
+		"percent": func(v float64) string { return fmt.Sprintf("%.1f%%", v) },
+	}).Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+					<div class="d-flex justify-content-between mb-3">
+						<a href="/computers/list">Back to the computer list</a>
+						<div>
+							<a class="btn btn-secondary" href="/computers/export/subnets.csv">Download CSV</a>
+							<a class="btn btn-secondary" href="/computers/export/subnets.xlsx">Download XLSX</a>
+						</div>
+					</div>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Name</th>
+								<th scope="col">Subnet</th>
+								<th scope="col">VLAN</th>
+								<th scope="col">Site</th>
+								<th scope="col">Computers</th>
+								<th scope="col">Active</th>
+								<th scope="col">Addresses</th>
+								<th scope="col">Utilisation</th>
+								<th scope="col"></th>
 							</tr>
 						</thead>
 						<tbody>
-							<<range .Records>>
+							<<range .Subnets>>
 								<tr>
-									<td></td>
+									<td><a href="/computers/list?subnet=<< .ID.Int64 >>"><< .Name.String >></a></td>
+									<td><< .CIDR.String >></td>
+									<td><<if .VLAN.Valid>><< .VLAN.Int64 >><<end>></td>
+									<td><< .LocationName.String >></td>
+									<td><< .Computers >></td>
+									<td><< .Active >></td>
+									<td><< .Addresses >> / << .Size >></td>
+									<td><< percent .Utilisation >></td>
 									<td>
-										<< .Created.String >>
+										<<if $.Account.Allows "admin">>
+											<form class="form-inline" method="POST" action="/computers/subnets/<< .ID.Int64 >>/delete">
+												<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+												<button class="btn btn-sm btn-danger" type="submit">Delete</button>
+											</form>
+										<<end>>
 									</td>
+								</tr>
+							<<end>>
+						</tbody>
+					</table>
+
+					<<if $.Account.Allows "admin">>
+						<form class="form-inline mb-4" method="POST" action="/computers/subnets">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<input class="form-control mr-2" type="text" name="name" placeholder="Name" required />
+							<input class="form-control mr-2" type="text" name="cidr" placeholder="10.1.0.0/24" required />
+							<input class="form-control mr-2" type="number" min="1" max="4094" name="vlan" placeholder="VLAN" />
+							<select class="form-control mr-2" name="location">
+								<option value="">No site</option>
+								<<range .Locations>><option value="<< .ID.Int64 >>"><< .Path >></option><<end>>
+							</select>
+							<button class="btn btn-primary" type="submit">Create Subnet</button>
+						</form>
+					<<end>>
+					<p class="text-muted">
+						Every report is mapped to the most specific subnet containing one of its addresses and the
+						computer is placed at the site of that subnet. Active counts the computers that reported recently.
+					</p>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// fieldPage manages the custom field definitions.
+func fieldPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Label</th>
+								<th scope="col">Key</th>
+								<th scope="col">Kind</th>
+								<th scope="col">Options</th>
+								<th scope="col"></th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Fields>>
+								<tr>
+									<td><< .Label.String >></td>
+									<td><< .Key.String >></td>
+									<td><< .Kind.String >></td>
+									<td><< .Options.String >></td>
 									<td>
-										<< .ComputerName.String >>
+										<<if $.Account.Allows "admin">>
+											<form class="form-inline" method="POST" action="/computers/fields/<< .ID.Int64 >>/delete">
+												<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+												<button class="btn btn-sm btn-danger" type="submit">Delete</button>
+											</form>
+										<<end>>
 									</td>
+								</tr>
+							<<end>>
+						</tbody>
+					</table>
+
+					<<if $.Account.Allows "admin">>
+						<form class="form-inline mb-4" method="POST" action="/computers/fields">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<input class="form-control mr-2" type="text" name="label" placeholder="Label" required />
+							<input class="form-control mr-2" type="text" name="key" placeholder="Key (optional)" />
+							<select class="form-control mr-2" name="kind">
+								<option value="text">Text</option>
+								<option value="number">Number</option>
+								<option value="date">Date</option>
+								<option value="enum">Enum</option>
+							</select>
+							<input class="form-control mr-2" type="text" name="options" placeholder="Enum options, comma separated" />
+							<button class="btn btn-primary" type="submit">Create Field</button>
+						</form>
+					<<end>>
+					<p class="text-muted">
+						Deleting a field removes its values from every computer. Field values are only changed by hand,
+						agent reports never overwrite them.
+					</p>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// mergePage lists the possible duplicates with forms merging them, and the
+// merges made so far.
+func mergePage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Computer</th>
+								<th scope="col">Last Seen</th>
+								<th scope="col">Computer</th>
+								<th scope="col">Last Seen</th>
+								<th scope="col">Reasons</th>
+								<th scope="col">Keep</th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Duplicates>>
+								<tr>
+									<td><a href="/computers/<< .First.ID >>"><< .First.Name >></a></td>
+									<td><< .First.LastSeen >></td>
+									<td><a href="/computers/<< .Second.ID >>"><< .Second.Name >></a></td>
+									<td><< .Second.LastSeen >></td>
+									<td><<range .Reasons>><< . >><br /><<end>></td>
 									<td>
-										<< .Username.String >>
+										<<if $.Account.Allows "admin">>
+											<form class="form-inline" method="POST" action="/computers/<< .First.ID >>/merge">
+												<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+												<input type="hidden" name="duplicate" value="<< .Second.ID >>" />
+												<button class="btn btn-sm btn-primary" type="submit">Keep << .First.Name >></button>
+											</form>
+										<<end>>
+										<<if $.Account.Allows "admin">>
+											<form class="form-inline mt-1" method="POST" action="/computers/<< .Second.ID >>/merge">
+												<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+												<input type="hidden" name="duplicate" value="<< .First.ID >>" />
+												<button class="btn btn-sm btn-primary" type="submit">Keep << .Second.Name >></button>
+											</form>
+										<<end>>
 									</td>
 								</tr>
+							<<else>>
+								<tr><td colspan="6">No possible duplicates found.</td></tr>
+							<<end>>
+						</tbody>
+					</table>
+					<p class="text-muted">
+						Merging moves the users, network adapters, tags, locations and custom field values of the
+						other computer to the one kept, and deletes the other computer. Reports under its name are
+						recorded on the computer kept.
+					</p>
+
+					<h2>Merge History</h2>
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Date</th>
+								<th scope="col">Computer</th>
+								<th scope="col">Merged</th>
+								<th scope="col">Users</th>
+								<th scope="col">Adapters</th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Merges>>
+								<tr>
+									<td><< .Created.String >></td>
+									<td><a href="/computers/<< .ComputerID.Int64 >>"><< .ComputerName.String >></a></td>
+									<td><< .MergedName.String >></td>
+									<td><< .Users >></td>
+									<td><< .Adapters >></td>
+								</tr>
 							<<end>>
 						</tbody>
 					</table>
@@ -49,3 +958,1093 @@ func editorPage() *template.Template {
 		</html>
 	`))
 }
+
+// searchPage shows the results of a search grouped by what matched.
+func searchPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title>Search</title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3">Search</h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<form class="form-inline mb-4" method="GET" action="/computers/search">
+						<input class="form-control mr-2" type="search" name="q" value="<< .Query >>" placeholder="Host, user, MAC or IP" autofocus />
+						<button class="btn btn-primary" type="submit">Search</button>
+					</form>
+
+					<<define "hits">>
+						<table class="table table-dark">
+							<thead>
+								<tr>
+									<th scope="col">Computer</th>
+									<th scope="col">Match</th>
+									<th scope="col">Last Seen</th>
+								</tr>
+							</thead>
+							<tbody>
+								<<range .>>
+									<tr>
+										<td><a href="/computers/<< .ComputerID.Int64 >>"><< .ComputerName.String >></a></td>
+										<td><< .Value.String >></td>
+										<td><< .LastSeen.String >></td>
+									</tr>
+								<<end>>
+							</tbody>
+						</table>
+					<<end>>
+
+					<<if .Query>>
+						<<if eq .Kind "name">>
+							<h2>Computers</h2>
+							<<if .Computers>><<template "hits" .Computers>><<else>><p class="text-muted">No computers found.</p><<end>>
+							<h2>Users</h2>
+							<<if .Users>><<template "hits" .Users>><<else>><p class="text-muted">No users found.</p><<end>>
+						<<else>>
+							<h2>Network Adapters <small class="text-muted"><<if eq .Kind "mac">>MAC<<else>>IP<<end>> address</small></h2>
+							<<if .Adapters>><<template "hits" .Adapters>><<else>><p class="text-muted">No network adapters found.</p><<end>>
+						<<end>>
+					<<end>>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// loginPage asks for the username and password of an account.
+func loginPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title>Sign In</title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3">Sign In</h1>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<form method="POST" action="/login">
+						<input type="hidden" name="next" value="<< .Next >>" />
+						<div class="form-group">
+							<label for="username">Username</label>
+							<input class="form-control" type="text" id="username" name="username" value="<< .Username >>" autocomplete="username" required autofocus />
+						</div>
+						<div class="form-group">
+							<label for="password">Password</label>
+							<input class="form-control" type="password" id="password" name="password" autocomplete="current-password" required />
+						</div>
+						<button class="btn btn-primary" type="submit">Sign In</button>
+					</form>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// keyPage lists the API keys with forms creating and revoking them, a key
+// just created is shown once.
+func keyPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<<if .Key>>
+						<div class="alert alert-success">
+							Copy the new key now, it is not shown again:
+							<pre class="mb-0 mt-2"><code><< .Key >></code></pre>
+						</div>
+					<<end>>
+
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Name</th>
+								<th scope="col">Key</th>
+								<th scope="col">Scope</th>
+								<th scope="col">Created</th>
+								<th scope="col">Expires</th>
+								<th scope="col">Last Used</th>
+								<th scope="col">Status</th>
+								<th scope="col"></th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Keys>>
+								<tr>
+									<td><< .Name.String >></td>
+									<td><code><< .Prefix.String >>…</code></td>
+									<td><< .Scope.String >></td>
+									<td><< .Created.String >><<if .CreatedBy.Valid>> by << .CreatedBy.String >><<end>></td>
+									<td><<if .Expires.Valid>><< .Expires.String >><<else>>Never<<end>></td>
+									<td><< .LastUsed.String >></td>
+									<td>
+										<<if .Revoked.Valid>>Revoked << .Revoked.String >>
+										<<else if and .Expires.Valid (le .Expires.String $.Now)>>Expired
+										<<else>>Active<<end>>
+									</td>
+									<td>
+										<<if not .Revoked.Valid>>
+											<form class="form-inline" method="POST" action="/computers/keys/<< .ID.Int64 >>/revoke">
+												<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+												<button class="btn btn-sm btn-danger" type="submit">Revoke</button>
+											</form>
+										<<end>>
+									</td>
+								</tr>
+							<<end>>
+						</tbody>
+					</table>
+
+					<form class="form-inline mb-4" method="POST" action="/computers/keys">
+						<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+						<input class="form-control mr-2" type="text" name="name" placeholder="Name, e.g. CMDB sync" required />
+						<select class="form-control mr-2" name="scope">
+							<<range .Scopes>><option value="<< . >>"><< . >></option><<end>>
+						</select>
+						<select class="form-control mr-2" name="expires">
+							<option value="30">Expires in 30 days</option>
+							<option value="90" selected>Expires in 90 days</option>
+							<option value="365">Expires in a year</option>
+							<option value="">Never expires</option>
+						</select>
+						<button class="btn btn-primary" type="submit">Create Key</button>
+					</form>
+					<p class="text-muted">
+						Scripts send the key as an "Authorization: Bearer" header. Read keys may read the JSON API
+						below /api/v1, export keys may also download the exports and admin keys may use the whole API.
+					</p>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// alertPage lists the alerts with forms acknowledging and resolving them,
+// and the rules raising them.
+func alertPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<ul class="nav nav-pills mb-3">
+						<<range .States>>
+							<li class="nav-item"><a class="nav-link <<if eq . $.State>>active<<end>>" href="/computers/alerts?state=<< . >>"><< . >></a></li>
+						<<end>>
+					</ul>
+
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Severity</th>
+								<th scope="col">Alert</th>
+								<th scope="col">Computer</th>
+								<th scope="col">Rule</th>
+								<th scope="col">Raised</th>
+								<th scope="col">Last Seen</th>
+								<th scope="col">Count</th>
+								<th scope="col">State</th>
+								<th scope="col"></th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Alerts>>
+								<tr>
+									<td>
+										<span class="badge <<if eq .Severity.String "critical">>badge-danger<<else if eq .Severity.String "warning">>badge-warning<<else>>badge-info<<end>>">
+											<< .Severity.String >>
+										</span>
+									</td>
+									<td><< .Message.String >></td>
+									<td><<if .ComputerID.Valid>><a href="/computers/<< .ComputerID.Int64 >>"><< .ComputerName.String >></a><<end>></td>
+									<td><< .RuleName.String >></td>
+									<td><< .Created.String >></td>
+									<td><< .Updated.String >></td>
+									<td><< .Count.Int64 >></td>
+									<td>
+										<< .State.String >>
+										<<if .AcknowledgedBy.Valid>><br /><small class="text-muted">by << .AcknowledgedBy.String >></small><<end>>
+										<<if .ResolvedBy.Valid>><br /><small class="text-muted">resolved by << .ResolvedBy.String >></small><<end>>
+									</td>
+									<td>
+										<<if $.Account.Allows "helpdesk">>
+											<<if eq .State.String "open">>
+												<form class="form-inline mb-1" method="POST" action="/computers/alerts/<< .ID.Int64 >>/acknowledge">
+													<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+													<button class="btn btn-sm btn-secondary" type="submit">Acknowledge</button>
+												</form>
+											<<end>>
+											<<if ne .State.String "resolved">>
+												<form class="form-inline" method="POST" action="/computers/alerts/<< .ID.Int64 >>/resolve">
+													<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+													<button class="btn btn-sm btn-success" type="submit">Resolve</button>
+												</form>
+											<<end>>
+										<<end>>
+									</td>
+								</tr>
+							<<else>>
+								<tr><td colspan="9" class="text-muted">No alerts.</td></tr>
+							<<end>>
+						</tbody>
+					</table>
+
+					<h2 class="h4 mt-4">Rules</h2>
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Name</th>
+								<th scope="col">Kind</th>
+								<th scope="col">Severity</th>
+								<th scope="col">Settings</th>
+								<th scope="col">Cooldown</th>
+								<th scope="col"></th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Rules>>
+								<tr>
+									<td><< .Name.String >><<if .Disabled.Valid>> <span class="badge badge-secondary">disabled</span><<end>></td>
+									<td><< .Kind.String >></td>
+									<td><< .Severity.String >></td>
+									<td>
+										<<if .Threshold.Valid>>threshold << .Threshold.Int64 >><<end>>
+										<<if .Pattern.Valid>>usernames <code><< .Pattern.String >></code><<end>>
+										<<if .Exclude.Valid>>except <code><< .Exclude.String >></code><<end>>
+									</td>
+									<td><< .Cooldown.Int64 >> min</td>
+									<td>
+										<<if $.Account.Allows "admin">>
+											<form class="form-inline mb-1" method="POST" action="/computers/alerts/rules/<< .ID.Int64 >>/<<if .Disabled.Valid>>enable<<else>>disable<<end>>">
+												<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+												<button class="btn btn-sm btn-secondary" type="submit"><<if .Disabled.Valid>>Enable<<else>>Disable<<end>></button>
+											</form>
+											<form class="form-inline" method="POST" action="/computers/alerts/rules/<< .ID.Int64 >>/delete">
+												<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+												<button class="btn btn-sm btn-danger" type="submit">Delete</button>
+											</form>
+										<<end>>
+									</td>
+								</tr>
+							<<end>>
+						</tbody>
+					</table>
+
+					<<if $.Account.Allows "admin">>
+						<form class="mb-4" method="POST" action="/computers/alerts/rules">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<div class="form-row">
+								<div class="col-md-3 mb-2"><input class="form-control" type="text" name="name" placeholder="Name" required /></div>
+								<div class="col-md-5 mb-2">
+									<select class="form-control" name="kind">
+										<<range .Kinds>><option value="<< .Kind >>"><< .Description >></option><<end>>
+									</select>
+								</div>
+								<div class="col-md-2 mb-2">
+									<select class="form-control" name="severity">
+										<<range .Severities>><option value="<< . >>" <<if eq . "warning">>selected<<end>>><< . >></option><<end>>
+									</select>
+								</div>
+								<div class="col-md-2 mb-2"><input class="form-control" type="number" min="1" name="threshold" placeholder="Threshold" /></div>
+							</div>
+							<div class="form-row">
+								<div class="col-md-4 mb-2"><input class="form-control" type="text" name="pattern" placeholder="Usernames, e.g. administrator|admin-.*" /></div>
+								<div class="col-md-4 mb-2"><input class="form-control" type="text" name="exclude" placeholder="Ignore computers matching, e.g. ^SRV-" /></div>
+								<div class="col-md-2 mb-2"><input class="form-control" type="number" min="0" name="cooldown" placeholder="Cooldown min" /></div>
+								<div class="col-md-2 mb-2"><button class="btn btn-primary btn-block" type="submit">Create Rule</button></div>
+							</div>
+						</form>
+					<<end>>
+					<p class="text-muted">
+						An alert that has not been resolved is counted again instead of being raised twice, and a rule does
+						not raise an alert again during its cooldown after it was resolved. Stale alerts resolve themselves
+						when the computer reports.
+					</p>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// webhookPage renders the webhook targets and the log of their deliveries.
+func webhookPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<<if .Secret>>
+						<div class="alert alert-success">
+							Copy the signing secret now, it is not shown again:
+							<pre class="mb-0 mt-2"><code><< .Secret >></code></pre>
+						</div>
+					<<end>>
+
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Name</th>
+								<th scope="col">URL</th>
+								<th scope="col">Events</th>
+								<th scope="col">Created</th>
+								<th scope="col"></th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Webhooks>>
+								<tr>
+									<td><< .Name.String >></td>
+									<td><code><< .URL.String >></code></td>
+									<td><<if .Events.String>><< .Events.String >><<else>>all<<end>></td>
+									<td><< .Created.String >></td>
+									<td>
+										<form class="form-inline mb-1" method="POST" action="/computers/webhooks/<< .ID.Int64 >>/ping">
+											<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+											<button class="btn btn-sm btn-secondary" type="submit">Send Ping</button>
+										</form>
+										<form class="form-inline" method="POST" action="/computers/webhooks/<< .ID.Int64 >>/delete">
+											<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+											<button class="btn btn-sm btn-danger" type="submit">Delete</button>
+										</form>
+									</td>
+								</tr>
+							<<else>>
+								<tr><td colspan="5" class="text-muted">No webhooks.</td></tr>
+							<<end>>
+						</tbody>
+					</table>
+
+					<form class="mb-4" method="POST" action="/computers/webhooks">
+						<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+						<div class="form-row">
+							<div class="col-md-3 mb-2"><input class="form-control" type="text" name="name" placeholder="Name, e.g. Helpdesk chat" required /></div>
+							<div class="col-md-5 mb-2"><input class="form-control" type="url" name="url" placeholder="https://example.com/hooks/fpsmonitor" required /></div>
+							<div class="col-md-4 mb-2"><input class="form-control" type="text" name="secret" placeholder="Secret, generated when empty" /></div>
+						</div>
+						<div class="form-row align-items-center">
+							<div class="col-md-10 mb-2">
+								<<range .EventTypes>>
+									<div class="form-check form-check-inline">
+										<input class="form-check-input" type="checkbox" name="events" value="<< . >>" id="event-<< . >>" />
+										<label class="form-check-label" for="event-<< . >>"><< . >></label>
+									</div>
+								<<end>>
+							</div>
+							<div class="col-md-2 mb-2"><button class="btn btn-primary btn-block" type="submit">Create Webhook</button></div>
+						</div>
+					</form>
+					<p class="text-muted">
+						Events are posted as JSON, signed in the << .SignatureHeader >> header with the HMAC-SHA256 of the
+						body keyed with the secret. Webhooks without selected events receive every event. Failed posts are
+						retried with a growing delay and given up after << .MaxAttempts >> attempts.
+					</p>
+
+					<h2 class="h4 mt-4">Deliveries</h2>
+					<table class="table table-dark table-sm">
+						<thead>
+							<tr>
+								<th scope="col">Queued</th>
+								<th scope="col">Webhook</th>
+								<th scope="col">Event</th>
+								<th scope="col">State</th>
+								<th scope="col">Attempts</th>
+								<th scope="col">Last Attempt</th>
+								<th scope="col">Response</th>
+								<th scope="col"></th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Deliveries>>
+								<tr>
+									<td><< .Created.String >></td>
+									<td><< .WebhookName.String >></td>
+									<td><< .EventType.String >><br /><small class="text-muted"><< .EventID.String >></small></td>
+									<td>
+										<< .State.String >>
+										<<if eq .State.String "pending">><br /><small class="text-muted">next << .NextAttempt.String >></small><<end>>
+									</td>
+									<td><< .Attempts.Int64 >></td>
+									<td><< .LastAttempt.String >></td>
+									<td>
+										<<if .StatusCode.Valid>><< .StatusCode.Int64 >><<end>>
+										<<if .Error.Valid>><br /><small class="text-muted"><< .Error.String >></small><<end>>
+									</td>
+									<td>
+										<<if eq .State.String "failed">>
+											<form class="form-inline" method="POST" action="/computers/webhooks/deliveries/<< .ID.Int64 >>/retry">
+												<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+												<button class="btn btn-sm btn-secondary" type="submit">Retry</button>
+											</form>
+										<<end>>
+									</td>
+								</tr>
+							<<else>>
+								<tr><td colspan="8" class="text-muted">No deliveries.</td></tr>
+							<<end>>
+						</tbody>
+					</table>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// agentConfigPage renders the configurations returned to the agents and
+// the form creating or editing one.
+func agentConfigPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Name</th>
+								<th scope="col">Tag</th>
+								<th scope="col">Priority</th>
+								<th scope="col">Revision</th>
+								<th scope="col">Interval</th>
+								<th scope="col">Collectors</th>
+								<th scope="col">Interfaces</th>
+								<th scope="col"></th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Configs>>
+								<tr>
+									<td><< .Name.String >></td>
+									<td><<if .TagID.Valid>><< .TagName.String >><<else>><span class="text-muted">every agent</span><<end>></td>
+									<td><< .Priority.Int64 >></td>
+									<td><< .Revision.Int64 >></td>
+									<td><< .Interval.String >></td>
+									<td><<if .Collectors.String>><< .Collectors.String >><<else>><span class="text-muted">none</span><<end>></td>
+									<td>
+										<<with .Document>>
+											<<range .Include>><code><< . >></code> <<end>>
+											<<range .Exclude>><code>!<< . >></code> <<end>>
+										<<end>>
+									</td>
+									<td>
+										<a class="btn btn-sm btn-secondary mb-1" href="/computers/agent-config?edit=<< .ID.Int64 >>">Edit</a>
+										<form class="form-inline" method="POST" action="/computers/agent-config/<< .ID.Int64 >>/delete">
+											<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+											<button class="btn btn-sm btn-danger" type="submit">Delete</button>
+										</form>
+									</td>
+								</tr>
+							<<else>>
+								<tr><td colspan="8" class="text-muted">No configurations, agents use the default.</td></tr>
+							<<end>>
+						</tbody>
+					</table>
+
+					<<with .Form>>
+						<h2 class="h4 mt-4"><<if .ID.Valid>>Edit << .Name.String >><<else>>New Configuration<<end>></h2>
+						<form class="mb-4" method="POST" action="/computers/agent-config<<if .ID.Valid>>/<< .ID.Int64 >><<end>>">
+							<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+							<div class="form-row">
+								<div class="col-md-4 mb-2"><input class="form-control" type="text" name="name" value="<< .Name.String >>" placeholder="Name, e.g. Laptops" required /></div>
+								<div class="col-md-4 mb-2">
+									<select class="form-control" name="tag">
+										<option value="">Every agent</option>
+										<<$tag := .TagID>>
+										<<range $.Tags>>
+											<option value="<< .ID.Int64 >>" <<if and $tag.Valid (eq $tag.Int64 .ID.Int64)>>selected<<end>>><< .Name.String >></option>
+										<<end>>
+									</select>
+								</div>
+								<div class="col-md-2 mb-2"><input class="form-control" type="number" name="priority" value="<< .Priority.Int64 >>" title="Priority" /></div>
+								<div class="col-md-2 mb-2"><input class="form-control" type="text" name="interval" value="<< .Interval.String >>" placeholder="Interval, e.g. 1h" required /></div>
+							</div>
+							<div class="form-row">
+								<div class="col-md-12 mb-2">
+									<<$doc := .Document>>
+									<<range $.Collectors>>
+										<div class="form-check form-check-inline">
+											<input class="form-check-input" type="checkbox" name="collectors" value="<< . >>" id="collector-<< . >>" <<if $doc.Collects .>>checked<<end>> />
+											<label class="form-check-label" for="collector-<< . >>"><< . >></label>
+										</div>
+									<<end>>
+								</div>
+							</div>
+							<div class="form-row">
+								<div class="col-md-6 mb-2">
+									<label for="include">Include interfaces, a pattern per line</label>
+									<textarea class="form-control" id="include" name="include" rows="3"><< .Include.String >></textarea>
+								</div>
+								<div class="col-md-6 mb-2">
+									<label for="exclude">Exclude interfaces, a pattern per line</label>
+									<textarea class="form-control" id="exclude" name="exclude" rows="3"><< .Exclude.String >></textarea>
+								</div>
+							</div>
+							<button class="btn btn-primary" type="submit"><<if .ID.Valid>>Save Configuration<<else>>Create Configuration<<end>></button>
+							<<if .ID.Valid>><a class="btn btn-link" href="/computers/agent-config">Cancel</a><<end>>
+						</form>
+					<<end>>
+					<p class="text-muted">
+						Agents apply the configuration in the response to their next report. Of the configurations matching
+						a computer those with one of its tags win over those for every agent, then the highest priority.
+						Agents without a matching configuration report every << .Default.Interval >> and leave out the
+						interfaces matching <<range .Default.Exclude>><code><< . >></code> <<end>>. Interface names are
+						matched ignoring case, * matches any text.
+					</p>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// alertMail renders the mail sent to the subscribers of an alert.
+func alertMail() *template.Template {
+	return template.Must(template.New("mail").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<title><< .Message.String >></title>
+			</head>
+
+			<body style="font-family: sans-serif; color: #212529;">
+				<h1 style="font-size: 20px;"><< .Message.String >></h1>
+				<table cellpadding="4" style="border-collapse: collapse;">
+					<tr><th align="left">Severity</th><td><< .Severity.String >></td></tr>
+					<tr><th align="left">Rule</th><td><< .RuleName.String >></td></tr>
+					<<if .ComputerName.Valid>><tr><th align="left">Computer</th><td><< .ComputerName.String >></td></tr><<end>>
+					<tr><th align="left">Raised</th><td><< .Created.String >></td></tr>
+				</table>
+				<p style="color: #6c757d; font-size: 12px;">
+					You receive this mail because you subscribed to these alerts on the notifications page of the inventory.
+				</p>
+			</body>
+		</html>
+	`))
+}
+
+// digestMail renders the digest mails.
+func digestMail() *template.Template {
+	return template.Must(template.New("mail").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<title><< .Title >></title>
+			</head>
+
+			<body style="font-family: sans-serif; color: #212529;">
+				<h1 style="font-size: 20px;"><< .Title >></h1>
+				<p style="color: #6c757d;"><< .Since >> to << .Until >></p>
+
+				<h2 style="font-size: 16px;">New computers (<< len .NewComputers >>)</h2>
+				<<if .NewComputers>>
+					<table cellpadding="4" style="border-collapse: collapse;">
+						<tr><th align="left">Name</th><th align="left">First seen</th><th align="left">Source</th></tr>
+						<<range .NewComputers>>
+							<tr><td><< .Name.String >></td><td><< .Created.String >></td><td><< .Source.String >></td></tr>
+						<<end>>
+					</table>
+				<<else>>
+					<p>None.</p>
+				<<end>>
+
+				<h2 style="font-size: 16px;">Stale computers (<< len .Stale >>)</h2>
+				<<if .Stale>>
+					<table cellpadding="4" style="border-collapse: collapse;">
+						<tr><th align="left">Name</th><th align="left">Last seen</th></tr>
+						<<range .Stale>>
+							<tr><td><< .Name.String >></td><td><< .LastSeen.String >></td></tr>
+						<<end>>
+					</table>
+				<<else>>
+					<p>None.</p>
+				<<end>>
+
+				<h2 style="font-size: 16px;">Conflicts (<< len .Conflicts >>)</h2>
+				<<if .Conflicts>>
+					<table cellpadding="4" style="border-collapse: collapse;">
+						<tr><th align="left">Computers</th><th align="left">Reason</th></tr>
+						<<range .Conflicts>>
+							<tr>
+								<td><< .First.Name >> and << .Second.Name >></td>
+								<td><<range $i, $r := .Reasons>><<if $i>>, <<end>><< $r >><<end>></td>
+							</tr>
+						<<end>>
+					</table>
+				<<else>>
+					<p>None.</p>
+				<<end>>
+
+				<h2 style="font-size: 16px;">Top users</h2>
+				<<if .TopUsers>>
+					<table cellpadding="4" style="border-collapse: collapse;">
+						<tr><th align="left">Username</th><th align="left">Computers</th></tr>
+						<<range .TopUsers>>
+							<tr><td><< .Username.String >></td><td><< .Computers.Int64 >></td></tr>
+						<<end>>
+					</table>
+				<<else>>
+					<p>None.</p>
+				<<end>>
+
+				<p style="color: #6c757d; font-size: 12px;">
+					You receive this mail because you subscribed to it on the notifications page of the inventory.
+				</p>
+			</body>
+		</html>
+	`))
+}
+
+// notificationPage renders the email address and subscriptions of the
+// signed in account.
+func notificationPage() *template.Template {
+	return template.Must(template.New("page").Delims("<<", ">>").Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<h1 class="my-3"><<.Title>></h1>
+					<p><a href="/computers/list">Back to the computer list</a></p>
+
+					<<if .Error>>
+						<div class="alert alert-danger"><< .Error >></div>
+					<<end>>
+					<<if .Saved>>
+						<div class="alert alert-success">Your notifications were saved.</div>
+					<<end>>
+					<<if not .Enabled>>
+						<div class="alert alert-warning">No SMTP server is configured, mail is queued but not sent.</div>
+					<<end>>
+
+					<form class="mb-4" method="POST" action="/computers/notifications">
+						<input type="hidden" name="csrf_token" value="<< $.CSRF >>" />
+						<div class="form-group">
+							<label for="email">Email address</label>
+							<input class="form-control" type="email" id="email" name="email" value="<< .Email >>" placeholder="you@example.com" />
+						</div>
+						<<range .Topics>>
+							<div class="form-check">
+								<input class="form-check-input" type="checkbox" name="topics" value="<< .Topic >>" id="topic-<< .Topic >>" <<if index $.Subscribed .Topic>>checked<<end>> />
+								<label class="form-check-label" for="topic-<< .Topic >>"><< .Description >></label>
+							</div>
+						<<end>>
+						<button class="btn btn-primary mt-3" type="submit">Save</button>
+					</form>
+					<p class="text-muted">
+						Alert mails are sent when a rule raises a new alert. Digests list the new, stale and conflicting
+						computers and the users seen on the most computers.
+					</p>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// dashboardPage is the landing page summarising the inventory.
+func dashboardPage() *template.Template {
+	t := template.Must(template.New("page").Delims("<<", ">>").Parse(navTabs))
+	return template.Must(t.Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<<template "nav" .>>
+
+					<div class="row mb-3">
+						<div class="col-sm-3">
+							<div class="card"><div class="card-body">
+								<h6 class="card-subtitle text-muted">Computers</h6>
+								<p class="h2 mb-0" id="count-total"><< .Counts.Total >></p>
+							</div></div>
+						</div>
+						<div class="col-sm-3">
+							<div class="card"><div class="card-body">
+								<h6 class="card-subtitle text-muted">Active today</h6>
+								<p class="h2 mb-0" id="count-active"><< .Counts.Active >></p>
+							</div></div>
+						</div>
+						<div class="col-sm-3">
+							<div class="card"><div class="card-body">
+								<h6 class="card-subtitle text-muted"><a href="/computers/stale">Stale</a></h6>
+								<p class="h2 mb-0" id="count-stale"><< .Counts.Stale >></p>
+							</div></div>
+						</div>
+						<div class="col-sm-3">
+							<div class="card"><div class="card-body">
+								<h6 class="card-subtitle text-muted"><a href="/computers/alerts">Active alerts</a></h6>
+								<p class="h2 mb-0" id="count-alerts"><< .ActiveAlerts >></p>
+							</div></div>
+						</div>
+					</div>
+
+					<h5>Reports per hour</h5>
+					<div class="d-flex align-items-end border-bottom mb-1" style="height: 120px">
+						<<range .Hours>>
+							<div class="flex-fill d-flex flex-column justify-content-end h-100 mx-1" title="<< .Label >>: << .Reports >> reports">
+								<div class="bg-primary" style="height: << .Percent >>%"></div>
+							</div>
+						<<end>>
+					</div>
+					<div class="d-flex small text-muted mb-4">
+						<<range .Hours>>
+							<div class="flex-fill text-center mx-1"><<if .Tick>><< .Label >><<end>></div>
+						<<end>>
+					</div>
+
+					<div class="row">
+						<div class="col-md-4">
+							<h5>Top users</h5>
+							<p class="small text-muted">Computers signed in to over the last << .UserDays >> days.</p>
+							<table class="table table-sm">
+								<tbody>
+									<<range .TopUsers>>
+										<tr>
+											<td><a href="/computers/users?q=<< .Username.String >>"><< .Username.String >></a></td>
+											<td class="text-right"><< .Computers.Int64 >></td>
+										</tr>
+									<<else>>
+										<tr><td class="text-muted">No reports.</td></tr>
+									<<end>>
+								</tbody>
+							</table>
+						</div>
+						<div class="col-md-4">
+							<h5>Adapter vendors</h5>
+							<p class="small text-muted">By the prefix of the MAC address.</p>
+							<table class="table table-sm">
+								<tbody>
+									<<range .Vendors>>
+										<tr>
+											<td><< .Vendor >></td>
+											<td class="text-right"><< .Adapters >></td>
+										</tr>
+									<<else>>
+										<tr><td class="text-muted">No adapters.</td></tr>
+									<<end>>
+								</tbody>
+							</table>
+						</div>
+						<div class="col-md-4">
+							<h5>Operating systems</h5>
+							<p class="small text-muted">As reported by the agents, see <a href="/computers/versions">agent versions</a>.</p>
+							<table class="table table-sm">
+								<tbody>
+									<<range .Systems>>
+										<tr>
+											<td><<if .OS.String>><< .OS.String >><<else>>unknown<<end>></td>
+											<td class="text-right"><< .Computers.Int64 >></td>
+										</tr>
+									<<else>>
+										<tr><td class="text-muted">No agents.</td></tr>
+									<<end>>
+								</tbody>
+							</table>
+						</div>
+					</div>
+
+					<h5>Recent alerts</h5>
+					<table class="table table-dark">
+						<tbody>
+							<<range .Alerts>>
+								<tr>
+									<td>
+										<span class="badge <<if eq .Severity.String "critical">>badge-danger<<else if eq .Severity.String "warning">>badge-warning<<else>>badge-info<<end>>">
+											<< .Severity.String >>
+										</span>
+									</td>
+									<td><< .Message.String >></td>
+									<td><<if .ComputerID.Valid>><a href="/computers/<< .ComputerID.Int64 >>"><< .ComputerName.String >></a><<end>></td>
+									<td><< .Updated.String >></td>
+								</tr>
+							<<else>>
+								<tr><td>No active alerts.</td></tr>
+							<<end>>
+						</tbody>
+					</table>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// checkinPage reports the agents not checking in as expected.
+func checkinPage() *template.Template {
+	t := template.Must(template.New("page").Delims("<<", ">>").Funcs(template.FuncMap{
+		"formatInterval": formatInterval,
+	}).Parse(navTabs))
+	return template.Must(t.Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<<template "nav" .>>
+
+					<h1 class="h3"><<.Title>></h1>
+					<p class="text-muted">
+						Agents are expected to check in at the interval of their agent configuration, or every << .Expected >>
+						without one. Listed are the agents that have not checked in for twice as long, or checked in less than
+						half as often over the last << .Days >> days.
+					</p>
+
+					<table class="table table-dark">
+						<thead>
+							<tr>
+								<th scope="col">Computer</th>
+								<th scope="col">Last Check-in</th>
+								<th scope="col">Check-ins</th>
+								<th scope="col">Average Interval</th>
+								<th scope="col">Expected</th>
+								<th scope="col">Reason</th>
+							</tr>
+						</thead>
+						<tbody>
+							<<range .Agents>>
+								<tr>
+									<td><a href="/computers/<< .ComputerID.Int64 >>"><< .Name.String >></a></td>
+									<td><< .LastSeen.String >></td>
+									<td><< .Checkins.Int64 >></td>
+									<td><<if .Interval>><< formatInterval .Interval >><<end>></td>
+									<td><< formatInterval .Expected >></td>
+									<td><< .Reason >></td>
+								</tr>
+							<<else>>
+								<tr><td colspan="6">Every agent is checking in as expected.</td></tr>
+							<<end>>
+						</tbody>
+					</table>
+				</div>
+			</body>
+		</html>
+	`))
+}
+
+// versionPage breaks the agent fleet down by version and platform.
+func versionPage() *template.Template {
+	t := template.Must(template.New("page").Delims("<<", ">>").Parse(navTabs))
+	return template.Must(t.Parse(`
+		<!DOCTYPE html>
+		<html lang="en">
+			<head>
+				<meta charset="utf-8" />
+				<meta language="english" />
+				<meta http-equiv="X-UA-Compatible" content="IE=edge">
+				<meta name="viewport" content="width=device-width, initial-scale=1, maximum-scale=1, user-scalable=no" />
+				<title><<.Title>></title>
+                <link rel="stylesheet" href="/bootstrap" type="text/css" />
+				<link rel="stylesheet" href="/computers/stylesheet" type="text/css" />
+			</head>
+
+			<body>
+				<div class="container">
+					<<template "nav" .>>
+
+					<h1 class="h3"><<.Title>></h1>
+					<p class="text-muted">
+						<< .Total >> computers reported by an agent.
+						<<if .MinVersion>>
+							Agents below version << .MinVersion >> are asked to upgrade, << .Outdated >> still run one.
+						<<else>>
+							No minimum version is configured.
+						<<end>>
+					</p>
+
+					<div class="row">
+						<div class="col-md-7">
+							<h5>Versions</h5>
+							<table class="table table-dark">
+								<thead>
+									<tr>
+										<th scope="col">Version</th>
+										<th scope="col" class="text-right">Computers</th>
+										<th scope="col" class="text-right">Share</th>
+									</tr>
+								</thead>
+								<tbody>
+									<<range .Versions>>
+										<tr>
+											<td>
+												<a href="/computers/versions?version=<< .Version.String >>"><<if .Version.String>><< .Version.String >><<else>>unknown<<end>></a>
+												<<if .Outdated>><span class="badge badge-warning ml-1">outdated</span><<end>>
+											</td>
+											<td class="text-right"><< .Computers.Int64 >></td>
+											<td class="text-right"><< .Percent >>%</td>
+										</tr>
+									<<else>>
+										<tr><td colspan="3">No agents have reported.</td></tr>
+									<<end>>
+								</tbody>
+							</table>
+						</div>
+						<div class="col-md-5">
+							<h5>Platforms</h5>
+							<table class="table table-dark">
+								<thead>
+									<tr>
+										<th scope="col">OS</th>
+										<th scope="col">Architecture</th>
+										<th scope="col" class="text-right">Computers</th>
+									</tr>
+								</thead>
+								<tbody>
+									<<range .Platforms>>
+										<tr>
+											<td><<if .OS.String>><< .OS.String >><<else>>unknown<<end>></td>
+											<td><< .Arch.String >></td>
+											<td class="text-right"><< .Computers.Int64 >></td>
+										</tr>
+									<<end>>
+								</tbody>
+							</table>
+						</div>
+					</div>
+
+					<<with .Version>>
+						<h5>Running <<if .>><< . >><<else>>an unknown version<<end>></h5>
+						<table class="table table-dark">
+							<thead>
+								<tr>
+									<th scope="col">Computer</th>
+									<th scope="col">Commit</th>
+									<th scope="col">Platform</th>
+									<th scope="col">Last Seen</th>
+								</tr>
+							</thead>
+							<tbody>
+								<<range $.Computers>>
+									<tr>
+										<td><a href="/computers/<< .ID.Int64 >>"><< .Name.String >></a></td>
+										<td><< .AgentCommit.String >></td>
+										<td><< .OS.String >> << .Arch.String >></td>
+										<td><<if .Updated.Valid>><< .Updated.String >><<else>><< .Created.String >><<end>></td>
+									</tr>
+								<<else>>
+									<tr><td colspan="4">No computers.</td></tr>
+								<<end>>
+							</tbody>
+						</table>
+					<<end>>
+				</div>
+			</body>
+		</html>
+	`))
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
 	"gopkg.in/guregu/null.v3"
 )
 
@@ -27,9 +28,13 @@ type UserRepository interface {
 	Delete(context.Context, int) error
 	List(context.Context, int, int) ([]User, error)
 	ListWithComputerNames(context.Context, int, int) ([]User, error)
+	EachWithComputerName(context.Context, Filter, func(User) error) error
 
 	SelectWithUsername(context.Context, string) (*User, error)
 	SelectWithUsernameAndComputerID(context.Context, int, string) (*User, error)
+
+	// Latest returns the user that last signed in to the computer.
+	Latest(context.Context, int64) (*User, error)
 }
 
 type userRepository struct {
@@ -43,17 +48,17 @@ func NewUserRepository(db *sqlx.DB) UserRepository {
 }
 
 func (r *userRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
 	_, err := r.db.ExecContext(
 		ctx,
 		`CREATE TABLE computer_users (
-            "id" INTEGER,
+            `+d.PrimaryKey+`,
             "created" TEXT,
             "updated" TEXT,
             "deleted" TEXT,
             "computer_id" INTEGER NOT NULL,
-			"username" TEXT,
-			FOREIGN KEY("computer_id") REFERENCES "computers"("id") ON DELETE CASCADE ON UPDATE NO ACTION,
-            PRIMARY KEY("id" AUTOINCREMENT)
+            "username" TEXT`+d.ForeignKey("computer_id", "computers")+`
         )`,
 	)
 
@@ -64,11 +69,13 @@ func (r *userRepository) Install(ctx context.Context) error {
 }
 
 func (r *userRepository) Select(ctx context.Context, id int) (*User, error) {
+	defer observeQuery("user", "Select", time.Now())
+
 	data := User{}
 
 	stmt, err := r.db.PreparexContext(
 		ctx,
-		`SELECT 
+		r.db.Rebind(`SELECT 
             id,
             created,
             updated,
@@ -76,7 +83,7 @@ func (r *userRepository) Select(ctx context.Context, id int) (*User, error) {
             computer_id,
             username
         FROM computer_users
-        WHERE id=?`,
+        WHERE id=?`),
 	)
 
 	if err != nil {
@@ -100,11 +107,13 @@ func (r *userRepository) Select(ctx context.Context, id int) (*User, error) {
 }
 
 func (r *userRepository) SelectWithUsername(ctx context.Context, id string) (*User, error) {
+	defer observeQuery("user", "SelectWithUsername", time.Now())
+
 	data := User{}
 
 	stmt, err := r.db.PreparexContext(
 		ctx,
-		`SELECT 
+		r.db.Rebind(`SELECT 
             id,
             created,
             updated,
@@ -112,7 +121,7 @@ func (r *userRepository) SelectWithUsername(ctx context.Context, id string) (*Us
             computer_id,
             username
         FROM computer_users
-        WHERE username=?`,
+        WHERE username=?`),
 	)
 
 	if err != nil {
@@ -136,11 +145,13 @@ func (r *userRepository) SelectWithUsername(ctx context.Context, id string) (*Us
 }
 
 func (r *userRepository) SelectWithUsernameAndComputerID(ctx context.Context, id int, username string) (*User, error) {
+	defer observeQuery("user", "SelectWithUsernameAndComputerID", time.Now())
+
 	data := User{}
 
 	stmt, err := r.db.PreparexContext(
 		ctx,
-		`SELECT 
+		r.db.Rebind(`SELECT 
             id,
             created,
             updated,
@@ -148,7 +159,7 @@ func (r *userRepository) SelectWithUsernameAndComputerID(ctx context.Context, id
             computer_id,
             username
         FROM computer_users
-        WHERE computer_id=? AND username=?`,
+        WHERE computer_id=? AND username=?`),
 	)
 
 	if err != nil {
@@ -172,28 +183,56 @@ func (r *userRepository) SelectWithUsernameAndComputerID(ctx context.Context, id
 	return &data, nil
 }
 
-func (r *userRepository) Create(ctx context.Context, data *User) (int64, error) {
-	tx, err := r.db.BeginTxx(ctx, nil)
+func (r *userRepository) Latest(ctx context.Context, computerID int64) (*User, error) {
+	defer observeQuery("user", "Latest", time.Now())
 
-	if err != nil {
-		return -1, err
-	}
+	data := User{}
 
-	stmt, err := tx.PreparexContext(
+	err := r.db.GetContext(
 		ctx,
-		`INSERT INTO computer_users (
+		&data,
+		r.db.Rebind(`SELECT
+            id,
             created,
+            updated,
+            deleted,
             computer_id,
             username
-        ) VALUES (?,?,?)`,
+        FROM computer_users
+        WHERE computer_id=?
+        AND deleted IS NULL
+        ORDER BY id DESC
+        LIMIT 1`),
+		computerID,
 	)
 
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *userRepository) Create(ctx context.Context, data *User) (int64, error) {
+	defer observeQuery("user", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
 	if err != nil {
 		return -1, err
 	}
 
-	result, err := stmt.ExecContext(
+	id, err := database.Insert(
 		ctx,
+		tx,
+		`INSERT INTO computer_users (
+            created,
+            computer_id,
+            username
+        ) VALUES (?,?,?)`,
 		time.Now().Format("2006-01-02 15:04:05"),
 		data.ComputerID,
 		data.Username,
@@ -205,11 +244,12 @@ func (r *userRepository) Create(ctx context.Context, data *User) (int64, error)
 	}
 
 	tx.Commit()
-	id, _ := result.LastInsertId()
 	return id, nil
 }
 
 func (r *userRepository) Update(ctx context.Context, data *User) error {
+	defer observeQuery("user", "Update", time.Now())
+
 	tx, err := r.db.BeginTxx(ctx, nil)
 
 	if err != nil {
@@ -218,10 +258,10 @@ func (r *userRepository) Update(ctx context.Context, data *User) error {
 
 	stmt, err := tx.PreparexContext(
 		ctx,
-		`UPDATE computer_users SET
+		tx.Rebind(`UPDATE computer_users SET
             updated=?,
 			username=?
-        WHERE id=?`,
+        WHERE id=?`),
 	)
 
 	if err != nil {
@@ -245,6 +285,8 @@ func (r *userRepository) Update(ctx context.Context, data *User) error {
 }
 
 func (r *userRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("user", "Delete", time.Now())
+
 	tx, err := r.db.BeginTxx(ctx, nil)
 
 	if err != nil {
@@ -253,9 +295,9 @@ func (r *userRepository) Delete(ctx context.Context, id int) error {
 
 	stmt, err := tx.PreparexContext(
 		ctx,
-		`UPDATE computer_users SET
+		tx.Rebind(`UPDATE computer_users SET
             deleted=?
-        WHERE id=?`,
+        WHERE id=?`),
 	)
 
 	if err != nil {
@@ -278,11 +320,13 @@ func (r *userRepository) Delete(ctx context.Context, id int) error {
 }
 
 func (r *userRepository) List(ctx context.Context, start int, count int) ([]User, error) {
+	defer observeQuery("user", "List", time.Now())
+
 	data := []User{}
 
 	stmt, err := r.db.PreparexContext(
 		ctx,
-		`SELECT
+		r.db.Rebind(`SELECT
             id,
             created,
             updated,
@@ -290,7 +334,8 @@ func (r *userRepository) List(ctx context.Context, start int, count int) ([]User
             computer_id,
             username
         FROM computer_users
-        LIMIT ?, ?`,
+        ORDER BY id
+        LIMIT ? OFFSET ?`),
 	)
 
 	if err != nil {
@@ -300,8 +345,8 @@ func (r *userRepository) List(ctx context.Context, start int, count int) ([]User
 	err = stmt.SelectContext(
 		ctx,
 		&data,
-		start,
 		count,
+		start,
 	)
 
 	if err != nil {
@@ -315,18 +360,21 @@ func (r *userRepository) List(ctx context.Context, start int, count int) ([]User
 }
 
 func (r *userRepository) ListWithComputerNames(ctx context.Context, start int, count int) ([]User, error) {
+	defer observeQuery("user", "ListWithComputerNames", time.Now())
+
 	data := []User{}
 
 	stmt, err := r.db.PreparexContext(
 		ctx,
-		`SELECT
+		r.db.Rebind(`SELECT
             cu.created,
 			cu.computer_id,
             cu.username,
 			c.name AS computer_name
         FROM computer_users cu
 		LEFT JOIN computers c ON cu.computer_id = c.id
-        LIMIT ? OFFSET ?`,
+        ORDER BY cu.id
+        LIMIT ? OFFSET ?`),
 	)
 
 	if err != nil {
@@ -355,3 +403,54 @@ func (r *userRepository) ListWithComputerNames(ctx context.Context, start int, c
 
 	return data, nil
 }
+
+// EachWithComputerName calls fn for every user record matching f, newest
+// first, with the name of the computer it was seen on. Rows are streamed,
+// an error returned by fn stops the iteration.
+func (r *userRepository) EachWithComputerName(ctx context.Context, f Filter, fn func(User) error) error {
+	defer observeQuery("user", "EachWithComputerName", time.Now())
+
+	query := `SELECT
+            cu.id,
+            cu.created,
+            cu.computer_id,
+            cu.username,
+            c.name AS computer_name
+        FROM computer_users cu
+        LEFT JOIN computers c ON cu.computer_id = c.id
+        WHERE cu.deleted IS NULL`
+	var args []interface{}
+
+	if f.Search != "" {
+		query += ` AND (LOWER(cu.username) LIKE ? ESCAPE '\' OR LOWER(c.name) LIKE ? ESCAPE '\')`
+		args = append(args, like(f.Search), like(f.Search))
+	}
+
+	limit, limitArgs := f.limit()
+	query += ` ORDER BY cu.id DESC` + limit
+	args = append(args, limitArgs...)
+
+	rows, err := r.db.QueryxContext(ctx, r.db.Rebind(query), args...)
+	if err != nil {
+		return &ErrorEx{
+			ErrorMsg: err,
+			Func:     "computer.userRepository.EachWithComputerName.DB.QueryxContext",
+		}
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data User
+		if err = rows.StructScan(&data); err != nil {
+			return &ErrorEx{
+				ErrorMsg: err,
+				Func:     "computer.userRepository.EachWithComputerName.Rows.StructScan",
+			}
+		}
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,58 @@
+package computer
+
+import (
+	"strconv"
+	"strings"
+)
+
+// HeaderMinVersion carries the minimum agent version in the responses
+// asking agents to upgrade.
+const HeaderMinVersion = "X-Fpsmonitor-Min-Version"
+
+// parseVersion returns the major, minor and patch numbers of an agent
+// version such as "v1.4.2". Missing numbers are 0 and anything after a
+// "-" or "+", like the commits git describe appends, is ignored.
+func parseVersion(v string) ([3]int, bool) {
+	var n [3]int
+
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return n, false
+	}
+
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return n, false
+	}
+	for i, p := range parts {
+		x, err := strconv.Atoi(p)
+		if err != nil || x < 0 {
+			return n, false
+		}
+		n[i] = x
+	}
+	return n, true
+}
+
+// versionBelow reports whether the agent version v is older than min.
+// Agents without a version, or one that can not be parsed such as
+// development builds, are below every minimum.
+func versionBelow(v string, min string) bool {
+	m, ok := parseVersion(min)
+	if !ok {
+		return false
+	}
+	n, ok := parseVersion(v)
+	if !ok {
+		return true
+	}
+	for i := range n {
+		if n[i] != m[i] {
+			return n[i] < m[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package computer
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+)
+
+type versionController struct {
+	log           lumber.Logger
+	dashboardRepo DashboardRepository
+	minVersion    string
+}
+
+type VersionController interface {
+	Versions(http.ResponseWriter, *http.Request)
+}
+
+// NewVersionController registers the breakdown of the agent fleet by
+// version and platform at /computers/versions. Versions below minVersion
+// are marked outdated.
+func NewVersionController(db *sqlx.DB, log lumber.Logger, router *mux.Router, minVersion string, guard Guard, middleware ...alice.Constructor) VersionController {
+	c := &versionController{
+		log:           log,
+		dashboardRepo: NewDashboardRepository(db),
+		minVersion:    minVersion,
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	view := alice.New(m...).Append(guard(account.RoleViewer))
+
+	router.Handle("/computers/versions", view.ThenFunc(c.Versions)).Methods("GET").Name("versions")
+
+	return c
+}
+
+// AgentVersion is a row of the version breakdown.
+type AgentVersion struct {
+	VersionCount
+	Percent  int64
+	Outdated bool
+}
+
+// versionPageData is rendered by versionPage.
+type versionPageData struct {
+	Title      string
+	View       string
+	MinVersion string
+	Total      int64
+	Outdated   int64
+	Versions   []AgentVersion
+	Platforms  []PlatformCount
+
+	// Version lists the Computers running it when one is selected.
+	Version   *string
+	Computers []Computer
+	Account   *account.Account
+}
+
+func (c *versionController) Versions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	data := &versionPageData{
+		Title:      "Agent Versions",
+		View:       "versions",
+		MinVersion: c.minVersion,
+		Account:    AccountFromContext(ctx),
+	}
+
+	versions, err := c.dashboardRepo.Versions(ctx)
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	for _, v := range versions {
+		data.Total += v.Computers.Int64
+	}
+	for _, v := range versions {
+		a := AgentVersion{
+			VersionCount: v,
+			Percent:      v.Computers.Int64 * 100 / data.Total,
+			Outdated:     c.minVersion != "" && versionBelow(v.Version.String, c.minVersion),
+		}
+		if a.Outdated {
+			data.Outdated += v.Computers.Int64
+		}
+		data.Versions = append(data.Versions, a)
+	}
+
+	if data.Platforms, err = c.dashboardRepo.Platforms(ctx); err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	if q := r.URL.Query(); q["version"] != nil {
+		version := q.Get("version")
+		data.Version = &version
+		if data.Computers, err = c.dashboardRepo.WithVersion(ctx, version); err != nil {
+			c.failed(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := versionPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(ctx, c.log, err)
+	}
+}
+
+func (c *versionController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
@@ -0,0 +1,275 @@
+package computer
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+const (
+	// maxDeliveryAttempts is the number of posts after which a delivery
+	// fails.
+	maxDeliveryAttempts = 10
+
+	// deliveryBatch limits the deliveries posted by one pass of Deliver.
+	deliveryBatch = 50
+
+	// deliveryTimeout limits a single post.
+	deliveryTimeout = 10 * time.Second
+
+	// DefaultDeliveryRetention is how long delivered and failed deliveries
+	// are kept unless changed with SetRetention.
+	DefaultDeliveryRetention = 30 * 24 * time.Hour
+
+	// pruneInterval is how often Run prunes the deliveries.
+	pruneInterval = time.Hour
+)
+
+// Headers of webhook posts. The signature is the hex encoded HMAC-SHA256
+// of the body keyed with the secret of the webhook, prefixed with
+// "sha256=".
+const (
+	HeaderEvent     = "X-Fpsmonitor-Event"
+	HeaderDelivery  = "X-Fpsmonitor-Delivery"
+	HeaderSignature = "X-Fpsmonitor-Signature"
+)
+
+// Webhooks queues events for the webhooks subscribing to them and posts
+// the queued deliveries.
+type Webhooks struct {
+	log          lumber.Logger
+	hookRepo     WebhookRepository
+	deliveryRepo DeliveryRepository
+	lock         sync.Locker
+	client       *http.Client
+
+	// mu guards retention, which is changed on reload.
+	mu        sync.Mutex
+	retention time.Duration
+}
+
+func NewWebhooks(db *sqlx.DB, log lumber.Logger) *Webhooks {
+	return &Webhooks{
+		log:          log,
+		hookRepo:     NewWebhookRepository(db),
+		deliveryRepo: NewDeliveryRepository(db),
+		lock:         writeLock(db),
+		client:       &http.Client{Timeout: deliveryTimeout},
+		retention:    DefaultDeliveryRetention,
+	}
+}
+
+// SetRetention changes how long delivered and failed deliveries are kept,
+// it applies from the next pruning.
+func (h *Webhooks) SetRetention(retention time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.retention = retention
+}
+
+// NewWebhookSecret returns a random secret for signing payloads.
+func NewWebhookSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// signPayload returns the value of HeaderSignature for body.
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliveryBackoff returns the delay before the next post of a delivery
+// that failed attempts times, doubling from 30 seconds up to 6 hours.
+func deliveryBackoff(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < 6*time.Hour; i++ {
+		delay *= 2
+	}
+	if delay > 6*time.Hour {
+		delay = 6 * time.Hour
+	}
+	return delay
+}
+
+// Enqueue queues the events for every webhook subscribing to their type.
+// The caller holds the write lock.
+func (h *Webhooks) Enqueue(ctx context.Context, events ...Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	hooks, err := h.hookRepo.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range events {
+		var payload []byte
+		for _, hook := range hooks {
+			if !hook.Subscribes(event.Type) {
+				continue
+			}
+			if payload == nil {
+				if payload, err = json.Marshal(event); err != nil {
+					return err
+				}
+			}
+			if err = h.enqueue(ctx, hook, event, payload); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Ping queues a ping event for the webhook with id.
+func (h *Webhooks) Ping(ctx context.Context, id int) error {
+	hook, err := h.hookRepo.Select(ctx, id)
+	if err != nil || hook == nil {
+		return err
+	}
+
+	event := NewEvent(EventPing)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return h.enqueue(ctx, *hook, event, payload)
+}
+
+func (h *Webhooks) enqueue(ctx context.Context, hook Webhook, event Event, payload []byte) error {
+	_, err := h.deliveryRepo.Create(ctx, &WebhookDelivery{
+		WebhookID: hook.ID,
+		EventID:   null.StringFrom(event.ID),
+		EventType: null.StringFrom(event.Type),
+		Payload:   null.StringFrom(string(payload)),
+	})
+	return err
+}
+
+// Deliver posts the deliveries that are due. Posts that fail are retried
+// with deliveryBackoff until maxDeliveryAttempts. The write lock is only
+// held while recording the outcome, not during the posts.
+func (h *Webhooks) Deliver(ctx context.Context) error {
+	due, err := h.deliveryRepo.Due(ctx, time.Now(), deliveryBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		status, err := h.post(ctx, d)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		state := DeliveryDelivered
+		message := ""
+		var next time.Time
+		if err != nil {
+			message = err.Error()
+			state = DeliveryPending
+			attempts := int(d.Attempts.Int64) + 1
+			if attempts >= maxDeliveryAttempts {
+				state = DeliveryFailed
+			}
+			next = time.Now().Add(deliveryBackoff(attempts))
+			h.log.Errorf("webhooks: delivery %d to %s failed: %s", d.ID.Int64, d.WebhookName.String, message)
+		}
+		webhookDeliveries.Inc(state)
+
+		h.lock.Lock()
+		err = h.deliveryRepo.Attempted(ctx, d.ID.Int64, state, status, message, next)
+		h.lock.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post sends a delivery, returning the status code of the response. Only
+// 2xx responses are successful.
+func (h *Webhooks) post(ctx context.Context, d WebhookDelivery) (int, error) {
+	body := []byte(d.Payload.String)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL.String, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "fpsmonitor-webhook")
+	req.Header.Set(HeaderEvent, d.EventType.String)
+	req.Header.Set(HeaderDelivery, d.EventID.String)
+	req.Header.Set(HeaderSignature, signPayload(d.Secret.String, body))
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", strings.TrimSpace(resp.Status))
+	}
+	return resp.StatusCode, nil
+}
+
+// Prune deletes the delivered and failed deliveries older than the
+// retention at now. Pending deliveries are kept.
+func (h *Webhooks) Prune(ctx context.Context, now time.Time) (int64, error) {
+	h.mu.Lock()
+	before := now.Add(-h.retention)
+	h.mu.Unlock()
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.deliveryRepo.Prune(ctx, before)
+}
+
+// Run posts the due deliveries every interval and prunes them every
+// pruneInterval until ctx is cancelled.
+func (h *Webhooks) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.Deliver(ctx); err != nil {
+				h.log.Errorf("webhooks: delivery failed: %s", err)
+			}
+		case now := <-prune.C:
+			n, err := h.Prune(ctx, now)
+			if err != nil {
+				h.log.Errorf("webhooks: pruning failed: %s", err)
+				continue
+			}
+			if n > 0 {
+				h.log.Infof("webhooks: pruned %d deliveries", n)
+			}
+		}
+	}
+}
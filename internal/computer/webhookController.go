@@ -0,0 +1,211 @@
+package computer
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/justinas/alice"
+	"github.com/stockholmr/fpsmonitor/internal/account"
+	"github.com/stockholmr/fpsmonitor/internal/requestlog"
+	"github.com/stockholmr/lumber"
+	"gopkg.in/guregu/null.v3"
+)
+
+// maxDeliveries limits the deliveries listed on the webhooks page.
+const maxDeliveries = 200
+
+type webhookController struct {
+	log          lumber.Logger
+	hookRepo     WebhookRepository
+	deliveryRepo DeliveryRepository
+	webhooks     *Webhooks
+	lock         sync.Locker
+}
+
+type WebhookController interface {
+	Page(http.ResponseWriter, *http.Request)
+	Create(http.ResponseWriter, *http.Request)
+	Delete(http.ResponseWriter, *http.Request)
+	Ping(http.ResponseWriter, *http.Request)
+	Retry(http.ResponseWriter, *http.Request)
+}
+
+// NewWebhookController registers the page managing webhooks and listing
+// their deliveries at /computers/webhooks, which takes the admin role.
+func NewWebhookController(db *sqlx.DB, log lumber.Logger, router *mux.Router, guard Guard, middleware ...alice.Constructor) WebhookController {
+	c := &webhookController{
+		log:          log,
+		hookRepo:     NewWebhookRepository(db),
+		deliveryRepo: NewDeliveryRepository(db),
+		webhooks:     NewWebhooks(db, log),
+		lock:         writeLock(db),
+	}
+
+	m := []alice.Constructor{
+		requestlog.Middleware(log),
+	}
+	m = append(m, middleware...)
+	admin := alice.New(m...).Append(guard(account.RoleAdmin))
+
+	r := router.PathPrefix("/computers/webhooks").Subrouter()
+	r.Handle("", admin.ThenFunc(c.Page)).Methods("GET").Name("webhooks")
+	r.Handle("", admin.ThenFunc(c.Create)).Methods("POST").Name("webhook_create")
+	r.Handle("/{id:[0-9]+}/delete", admin.ThenFunc(c.Delete)).Methods("POST").Name("webhook_delete")
+	r.Handle("/{id:[0-9]+}/ping", admin.ThenFunc(c.Ping)).Methods("POST").Name("webhook_ping")
+	r.Handle("/deliveries/{id:[0-9]+}/retry", admin.ThenFunc(c.Retry)).Methods("POST").Name("webhook_retry")
+
+	return c
+}
+
+// webhookPageData is rendered by webhookPage.
+type webhookPageData struct {
+	Title           string
+	Error           string
+	Secret          string
+	Webhooks        []Webhook
+	Deliveries      []WebhookDelivery
+	EventTypes      []string
+	SignatureHeader string
+	MaxAttempts     int
+	Account         *account.Account
+	CSRF            string
+}
+
+func (c *webhookController) Page(w http.ResponseWriter, r *http.Request) {
+	c.render(w, r, http.StatusOK, &webhookPageData{})
+}
+
+// Create adds a webhook. A secret is generated when none is given and
+// shown once.
+func (c *webhookController) Create(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		c.render(w, r, http.StatusBadRequest, &webhookPageData{Error: "The form could not be read."})
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		c.render(w, r, http.StatusUnprocessableEntity, &webhookPageData{Error: "A name is required."})
+		return
+	}
+
+	target := strings.TrimSpace(r.FormValue("url"))
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		c.render(w, r, http.StatusUnprocessableEntity, &webhookPageData{Error: "The URL must be an absolute http or https URL."})
+		return
+	}
+
+	var events []string
+	for _, e := range r.Form["events"] {
+		if !contains(EventTypes(), e) {
+			c.render(w, r, http.StatusUnprocessableEntity, &webhookPageData{Error: "Unknown event type " + e + "."})
+			return
+		}
+		events = append(events, e)
+	}
+
+	data := &webhookPageData{}
+	secret := strings.TrimSpace(r.FormValue("secret"))
+	if secret == "" {
+		secret = NewWebhookSecret()
+		data.Secret = secret
+	}
+
+	c.lock.Lock()
+	_, err := c.hookRepo.Create(r.Context(), &Webhook{
+		Name:   null.StringFrom(name),
+		URL:    null.StringFrom(target),
+		Secret: null.StringFrom(secret),
+		Events: null.StringFrom(strings.Join(events, ",")),
+	})
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	if data.Secret == "" {
+		http.Redirect(w, r, "/computers/webhooks", http.StatusSeeOther)
+		return
+	}
+	c.render(w, r, http.StatusCreated, data)
+}
+
+func (c *webhookController) Delete(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.hookRepo.Delete(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/webhooks", http.StatusSeeOther)
+}
+
+// Ping queues a ping event for the webhook, it is posted by the next
+// delivery run.
+func (c *webhookController) Ping(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.webhooks.Ping(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/webhooks", http.StatusSeeOther)
+}
+
+func (c *webhookController) Retry(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	c.lock.Lock()
+	err := c.deliveryRepo.Retry(r.Context(), id)
+	c.lock.Unlock()
+
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/computers/webhooks", http.StatusSeeOther)
+}
+
+func (c *webhookController) failed(w http.ResponseWriter, r *http.Request, err error) {
+	requestlog.Error(r.Context(), c.log, err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func (c *webhookController) render(w http.ResponseWriter, r *http.Request, status int, data *webhookPageData) {
+	data.Title = "Webhooks"
+	data.EventTypes = EventTypes()
+	data.SignatureHeader = HeaderSignature
+	data.MaxAttempts = maxDeliveryAttempts
+	data.Account = AccountFromContext(r.Context())
+	data.CSRF = CSRFFromContext(r.Context())
+
+	var err error
+	if data.Webhooks, err = c.hookRepo.List(r.Context()); err == nil {
+		data.Deliveries, err = c.deliveryRepo.List(r.Context(), maxDeliveries)
+	}
+	if err != nil {
+		c.failed(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := webhookPage().ExecuteTemplate(w, "page", data); err != nil {
+		requestlog.Error(r.Context(), c.log, err)
+	}
+}
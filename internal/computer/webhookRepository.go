@@ -0,0 +1,478 @@
+package computer
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"gopkg.in/guregu/null.v3"
+)
+
+// Webhook is a target events are posted to, see Webhooks.
+type Webhook struct {
+	ID      null.Int    `db:"id" json:"id"`
+	Created null.String `db:"created" json:"created"`
+	Deleted null.String `db:"deleted" json:"-"`
+
+	Name null.String `db:"name" json:"name"`
+	URL  null.String `db:"url" json:"url"`
+
+	// Secret signs the payloads, see signPayload.
+	Secret null.String `db:"secret" json:"-"`
+
+	// Events is a comma separated list of the event types posted, every
+	// type when empty.
+	Events null.String `db:"events" json:"events"`
+}
+
+// Subscribes reports whether events of type typ are posted to the webhook.
+// Pings are posted to every webhook.
+func (w *Webhook) Subscribes(typ string) bool {
+	if typ == EventPing || w.Events.String == "" {
+		return true
+	}
+	for _, e := range strings.Split(w.Events.String, ",") {
+		if strings.TrimSpace(e) == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery states.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is a queued post of an event to a webhook.
+type WebhookDelivery struct {
+	ID        null.Int    `db:"id"`
+	Created   null.String `db:"created"`
+	WebhookID null.Int    `db:"webhook_id"`
+	EventID   null.String `db:"event_id"`
+	EventType null.String `db:"event_type"`
+	Payload   null.String `db:"payload"`
+	State     null.String `db:"state"`
+	Attempts  null.Int    `db:"attempts"`
+
+	// NextAttempt is when a pending delivery is posted again.
+	NextAttempt null.String `db:"next_attempt"`
+	LastAttempt null.String `db:"last_attempt"`
+	StatusCode  null.Int    `db:"status_code"`
+	Error       null.String `db:"error"`
+	Delivered   null.String `db:"delivered"`
+
+	// WebhookName, URL and Secret are set by Due and List.
+	WebhookName null.String `db:"webhook_name"`
+	URL         null.String `db:"url"`
+	Secret      null.String `db:"secret"`
+}
+
+type WebhookRepository interface {
+	Install(context.Context) error
+	Create(context.Context, *Webhook) (int64, error)
+	Select(context.Context, int) (*Webhook, error)
+	Delete(context.Context, int) error
+	List(context.Context) ([]Webhook, error)
+}
+
+type webhookRepository struct {
+	db *sqlx.DB
+}
+
+func NewWebhookRepository(db *sqlx.DB) WebhookRepository {
+	return &webhookRepository{
+		db: db,
+	}
+}
+
+func (r *webhookRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE webhooks (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "deleted" TEXT,
+            "name" TEXT NOT NULL,
+            "url" TEXT NOT NULL,
+            "secret" TEXT NOT NULL,
+            "events" TEXT
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (r *webhookRepository) Create(ctx context.Context, data *Webhook) (int64, error) {
+	defer observeQuery("webhook", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO webhooks (
+            created,
+            name,
+            url,
+            secret,
+            events
+        ) VALUES (?,?,?,?,?)`,
+		time.Now().Format("2006-01-02 15:04:05"),
+		data.Name,
+		data.URL,
+		data.Secret,
+		data.Events,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+func (r *webhookRepository) Select(ctx context.Context, id int) (*Webhook, error) {
+	defer observeQuery("webhook", "Select", time.Now())
+
+	data := Webhook{}
+
+	err := r.db.GetContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            name,
+            url,
+            secret,
+            events
+        FROM webhooks
+        WHERE id=?
+        AND deleted IS NULL`),
+		id,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (r *webhookRepository) Delete(ctx context.Context, id int) error {
+	defer observeQuery("webhook", "Delete", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE webhooks SET
+            deleted=?
+        WHERE id=?`),
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+	)
+	return err
+}
+
+func (r *webhookRepository) List(ctx context.Context) ([]Webhook, error) {
+	defer observeQuery("webhook", "List", time.Now())
+
+	data := []Webhook{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            id,
+            created,
+            name,
+            url,
+            secret,
+            events
+        FROM webhooks
+        WHERE deleted IS NULL
+        ORDER BY name`),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+type DeliveryRepository interface {
+	Install(context.Context) error
+	Create(context.Context, *WebhookDelivery) (int64, error)
+
+	// Due returns the pending deliveries to live webhooks whose next
+	// attempt is before now, oldest first.
+	Due(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+
+	// Attempted records the outcome of posting a delivery. Pending
+	// deliveries are attempted again at next.
+	Attempted(ctx context.Context, id int64, state string, status int, message string, next time.Time) error
+
+	// Retry queues a failed delivery again.
+	Retry(context.Context, int) error
+
+	// List returns the newest deliveries.
+	List(ctx context.Context, limit int) ([]WebhookDelivery, error)
+
+	// Prune deletes the delivered and failed deliveries last attempted
+	// before before and returns their number.
+	Prune(ctx context.Context, before time.Time) (int64, error)
+}
+
+type deliveryRepository struct {
+	db *sqlx.DB
+}
+
+func NewDeliveryRepository(db *sqlx.DB) DeliveryRepository {
+	return &deliveryRepository{
+		db: db,
+	}
+}
+
+func (r *deliveryRepository) Install(ctx context.Context) error {
+	d := database.DialectOf(r.db)
+
+	_, err := r.db.ExecContext(
+		ctx,
+		`CREATE TABLE webhook_deliveries (
+            `+d.PrimaryKey+`,
+            "created" TEXT,
+            "webhook_id" INTEGER NOT NULL,
+            "event_id" TEXT NOT NULL,
+            "event_type" TEXT NOT NULL,
+            "payload" TEXT NOT NULL,
+            "state" TEXT NOT NULL,
+            "attempts" INTEGER NOT NULL,
+            "next_attempt" TEXT,
+            "last_attempt" TEXT,
+            "status_code" INTEGER,
+            "error" TEXT,
+            "delivered" TEXT`+d.ForeignKey("webhook_id", "webhooks")+`
+        )`,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX webhook_deliveries_due ON webhook_deliveries (state, next_attempt)`)
+	return err
+}
+
+func (r *deliveryRepository) Create(ctx context.Context, data *WebhookDelivery) (int64, error) {
+	defer observeQuery("webhook_delivery", "Create", time.Now())
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+
+	if err != nil {
+		return -1, err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	id, err := database.Insert(
+		ctx,
+		tx,
+		`INSERT INTO webhook_deliveries (
+            created,
+            webhook_id,
+            event_id,
+            event_type,
+            payload,
+            state,
+            attempts,
+            next_attempt
+        ) VALUES (?,?,?,?,?,?,?,?)`,
+		now,
+		data.WebhookID,
+		data.EventID,
+		data.EventType,
+		data.Payload,
+		DeliveryPending,
+		0,
+		now,
+	)
+
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+
+	tx.Commit()
+	return id, nil
+}
+
+// deliveryColumns are selected by every query returning deliveries.
+const deliveryColumns = `d.id,
+            d.created,
+            d.webhook_id,
+            d.event_id,
+            d.event_type,
+            d.payload,
+            d.state,
+            d.attempts,
+            d.next_attempt,
+            d.last_attempt,
+            d.status_code,
+            d.error,
+            d.delivered,
+            w.name AS webhook_name,
+            w.url,
+            w.secret`
+
+func (r *deliveryRepository) Due(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	defer observeQuery("webhook_delivery", "Due", time.Now())
+
+	data := []WebhookDelivery{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            `+deliveryColumns+`
+        FROM webhook_deliveries d
+        INNER JOIN webhooks w ON w.id = d.webhook_id
+        WHERE d.state=?
+        AND d.next_attempt<=?
+        AND w.deleted IS NULL
+        ORDER BY d.next_attempt, d.id
+        LIMIT ?`),
+		DeliveryPending,
+		now.Format("2006-01-02 15:04:05"),
+		limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *deliveryRepository) Attempted(ctx context.Context, id int64, state string, status int, message string, next time.Time) error {
+	defer observeQuery("webhook_delivery", "Attempted", time.Now())
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+
+	var delivered, nextAttempt, errMessage null.String
+	var statusCode null.Int
+	switch state {
+	case DeliveryDelivered:
+		delivered = null.StringFrom(now)
+	case DeliveryPending:
+		nextAttempt = null.StringFrom(next.Format("2006-01-02 15:04:05"))
+	}
+	if status > 0 {
+		statusCode = null.IntFrom(int64(status))
+	}
+	if message != "" {
+		errMessage = null.StringFrom(message)
+	}
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE webhook_deliveries SET
+            state=?,
+            attempts=attempts+1,
+            next_attempt=?,
+            last_attempt=?,
+            status_code=?,
+            error=?,
+            delivered=?
+        WHERE id=?`),
+		state,
+		nextAttempt,
+		now,
+		statusCode,
+		errMessage,
+		delivered,
+		id,
+	)
+	return err
+}
+
+func (r *deliveryRepository) Retry(ctx context.Context, id int) error {
+	defer observeQuery("webhook_delivery", "Retry", time.Now())
+
+	_, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`UPDATE webhook_deliveries SET
+            state=?,
+            attempts=0,
+            next_attempt=?
+        WHERE id=?
+        AND state=?`),
+		DeliveryPending,
+		time.Now().Format("2006-01-02 15:04:05"),
+		id,
+		DeliveryFailed,
+	)
+	return err
+}
+
+func (r *deliveryRepository) List(ctx context.Context, limit int) ([]WebhookDelivery, error) {
+	defer observeQuery("webhook_delivery", "List", time.Now())
+
+	data := []WebhookDelivery{}
+
+	err := r.db.SelectContext(
+		ctx,
+		&data,
+		r.db.Rebind(`SELECT
+            `+deliveryColumns+`
+        FROM webhook_deliveries d
+        INNER JOIN webhooks w ON w.id = d.webhook_id
+        ORDER BY d.id DESC
+        LIMIT ?`),
+		limit,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (r *deliveryRepository) Prune(ctx context.Context, before time.Time) (int64, error) {
+	defer observeQuery("webhook_delivery", "Prune", time.Now())
+
+	res, err := r.db.ExecContext(
+		ctx,
+		r.db.Rebind(`DELETE FROM webhook_deliveries
+        WHERE state IN (?,?)
+        AND last_attempt<?`),
+		DeliveryDelivered,
+		DeliveryFailed,
+		before.Format("2006-01-02 15:04:05"),
+	)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
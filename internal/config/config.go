@@ -0,0 +1,671 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/stockholmr/fpsmonitor/internal/database"
+	"github.com/stockholmr/fpsmonitor/internal/mail"
+	"github.com/stockholmr/fpsmonitor/internal/tlsconfig"
+	ini "gopkg.in/ini.v1"
+)
+
+// EnvPrefix prefixes the environment variables overriding config keys, e.g.
+// FPSMONITOR_SERVER_PORT.
+const EnvPrefix = "FPSMONITOR_"
+
+// Keys tagged reload:"true" are applied on SIGHUP, every other key needs a
+// restart. Keys tagged secret:"true" are masked by Print.
+type ServerConfig struct {
+	ListenAddress     string        `ini:"Listen"`
+	Port              string        `ini:"Port"`
+	SessionKey        string        `ini:"SessionKey" secret:"true"`
+	DrainDelay        time.Duration `ini:"DrainDelay"`
+	CertFile          string        `ini:"CertFile"`
+	KeyFile           string        `ini:"KeyFile"`
+	MinTLSVersion     string        `ini:"MinTLSVersion"`
+	ClientCAFile      string        `ini:"ClientCAFile"`
+	RequireClientCert bool          `ini:"RequireClientCert"`
+
+	// AdminToken protects the admin endpoints such as backups, which are
+	// disabled without it, and lets scripts use the inventory as an admin.
+	AdminToken string `ini:"AdminToken" secret:"true"`
+}
+
+type DatabaseConfig struct {
+	Driver       string `ini:"Driver"`
+	File         string `ini:"File"`
+	DSN          string `ini:"DSN" secret:"true"`
+	MinFreeSpace int    `ini:"MinFreeSpace"`
+
+	// SQLite pragmas
+	JournalMode string        `ini:"JournalMode"`
+	BusyTimeout time.Duration `ini:"BusyTimeout"`
+	ForeignKeys bool          `ini:"ForeignKeys"`
+	Synchronous string        `ini:"Synchronous"`
+
+	MaxOpenConns    int           `ini:"MaxOpenConns"`
+	MaxIdleConns    int           `ini:"MaxIdleConns"`
+	ConnMaxLifetime time.Duration `ini:"ConnMaxLifetime"`
+}
+
+// DataSource returns the data source name passed to the driver, the file
+// with its pragmas for SQLite and DSN for every other driver.
+func (d DatabaseConfig) DataSource() string {
+	if d.Driver == database.SQLite {
+		return database.SQLiteDSN(d.File, database.SQLiteOptions{
+			JournalMode: d.JournalMode,
+			BusyTimeout: d.BusyTimeout,
+			ForeignKeys: d.ForeignKeys,
+			Synchronous: d.Synchronous,
+		})
+	}
+	return d.DSN
+}
+
+func (d DatabaseConfig) Pool() database.PoolOptions {
+	return database.PoolOptions{
+		MaxOpenConns:    d.MaxOpenConns,
+		MaxIdleConns:    d.MaxIdleConns,
+		ConnMaxLifetime: d.ConnMaxLifetime,
+	}
+}
+
+type LoggingConfig struct {
+	File  string `ini:"File"`
+	Level string `ini:"Level" reload:"true"`
+}
+
+// BackupConfig schedules SQLite backups, an Interval of 0 disables them.
+// Other drivers are backed up with their own tooling.
+type BackupConfig struct {
+	Dir      string        `ini:"Dir"`
+	Interval time.Duration `ini:"Interval"`
+	Keep     int           `ini:"Keep" reload:"true"`
+}
+
+// AlertsConfig schedules the evaluation of the alert rules not matched on
+// reports, such as stale computers. An Interval of 0 disables it.
+type AlertsConfig struct {
+	Interval time.Duration `ini:"Interval"`
+}
+
+// WebhooksConfig schedules the posting of queued webhook deliveries. An
+// Interval of 0 disables it, events are still queued. Delivered and failed
+// deliveries are kept for Retention.
+type WebhooksConfig struct {
+	Interval  time.Duration `ini:"Interval"`
+	Retention time.Duration `ini:"Retention" reload:"true"`
+}
+
+// AgentsConfig sets the oldest agent version accepted without asking it to
+// upgrade, such as "1.4" or "v1.4.2". Every version is accepted when
+// MinVersion is empty.
+type AgentsConfig struct {
+	MinVersion string `ini:"MinVersion"`
+}
+
+// agentVersion matches the versions MinVersion can be set to.
+var agentVersion = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+){0,2}$`)
+
+// CheckinsConfig is the schedule agents are expected to check in on and
+// how long check-ins are kept before they are compacted into hourly and
+// daily rollups every Interval. An Interval of 0 disables compaction.
+type CheckinsConfig struct {
+	Expected  time.Duration `ini:"Expected"`
+	Retention time.Duration `ini:"Retention" reload:"true"`
+	Interval  time.Duration `ini:"Interval"`
+}
+
+// SMTPConfig is the server alert and digest mail is sent through. Mail is
+// queued but not sent without Host. TLS is used by servers on port 465,
+// StartTLS by those on port 587.
+type SMTPConfig struct {
+	Host     string `ini:"Host"`
+	Port     int    `ini:"Port"`
+	Username string `ini:"Username"`
+	Password string `ini:"Password" secret:"true"`
+	From     string `ini:"From"`
+	StartTLS bool   `ini:"StartTLS"`
+	TLS      bool   `ini:"TLS"`
+}
+
+func (s SMTPConfig) Mail() mail.Config {
+	return mail.Config{
+		Host:     s.Host,
+		Port:     s.Port,
+		Username: s.Username,
+		Password: s.Password,
+		From:     s.From,
+		StartTLS: s.StartTLS,
+		TLS:      s.TLS,
+	}
+}
+
+// DigestConfig schedules the digest mails, daily ones are sent at Hour
+// and weekly ones at Hour on WeeklyOn.
+type DigestConfig struct {
+	Hour     int    `ini:"Hour"`
+	WeeklyOn string `ini:"WeeklyOn"`
+}
+
+// Weekday returns the day of WeeklyOn, Monday when it is not a day name.
+func (d DigestConfig) Weekday() time.Weekday {
+	day, _ := parseWeekday(d.WeeklyOn)
+	return day
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(day.String(), strings.TrimSpace(s)) {
+			return day, true
+		}
+	}
+	return time.Monday, false
+}
+
+type Config struct {
+	Server   ServerConfig   `ini:"Server"`
+	Database DatabaseConfig `ini:"Database"`
+	Logging  LoggingConfig  `ini:"Logging"`
+	Backup   BackupConfig   `ini:"Backup"`
+	Alerts   AlertsConfig   `ini:"Alerts"`
+	Webhooks WebhooksConfig `ini:"Webhooks"`
+	Checkins CheckinsConfig `ini:"Checkins"`
+	Agents   AgentsConfig   `ini:"Agents"`
+	SMTP     SMTPConfig     `ini:"SMTP"`
+	Digest   DigestConfig   `ini:"Digest"`
+}
+
+// Default returns the configuration used for keys missing from the file.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddress: "",
+			Port:          "8080",
+			DrainDelay:    time.Second * 5,
+			MinTLSVersion: "1.2",
+		},
+		Database: DatabaseConfig{
+			Driver:       database.SQLite,
+			File:         "fpsmonitor.sqlite",
+			MinFreeSpace: 100,
+
+			JournalMode: "WAL",
+			BusyTimeout: time.Second * 5,
+			ForeignKeys: true,
+			Synchronous: "NORMAL",
+
+			MaxOpenConns: 10,
+			MaxIdleConns: 5,
+		},
+		Logging: LoggingConfig{
+			File:  "fpsmonitor.log",
+			Level: "TRACE",
+		},
+		Backup: BackupConfig{
+			Dir:      "backups",
+			Interval: time.Hour * 24,
+			Keep:     7,
+		},
+		Alerts: AlertsConfig{
+			Interval: time.Minute * 15,
+		},
+		Webhooks: WebhooksConfig{
+			Interval:  time.Second * 10,
+			Retention: time.Hour * 24 * 30,
+		},
+		Checkins: CheckinsConfig{
+			Expected:  time.Hour * 24,
+			Retention: time.Hour * 24 * 7,
+			Interval:  time.Hour,
+		},
+		SMTP: SMTPConfig{
+			Port: 25,
+		},
+		Digest: DigestConfig{
+			Hour:     7,
+			WeeklyOn: "Monday",
+		},
+	}
+}
+
+// Levels maps the Logging.Level names to lumber levels.
+var Levels = map[string]int{
+	"TRACE": 0,
+	"DEBUG": 1,
+	"INFO":  2,
+	"WARN":  3,
+	"ERROR": 4,
+	"FATAL": 5,
+}
+
+// LogLevel returns the lumber level of Logging.Level.
+func (c *Config) LogLevel() int {
+	return Levels[strings.ToUpper(c.Logging.Level)]
+}
+
+// WriteDefault creates file holding the default configuration with a newly
+// generated session key.
+func WriteDefault(file string) error {
+	c := Default()
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	c.Server.SessionKey = base64.StdEncoding.EncodeToString(key)
+
+	f, err := reflectFrom(c)
+	if err != nil {
+		return err
+	}
+	return f.SaveTo(file)
+}
+
+// renamedKeys are the keys read under their old name when the new one is
+// not set, see Deprecated.
+var renamedKeys = []struct {
+	Old Key
+	New Key
+}{
+	{Key{Section: "Backup", Name: "AdminToken"}, Key{Section: "Server", Name: "AdminToken"}},
+}
+
+// Deprecated returns a warning for every key in file that was renamed.
+func Deprecated(file string) ([]string, error) {
+	f, err := ini.Load(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for _, r := range renamedKeys {
+		if f.Section(r.Old.Section).HasKey(r.Old.Name) {
+			warnings = append(warnings, fmt.Sprintf("%s.%s is deprecated, use %s.%s",
+				r.Old.Section, r.Old.Name, r.New.Section, r.New.Name))
+		}
+	}
+	return warnings, nil
+}
+
+// Load reads file on top of the defaults and applies the environment and
+// flag overrides, in that order. Renamed keys are still read under their
+// old name. The result is not validated.
+func Load(file string, environ []string, flags map[string]string) (*Config, error) {
+	f, err := reflectFrom(Default())
+	if err != nil {
+		return nil, err
+	}
+
+	if file != "" {
+		if err := f.Append(file); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, r := range renamedKeys {
+		if !f.Section(r.Old.Section).HasKey(r.Old.Name) {
+			continue
+		}
+		if key := f.Section(r.New.Section).Key(r.New.Name); key.String() == "" {
+			key.SetValue(f.Section(r.Old.Section).Key(r.Old.Name).String())
+		}
+	}
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv[:i], EnvPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+
+	for _, k := range Keys() {
+		if v, ok := env[k.Env()]; ok {
+			f.Section(k.Section).Key(k.Name).SetValue(v)
+		}
+		if v, ok := flags[k.Flag()]; ok {
+			f.Section(k.Section).Key(k.Name).SetValue(v)
+		}
+	}
+
+	c := new(Config)
+	if err := f.MapTo(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate returns every problem found in the configuration.
+func (c *Config) Validate() error {
+	var errs []string
+	add := func(key string, format string, v ...interface{}) {
+		errs = append(errs, key+": "+fmt.Sprintf(format, v...))
+	}
+
+	if c.Server.Port == "" {
+		add("Server.Port", "is required")
+	} else {
+		var port int
+		if _, err := fmt.Sscanf(c.Server.Port, "%d", &port); err != nil || port < 1 || port > 65535 || fmt.Sprint(port) != c.Server.Port {
+			add("Server.Port", "%q is not a valid port", c.Server.Port)
+		}
+	}
+
+	if c.Server.SessionKey == "" {
+		add("Server.SessionKey", "is required, session cookies can not be secured without it")
+	} else if len(c.Server.SessionKey) < 32 {
+		add("Server.SessionKey", "must be at least 32 characters")
+	}
+
+	if c.Server.AdminToken != "" && len(c.Server.AdminToken) < 32 {
+		add("Server.AdminToken", "must be at least 32 characters")
+	}
+
+	if c.Server.DrainDelay < 0 {
+		add("Server.DrainDelay", "can not be negative")
+	}
+
+	if (c.Server.CertFile == "") != (c.Server.KeyFile == "") {
+		add("Server.CertFile", "CertFile and KeyFile must be set together")
+	}
+
+	if _, err := tlsconfig.ParseVersion(c.Server.MinTLSVersion); err != nil {
+		add("Server.MinTLSVersion", "%s", err)
+	}
+
+	if c.Server.ClientCAFile != "" && c.Server.CertFile == "" {
+		add("Server.ClientCAFile", "requires CertFile and KeyFile")
+	}
+
+	if c.Server.RequireClientCert && c.Server.ClientCAFile == "" {
+		add("Server.RequireClientCert", "requires ClientCAFile")
+	}
+
+	for _, file := range []struct{ key, path string }{
+		{"Server.CertFile", c.Server.CertFile},
+		{"Server.KeyFile", c.Server.KeyFile},
+		{"Server.ClientCAFile", c.Server.ClientCAFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			add(file.key, "%s", err)
+		}
+	}
+
+	switch c.Database.Driver {
+	case database.SQLite:
+		if c.Database.File == "" {
+			add("Database.File", "is required")
+		}
+		if !oneOf(c.Database.JournalMode, database.JournalModes) {
+			add("Database.JournalMode", "%q is not one of %s", c.Database.JournalMode, strings.Join(database.JournalModes, ", "))
+		}
+		if !oneOf(c.Database.Synchronous, database.SynchronousModes) {
+			add("Database.Synchronous", "%q is not one of %s", c.Database.Synchronous, strings.Join(database.SynchronousModes, ", "))
+		}
+		if c.Database.BusyTimeout < 0 {
+			add("Database.BusyTimeout", "can not be negative")
+		}
+	case database.Postgres:
+		if c.Database.DSN == "" {
+			add("Database.DSN", "is required for the %s driver", c.Database.Driver)
+		}
+	default:
+		add("Database.Driver", "%q is not one of %s", c.Database.Driver, strings.Join(database.Drivers(), ", "))
+	}
+
+	if c.Database.MinFreeSpace < 0 {
+		add("Database.MinFreeSpace", "can not be negative")
+	}
+
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 || c.Database.ConnMaxLifetime < 0 {
+		add("Database.MaxOpenConns", "connection pool limits can not be negative")
+	}
+
+	if c.Logging.File == "" {
+		add("Logging.File", "is required")
+	}
+
+	if _, ok := Levels[strings.ToUpper(c.Logging.Level)]; !ok {
+		add("Logging.Level", "%q is not one of TRACE, DEBUG, INFO, WARN, ERROR or FATAL", c.Logging.Level)
+	}
+
+	if c.Backup.Interval < 0 {
+		add("Backup.Interval", "can not be negative")
+	}
+
+	if c.Backup.Interval > 0 && c.Backup.Dir == "" {
+		add("Backup.Dir", "is required when Interval is set")
+	}
+
+	if c.Backup.Keep < 0 {
+		add("Backup.Keep", "can not be negative")
+	}
+
+	if c.Alerts.Interval < 0 {
+		add("Alerts.Interval", "can not be negative")
+	}
+
+	if c.Webhooks.Interval < 0 {
+		add("Webhooks.Interval", "can not be negative")
+	}
+
+	if c.Webhooks.Retention <= 0 {
+		add("Webhooks.Retention", "must be positive")
+	}
+
+	if c.Checkins.Expected <= 0 {
+		add("Checkins.Expected", "must be positive")
+	}
+
+	if c.Checkins.Retention <= 0 {
+		add("Checkins.Retention", "must be positive")
+	}
+
+	if c.Checkins.Interval < 0 {
+		add("Checkins.Interval", "can not be negative")
+	}
+
+	if c.Agents.MinVersion != "" && !agentVersion.MatchString(c.Agents.MinVersion) {
+		add("Agents.MinVersion", "%q is not a version such as 1.4.2", c.Agents.MinVersion)
+	}
+
+	if c.SMTP.Host != "" {
+		if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+			add("SMTP.Port", "%d is not a valid port", c.SMTP.Port)
+		}
+		if c.SMTP.From == "" {
+			add("SMTP.From", "is required when Host is set")
+		}
+		if c.SMTP.TLS && c.SMTP.StartTLS {
+			add("SMTP.TLS", "TLS and StartTLS can not be set together")
+		}
+		// The password is only sent over an encrypted connection or to
+		// the local host.
+		if c.SMTP.Username != "" && !c.SMTP.TLS && !c.SMTP.StartTLS && !localHost(c.SMTP.Host) {
+			add("SMTP.Username", "requires TLS or StartTLS unless Host is localhost")
+		}
+	}
+
+	if c.Digest.Hour < 0 || c.Digest.Hour > 23 {
+		add("Digest.Hour", "must be between 0 and 23")
+	}
+
+	if _, ok := parseWeekday(c.Digest.WeeklyOn); !ok {
+		add("Digest.WeeklyOn", "%q is not a day of the week", c.Digest.WeeklyOn)
+	}
+
+	if len(errs) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// Print writes the effective configuration in ini format with secrets
+// masked.
+func (c *Config) Print(w io.Writer) error {
+	f, err := reflectFrom(c)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range Keys() {
+		if k.Secret {
+			key := f.Section(k.Section).Key(k.Name)
+			if key.String() != "" {
+				key.SetValue("********")
+			}
+		}
+	}
+
+	_, err = f.WriteTo(w)
+	return err
+}
+
+// reflectFrom converts c to an ini file, writing durations in their
+// readable form rather than as nanoseconds.
+func reflectFrom(c *Config) (*ini.File, error) {
+	f := ini.Empty()
+	if err := f.ReflectFrom(c); err != nil {
+		return nil, err
+	}
+
+	v := reflect.ValueOf(c).Elem()
+	for _, k := range Keys() {
+		if d, ok := v.FieldByIndex(k.index).Interface().(time.Duration); ok {
+			f.Section(k.Section).Key(k.Name).SetValue(d.String())
+		}
+	}
+	return f, nil
+}
+
+// Change describes a key that differs between two configurations.
+type Change struct {
+	Key    string
+	Reload bool
+}
+
+// Diff lists the keys whose values differ between c and next.
+func (c *Config) Diff(next *Config) []Change {
+	var changes []Change
+
+	cur := reflect.ValueOf(c).Elem()
+	nxt := reflect.ValueOf(next).Elem()
+	for _, k := range Keys() {
+		a := cur.FieldByIndex(k.index)
+		b := nxt.FieldByIndex(k.index)
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			changes = append(changes, Change{
+				Key:    k.Section + "." + k.Name,
+				Reload: k.Reload,
+			})
+		}
+	}
+	return changes
+}
+
+// Key describes a single configuration key.
+type Key struct {
+	Section string
+	Name    string
+	Reload  bool
+	Secret  bool
+	index   []int
+}
+
+// Env returns the environment variable overriding the key.
+func (k Key) Env() string {
+	return EnvPrefix + strings.ToUpper(snake(k.Section, "_")+"_"+snake(k.Name, "_"))
+}
+
+// Flag returns the command line flag overriding the key.
+func (k Key) Flag() string {
+	return snake(k.Section, "-") + "." + snake(k.Name, "-")
+}
+
+// Keys returns every configuration key.
+func Keys() []Key {
+	var list []Key
+
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		section := t.Field(i)
+		for j := 0; j < section.Type.NumField(); j++ {
+			field := section.Type.Field(j)
+			name := field.Tag.Get("ini")
+			if name == "" || name == "-" {
+				continue
+			}
+
+			list = append(list, Key{
+				Section: section.Tag.Get("ini"),
+				Name:    name,
+				Reload:  field.Tag.Get("reload") == "true",
+				Secret:  field.Tag.Get("secret") == "true",
+				index:   []int{i, j},
+			})
+		}
+	}
+	return list
+}
+
+func oneOf(v string, list []string) bool {
+	for _, item := range list {
+		if strings.EqualFold(v, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// localHost reports whether host is the local host, which net/smtp sends
+// passwords to without TLS.
+func localHost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// snake converts CamelCase to lower case words joined by sep, keeping
+// acronyms such as TLS or CA together.
+func snake(s string, sep string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i, r := range runes {
+		upper := r >= 'A' && r <= 'Z'
+		if upper && i > 0 {
+			prevLower := runes[i-1] >= 'a' && runes[i-1] <= 'z'
+			nextLower := i+1 < len(runes) && runes[i+1] >= 'a' && runes[i+1] <= 'z'
+			prevUpper := runes[i-1] >= 'A' && runes[i-1] <= 'Z'
+			if prevLower || (prevUpper && nextLower) {
+				b.WriteString(sep)
+			}
+		}
+		b.WriteString(strings.ToLower(string(r)))
+	}
+	return b.String()
+}
+
+// Reload copies the keys tagged reload:"true" from next and returns the
+// changes between the two configurations.
+func (c *Config) Reload(next *Config) []Change {
+	changes := c.Diff(next)
+
+	cur := reflect.ValueOf(c).Elem()
+	nxt := reflect.ValueOf(next).Elem()
+	for _, k := range Keys() {
+		if k.Reload {
+			cur.FieldByIndex(k.index).Set(nxt.FieldByIndex(k.index))
+		}
+	}
+	return changes
+}
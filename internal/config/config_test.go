@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: "+msg+"\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: unexpected error: %s\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func TestWriteDefault(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fpsmonitor.ini")
+	ok(t, WriteDefault(file))
+
+	c, err := Load(file, nil, nil)
+	ok(t, err)
+	ok(t, c.Validate())
+
+	equals(t, "8080", c.Server.Port)
+	equals(t, 44, len(c.Server.SessionKey))
+	equals(t, time.Second*5, c.Server.DrainDelay)
+}
+
+func TestLoadOverrides(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fpsmonitor.ini")
+	ok(t, ioutil.WriteFile(file, []byte("[Server]\nPort = 9000\nDrainDelay = 1s\n\n[Logging]\nLevel = INFO\n"), 0600))
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := Flags(fs)
+	ok(t, fs.Parse([]string{"-logging.level", "ERROR", "-server.min-tls-version", "1.3"}))
+
+	c, err := Load(file, []string{
+		"FPSMONITOR_SERVER_PORT=9100",
+		"FPSMONITOR_LOGGING_LEVEL=WARN",
+		"FPSMONITOR_DATABASE_MIN_FREE_SPACE=5",
+		"HOME=/root",
+	}, flags())
+	ok(t, err)
+
+	equals(t, "9100", c.Server.Port)
+	equals(t, time.Second, c.Server.DrainDelay)
+	equals(t, "1.3", c.Server.MinTLSVersion)
+	equals(t, "ERROR", c.Logging.Level)
+	equals(t, 4, c.LogLevel())
+	equals(t, 5, c.Database.MinFreeSpace)
+	equals(t, "fpsmonitor.sqlite", c.Database.File)
+}
+
+func TestRenamedKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fpsmonitor.ini")
+	ok(t, ioutil.WriteFile(file, []byte("[Backup]\nAdminToken = an-admin-token-of-at-least-32-chars\n"), 0600))
+
+	c, err := Load(file, nil, nil)
+	ok(t, err)
+	equals(t, "an-admin-token-of-at-least-32-chars", c.Server.AdminToken)
+
+	warnings, err := Deprecated(file)
+	ok(t, err)
+	equals(t, []string{"Backup.AdminToken is deprecated, use Server.AdminToken"}, warnings)
+
+	// The new key wins.
+	ok(t, ioutil.WriteFile(file, []byte("[Server]\nAdminToken = new\n\n[Backup]\nAdminToken = old\n"), 0600))
+	c, err = Load(file, nil, nil)
+	ok(t, err)
+	equals(t, "new", c.Server.AdminToken)
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	c.Server.Port = "80a"
+	c.Server.CertFile = "server.crt"
+	c.Server.RequireClientCert = true
+	c.Logging.Level = "LOUD"
+	c.Backup.Keep = -1
+	c.Alerts.Interval = -time.Minute
+	c.Webhooks.Interval = -time.Second
+	c.Webhooks.Retention = 0
+	c.Checkins.Expected = 0
+	c.Checkins.Interval = -time.Hour
+	c.Agents.MinVersion = "latest"
+	c.SMTP.Host = "mail.example.com"
+	c.SMTP.Port = 0
+	c.SMTP.Username = "fpsmonitor"
+	c.Digest.Hour = 24
+	c.Digest.WeeklyOn = "Someday"
+	c.Server.AdminToken = "short"
+
+	err := c.Validate()
+	assert(t, err != nil, "expected validation to fail")
+
+	for _, key := range []string{
+		"Server.Port",
+		"Server.SessionKey: is required",
+		"Server.CertFile: CertFile and KeyFile must be set together",
+		"Server.RequireClientCert",
+		"Logging.Level",
+		"Backup.Keep",
+		"Alerts.Interval",
+		"Webhooks.Interval",
+		"Webhooks.Retention",
+		"Checkins.Expected",
+		"Checkins.Interval",
+		"Agents.MinVersion",
+		"SMTP.Port",
+		"SMTP.From",
+		"SMTP.Username: requires TLS or StartTLS",
+		"Digest.Hour",
+		"Digest.WeeklyOn",
+		"Server.AdminToken: must be at least 32 characters",
+	} {
+		assert(t, strings.Contains(err.Error(), key), "missing %s in: %s", key, err)
+	}
+}
+
+func TestPrintMasksSecrets(t *testing.T) {
+	c := Default()
+	c.Server.SessionKey = "a-very-secret-session-key-of-32-chars"
+
+	buf := new(bytes.Buffer)
+	ok(t, c.Print(buf))
+
+	assert(t, !strings.Contains(buf.String(), c.Server.SessionKey), "session key printed: %s", buf.String())
+	assert(t, strings.Contains(buf.String(), "SessionKey        = ********"), "masked key missing: %s", buf.String())
+}
+
+func TestDiff(t *testing.T) {
+	a := Default()
+	b := Default()
+	b.Logging.Level = "INFO"
+	b.Server.Port = "9000"
+
+	equals(t, []Change{
+		{Key: "Server.Port", Reload: false},
+		{Key: "Logging.Level", Reload: true},
+	}, a.Diff(b))
+}
+
+func TestKeyNames(t *testing.T) {
+	k := Key{Section: "Server", Name: "MinTLSVersion"}
+	equals(t, "FPSMONITOR_SERVER_MIN_TLS_VERSION", k.Env())
+	equals(t, "server.min-tls-version", k.Flag())
+
+	k = Key{Section: "Server", Name: "ClientCAFile"}
+	equals(t, "server.client-ca-file", k.Flag())
+}
+
+func TestReload(t *testing.T) {
+	a := Default()
+	b := Default()
+	b.Logging.Level = "INFO"
+	b.Server.Port = "9000"
+	b.Backup.Keep = 30
+	b.Checkins.Retention = time.Hour * 48
+	b.Webhooks.Retention = time.Hour * 72
+
+	changes := a.Reload(b)
+	equals(t, 5, len(changes))
+	equals(t, "INFO", a.Logging.Level)
+	equals(t, "8080", a.Server.Port)
+	equals(t, 30, a.Backup.Keep)
+	equals(t, time.Hour*48, a.Checkins.Retention)
+	equals(t, time.Hour*72, a.Webhooks.Retention)
+}
+
+func TestValidateDatabase(t *testing.T) {
+	c := Default()
+	c.Server.SessionKey = "a-very-secret-session-key-of-32-chars"
+	ok(t, c.Validate())
+	equals(t, "fpsmonitor.sqlite?_busy_timeout=5000&_foreign_keys=on&_journal_mode=WAL&_synchronous=NORMAL", c.Database.DataSource())
+
+	c.Database.JournalMode = "fast"
+	err := c.Validate()
+	assert(t, err != nil && strings.Contains(err.Error(), "Database.JournalMode"), "expected journal mode error, got %v", err)
+	c.Database.JournalMode = "wal"
+
+	c.Database.Driver = "postgres"
+	err = c.Validate()
+	assert(t, err != nil && strings.Contains(err.Error(), "Database.DSN"), "expected missing DSN error, got %v", err)
+
+	c.Database.DSN = "postgres://localhost/fpsmonitor"
+	ok(t, c.Validate())
+	equals(t, "postgres://localhost/fpsmonitor", c.Database.DataSource())
+
+	c.Database.Driver = "mysql"
+	err = c.Validate()
+	assert(t, err != nil && strings.Contains(err.Error(), "Database.Driver"), "expected driver error, got %v", err)
+}
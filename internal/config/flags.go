@@ -0,0 +1,24 @@
+package config
+
+import (
+	"flag"
+)
+
+// Flags registers an override flag for every key on fs. The returned
+// function reports the flags that were set once fs has been parsed.
+func Flags(fs *flag.FlagSet) func() map[string]string {
+	values := make(map[string]*string)
+	for _, k := range Keys() {
+		values[k.Flag()] = fs.String(k.Flag(), "", "override "+k.Section+"."+k.Name)
+	}
+
+	return func() map[string]string {
+		set := make(map[string]string)
+		fs.Visit(func(f *flag.Flag) {
+			if v, ok := values[f.Name]; ok {
+				set[f.Name] = *v
+			}
+		})
+		return set
+	}
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Supported driver names.
+const (
+	SQLite   = "sqlite3"
+	Postgres = "postgres"
+)
+
+// Dialect holds the SQL that differs between the supported databases.
+type Dialect struct {
+	Name string
+
+	// PrimaryKey is the column definition of an auto incrementing "id"
+	// primary key.
+	PrimaryKey string
+
+	// Returning is true when inserts report the new id with RETURNING
+	// instead of LastInsertId.
+	Returning bool
+
+	// InlineForeignKeys is true when foreign keys have to be declared in
+	// CREATE TABLE. Other dialects add them once every table exists.
+	InlineForeignKeys bool
+}
+
+var dialects = map[string]Dialect{
+	SQLite: {
+		Name:       SQLite,
+		PrimaryKey: `"id" INTEGER PRIMARY KEY AUTOINCREMENT`,
+		Returning:  false,
+
+		InlineForeignKeys: true,
+	},
+	Postgres: {
+		Name:       Postgres,
+		PrimaryKey: `"id" SERIAL PRIMARY KEY`,
+		Returning:  true,
+
+		InlineForeignKeys: false,
+	},
+}
+
+// Open connects to the database, driver is one of SQLite or Postgres.
+func Open(driver string, dsn string) (*sqlx.DB, error) {
+	if _, ok := dialects[driver]; !ok {
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+	return sqlx.Open(driver, dsn)
+}
+
+// Drivers lists the supported driver names.
+func Drivers() []string {
+	return []string{SQLite, Postgres}
+}
+
+// DialectOf returns the dialect of db, defaulting to SQLite for unknown
+// drivers.
+func DialectOf(db *sqlx.DB) Dialect {
+	if d, ok := dialects[db.DriverName()]; ok {
+		return d
+	}
+	return dialects[SQLite]
+}
+
+// Insert runs an INSERT written with ? placeholders and returns the id of
+// the new row.
+func Insert(ctx context.Context, tx *sqlx.Tx, query string, args ...interface{}) (int64, error) {
+	if dialects[tx.DriverName()].Returning {
+		var id int64
+		err := tx.QueryRowxContext(ctx, tx.Rebind(query+` RETURNING id`), args...).Scan(&id)
+		if err != nil {
+			return -1, err
+		}
+		return id, nil
+	}
+
+	result, err := tx.ExecContext(ctx, tx.Rebind(query), args...)
+	if err != nil {
+		return -1, err
+	}
+
+	id, _ := result.LastInsertId()
+	return id, nil
+}
+
+// TableExists reports whether a table called name exists.
+func TableExists(ctx context.Context, db *sqlx.DB, name string) (bool, error) {
+	var count int
+	var err error
+
+	switch DialectOf(db).Name {
+	case Postgres:
+		err = db.GetContext(
+			ctx,
+			&count,
+			`SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`,
+			name,
+		)
+	default:
+		err = db.GetContext(
+			ctx,
+			&count,
+			`SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name=?`,
+			name,
+		)
+	}
+
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// ColumnExists reports whether table has a column called name.
+func ColumnExists(ctx context.Context, db *sqlx.DB, table string, name string) (bool, error) {
+	var count int
+	var err error
+
+	switch DialectOf(db).Name {
+	case Postgres:
+		err = db.GetContext(
+			ctx,
+			&count,
+			`SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2`,
+			table,
+			name,
+		)
+	default:
+		err = db.GetContext(
+			ctx,
+			&count,
+			`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?`,
+			table,
+			name,
+		)
+	}
+
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// FullTextSearch reports whether the database supports FTS5 full text
+// search tables. SQLite only includes FTS5 when the server is built with
+// the sqlite_fts5 tag.
+func FullTextSearch(ctx context.Context, db *sqlx.DB) (bool, error) {
+	if DialectOf(db).Name != SQLite {
+		return false, nil
+	}
+
+	var used bool
+	err := db.GetContext(ctx, &used, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`)
+	if err != nil {
+		return false, err
+	}
+	return used, nil
+}
+
+// AddColumn adds a column to an existing table unless it is already there,
+// which is the case for tables created by an Install holding the current
+// schema.
+func AddColumn(ctx context.Context, db *sqlx.DB, table string, name string, definition string) error {
+	exists, err := ColumnExists(ctx, db, table, name)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.ExecContext(
+		ctx,
+		fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, table, name, definition),
+	)
+	return err
+}
+
+// ForeignKey returns the CREATE TABLE constraint for column referencing the
+// id of table, or an empty string for dialects where AddForeignKey is used.
+func (d Dialect) ForeignKey(column string, table string) string {
+	if !d.InlineForeignKeys {
+		return ""
+	}
+	return fmt.Sprintf(
+		`,
+            FOREIGN KEY("%s") REFERENCES "%s"("id") ON DELETE CASCADE ON UPDATE NO ACTION`,
+		column,
+		table,
+	)
+}
+
+// AddForeignKey adds the constraint ForeignKey declares inline to an
+// existing table. It does nothing for dialects with inline foreign keys.
+func AddForeignKey(ctx context.Context, db *sqlx.DB, table string, column string, references string) error {
+	if DialectOf(db).InlineForeignKeys {
+		return nil
+	}
+
+	_, err := db.ExecContext(
+		ctx,
+		fmt.Sprintf(
+			`ALTER TABLE "%s" ADD FOREIGN KEY ("%s") REFERENCES "%s"("id") ON DELETE CASCADE ON UPDATE NO ACTION`,
+			table,
+			column,
+			references,
+		),
+	)
+	return err
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: unexpected error: %s\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	dsn := SQLiteDSN("data/fpsmonitor.sqlite", SQLiteOptions{
+		JournalMode: "wal",
+		BusyTimeout: time.Second * 5,
+		ForeignKeys: true,
+		Synchronous: "normal",
+	})
+	equals(t, "data/fpsmonitor.sqlite?_busy_timeout=5000&_foreign_keys=on&_journal_mode=WAL&_synchronous=NORMAL", dsn)
+}
+
+func TestSQLitePragmas(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "fpsmonitor.sqlite")
+
+	db, err := Open(SQLite, SQLiteDSN(file, SQLiteOptions{
+		JournalMode: "WAL",
+		BusyTimeout: time.Second * 3,
+		ForeignKeys: true,
+		Synchronous: "NORMAL",
+	}))
+	ok(t, err)
+	defer db.Close()
+
+	PoolOptions{MaxOpenConns: 4, MaxIdleConns: 2}.Apply(db)
+	equals(t, 4, db.Stats().MaxOpenConnections)
+
+	var journal string
+	ok(t, db.GetContext(ctx, &journal, "PRAGMA journal_mode"))
+	equals(t, "wal", journal)
+
+	var timeout, fk, sync int
+	ok(t, db.GetContext(ctx, &timeout, "PRAGMA busy_timeout"))
+	ok(t, db.GetContext(ctx, &fk, "PRAGMA foreign_keys"))
+	ok(t, db.GetContext(ctx, &sync, "PRAGMA synchronous"))
+	equals(t, 3000, timeout)
+	equals(t, 1, fk)
+	equals(t, 1, sync)
+}
+
+func TestTableExists(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := Open(SQLite, ":memory:")
+	ok(t, err)
+	defer db.Close()
+
+	exists, err := TableExists(ctx, db, "computers")
+	ok(t, err)
+	equals(t, false, exists)
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE computers (`+DialectOf(db).PrimaryKey+`, "name" TEXT)`)
+	ok(t, err)
+
+	exists, err = TableExists(ctx, db, "computers")
+	ok(t, err)
+	equals(t, true, exists)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	ok(t, err)
+	id, err := Insert(ctx, tx, `INSERT INTO computers (name) VALUES (?)`, "PC01")
+	ok(t, err)
+	ok(t, tx.Commit())
+	equals(t, int64(1), id)
+}
+
+func TestAddColumn(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := Open(SQLite, ":memory:")
+	ok(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE computers (`+DialectOf(db).PrimaryKey+`, "name" TEXT)`)
+	ok(t, err)
+
+	exists, err := ColumnExists(ctx, db, "computers", "source")
+	ok(t, err)
+	equals(t, false, exists)
+
+	ok(t, AddColumn(ctx, db, "computers", "source", "TEXT"))
+	ok(t, AddColumn(ctx, db, "computers", "source", "TEXT"))
+
+	exists, err = ColumnExists(ctx, db, "computers", "source")
+	ok(t, err)
+	equals(t, true, exists)
+}
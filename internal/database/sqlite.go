@@ -0,0 +1,66 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SQLiteOptions are the pragmas set on every SQLite connection.
+type SQLiteOptions struct {
+	JournalMode string
+	BusyTimeout time.Duration
+	ForeignKeys bool
+	Synchronous string
+}
+
+var (
+	JournalModes     = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	SynchronousModes = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// SQLiteDSN adds the pragmas of opts to file as parameters understood by
+// the go-sqlite3 driver.
+func SQLiteDSN(file string, opts SQLiteOptions) string {
+	params := url.Values{}
+
+	if opts.JournalMode != "" {
+		params.Set("_journal_mode", strings.ToUpper(opts.JournalMode))
+	}
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", fmt.Sprintf("%d", opts.BusyTimeout.Milliseconds()))
+	}
+	if opts.ForeignKeys {
+		params.Set("_foreign_keys", "on")
+	} else {
+		params.Set("_foreign_keys", "off")
+	}
+	if opts.Synchronous != "" {
+		params.Set("_synchronous", strings.ToUpper(opts.Synchronous))
+	}
+
+	return file + "?" + params.Encode()
+}
+
+// PoolOptions limit the connections kept by database/sql, zero leaves the
+// driver default in place.
+type PoolOptions struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+func (p PoolOptions) Apply(db *sqlx.DB) {
+	if p.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	}
+}
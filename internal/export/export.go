@@ -0,0 +1,75 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Supported export formats.
+const (
+	CSV  = "csv"
+	XLSX = "xlsx"
+)
+
+// Writer streams rows to a spreadsheet. Close must be called once every
+// row has been written, the output is incomplete without it.
+type Writer interface {
+	Write(row []string) error
+	Close() error
+}
+
+// New returns a writer for format, sheet names the worksheet of XLSX
+// exports.
+func New(format string, w io.Writer, sheet string) (Writer, error) {
+	switch format {
+	case CSV:
+		return NewCSV(w), nil
+	case XLSX:
+		return NewXLSX(w, sheet)
+	}
+	return nil, fmt.Errorf("unsupported export format %q", format)
+}
+
+// ContentType returns the media type of format.
+func ContentType(format string) string {
+	switch format {
+	case XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// NewCSV returns a writer producing RFC 4180 CSV.
+func NewCSV(w io.Writer) Writer {
+	return &csvWriter{
+		w: csv.NewWriter(w),
+	}
+}
+
+func (c *csvWriter) Write(row []string) error {
+	cells := make([]string, len(row))
+	for i, v := range row {
+		cells[i] = sanitize(v)
+	}
+	return c.w.Write(cells)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// sanitize stops spreadsheet applications from evaluating values reported
+// by agents as formulas.
+func sanitize(v string) string {
+	if v != "" && strings.ContainsAny(v[:1], "=+-@\t\r") {
+		return "'" + v
+	}
+	return v
+}
@@ -0,0 +1,107 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: "+msg+"\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: unexpected error: %s\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func TestCSV(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := NewCSV(buf)
+
+	ok(t, w.Write([]string{"Name", "Username"}))
+	ok(t, w.Write([]string{"PC01", `=HYPERLINK("x")`}))
+	ok(t, w.Write([]string{"PC02, lab", "-1"}))
+	ok(t, w.Close())
+
+	equals(t, "Name,Username\nPC01,\"'=HYPERLINK(\"\"x\"\")\"\n\"PC02, lab\",'-1\n", buf.String())
+}
+
+func TestXLSX(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := New(XLSX, buf, "Computers: site/1")
+	ok(t, err)
+
+	ok(t, w.Write([]string{"Name", "Username"}))
+	ok(t, w.Write([]string{"PC01", "<admin> & co"}))
+	ok(t, w.Close())
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	ok(t, err)
+
+	parts := make(map[string][]byte)
+	for _, f := range z.File {
+		r, err := f.Open()
+		ok(t, err)
+		parts[f.Name], err = ioutil.ReadAll(r)
+		ok(t, err)
+		r.Close()
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		data, found := parts[name]
+		assert(t, found, "missing part %s", name)
+		ok(t, xml.Unmarshal(data, new(interface{})))
+	}
+
+	assert(t, bytes.Contains(parts["xl/workbook.xml"], []byte(`name="Computers site1"`)), "sheet name not sanitised: %s", parts["xl/workbook.xml"])
+
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Ref  string `xml:"r,attr"`
+				Text string `xml:"is>t"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	ok(t, xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet))
+
+	equals(t, 2, len(sheet.Rows))
+	equals(t, "B2", sheet.Rows[1].Cells[1].Ref)
+	equals(t, "<admin> & co", sheet.Rows[1].Cells[1].Text)
+}
+
+func TestColumn(t *testing.T) {
+	for i, exp := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		equals(t, exp, column(i))
+	}
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	_, err := New("pdf", new(bytes.Buffer), "")
+	assert(t, err != nil, "expected an error for an unsupported format")
+}
@@ -0,0 +1,201 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Check reports a problem with a dependency by returning an error.
+type Check func(context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker serves the liveness and readiness endpoints.
+type Checker struct {
+	mu           sync.Mutex
+	checks       []namedCheck
+	shuttingDown int32
+	started      time.Time
+}
+
+type CheckResult struct {
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Uptime string                 `json:"uptime"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+var errShuttingDown = errors.New("server is shutting down")
+
+func NewChecker() *Checker {
+	return &Checker{
+		started: time.Now(),
+	}
+}
+
+// Add registers a readiness check.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Shutdown marks the server as not ready so load balancers stop sending
+// traffic while in flight requests drain.
+func (c *Checker) Shutdown() {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+}
+
+func (c *Checker) ShuttingDown() bool {
+	return atomic.LoadInt32(&c.shuttingDown) == 1
+}
+
+// Ready runs every check and returns the combined report.
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mu.Unlock()
+
+	report := Report{
+		Status: StatusOK,
+		Uptime: time.Since(c.started).Round(time.Second).String(),
+		Checks: make(map[string]CheckResult),
+	}
+
+	if c.ShuttingDown() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = CheckResult{
+			Status: StatusFail,
+			Error:  errShuttingDown.Error(),
+		}
+	}
+
+	for _, nc := range checks {
+		start := time.Now()
+		err := nc.check(ctx)
+
+		result := CheckResult{
+			Status:   StatusOK,
+			Duration: time.Since(start).String(),
+		}
+
+		if err != nil {
+			result.Status = StatusFail
+			result.Error = err.Error()
+			report.Status = StatusFail
+		}
+
+		report.Checks[nc.name] = result
+	}
+
+	return report
+}
+
+// Err returns an error describing the first failed check of a report.
+func (r Report) Err() error {
+	if r.Status == StatusOK {
+		return nil
+	}
+	for name, result := range r.Checks {
+		if result.Status != StatusOK {
+			return fmt.Errorf("%s: %s", name, result.Error)
+		}
+	}
+	return errors.New("not ready")
+}
+
+// Liveness reports that the process is up and able to serve requests.
+func (c *Checker) Liveness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Report{
+			Status: StatusOK,
+			Uptime: time.Since(c.started).Round(time.Second).String(),
+		})
+	}
+}
+
+// Readiness runs the registered checks and answers 503 when any of them
+// fail or the server is shutting down.
+func (c *Checker) Readiness() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), (time.Second * 5))
+		defer cancel()
+
+		report := c.Ready(ctx)
+
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, report)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(data)))
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// DatabaseCheck pings the database.
+func DatabaseCheck(db *sqlx.DB) Check {
+	return func(ctx context.Context) error {
+		return db.PingContext(ctx)
+	}
+}
+
+// WritableCheck verifies that file can be opened for writing.
+func WritableCheck(file string) Check {
+	return func(ctx context.Context) error {
+		f, err := os.OpenFile(file, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+}
+
+// DiskSpaceCheck fails when the file system holding path has less than min
+// bytes available.
+func DiskSpaceCheck(path string, min uint64) Check {
+	return func(ctx context.Context) error {
+		free, err := freeSpace(path)
+		if err != nil {
+			return err
+		}
+		if free < min {
+			return fmt.Errorf("%d MB available, %d MB required", free>>20, min>>20)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: unexpected error: %s\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func readiness(c *Checker) (int, Report) {
+	rec := httptest.NewRecorder()
+	c.Readiness()(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+	var report Report
+	json.Unmarshal(rec.Body.Bytes(), &report)
+	return rec.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	db, err := sqlx.Open("sqlite3", ":memory:")
+	ok(t, err)
+	defer db.Close()
+
+	c := NewChecker()
+	c.Add("database", DatabaseCheck(db))
+	c.Add("disk", DiskSpaceCheck(os.TempDir(), 1))
+
+	code, report := readiness(c)
+	equals(t, http.StatusOK, code)
+	equals(t, StatusOK, report.Status)
+	equals(t, StatusOK, report.Checks["database"].Status)
+
+	c.Add("schema", func(ctx context.Context) error {
+		return errors.New("schema version 1, expected 2")
+	})
+
+	code, report = readiness(c)
+	equals(t, http.StatusServiceUnavailable, code)
+	equals(t, StatusFail, report.Status)
+	equals(t, "schema version 1, expected 2", report.Checks["schema"].Error)
+}
+
+func TestReadinessShutdown(t *testing.T) {
+	c := NewChecker()
+
+	code, _ := readiness(c)
+	equals(t, http.StatusOK, code)
+
+	c.Shutdown()
+
+	code, report := readiness(c)
+	equals(t, http.StatusServiceUnavailable, code)
+	equals(t, StatusFail, report.Checks["shutdown"].Status)
+
+	rec := httptest.NewRecorder()
+	c.Liveness()(rec, httptest.NewRequest("GET", "/healthz", nil))
+	equals(t, http.StatusOK, rec.Code)
+}
+
+func TestWritableCheck(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "fpsmonitor.sqlite")
+
+	err := WritableCheck(file)(context.Background())
+	equals(t, true, err != nil)
+
+	ok(t, os.WriteFile(file, nil, 0644))
+	ok(t, WritableCheck(file)(context.Background()))
+}
+
+func TestDiskSpaceCheck(t *testing.T) {
+	err := DiskSpaceCheck(os.TempDir(), ^uint64(0))(context.Background())
+	equals(t, true, err != nil)
+}
@@ -0,0 +1,164 @@
+// Package mail sends HTML email through an SMTP server.
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialTimeout limits connecting to the server.
+const dialTimeout = 10 * time.Second
+
+// rootCAs verifies the certificate of the server, the system roots when
+// nil. It is replaced by tests.
+var rootCAs *x509.CertPool
+
+// Config is the SMTP server mail is sent through. Mail is disabled without
+// Host.
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+
+	// StartTLS requires the server to upgrade the connection before
+	// authenticating and sending. TLS connects with TLS from the start,
+	// as servers on port 465 expect.
+	StartTLS bool
+	TLS      bool
+}
+
+// Enabled reports whether a server is configured.
+func (c Config) Enabled() bool {
+	return c.Host != ""
+}
+
+// Message is an HTML email.
+type Message struct {
+	To      []string
+	Subject string
+	HTML    string
+}
+
+// Bytes returns the message with its headers as sent from from.
+func (m Message) Bytes(from string, now time.Time) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+
+	domain := "fpsmonitor"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "<> ")
+	}
+
+	var b bytes.Buffer
+	header := func(key string, value string) {
+		b.WriteString(key + ": " + clean(value) + "\r\n")
+	}
+	header("From", from)
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", "<"+hex.EncodeToString(id)+"@"+domain+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/html; charset=utf-8")
+	header("Content-Transfer-Encoding", "quoted-printable")
+	b.WriteString("\r\n")
+
+	w := quotedprintable.NewWriter(&b)
+	w.Write([]byte(m.HTML))
+	w.Close()
+	return b.Bytes()
+}
+
+// clean removes line breaks that would start another header.
+func clean(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// Send delivers the message to every recipient in a single transaction.
+func Send(cfg Config, m Message) error {
+	if !cfg.Enabled() {
+		return errors.New("mail: no SMTP host configured")
+	}
+	if len(m.To) == 0 {
+		return errors.New("mail: no recipients")
+	}
+
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	var conn net.Conn
+	var err error
+	if cfg.TLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", addr, &tls.Config{ServerName: cfg.Host, RootCAs: rootCAs})
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, dialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if cfg.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("mail: server does not support STARTTLS")
+		}
+		if err = c.StartTLS(&tls.Config{ServerName: cfg.Host, RootCAs: rootCAs}); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(address(cfg.From)); err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		if err = c.Rcpt(address(to)); err != nil {
+			return fmt.Errorf("mail: recipient %s: %w", to, err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(m.Bytes(cfg.From, time.Now())); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// address returns the bare address of "Name <address>".
+func address(s string) string {
+	if start := strings.LastIndex(s, "<"); start >= 0 {
+		if end := strings.LastIndex(s, ">"); end > start {
+			return s[start+1 : end]
+		}
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,184 @@
+package mail
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// received is a transaction accepted by fakeSMTP.
+type received struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTP accepts a single connection speaking enough SMTP for Send and
+// returns its address and the transaction. The connection uses TLS from
+// the start with tlsConfig.
+func fakeSMTP(tb testing.TB, rejectRcpt string, tlsConfig *tls.Config) (string, <-chan received) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	done := make(chan received, 1)
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		var r received
+		defer func() { done <- r }()
+
+		tp.PrintfLine("220 localhost fake")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch verb {
+			case "EHLO":
+				tp.PrintfLine("250-localhost")
+				tp.PrintfLine("250 AUTH PLAIN")
+			case "AUTH":
+				r.auth = line
+				tp.PrintfLine("235 ok")
+			case "MAIL":
+				r.from = line
+				tp.PrintfLine("250 ok")
+			case "RCPT":
+				if rejectRcpt != "" && strings.Contains(line, rejectRcpt) {
+					tp.PrintfLine("550 no such user")
+					continue
+				}
+				r.to = append(r.to, line)
+				tp.PrintfLine("250 ok")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := io.ReadAll(tp.DotReader())
+				if err != nil {
+					return
+				}
+				r.data = string(data)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+
+	return l.Addr().String(), done
+}
+
+func config(tb testing.TB, addr string) Config {
+	host, port, _ := net.SplitHostPort(addr)
+	var p int
+	fmt.Sscan(port, &p)
+	return Config{Host: host, Port: p, From: "Inventory <fpsmonitor@example.com>"}
+}
+
+func TestSend(t *testing.T) {
+	addr, done := fakeSMTP(t, "", nil)
+	cfg := config(t, addr)
+	cfg.Username = "mailer"
+	cfg.Password = "secret"
+
+	err := Send(cfg, Message{
+		To:      []string{"alice@example.com", "Bob <bob@example.com>"},
+		Subject: "Daily digest – 3 new computers",
+		HTML:    "<p>Hello</p>",
+	})
+	equals(t, nil, err)
+
+	r := <-done
+	equals(t, "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00mailer\x00secret")), r.auth)
+	equals(t, "MAIL FROM:<fpsmonitor@example.com>", strings.SplitN(r.from, " BODY", 2)[0])
+	equals(t, []string{"RCPT TO:<alice@example.com>", "RCPT TO:<bob@example.com>"}, r.to)
+
+	msg, err := textproto.NewReader(bufio.NewReader(strings.NewReader(r.data))).ReadMIMEHeader()
+	equals(t, nil, err)
+	equals(t, "Inventory <fpsmonitor@example.com>", msg.Get("From"))
+	equals(t, "alice@example.com, Bob <bob@example.com>", msg.Get("To"))
+	equals(t, "=?utf-8?q?Daily_digest_=E2=80=93_3_new_computers?=", msg.Get("Subject"))
+	equals(t, "text/html; charset=utf-8", msg.Get("Content-Type"))
+	equals(t, true, strings.HasSuffix(msg.Get("Message-Id"), "@example.com>"))
+
+	body := r.data[strings.Index(r.data, "\n\n")+2:]
+	html, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(body)))
+	equals(t, nil, err)
+	equals(t, "<p>Hello</p>", strings.TrimSpace(string(html)))
+}
+
+func TestSendTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(nil)
+	defer srv.Close()
+	rootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	defer func() { rootCAs = nil }()
+
+	addr, done := fakeSMTP(t, "", srv.TLS)
+	cfg := config(t, addr)
+	cfg.TLS = true
+	cfg.Username = "mailer"
+	cfg.Password = "secret"
+
+	err := Send(cfg, Message{To: []string{"alice@example.com"}, Subject: "x", HTML: "x"})
+	equals(t, nil, err)
+
+	r := <-done
+	equals(t, "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte("\x00mailer\x00secret")), r.auth)
+	equals(t, []string{"RCPT TO:<alice@example.com>"}, r.to)
+}
+
+func TestSendRejected(t *testing.T) {
+	addr, done := fakeSMTP(t, "mallory", nil)
+
+	err := Send(config(t, addr), Message{To: []string{"mallory@example.com"}, Subject: "x", HTML: "x"})
+	equals(t, true, err != nil && strings.Contains(err.Error(), "mallory@example.com"))
+	<-done
+
+	equals(t, "mail: no SMTP host configured", Send(Config{}, Message{To: []string{"a@example.com"}}).Error())
+	equals(t, "mail: no recipients", Send(Config{Host: "localhost"}, Message{}).Error())
+}
+
+func TestBytes(t *testing.T) {
+	m := Message{To: []string{"a@example.com"}, Subject: "Hi\r\nBcc: evil@example.com", HTML: "x"}
+	data := string(m.Bytes("fpsmonitor@example.com", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	equals(t, false, strings.Contains(data, "\r\nBcc:"))
+	equals(t, true, strings.Contains(data, "Date: Fri, 02 Jan 2026 03:04:05 +0000\r\n"))
+}
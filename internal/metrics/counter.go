@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"bytes"
+	"sync"
+)
+
+// CounterVec is a set of monotonically increasing values partitioned by
+// label values.
+type CounterVec struct {
+	mu     sync.Mutex
+	Name   string
+	Help   string
+	labels []string
+	keys   map[string][]string
+	values map[string]float64
+}
+
+// NewCounterVec creates a counter and registers it with the default registry.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		Name:   name,
+		Help:   help,
+		labels: labels,
+		keys:   make(map[string][]string),
+		values: make(map[string]float64),
+	}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counters can not decrease")
+	}
+
+	key := seriesKey(c.labels, values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.keys[key]; !ok {
+		c.keys[key] = append([]string(nil), values...)
+	}
+	c.values[key] += v
+}
+
+// Value returns the current value of the series identified by values.
+func (c *CounterVec) Value(values ...string) float64 {
+	key := seriesKey(c.labels, values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *CounterVec) name() string {
+	return c.Name
+}
+
+func (c *CounterVec) write(buf *bytes.Buffer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(buf, c.Name, c.Help, "counter")
+	for _, s := range sortedSeries(c.keys) {
+		writeSample(buf, c.Name, c.labels, s.values, c.values[s.key])
+	}
+}
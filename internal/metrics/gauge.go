@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"bytes"
+	"sync"
+)
+
+// GaugeVec is a set of values that can go up and down partitioned by label
+// values.
+type GaugeVec struct {
+	mu     sync.Mutex
+	Name   string
+	Help   string
+	labels []string
+	keys   map[string][]string
+	values map[string]float64
+}
+
+// NewGaugeVec creates a gauge and registers it with the default registry.
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{
+		Name:   name,
+		Help:   help,
+		labels: labels,
+		keys:   make(map[string][]string),
+		values: make(map[string]float64),
+	}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeVec) Set(v float64, values ...string) {
+	key := seriesKey(g.labels, values)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.keys[key]; !ok {
+		g.keys[key] = append([]string(nil), values...)
+	}
+	g.values[key] = v
+}
+
+func (g *GaugeVec) Add(v float64, values ...string) {
+	key := seriesKey(g.labels, values)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, ok := g.keys[key]; !ok {
+		g.keys[key] = append([]string(nil), values...)
+	}
+	g.values[key] += v
+}
+
+// Reset removes every series, used by collectors that rebuild a gauge from
+// scratch on each scrape.
+func (g *GaugeVec) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.keys = make(map[string][]string)
+	g.values = make(map[string]float64)
+}
+
+// Value returns the current value of the series identified by values.
+func (g *GaugeVec) Value(values ...string) float64 {
+	key := seriesKey(g.labels, values)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.values[key]
+}
+
+func (g *GaugeVec) name() string {
+	return g.Name
+}
+
+func (g *GaugeVec) write(buf *bytes.Buffer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	writeHeader(buf, g.Name, g.Help, "gauge")
+	for _, s := range sortedSeries(g.keys) {
+		writeSample(buf, g.Name, g.labels, s.values, g.values[s.key])
+	}
+}
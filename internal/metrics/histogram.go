@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefBuckets are the default latency buckets in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// HistogramVec counts observations in configurable buckets partitioned by
+// label values.
+type HistogramVec struct {
+	mu      sync.Mutex
+	Name    string
+	Help    string
+	buckets []float64
+	labels  []string
+	keys    map[string][]string
+	values  map[string]*histogram
+}
+
+// NewHistogramVec creates a histogram and registers it with the default
+// registry. A nil buckets slice uses DefBuckets.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &HistogramVec{
+		Name:    name,
+		Help:    help,
+		buckets: buckets,
+		labels:  labels,
+		keys:    make(map[string][]string),
+		values:  make(map[string]*histogram),
+	}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	key := seriesKey(h.labels, values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hist, ok := h.values[key]
+	if !ok {
+		h.keys[key] = append([]string(nil), values...)
+		hist = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hist
+	}
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += v
+}
+
+// Since observes the seconds elapsed since start.
+func (h *HistogramVec) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Count returns the number of observations of the series identified by
+// values.
+func (h *HistogramVec) Count(values ...string) uint64 {
+	key := seriesKey(h.labels, values)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if hist, ok := h.values[key]; ok {
+		return hist.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) name() string {
+	return h.Name
+}
+
+func (h *HistogramVec) write(buf *bytes.Buffer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(buf, h.Name, h.Help, "histogram")
+
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, s := range sortedSeries(h.keys) {
+		hist := h.values[s.key]
+		for i, upper := range h.buckets {
+			values := append(append([]string(nil), s.values...), formatFloat(upper))
+			writeSample(buf, h.Name+"_bucket", labels, values, float64(hist.counts[i]))
+		}
+		values := append(append([]string(nil), s.values...), "+Inf")
+		writeSample(buf, h.Name+"_bucket", labels, values, float64(hist.count))
+		writeSample(buf, h.Name+"_sum", h.labels, s.values, hist.sum)
+		writeSample(buf, h.Name+"_count", h.labels, s.values, float64(hist.count))
+	}
+}
@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default is the registry used by the package level constructors and
+// served by Handler.
+var Default = NewRegistry()
+
+type metric interface {
+	name() string
+	write(*bytes.Buffer)
+}
+
+// Registry holds a set of metrics and the collectors that refresh gauges
+// before every scrape.
+type Registry struct {
+	mu         sync.Mutex
+	metrics    []metric
+	collectors map[string]func(context.Context) error
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		collectors: make(map[string]func(context.Context) error),
+	}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.metrics {
+		if existing.name() == m.name() {
+			panic(fmt.Sprintf("metrics: duplicate metric %q", m.name()))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// Collect registers fn to be run before every scrape. Registering a
+// collector with a name already in use replaces the previous one.
+func (r *Registry) Collect(name string, fn func(context.Context) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors[name] = fn
+}
+
+// WriteTo runs the collectors and writes every metric in the Prometheus
+// text exposition format.
+func (r *Registry) WriteTo(ctx context.Context, buf *bytes.Buffer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]func(context.Context) error, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	var errs []string
+	for i, fn := range collectors {
+		if err := fn(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", names[i], err))
+		}
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name() < metrics[j].name()
+	})
+	for _, m := range metrics {
+		m.write(buf)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("metrics collectors failed: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Handler serves the metrics of the default registry.
+func Handler() http.HandlerFunc {
+	return Default.Handler()
+}
+
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), (time.Second * 5))
+		defer cancel()
+
+		buf := new(bytes.Buffer)
+
+		// A failing collector leaves its gauges at their previous values,
+		// the rest of the metrics are still worth serving.
+		err := r.WriteTo(ctx, buf)
+		if err != nil {
+			w.Header().Set("X-Metrics-Error", err.Error())
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", buf.Len()))
+		w.Write(buf.Bytes())
+	}
+}
+
+func writeHeader(buf *bytes.Buffer, name string, help string, kind string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, escapeHelp(help))
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+}
+
+func writeSample(buf *bytes.Buffer, name string, labels []string, values []string, value float64) {
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteByte('{')
+		for i := range labels {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(labels[i])
+			buf.WriteString(`="`)
+			buf.WriteString(escapeLabel(values[i]))
+			buf.WriteByte('"')
+		}
+		buf.WriteByte('}')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(formatFloat(value))
+	buf.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func escapeLabel(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+// series is a single label value combination of a vector.
+type series struct {
+	key    string
+	values []string
+}
+
+func seriesKey(labels []string, values []string) string {
+	if len(values) != len(labels) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func sortedSeries(m map[string][]string) []series {
+	list := make([]series, 0, len(m))
+	for key, values := range m {
+		list = append(list, series{key: key, values: values})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].key < list[j].key
+	})
+	return list
+}
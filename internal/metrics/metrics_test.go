@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: "+msg+"\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: unexpected error: %s\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+func TestCounterVec(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("test_total", "Test counter.", "class")
+	c.Inc("a")
+	c.Add(2, "a")
+	c.Inc(`b"c`)
+
+	buf := new(bytes.Buffer)
+	ok(t, r.WriteTo(context.Background(), buf))
+
+	exp := "# HELP test_total Test counter.\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{class=\"a\"} 3\n" +
+		"test_total{class=\"b\\\"c\"} 1\n"
+	equals(t, exp, buf.String())
+}
+
+func TestHistogramVec(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("test_seconds", "Test histogram.", []float64{1, 0.5}, "method")
+	h.Observe(0.2, "Select")
+	h.Observe(0.7, "Select")
+	h.Observe(3, "Select")
+
+	buf := new(bytes.Buffer)
+	ok(t, r.WriteTo(context.Background(), buf))
+
+	exp := "# HELP test_seconds Test histogram.\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{method=\"Select\",le=\"0.5\"} 1\n" +
+		"test_seconds_bucket{method=\"Select\",le=\"1\"} 2\n" +
+		"test_seconds_bucket{method=\"Select\",le=\"+Inf\"} 3\n" +
+		"test_seconds_sum{method=\"Select\"} 3.9\n" +
+		"test_seconds_count{method=\"Select\"} 3\n"
+	equals(t, exp, buf.String())
+	equals(t, uint64(3), h.Count("Select"))
+}
+
+func TestRegistryCollect(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGaugeVec("test_gauge", "Test gauge.")
+
+	calls := 0
+	r.Collect("inventory", func(ctx context.Context) error {
+		calls++
+		g.Set(float64(calls))
+		return nil
+	})
+
+	buf := new(bytes.Buffer)
+	ok(t, r.WriteTo(context.Background(), buf))
+	ok(t, r.WriteTo(context.Background(), buf))
+
+	equals(t, 2, calls)
+	equals(t, float64(2), g.Value())
+
+	r.Collect("inventory", func(ctx context.Context) error {
+		return errors.New("database closed")
+	})
+
+	buf.Reset()
+	err := r.WriteTo(context.Background(), buf)
+	assert(t, err != nil, "expected collector error")
+	assert(t, strings.Contains(buf.String(), "test_gauge 2\n"), "gauge missing after failed collect: %s", buf.String())
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("test_total", "Test counter.")
+
+	defer func() {
+		assert(t, recover() != nil, "expected duplicate registration to panic")
+	}()
+	r.NewGaugeVec("test_total", "Test gauge.")
+}
+
+func TestMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/computers/update", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}).Name("test-update")
+	router.HandleFunc("/computers/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	before := httpRequests.Value("test-update", "POST", "201")
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/computers/update", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/computers/12", nil))
+
+	equals(t, before+1, httpRequests.Value("test-update", "POST", "201"))
+	equals(t, float64(1), httpRequests.Value("/computers/{id}", "GET", "200"))
+
+	rec := httptest.NewRecorder()
+	Handler()(rec, httptest.NewRequest("GET", "/metrics", nil))
+	equals(t, http.StatusOK, rec.Code)
+	assert(t, strings.Contains(rec.Body.String(), `fpsmonitor_http_request_duration_seconds_count{route="test-update",method="POST"}`), "missing latency series")
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequests = NewCounterVec(
+		"fpsmonitor_http_requests_total",
+		"Number of HTTP requests by route, method and status code.",
+		"route", "method", "code",
+	)
+
+	httpDuration = NewHistogramVec(
+		"fpsmonitor_http_request_duration_seconds",
+		"HTTP request latencies by route and method.",
+		nil,
+		"route", "method",
+	)
+)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush passes through to the wrapped writer for streamed responses.
+func (w *statusRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware records request counts and latencies labelled with the name of
+// the matched mux route. It has to be added with Router.Use so that the route
+// is known when it runs.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		route := routeName(r)
+		httpRequests.Inc(route, r.Method, strconv.Itoa(rec.status))
+		httpDuration.Since(start, route, r.Method)
+	})
+}
+
+// routeName prefers the mux route name and falls back to the path template
+// so unnamed routes do not create a series per requested URL.
+func routeName(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return "unmatched"
+	}
+
+	if name := route.GetName(); name != "" {
+		return name
+	}
+
+	if tpl, err := route.GetPathTemplate(); err == nil {
+		return tpl
+	}
+	return "unnamed"
+}
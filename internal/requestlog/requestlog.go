@@ -0,0 +1,227 @@
+package requestlog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stockholmr/lumber"
+)
+
+// Header carries the request ID between agents, proxies and the server.
+const Header = "X-Request-ID"
+
+type key int
+
+const entryKey key = 0
+
+// Field is a single key=value pair of a log line.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// entry collects the fields handlers attach to the access log line of the
+// request they are serving.
+type entry struct {
+	mu     sync.Mutex
+	id     string
+	fields []Field
+}
+
+// RequestID returns the ID of the request ctx belongs to, or an empty string
+// outside of Middleware.
+func RequestID(ctx context.Context) string {
+	if e, ok := ctx.Value(entryKey).(*entry); ok {
+		return e.id
+	}
+	return ""
+}
+
+// Set adds a field to the access log line of the current request.
+func Set(ctx context.Context, key string, value interface{}) {
+	e, ok := ctx.Value(entryKey).(*entry)
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for i := range e.fields {
+		if e.fields[i].Key == key {
+			e.fields[i].Value = value
+			return
+		}
+	}
+	e.fields = append(e.fields, Field{Key: key, Value: value})
+}
+
+func (e *entry) snapshot() []Field {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Field(nil), e.fields...)
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Flush passes through to the wrapped writer so streamed responses, such
+// as server-sent events, reach the client.
+func (w *responseRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Middleware assigns every request an ID, reusing a well formed incoming
+// X-Request-ID, and writes one logfmt line per request once the handler has
+// finished.
+func Middleware(log lumber.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(Header)
+			if !validID(id) {
+				id = newID()
+			}
+			w.Header().Set(Header, id)
+
+			e := &entry{id: id}
+			rec := &responseRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), entryKey, e)))
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			fields := []Field{
+				{"request_id", id},
+				{"method", r.Method},
+				{"uri", r.RequestURI},
+				{"remote", r.RemoteAddr},
+				{"status", rec.status},
+				{"bytes", rec.size},
+				{"duration", time.Since(start).Round(time.Microsecond)},
+			}
+			fields = append(fields, e.snapshot()...)
+
+			line := Format("request", fields...)
+			switch {
+			case rec.status >= 500:
+				log.Error(line)
+			case rec.status >= 400:
+				log.Warn(line)
+			default:
+				log.Info(line)
+			}
+		})
+	}
+}
+
+// Error logs err with the ID of the request ctx belongs to. Errors carrying
+// the function they were raised in add it as a func field.
+func Error(ctx context.Context, log lumber.Logger, err error, fields ...Field) {
+	all := []Field{{"request_id", RequestID(ctx)}}
+
+	if fe, ok := err.(interface{ Function() string }); ok {
+		all = append(all, Field{"func", fe.Function()})
+	}
+
+	all = append(all, fields...)
+	all = append(all, Field{"error", err.Error()})
+	log.Error(Format("error", all...))
+}
+
+// Format renders msg and fields as a logfmt line.
+func Format(msg string, fields ...Field) string {
+	var b strings.Builder
+	b.WriteString("msg=")
+	b.WriteString(quote(msg))
+
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(quote(formatValue(f.Value)))
+	}
+	return b.String()
+}
+
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case int:
+		return strconv.Itoa(t)
+	case time.Duration:
+		return t.String()
+	case fmt.Stringer:
+		return t.String()
+	case error:
+		return t.Error()
+	}
+	return fmt.Sprint(v)
+}
+
+func quote(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if strings.ContainsAny(s, " \t\r\n\"=\\") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func validID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
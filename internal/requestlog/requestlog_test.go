@@ -0,0 +1,118 @@
+package requestlog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stockholmr/lumber"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: "+msg+"\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+type buffer struct {
+	bytes.Buffer
+}
+
+func (b *buffer) Close() error {
+	return nil
+}
+
+type funcError struct{}
+
+func (funcError) Error() string {
+	return "no such table: computers"
+}
+
+func (funcError) Function() string {
+	return "computer.computerRepository.Select"
+}
+
+func TestMiddleware(t *testing.T) {
+	out := new(buffer)
+	log := lumber.NewBasicLogger(out, lumber.TRACE)
+
+	var seen string
+	handler := Middleware(log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+		Set(r.Context(), "computer", "PC 01")
+		Error(r.Context(), log, funcError{})
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("failed"))
+	}))
+
+	req := httptest.NewRequest("POST", "/computers/update", nil)
+	req.Header.Set(Header, "agent-42")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	equals(t, "agent-42", seen)
+	equals(t, "agent-42", rec.Header().Get(Header))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	equals(t, 2, len(lines))
+	assert(t, strings.Contains(lines[0], `msg=error request_id=agent-42 func=computer.computerRepository.Select error="no such table: computers"`), "unexpected error line: %s", lines[0])
+	assert(t, strings.Contains(lines[1], "ERROR msg=request request_id=agent-42 method=POST uri=/computers/update"), "unexpected request line: %s", lines[1])
+	assert(t, strings.Contains(lines[1], `status=500 bytes=6 duration=`), "unexpected request line: %s", lines[1])
+	assert(t, strings.HasSuffix(lines[1], `computer="PC 01"`), "unexpected request line: %s", lines[1])
+}
+
+func TestMiddlewareGeneratesID(t *testing.T) {
+	out := new(buffer)
+	handler := Middleware(lumber.NewBasicLogger(out, lumber.TRACE))(http.NotFoundHandler())
+
+	req := httptest.NewRequest("GET", "/computers/list", nil)
+	req.Header.Set(Header, "not a valid id")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	id := rec.Header().Get(Header)
+	equals(t, 24, len(id))
+	assert(t, strings.Contains(out.String(), "WARN  msg=request request_id="+id), "unexpected request line: %s", out.String())
+}
+
+func TestOutsideMiddleware(t *testing.T) {
+	ctx := context.Background()
+	Set(ctx, "computer", "PC01")
+	equals(t, "", RequestID(ctx))
+	equals(t, `msg=error request_id="" error=failed`, Format("error", Field{"request_id", RequestID(ctx)}, Field{"error", errors.New("failed")}))
+}
+
+func TestMiddlewareFlush(t *testing.T) {
+	log := lumber.NewBasicLogger(new(buffer), lumber.TRACE)
+
+	handler := Middleware(log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		assert(t, ok, "recorder does not implement http.Flusher")
+		w.Write([]byte("data: 1\n\n"))
+		f.Flush()
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/computers/events", nil))
+	equals(t, true, rec.Flushed)
+}
@@ -0,0 +1,185 @@
+package tlsconfig
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// ServerOptions configures the TLS listener of the server.
+type ServerOptions struct {
+	CertFile   string
+	KeyFile    string
+	MinVersion string
+
+	// ClientCAFile enables mutual TLS, client certificates are verified
+	// against the CAs it holds.
+	ClientCAFile string
+
+	// RequireClientCert rejects connections without a client certificate,
+	// otherwise one is only verified when it is presented.
+	RequireClientCert bool
+}
+
+// ClientOptions configures the agent connection to the server.
+type ClientOptions struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	MinVersion string
+
+	// PinSHA256 lists base64 or hex encoded SHA-256 hashes of the subject
+	// public key info of certificates the server chain must contain.
+	PinSHA256 []string
+}
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ParseVersion converts a version such as "1.2" to its crypto/tls constant.
+// An empty string selects TLS 1.2.
+func ParseVersion(v string) (uint16, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "TLS")
+	if v == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	version, ok := versions[v]
+	if !ok {
+		return 0, fmt.Errorf("unsupported TLS version %q", v)
+	}
+	return version, nil
+}
+
+func Server(opts ServerOptions) (*tls.Config, error) {
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, errors.New("certificate and key file are both required")
+	}
+
+	minVersion, err := ParseVersion(opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   minVersion,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if opts.ClientCAFile != "" {
+		pool, err := loadPool(opts.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if opts.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if opts.RequireClientCert {
+		return nil, errors.New("client certificates can not be required without a client CA file")
+	}
+
+	return cfg, nil
+}
+
+func Client(opts ClientOptions) (*tls.Config, error) {
+	minVersion, err := ParseVersion(opts.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion: minVersion,
+	}
+
+	if opts.CAFile != "" {
+		if cfg.RootCAs, err = loadPool(opts.CAFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(opts.PinSHA256) > 0 {
+		pins := make(map[string]bool)
+		for _, pin := range opts.PinSHA256 {
+			pin = strings.TrimSpace(pin)
+			if pin == "" {
+				continue
+			}
+
+			hash, err := decodePin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins[string(hash)] = true
+		}
+
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if pins[string(hash[:])] {
+					return nil
+				}
+			}
+			return errors.New("server certificate does not match any pinned key")
+		}
+	}
+
+	return cfg, nil
+}
+
+// Pin returns the base64 encoded SHA-256 hash of the public key of cert, as
+// expected by ClientOptions.PinSHA256.
+func Pin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func decodePin(pin string) ([]byte, error) {
+	if hash, err := base64.StdEncoding.DecodeString(pin); err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+
+	hash, err := hex.DecodeString(strings.ReplaceAll(pin, ":", ""))
+	if err == nil && len(hash) == sha256.Size {
+		return hash, nil
+	}
+	return nil, fmt.Errorf("invalid SHA-256 pin %q", pin)
+}
+
+func loadPool(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
@@ -0,0 +1,199 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// assert fails the test if the condition is false.
+func assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: "+msg+"\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// ok fails the test if an err is not nil.
+func ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d: unexpected error: %s\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// equals fails the test if exp is not equal to act.
+func equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("%s:%d:\n\n\texp: %#v\n\n\tgot: %#v\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+func newCert(tb testing.TB, dir string, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(tb, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	ok(tb, err)
+
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tpl, key
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signerKey)
+	ok(tb, err)
+	cert, err := x509.ParseCertificate(der)
+	ok(tb, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	ok(tb, err)
+
+	c := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	ok(tb, ioutil.WriteFile(c.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	ok(tb, ioutil.WriteFile(c.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return c
+}
+
+func newServer(tb testing.TB, opts ServerOptions) (*httptest.Server, *string) {
+	cfg, err := Server(opts)
+	ok(tb, err)
+
+	subject := new(string)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.VerifiedChains) > 0 {
+			*subject = r.TLS.VerifiedChains[0][0].Subject.CommonName
+		}
+	}))
+	srv.TLS = cfg
+	srv.StartTLS()
+	return srv, subject
+}
+
+func get(tb testing.TB, url string, opts ClientOptions) error {
+	cfg, err := Client(opts)
+	ok(tb, err)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, dir, "ca", nil)
+	server := newCert(t, dir, "server", ca)
+	client := newCert(t, dir, "PC01", ca)
+
+	srv, subject := newServer(t, ServerOptions{
+		CertFile:          server.certFile,
+		KeyFile:           server.keyFile,
+		MinVersion:        "1.3",
+		ClientCAFile:      ca.certFile,
+		RequireClientCert: true,
+	})
+	defer srv.Close()
+
+	err := get(t, srv.URL, ClientOptions{CAFile: ca.certFile})
+	assert(t, err != nil, "expected connection without client certificate to fail")
+
+	err = get(t, srv.URL, ClientOptions{
+		CAFile:   ca.certFile,
+		CertFile: client.certFile,
+		KeyFile:  client.keyFile,
+	})
+	ok(t, err)
+	equals(t, "PC01", *subject)
+
+	err = get(t, srv.URL, ClientOptions{CAFile: ca.certFile, MinVersion: "1.2", CertFile: client.certFile, KeyFile: client.keyFile})
+	ok(t, err)
+}
+
+func TestPinning(t *testing.T) {
+	dir := t.TempDir()
+	ca := newCert(t, dir, "ca", nil)
+	server := newCert(t, dir, "server", ca)
+	other := newCert(t, dir, "other", ca)
+
+	srv, _ := newServer(t, ServerOptions{CertFile: server.certFile, KeyFile: server.keyFile})
+	defer srv.Close()
+
+	err := get(t, srv.URL, ClientOptions{CAFile: ca.certFile, PinSHA256: []string{Pin(server.cert)}})
+	ok(t, err)
+
+	err = get(t, srv.URL, ClientOptions{CAFile: ca.certFile, PinSHA256: []string{Pin(other.cert)}})
+	assert(t, err != nil, "expected pin mismatch to fail")
+}
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("")
+	ok(t, err)
+	equals(t, uint16(tls.VersionTLS12), v)
+
+	v, err = ParseVersion("TLS1.3")
+	ok(t, err)
+	equals(t, uint16(tls.VersionTLS13), v)
+
+	_, err = ParseVersion("2.0")
+	assert(t, err != nil, "expected unsupported version to fail")
+}
+
+func TestServerOptions(t *testing.T) {
+	_, err := Server(ServerOptions{})
+	assert(t, err != nil, "expected missing certificate to fail")
+
+	dir := t.TempDir()
+	ca := newCert(t, dir, "ca", nil)
+	_, err = Server(ServerOptions{CertFile: ca.certFile, KeyFile: ca.keyFile, RequireClientCert: true})
+	assert(t, err != nil, "expected client certificates without CA to fail")
+
+	_, err = Client(ClientOptions{PinSHA256: []string{"abc"}})
+	assert(t, err != nil, "expected invalid pin to fail")
+}